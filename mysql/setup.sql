@@ -19,7 +19,12 @@ create table positions (
 	trailing_stop decimal(15, 9),
 	bad_for_analysis tinyint(1),
 	received_time datetime(3),
-	n_close_orders int
+	n_close_orders int,
+	open_order_pending tinyint(1) default false not null,
+	close_order_pending tinyint(1) default false not null,
+	take_profit_order_id varchar(255) default '',
+	stop_loss_order_id varchar(255) default '',
+	close_attempts int default 0 not null
 )
 
 create table trades (
@@ -42,6 +47,17 @@ create table trades (
 	n_close_orders int
 )
 
+create table shutdowns (
+  id int primary key auto_increment,
+  policy varchar(50),
+  trigger_source varchar(50),
+  start_time datetime(3),
+  end_time datetime(3),
+  positions_left int,
+  orders_pending int,
+  deadline_reached tinyint(1)
+)
+
 create database algo_test;
 use algo_test;
 
@@ -62,7 +78,12 @@ create table positions (
 	trailing_stop decimal(15, 9),
 	bad_for_analysis tinyint(1),
 	received_time datetime(3),
-	n_close_orders int
+	n_close_orders int,
+	open_order_pending tinyint(1) default false not null,
+	close_order_pending tinyint(1) default false not null,
+	take_profit_order_id varchar(255) default '',
+	stop_loss_order_id varchar(255) default '',
+	close_attempts int default 0 not null
 )
 
 create table trades (
@@ -83,4 +104,15 @@ create table trades (
 	bad_for_analysis tinyint(1),
 	received_time datetime(3),
 	n_close_orders int
-)
\ No newline at end of file
+)
+
+create table shutdowns (
+  id int primary key auto_increment,
+  policy varchar(50),
+  trigger_source varchar(50),
+  start_time datetime(3),
+  end_time datetime(3),
+  positions_left int,
+  orders_pending int,
+  deadline_reached tinyint(1)
+)
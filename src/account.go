@@ -6,6 +6,8 @@ import (
   "regexp"
   "errors"
   "strconv"
+  "strings"
+  "slices"
   "sync"
   "time"
   "context"
@@ -14,6 +16,7 @@ import (
   "github.com/valyala/fastjson"
   "github.com/shopspring/decimal"
   "github.com/Kjellemann1/AlgoTrader-Go/constant"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
   "github.com/Kjellemann1/AlgoTrader-Go/request"
   "github.com/Kjellemann1/AlgoTrader-Go/util"
 )
@@ -130,19 +133,19 @@ func (a *Account) connect() (err error) {
 func (a *Account) pingPongFunc(connWg *sync.WaitGroup, ctx context.Context, err_chan chan int8) {
   defer connWg.Done()
 
-  if err := a.conn.SetReadDeadline(time.Now().Add(constant.READ_DEADLINE_SEC)); err != nil {
+  if err := a.conn.SetReadDeadline(time.Now().Add(config.C.ReadDeadline)); err != nil {
     util.Warning(err)
   }
 
   a.conn.SetPongHandler(func(string) error {
-    err := a.conn.SetReadDeadline(time.Now().Add(constant.READ_DEADLINE_SEC))
+    err := a.conn.SetReadDeadline(time.Now().Add(config.C.ReadDeadline))
     if err != nil {
       util.Warning(err)
     }
     return nil
   })
 
-  ticker := time.NewTicker(constant.PING_INTERVAL_SEC)
+  ticker := time.NewTicker(config.C.PingInterval)
   defer ticker.Stop()
   util.Ok("PingPong initiated for account websocket")
 
@@ -199,8 +202,9 @@ func (a *Account) start(wg *sync.WaitGroup, ctx context.Context, backoff_sec_min
         continue
       } else {
         NNP.NoNewPositionsTrue("")
-        util.Error(err, "Max retries reached", retries, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
+        util.Emergency(err, "Max retries reached", retries, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
         request.CloseAllPositions(2, 0)
+        util.Flush()
         log.Panicln("SHUTTING DOWN")
       }
     }
@@ -240,10 +244,12 @@ func (a *Account) start(wg *sync.WaitGroup, ctx context.Context, backoff_sec_min
         if err := a.conn.UnderlyingConn().Close(); err != nil {
           leaks++
           util.Error(err, "Letting go routine leak. Leak count:", leaks)
+          reconnects.Inc("account")
           continue
         }
       }
       connWg.Wait()
+      reconnects.Inc("account")
     }
   }
 }
@@ -257,31 +263,42 @@ type OrderUpdate struct {
   AssetQty       *decimal.Decimal
   FillTime       *time.Time
   FilledAvgPrice *float64
+  OrderID        *string
+  Leg            string  // "take_profit" or "stop_loss" for updates on bracket and OCO legs
 }
 
 func (a *Account) getEvent(data *fastjson.Value) *string {
   // Shutdown if nil
-  // Only handle fill, partial_fill and canceled events.
+  // Only handle fill, partial_fill and the events that end an order without filling
+  // it completely, which are canceled, expired and rejected. New events are only
+  // handled for bracket and OCO legs.
   // Other events are likely not relevant. https://alpaca.markets/docs/api-documentation/api-v2/streaming/
   event := data.GetStringBytes("event")
   if event == nil {
     NNP.NoNewPositionsTrue("")
-    util.Error(
+    util.Emergency(
       errors.New("EVENT NOT IN TRADE UPDATE"), "Parsed message", data.String(),
       "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...",
     )
     request.CloseAllPositions(2, 0)
+    util.Flush()
     log.Panicln("SHUTTING DOWN")
   }
 
   event_str := string(event)
-  if event_str != "fill" && event_str != "partial_fill" && event_str != "canceled" {
+  if event_str != "new" && event_str != "fill" && event_str != "partial_fill" && !canceledEvent(event_str) {
     return nil
   }
 
   return &event_str
 }
 
+// Events for orders that will not be filled any further. Resting limit and stop orders
+// expire at the end of the day when time in force is "day".
+func canceledEvent(event string) bool {
+  return event == "canceled" || event == "expired" || event == "rejected"
+}
+
 func (p *Account) getPositionID(order *fastjson.Value) *string {
   position_id := order.GetStringBytes("client_order_id")  // client_order_id == PositionID
   if position_id == nil {
@@ -316,16 +333,38 @@ func (a *Account) getStratName(order *fastjson.Value) *string {
   return grepStratName(position_id)
 }
 
+// Returns the strategy name of the position that has order_id as one of its legs.
+// Legs are not sent with our client order id, so they are looked up by order id.
+func (a *Account) legStratName(asset_class string, symbol string, order_id *string) *string {
+  if order_id == nil {
+    return nil
+  }
+  asset := a.asset(asset_class, symbol)
+  if asset == nil {
+    return nil
+  }
+  for strat_name, pos := range asset.positionsCopy() {
+    pos.Rwm.RLock()
+    leg := pos.legOf(*order_id) != "" || slices.Contains(pos.CanceledLegIDs, *order_id)
+    pos.Rwm.RUnlock()
+    if leg {
+      return &strat_name
+    }
+  }
+  return nil
+}
+
 func (a *Account) getAssetClass(order *fastjson.Value) *string {
   // Shutdown if nil
   asset_class := order.GetStringBytes("asset_class")
   if asset_class == nil {
     NNP.NoNewPositionsTrue("")
-    util.Error(
+    util.Emergency(
       errors.New("ASSET CLASS NOT IN TRADE UPDATE"), "Parsed message", order.String(),
       "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...",
     )
     request.CloseAllPositions(2, 0)
+    util.Flush()
     log.Panicln("SHUTTING DOWN")
   }
 
@@ -342,11 +381,12 @@ func (a *Account) getSymbol(order *fastjson.Value) *string {
   symbol := order.GetStringBytes("symbol")
   if symbol == nil {
     NNP.NoNewPositionsTrue("")
-    util.Error(
+    util.Emergency(
       errors.New("SYMBOL NOT IN TRADE UPDATE"), "Parsed message", order.String(),
       "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...",
     )
     request.CloseAllPositions(2, 0)
+    util.Flush()
     log.Panicln("SHUTTING DOWN")
   }
 
@@ -376,8 +416,9 @@ func (a *Account) getAssetQty(data *fastjson.Value) *decimal.Decimal {
   asset_qty_dec, err := decimal.NewFromString(string(asset_qty))
   if err != nil {
     NNP.NoNewPositionsTrue("")
-    util.Error(err, "Asset qty", asset_qty, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
+    util.Emergency(err, "Asset qty", asset_qty, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
     request.CloseAllPositions(2, 0)
+    util.Flush()
     log.Panicln("SHUTTING DOWN")
   }
 
@@ -421,14 +462,23 @@ func (a *Account) updateParser(pm *fastjson.Value) *OrderUpdate {
     return nil
   }
 
+  order_id := getString(order, "id")
   strat_name := a.getStratName(order)
+  var asset_class, symbol *string
+  if strat_name == nil || *event == "new" {
+    asset_class = a.getAssetClass(order)
+    symbol = a.getSymbol(order)
+    strat_name = a.legStratName(*asset_class, *symbol, order_id)
+  }
   if strat_name == nil {
     return nil
   }
+  if asset_class == nil {
+    asset_class = a.getAssetClass(order)
+    symbol = a.getSymbol(order)
+  }
 
   asset_qty := a.getAssetQty(data)
-  asset_class := a.getAssetClass(order)
-  symbol := a.getSymbol(order)
   side := a.getSide(order)
   fill_time := a.getFillTime(order)
   filled_avg_price := a.getFilledAvgPrice(order)
@@ -442,6 +492,7 @@ func (a *Account) updateParser(pm *fastjson.Value) *OrderUpdate {
     AssetQty:         asset_qty,
     FillTime:         fill_time,
     FilledAvgPrice:   filled_avg_price,
+    OrderID:          order_id,
   }
 }
 
@@ -450,16 +501,25 @@ func updateAssetQty(p *Position, a *Asset, u *OrderUpdate) {
   defer a.Rwm.Unlock()
 
   var position_change decimal.Decimal = (*u.AssetQty).Sub(a.Qty)
+  // Changes against the position are closes
+  if u.FilledAvgPrice != nil && position_change.Sign() * p.Qty.Sign() < 0 {
+    closed := decimal.Min(position_change.Abs(), p.Qty.Abs())
+    if p.Qty.IsNegative() {
+      closed = closed.Neg()
+    }
+    Risk.recordClose(closed, p.OpenFilledAvgPrice, *u.FilledAvgPrice, clockNow(p.AssetClass))
+  }
   p.Qty = p.Qty.Add(position_change)
   a.Qty = *u.AssetQty
 
   if !a.sumPosQtysEqAssetQty() {
-    util.Error(
+    util.Emergency(
       errors.New("Sum of position qty not equal to asset qty"),
       "Asset", a.Qty, "Position", p.Qty, "OrderUpdate", u,
       "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...",
     )
     request.CloseAllPositions(2, 0)
+    util.Flush()
     log.Fatal("SHUTTING DOWN")
   }
 }
@@ -473,19 +533,71 @@ func (a *Account) closeLogic(asset *Asset, pos *Position, u *OrderUpdate) {
     pos.CloseFillTime = *u.FillTime
   }
 
-  if *u.Event == "fill" || *u.Event == "canceled" {
+  if *u.Event == "fill" || canceledEvent(*u.Event) {
+    // A market close that was not completely filled is closed again at market.
+    // Other order types are left open when canceled, and the strategy decides
+    // whether to close again.
+    if pos.restingClose() && !pos.Qty.IsZero() {
+      if u.FilledAvgPrice != nil && *u.FilledAvgPrice > 0 {
+        a.db_chan <-pos.LogClose()
+      }
+      pos.CloseOrderPending = false
+      return
+    }
     a.db_chan <-pos.LogClose()
     if pos.Qty.IsZero() {
       asset.removePosition(*u.StratName)
     } else {
       asset.Mutex.Lock()
       pos.CloseOrderPending = false
-      asset.close("IOC", *u.StratName)
+      asset.close(IOC, *u.StratName)
       asset.Mutex.Unlock()
     }
   }
 }
 
+// Fills of a take profit or stop loss leg close the position without a close order
+// from the strategy. The other leg is canceled by the broker when one is filled.
+func (a *Account) legLogic(asset *Asset, pos *Position, u *OrderUpdate) {
+  switch {
+  case *u.Event == "new":
+    a.db_chan <-pos.LogLegs()
+    return
+  case canceledEvent(*u.Event):
+    if pos.CloseOrderPending && pos.CloseOrderType == u.Leg {
+      // Canceled after a partial fill
+      a.db_chan <-pos.LogClose()
+      pos.CloseOrderPending = false
+    }
+    if u.Leg == "take_profit" {
+      pos.TakeProfitOrderID = ""
+    } else {
+      pos.StopLossOrderID = ""
+    }
+    a.db_chan <-pos.LogLegs()
+    return
+  }
+
+  pos.CloseOrderPending = true
+  pos.CloseOrderType = u.Leg
+  if u.FilledAvgPrice != nil {
+    pos.CloseFilledAvgPrice = *u.FilledAvgPrice
+  }
+  if u.FillTime != nil {
+    pos.CloseFillTime = *u.FillTime
+  }
+
+  if *u.Event == "fill" {
+    pos.clearLegs()
+    a.db_chan <-pos.LogClose()
+    if pos.Qty.IsZero() {
+      asset.removePosition(*u.StratName)
+    } else {
+      pos.CloseOrderPending = false
+    }
+  }
+}
+
 func (a *Account) openLogic(asset *Asset, pos *Position, u *OrderUpdate) {
   if u.FilledAvgPrice != nil {
     pos.OpenFilledAvgPrice = *u.FilledAvgPrice
@@ -495,7 +607,7 @@ func (a *Account) openLogic(asset *Asset, pos *Position, u *OrderUpdate) {
     pos.OpenFillTime = *u.FillTime
   }
 
-  if *u.Event == "fill" || *u.Event == "canceled" {
+  if *u.Event == "fill" || canceledEvent(*u.Event) {
     if pos.Qty.IsZero() {
       asset.removePosition(*u.StratName)
     } else {
@@ -505,17 +617,25 @@ func (a *Account) openLogic(asset *Asset, pos *Position, u *OrderUpdate) {
   }
 }
 
+// Looks up an asset while symbols may be added or removed. Nil if not found.
+func (a *Account) asset(asset_class string, symbol string) *Asset {
+  globRwm.RLock()
+  defer globRwm.RUnlock()
+  return a.assets[asset_class][symbol]
+}
+
 func (a *Account) reconnectDiff(u *OrderUpdate) {
-  asset := a.assets[*u.AssetClass][*u.Symbol]
+  asset := a.asset(*u.AssetClass, *u.Symbol)
   asset.Rwm.Lock()
   defer asset.Rwm.Unlock()
 
   if !asset.sumPosQtysEqAssetQty() {
     NNP.NoNewPositionsTrue("")
-    util.Error(errors.New("Position quantities do not sum to asset qty"),
+    util.Emergency(errors.New("Position quantities do not sum to asset qty"),
       "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...",
     )
     request.CloseAllPositions(2, 0)
+    util.Flush()
     log.Panicln("SHUTTING DOWN")
   }
 }
@@ -526,16 +646,20 @@ func (a *Account) orderUpdateHandler(u *OrderUpdate) {
     return
   }
 
-  var asset = a.assets[*u.AssetClass][*u.Symbol]
-  var pos *Position = asset.Positions[*u.StratName]
+  var asset = a.asset(*u.AssetClass, *u.Symbol)
+  var pos *Position
+  if asset != nil {
+    pos = asset.Positions[*u.StratName]
+  }
 
   if pos == nil {
-    util.Error(errors.New("Position nil"),
+    util.Emergency(errors.New("Position nil"),
       "Symbol", *u.Symbol,
       "StratName", *u.StratName,
       "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...",
     )
     request.CloseAllPositions(2, 0)
+    util.Flush()
     log.Panicln("SHUTTING DOWN")
   }
 
@@ -546,7 +670,20 @@ func (a *Account) orderUpdateHandler(u *OrderUpdate) {
     updateAssetQty(pos, asset, u)
   }
 
-  if pos.OpenOrderPending {
+  if u.OrderID != nil {
+    u.Leg = pos.legOf(*u.OrderID)
+    // Legs canceled by closeFunc are no longer stored, and must not be taken for
+    // the close
+    if u.Leg == "" && slices.Contains(pos.CanceledLegIDs, *u.OrderID) {
+      return
+    }
+  }
+
+  if u.Leg != "" {
+    a.legLogic(asset, pos, u)
+  } else if *u.Event == "new" {
+    return
+  } else if pos.OpenOrderPending {
     a.openLogic(asset, pos, u)
   } else if pos.CloseOrderPending {
     a.closeLogic(asset, pos, u)
@@ -607,8 +744,9 @@ func getFilledQty(co *fastjson.Value) *decimal.Decimal {
   dec, err := decimal.NewFromString(string(byte))
   if err != nil {
     NNP.NoNewPositionsTrue("")
-    util.Error(err, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
+    util.Emergency(err, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
     request.CloseAllPositions(2, 0)
+    util.Flush()
     log.Panicln("SHUTTING DOWN")
   }
 
@@ -651,10 +789,17 @@ func (a *Account) parseClosedOrders(relevant map[string][]*fastjson.Value) map[s
   return parsed
 }
 
+// Diff is the signed qty to offset. A positive diff is sold and a negative diff is bought back.
 func (a *Account) sendCloseGTC(diff decimal.Decimal, symbol string, backoff_sec float64) {
+  side := "sell"
+  if diff.IsNegative() {
+    side = "buy"
+  }
   retries := 0
+  // Unique per reconciliation, and kept on retries so that the order is sent once
+  close_id := "strat[reconnect_multiple_diff]_time[" + time.Now().UTC().Format(time.RFC3339Nano) + "]_close"
   for {
-    body, status, err := request.CloseGTC("sell", symbol, "strat[reconnect_multiple_diff]", diff)
+    body, status, err := request.CloseGTC(side, symbol, close_id, diff.Abs())
     if err != nil {
       util.Error(err, "Failed to send close order", "...")
     }
@@ -680,11 +825,12 @@ func (a *Account) sendCloseGTC(diff decimal.Decimal, symbol string, backoff_sec
       )
       util.Backoff(&backoff_sec)
     }
-    if retries >= constant.REQUEST_RETRIES {
-      util.Error(errors.New("max retries reached"),
+    if retries >= config.C.RequestRetries {
+      util.Emergency(errors.New("max retries reached"),
         "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...",
       )
       request.CloseAllPositions(2, 0)
+      util.Flush()
       log.Panicln("SHUTTING DOWN")
     }
     retries++
@@ -711,7 +857,9 @@ func (a *Account) multiple(parsed []*ParsedClosedOrder, asset_class string) {
   }
 }
 
-func (a *Account) diffPositive(diff decimal.Decimal, asset_class string, parsed []*ParsedClosedOrder) {
+// The open order was filled while disconnected. Diff is the signed change in asset
+// qty, which is negative for short positions.
+func (a *Account) openFilled(diff decimal.Decimal, asset_class string, parsed []*ParsedClosedOrder) {
   pco := parsed[0]
   asset := a.assets[asset_class][*pco.Symbol]
   pos := asset.Positions[*pco.StratName]
@@ -723,17 +871,23 @@ func (a *Account) diffPositive(diff decimal.Decimal, asset_class string, parsed
   a.db_chan <-pos.LogOpen()
 }
 
-func (a *Account) diffNegative(diff decimal.Decimal, asset_class string, parsed []*ParsedClosedOrder) {
+// The close order was filled while disconnected. The position is removed if the
+// diff offsets the whole position, otherwise the remainder is closed.
+func (a *Account) closeFilled(diff decimal.Decimal, asset_class string, parsed []*ParsedClosedOrder) {
   pco := parsed[0]
   asset := a.assets[asset_class][*pco.Symbol]
   pos := asset.Positions[*pco.StratName]
   pos.BadForAnalysis = true
-  if !diff.Abs().Equal(pos.Qty) {
+  // Either the close was sent after the legs were canceled, or one of the legs was
+  // filled and the broker canceled the other.
+  pos.clearLegs()
+  Risk.recordClose(diff.Neg(), pos.OpenFilledAvgPrice, *pco.FilledAvgPrice, *pco.FillTime)
+  if !diff.Neg().Equal(pos.Qty) {
     pos.Qty = pos.Qty.Add(diff)
     pos.CloseOrderPending = false
     a.db_chan <-pos.LogClose()
     asset.Mutex.Lock()
-    asset.close("IOC", pos.StratName)
+    asset.close(IOC, pos.StratName)
     asset.Mutex.Unlock()
     return
   }
@@ -744,11 +898,12 @@ func (a *Account) diffNegative(diff decimal.Decimal, asset_class string, parsed
   asset.removePosition(*pco.StratName)
 }
 
-func (a *Account) diffZero(asset_class string, parsed []*ParsedClosedOrder) {
+// The pending order was closed without changing the asset qty.
+func (a *Account) notFilled(asset_class string, parsed []*ParsedClosedOrder) {
   pco := parsed[0]
   asset := a.assets[asset_class][*pco.Symbol]
   pos := asset.Positions[*pco.StratName]
-  if *pco.Side == "buy" {
+  if pos.OpenOrderPending {
     asset.removePosition(*pco.StratName)
     return
   }
@@ -756,7 +911,7 @@ func (a *Account) diffZero(asset_class string, parsed []*ParsedClosedOrder) {
   pos.CloseOrderPending = false
   a.db_chan <-pos.LogClose()
   asset.Mutex.Lock()
-  asset.close("IOC", pos.StratName)
+  asset.close(IOC, pos.StratName)
   asset.Mutex.Unlock()
 }
 
@@ -764,13 +919,18 @@ func (a *Account) updatePositions(parsed map[string][]*ParsedClosedOrder) {
   qtys, err := request.GetAssetQtys()
   if err != nil {
     NNP.NoNewPositionsTrue("")
-    util.Error(err, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
+    util.Emergency(err, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
     request.CloseAllPositions(2, 0)
+    util.Flush()
     log.Panicln("SHUTTING DOWN")
   }
 
   for asset_class := range a.assets {
     for symbol, pcos := range parsed {
+      if _, ok := a.assets[asset_class][symbol]; !ok {
+        continue
+      }
+
       if len(pcos) > 1 {
         a.multiple(pcos, asset_class)
         continue
@@ -779,13 +939,16 @@ func (a *Account) updatePositions(parsed map[string][]*ParsedClosedOrder) {
       diff := qtys[symbol].Sub((*a.assets[asset_class][symbol]).Qty)
       a.assets[asset_class][symbol].Qty = qtys[symbol]
 
+      // Whether the diff is an open or close depends on the pending order and not
+      // the sign of the diff, since short positions have negative qtys.
+      pos := a.assets[asset_class][symbol].Positions[*pcos[0].StratName]
       switch {
-      case diff.IsPositive():
-        a.diffPositive(diff, asset_class, pcos)
-      case diff.IsNegative():
-        a.diffNegative(diff, asset_class, pcos)
+      case diff.IsZero():
+        a.notFilled(asset_class, pcos)
+      case pos.OpenOrderPending:
+        a.openFilled(diff, asset_class, pcos)
       default:
-        a.diffZero(asset_class, pcos)
+        a.closeFilled(diff, asset_class, pcos)
       }
     }
   }
@@ -806,11 +969,20 @@ func (a *Account) filterRelevantOrders(arr []*fastjson.Value, pending map[string
         } else if symbol == nil {
           util.Warning(errors.New("symbol not found"), nil)
           continue
-        } else if string(fill_time) == "null" {
+        } else if fill_time == nil || string(fill_time) == "null" {
           continue
         }
 
-        if string(position_id) == pos.PositionID {
+        if string(position_id) == pos.PositionID || strings.HasPrefix(string(position_id), pos.PositionID + "_close") {
+          relevant[string(symbol)] = append(relevant[string(symbol)], m)
+          break
+        }
+
+        // Legs have client order ids generated by the broker, so the position id is
+        // set to route them to the position like its other orders.
+        if pos.legOf(string(m.GetStringBytes("id"))) != "" {
+          var arena fastjson.Arena
+          m.Set("client_order_id", arena.NewString(pos.PositionID))
           relevant[string(symbol)] = append(relevant[string(symbol)], m)
           break
         }
@@ -826,6 +998,9 @@ func (a *Account) checkPending() {
   defer globRwm.RUnlock()
   
   pending := pendingOrders(a.assets)
+  for symbol, positions := range positionsWithLegs(a.assets) {
+    pending[symbol] = append(pending[symbol], positions...)
+  }
   if len(pending) == 0 {
     util.Ok("No pending orders")
     return
@@ -834,8 +1009,9 @@ func (a *Account) checkPending() {
   arr, err := request.GetClosedOrders(positionsSymbols(pending), 5, 0)
   if err != nil {
     NNP.NoNewPositionsTrue("")
-    util.Error(err, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
+    util.Emergency(err, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
     request.CloseAllPositions(2, 0)
+    util.Flush()
     log.Panicln("SHUTTING DOWN")
   }
 
@@ -16,7 +16,7 @@ import (
   "github.com/valyala/fastjson"
   "github.com/Kjellemann1/AlgoTrader-Go/request"
   "github.com/Kjellemann1/AlgoTrader-Go/push"
-  "github.com/Kjellemann1/AlgoTrader-Go/constant"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
   "github.com/Kjellemann1/AlgoTrader-Go/util"
 )
 
@@ -172,8 +172,8 @@ func init() {
   util.Error = func(err error, details ...any) {}
   util.Warning = func(err error, details ...any) {}
   util.Ok = func(message string) {}
-  util.Open = func(message string) {}
-  util.Close = func(message string) {}
+  util.Open = func(message string, details ...any) {}
+  util.Close = func(message string, details ...any) {}
 
   push.DisablePush()
 }
@@ -211,6 +211,7 @@ func TestFilterAndParse(t *testing.T) {
 
   t.Run("parseClosedOrders", func(t *testing.T) {
     globRwm.Lock()
+    defer globRwm.Unlock()
     parsed := a.parseClosedOrders(orders)
 
     assert.Equal(t, 2, len(parsed))
@@ -240,7 +241,7 @@ func TestSendCloseGTC(t *testing.T) {
         ret = &http.Response{ StatusCode: 200, Body: io.NopCloser(strings.NewReader(`[{"id":1}]`)) }
       } else if iter == 2 {
         ret = &http.Response{ StatusCode: 429, Body: nil }
-      } else if iter == constant.REQUEST_RETRIES + 5 {
+      } else if iter == config.C.RequestRetries + 5 {
         ret = &http.Response{ StatusCode: 200, Body: nil }
       } else {
         err = errors.New("error")
@@ -389,7 +390,7 @@ func TestUpdatePositions(t *testing.T) {
       a.assets["crypto"]["BTC/USD"] = &Asset{
         Symbol: "BTC/USD", 
         Positions: make(map[string]*Position),
-        close: func(string, string) {},
+        close: func(request.OrderParams, string) {},
       }
       btc := a.assets["crypto"]["BTC/USD"]
       btc.Qty, _ = decimal.NewFromString("0.020573338")
@@ -434,7 +435,7 @@ func TestUpdatePositions(t *testing.T) {
       a.assets["crypto"]["BTC/USD"] = &Asset{
         Symbol: "BTC/USD",
         Positions: make(map[string]*Position),
-        close: func(string, string) {},
+        close: func(request.OrderParams, string) {},
       }
       btc := a.assets["crypto"]["BTC/USD"]
       btc.Qty, _ = decimal.NewFromString("0.010573338")
@@ -468,7 +469,7 @@ func TestUpdatePositions(t *testing.T) {
       a.assets["crypto"]["BTC/USD"] = &Asset{
         Symbol: "BTC/USD",
         Positions: make(map[string]*Position),
-        close: func(string, string) {},
+        close: func(request.OrderParams, string) {},
       }
       btc := a.assets["crypto"]["BTC/USD"]
       btc.Qty, _ = decimal.NewFromString("0.010573338")
@@ -504,6 +505,183 @@ func TestUpdatePositions(t *testing.T) {
   })
 }
 
+func TestUpdatePositionsShort(t *testing.T) {
+  request.HttpClient = &http.Client{
+    Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+      body := `[{"symbol": "FOO", "asset_class": "us_equity", "qty": "-10", "side": "short"}]`
+      return &http.Response{ StatusCode: 200, Body: io.NopCloser(strings.NewReader(body)) }, nil
+    }),
+  }
+
+  newShortAccount := func() (*Account, *Asset) {
+    a := &Account{
+      assets: make(map[string]map[string]*Asset),
+      db_chan: make(chan *Query, 1),
+    }
+    a.assets["stock"] = make(map[string]*Asset)
+    a.assets["stock"]["FOO"] = &Asset{
+      Symbol: "FOO",
+      Positions: make(map[string]*Position),
+      close: func(request.OrderParams, string) {},
+    }
+    return a, a.assets["stock"]["FOO"]
+  }
+
+  strat1 := "rand1"
+  fill_price := 10.0
+  symbol := "FOO"
+  parsed := map[string][]*ParsedClosedOrder{ "FOO": {
+    { StratName: &strat1, Symbol: &symbol, FilledAvgPrice: &fill_price, FillTime: &time.Time{} },
+  }}
+
+  t.Run("Open short filled", func(t *testing.T) {
+    a, foo := newShortAccount()
+    foo.Positions[strat1] = &Position{ OpenOrderPending: true, OpenSide: "short" }
+
+    a.updatePositions(parsed)
+
+    assert.True(t, decimal.NewFromInt(-10).Equal(foo.Qty))
+    assert.True(t, decimal.NewFromInt(-10).Equal(foo.Positions[strat1].Qty))
+    assert.False(t, foo.Positions[strat1].OpenOrderPending)
+    assert.Equal(t, "open", (<-a.db_chan).Action)
+  })
+
+  t.Run("Close short filled", func(t *testing.T) {
+    a, foo := newShortAccount()
+    foo.Qty = decimal.NewFromInt(-20)
+    foo.Positions[strat1] = &Position{ CloseOrderPending: true, OpenSide: "short", Qty: decimal.NewFromInt(-10) }
+
+    a.updatePositions(parsed)
+
+    assert.True(t, decimal.NewFromInt(-10).Equal(foo.Qty))
+    assert.Nil(t, foo.Positions[strat1])
+    query := <-a.db_chan
+    assert.Equal(t, "close", query.Action)
+    assert.Equal(t, "buy", query.Side)
+  })
+}
+
+func TestSendCloseGTCSide(t *testing.T) {
+  var payload string
+  request.HttpClient = &http.Client{
+    Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+      body, _ := io.ReadAll(req.Body)
+      payload = string(body)
+      return &http.Response{ StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{}`)) }, nil
+    }),
+  }
+
+  a := &Account{}
+  a.sendCloseGTC(decimal.NewFromInt(-3), "FOO", 0)
+  assert.Contains(t, payload, `"side": "buy"`)
+  assert.Contains(t, payload, `"qty": "3"`)
+
+  a.sendCloseGTC(decimal.NewFromInt(3), "FOO", 0)
+  assert.Contains(t, payload, `"side": "sell"`)
+  assert.Contains(t, payload, `"qty": "3"`)
+}
+
+func TestCloseLogicRestingOrder(t *testing.T) {
+  var closes int
+  a := &Account{db_chan: make(chan *Query, 2)}
+  asset := &Asset{
+    Symbol: "FOO",
+    Positions: make(map[string]*Position),
+    close: func(request.OrderParams, string) { closes++ },
+  }
+  pos := &Position{
+    CloseOrderPending: true, Qty: decimal.NewFromInt(10), OpenSide: "long",
+    CloseParams: request.Limit(12, "day"),
+  }
+  asset.Positions["foo"] = pos
+
+  event := "expired"
+  strat := "foo"
+  a.closeLogic(asset, pos, &OrderUpdate{Event: &event, StratName: &strat})
+  assert.False(t, pos.CloseOrderPending)
+  assert.Equal(t, 0, closes, "Resting closes are not sent again at market")
+  assert.Equal(t, 0, len(a.db_chan), "Nothing filled, nothing logged")
+  assert.NotNil(t, asset.Positions["foo"])
+
+  pos.CloseOrderPending = true
+  pos.CloseParams = IOC
+  event = "canceled"
+  a.closeLogic(asset, pos, &OrderUpdate{Event: &event, StratName: &strat})
+  assert.Equal(t, 1, closes, "Market closes are sent again")
+  assert.Equal(t, 1, len(a.db_chan))
+}
+
+func TestLegFillAttribution(t *testing.T) {
+  a := &Account{
+    assets: map[string]map[string]*Asset{"stock": {}},
+    db_chan: make(chan *Query, 10),
+  }
+  foo := &Asset{Symbol: "FOO", Class: "stock", Positions: make(map[string]*Position), Qty: decimal.NewFromInt(5)}
+  a.assets["stock"]["FOO"] = foo
+  pos := &Position{
+    Symbol: "FOO", StratName: "strat1", OpenSide: "long", Qty: decimal.NewFromInt(5),
+    TakeProfitOrderID: "tp", StopLossOrderID: "sl",
+  }
+  foo.Positions["strat1"] = pos
+
+  update := func(event string, order_id string, position_qty string) *OrderUpdate {
+    msg := `{"stream": "trade_updates", "data": {"event": "` + event + `", "position_qty": "` + position_qty + `", ` +
+      `"order": {"id": "` + order_id + `", "client_order_id": "5f1d0a3e", "symbol": "FOO", "asset_class": "us_equity", ` +
+      `"side": "sell", "filled_avg_price": "11", "filled_at": "2025-01-02T15:00:00Z"}}}`
+    return a.updateParser(fastjson.MustParse(msg))
+  }
+
+  assert.Nil(t, update("fill", "unknown", "0"), "Orders without strategy and not a leg are ignored")
+
+  u := update("new", "sl", "5")
+  assert.NotNil(t, u)
+  a.orderUpdateHandler(u)
+  assert.Equal(t, "legs", (<-a.db_chan).Action)
+
+  u = update("partial_fill", "tp", "2")
+  assert.Equal(t, "strat1", *u.StratName)
+  a.orderUpdateHandler(u)
+  assert.True(t, pos.CloseOrderPending)
+  assert.True(t, decimal.NewFromInt(2).Equal(pos.Qty))
+
+  a.orderUpdateHandler(update("fill", "tp", "0"))
+  query := <-a.db_chan
+  assert.Equal(t, "close", query.Action)
+  assert.Equal(t, "take_profit", query.OrderType)
+  assert.Equal(t, 11.0, query.FilledAvgPrice)
+  assert.Nil(t, foo.Positions["strat1"])
+
+  assert.Nil(t, update("canceled", "sl", "0"), "Canceled leg of a closed position is ignored")
+}
+
+func TestCanceledLegIgnored(t *testing.T) {
+  var closes int
+  a := &Account{
+    assets: map[string]map[string]*Asset{"stock": {}},
+    db_chan: make(chan *Query, 10),
+  }
+  foo := &Asset{
+    Symbol: "FOO", Class: "stock", Positions: make(map[string]*Position), Qty: decimal.NewFromInt(5),
+    close: func(request.OrderParams, string) { closes++ },
+  }
+  a.assets["stock"]["FOO"] = foo
+  pos := &Position{
+    Symbol: "FOO", StratName: "strat1", OpenSide: "long", Qty: decimal.NewFromInt(5),
+    CloseOrderPending: true, CloseParams: IOC, CanceledLegIDs: []string{"tp", "sl"},
+  }
+  foo.Positions["strat1"] = pos
+
+  msg := `{"stream": "trade_updates", "data": {"event": "canceled", "position_qty": "5", ` +
+    `"order": {"id": "sl", "client_order_id": "5f1d0a3e", "symbol": "FOO", "asset_class": "us_equity", "side": "sell"}}}`
+  u := a.updateParser(fastjson.MustParse(msg))
+  assert.NotNil(t, u)
+  assert.Equal(t, "strat1", *u.StratName)
+  a.orderUpdateHandler(u)
+  assert.Equal(t, 0, closes, "Canceled legs are not taken for the close")
+  assert.True(t, pos.CloseOrderPending)
+  assert.Equal(t, 0, len(a.db_chan))
+}
+
 var getClosedOrdersResponse = `[
   {
     "id": "a7c58468-1069-4e5f-a9c6-cfbd5f93c113",
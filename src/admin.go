@@ -0,0 +1,143 @@
+// Admin commands for changing the running trader, read line by line from the unix
+// socket set with admin_socket, e.g.
+//
+//   echo "add AAPL" | nc -U algotrader.sock
+//
+// Commands:
+//   add <symbol>     Backfills and subscribes to the symbol
+//   remove <symbol>  Closes the positions of the symbol and unsubscribes from it
+//   symbols          Lists the subscribed symbols
+//
+// Symbols can only be added to asset classes that were subscribed to at startup.
+// The same commands and more are served over HTTP when admin_addr is set, see
+// admin_http.go.
+
+package main
+
+import (
+  "os"
+  "fmt"
+  "net"
+  "sync"
+  "bufio"
+  "errors"
+  "strings"
+  "context"
+  "github.com/Kjellemann1/AlgoTrader-Go/util"
+)
+
+type Admin struct {
+  markets   map[string]*Market  // By asset class
+  shutdown  *Shutdown
+  wg        *sync.WaitGroup
+  ctx       context.Context
+}
+
+func NewAdmin(markets map[string]*Market, shutdown *Shutdown, wg *sync.WaitGroup, ctx context.Context) *Admin {
+  return &Admin{markets: markets, shutdown: shutdown, wg: wg, ctx: ctx}
+}
+
+// Market of the asset class of the symbol
+func (ad *Admin) market(symbol string) (*Market, error) {
+  asset_class, err := symbolClass(symbol)
+  if err != nil {
+    return nil, err
+  }
+  m, ok := ad.markets[asset_class]
+  if !ok {
+    return nil, fmt.Errorf("No %s symbols subscribed at startup", asset_class)
+  }
+  return m, nil
+}
+
+func (ad *Admin) addSymbol(symbol string) error {
+  m, err := ad.market(symbol)
+  if err != nil {
+    return err
+  }
+  return m.addSymbol(symbol)
+}
+
+func (ad *Admin) removeSymbol(symbol string) error {
+  m, err := ad.market(symbol)
+  if err != nil {
+    return err
+  }
+  return m.removeSymbol(ad.wg, ad.ctx, symbol, true)
+}
+
+// Subscribed symbols by asset class
+func (ad *Admin) symbols() map[string][]string {
+  symbols := make(map[string][]string)
+  for asset_class, m := range ad.markets {
+    symbols[asset_class] = m.symbols()
+  }
+  return symbols
+}
+
+// Runs a command and returns the reply
+func (ad *Admin) exec(line string) string {
+  fields := strings.Fields(line)
+  if len(fields) == 0 {
+    return "error: Empty command"
+  }
+  var err error
+  switch {
+  case fields[0] == "add" && len(fields) == 2:
+    err = ad.addSymbol(fields[1])
+  case fields[0] == "remove" && len(fields) == 2:
+    if err = ad.removeSymbol(fields[1]); err == nil {
+      return "ok: Removing " + fields[1] + " once its positions are closed"
+    }
+  case fields[0] == "symbols" && len(fields) == 1:
+    symbols := ad.symbols()
+    var lines []string
+    for _, asset_class := range []string{"stock", "crypto"} {
+      if list, ok := symbols[asset_class]; ok {
+        lines = append(lines, asset_class + ": " + strings.Join(list, " "))
+      }
+    }
+    return strings.Join(lines, "\n")
+  default:
+    err = errors.New("Unknown command. Use add <symbol>, remove <symbol> or symbols.")
+  }
+  if err != nil {
+    return "error: " + err.Error()
+  }
+  return "ok"
+}
+
+// Accepts connections on the socket until ctx is canceled
+func (ad *Admin) listen(wg *sync.WaitGroup, path string) {
+  defer wg.Done()
+  // Left behind if the last run did not shut down cleanly
+  _ = os.Remove(path)
+  ln, err := net.Listen("unix", path)
+  if err != nil {
+    util.Warning(err, "Details", "Admin socket not started")
+    return
+  }
+  go func() {
+    <-ad.ctx.Done()
+    ln.Close()
+  }()
+  util.Ok("Admin commands on " + path)
+  for {
+    conn, err := ln.Accept()
+    if err != nil {
+      return
+    }
+    ad.serve(conn)
+  }
+}
+
+// Commands are handled one at a time, as adding a symbol backfills it first
+func (ad *Admin) serve(conn net.Conn) {
+  defer conn.Close()
+  scanner := bufio.NewScanner(conn)
+  for scanner.Scan() {
+    if _, err := fmt.Fprintln(conn, ad.exec(scanner.Text())); err != nil {
+      return
+    }
+  }
+}
@@ -0,0 +1,331 @@
+// HTTP admin API for inspecting and controlling the running trader, served on
+// admin_addr. Requests must carry admin_token as a bearer token if it is set.
+// Without a token the API is served on loopback only, and requests other than GET
+// must carry the X-Admin-Request header, so that webpages open on the host can not
+// send them cross origin. Symbols are passed as query parameters, as crypto symbols
+// contain a slash.
+//
+//   GET    /assets                            Subscribed assets with their last close
+//   GET    /window?symbol=<symbol>&n=<n>      The last n minutes of the rolling window
+//   GET    /positions                         Open positions
+//   GET    /symbols                           Subscribed symbols by asset class
+//   POST   /symbols?symbol=<symbol>           Backfills and subscribes to the symbol
+//   DELETE /symbols?symbol=<symbol>           Closes the positions of the symbol and unsubscribes
+//   GET    /no-new-positions                  Whether opening positions is paused
+//   POST   /no-new-positions?enabled=<bool>   Pauses or resumes opening positions
+//   POST   /close?symbol=<symbol>&strategy=<name>  Closes a single position at market
+//   POST   /close-all                         Closes all positions at market
+//   POST   /save-state                        Saves the positions to the database
+//   POST   /shutdown?mode=<policy>            Runs a shutdown policy other than prompt, see shutdown.go
+//   POST   /shutdown?mode=close_all&clear_table=<bool>  Also clears the positions table
+
+package main
+
+import (
+  "fmt"
+  "net"
+  "sync"
+  "time"
+  "errors"
+  "slices"
+  "strconv"
+  "context"
+  "net/http"
+  "crypto/subtle"
+  "encoding/json"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/util"
+)
+
+type assetView struct {
+  Symbol     string     `json:"symbol"`
+  Class      string     `json:"class"`
+  Time       time.Time  `json:"time"`
+  Close      float64    `json:"close"`
+  Qty        string     `json:"qty"`
+  Positions  int        `json:"positions"`
+  Retiring   bool       `json:"retiring"`
+}
+
+type windowView struct {
+  Symbol  string       `json:"symbol"`
+  Time    time.Time    `json:"time"`  // Time of the last minute
+  O       []float64    `json:"o"`
+  H       []float64    `json:"h"`
+  L       []float64    `json:"l"`
+  C       []float64    `json:"c"`
+  V       []float64    `json:"v"`
+}
+
+type positionView struct {
+  Symbol            string     `json:"symbol"`
+  Strategy          string     `json:"strategy"`
+  Side              string     `json:"side"`
+  Qty               string     `json:"qty"`
+  OpenFillTime      time.Time  `json:"open_fill_time"`
+  OpenPrice         float64    `json:"open_price"`
+  OpenOrderPending  bool       `json:"open_order_pending"`
+  CloseOrderPending bool       `json:"close_order_pending"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  _ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+  writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (ad *Admin) asset(symbol string) (*Asset, error) {
+  m, err := ad.market(symbol)
+  if err != nil {
+    return nil, err
+  }
+  a := m.asset(symbol)
+  if a == nil {
+    return nil, fmt.Errorf("%s not subscribed", symbol)
+  }
+  return a, nil
+}
+
+// Subscribed assets sorted by asset class and symbol
+func (ad *Admin) assets() []*Asset {
+  var assets []*Asset
+  for _, asset_class := range []string{"stock", "crypto"} {
+    m, ok := ad.markets[asset_class]
+    if !ok {
+      continue
+    }
+    for _, symbol := range m.symbols() {
+      if a := m.asset(symbol); a != nil {
+        assets = append(assets, a)
+      }
+    }
+  }
+  return assets
+}
+
+func (ad *Admin) getAssets(w http.ResponseWriter, r *http.Request) {
+  views := []assetView{}
+  for _, a := range ad.assets() {
+    a.Rwm.RLock()
+    views = append(views, assetView{
+      Symbol: a.Symbol,
+      Class: a.Class,
+      Time: a.Time,
+      Close: a.C[len(a.C)-1],
+      Qty: a.Qty.String(),
+      Positions: len(a.Positions),
+      Retiring: a.retiring.Load(),
+    })
+    a.Rwm.RUnlock()
+  }
+  writeJSON(w, http.StatusOK, views)
+}
+
+func (ad *Admin) getWindow(w http.ResponseWriter, r *http.Request) {
+  a, err := ad.asset(r.URL.Query().Get("symbol"))
+  if err != nil {
+    writeError(w, http.StatusNotFound, err)
+    return
+  }
+  n := 10
+  if s := r.URL.Query().Get("n"); s != "" {
+    if n, err = strconv.Atoi(s); err != nil || n < 1 {
+      writeError(w, http.StatusBadRequest, errors.New("n must be a positive integer"))
+      return
+    }
+  }
+  // Copied, since the windows are rolled in place
+  a.Rwm.RLock()
+  from := max(len(a.C) - n, 0)
+  view := windowView{
+    Symbol: a.Symbol,
+    Time: a.Time,
+    O: slices.Clone(a.O[from:]),
+    H: slices.Clone(a.H[from:]),
+    L: slices.Clone(a.L[from:]),
+    C: slices.Clone(a.C[from:]),
+    V: slices.Clone(a.V[from:]),
+  }
+  a.Rwm.RUnlock()
+  writeJSON(w, http.StatusOK, view)
+}
+
+func (ad *Admin) getPositions(w http.ResponseWriter, r *http.Request) {
+  views := []positionView{}
+  for _, a := range ad.assets() {
+    for strat_name, pos := range a.positionsCopy() {
+      pos.Rwm.RLock()
+      views = append(views, positionView{
+        Symbol: a.Symbol,
+        Strategy: strat_name,
+        Side: pos.OpenSide,
+        Qty: pos.Qty.String(),
+        OpenFillTime: pos.OpenFillTime,
+        OpenPrice: pos.OpenFilledAvgPrice,
+        OpenOrderPending: pos.OpenOrderPending,
+        CloseOrderPending: pos.CloseOrderPending,
+      })
+      pos.Rwm.RUnlock()
+    }
+  }
+  writeJSON(w, http.StatusOK, views)
+}
+
+func (ad *Admin) getSymbols(w http.ResponseWriter, r *http.Request) {
+  writeJSON(w, http.StatusOK, ad.symbols())
+}
+
+func (ad *Admin) postSymbol(w http.ResponseWriter, r *http.Request) {
+  if err := ad.addSymbol(r.URL.Query().Get("symbol")); err != nil {
+    writeError(w, http.StatusBadRequest, err)
+    return
+  }
+  writeJSON(w, http.StatusOK, ad.symbols())
+}
+
+func (ad *Admin) deleteSymbol(w http.ResponseWriter, r *http.Request) {
+  if err := ad.removeSymbol(r.URL.Query().Get("symbol")); err != nil {
+    writeError(w, http.StatusBadRequest, err)
+    return
+  }
+  // Dropped once its positions are closed
+  writeJSON(w, http.StatusAccepted, ad.symbols())
+}
+
+func (ad *Admin) getNoNewPositions(w http.ResponseWriter, r *http.Request) {
+  NNP.rwm.RLock()
+  defer NNP.rwm.RUnlock()
+  writeJSON(w, http.StatusOK, map[string]bool{"no_new_positions": NNP.Flag})
+}
+
+// Only clears the pause set here, so positions stay paused while other reasons hold
+func (ad *Admin) postNoNewPositions(w http.ResponseWriter, r *http.Request) {
+  enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+  if err != nil {
+    writeError(w, http.StatusBadRequest, errors.New("enabled must be true or false"))
+    return
+  }
+  if enabled {
+    NNP.NoNewPositionsTrue("Admin")
+  } else {
+    NNP.NoNewPositionsFalse("Admin")
+  }
+  ad.getNoNewPositions(w, r)
+}
+
+func (ad *Admin) postClose(w http.ResponseWriter, r *http.Request) {
+  a, err := ad.asset(r.URL.Query().Get("symbol"))
+  if err != nil {
+    writeError(w, http.StatusNotFound, err)
+    return
+  }
+  if err := a.closePosition(r.URL.Query().Get("strategy"), "Closed by admin"); err != nil {
+    writeError(w, http.StatusConflict, err)
+    return
+  }
+  writeJSON(w, http.StatusAccepted, map[string]string{})
+}
+
+func (ad *Admin) postCloseAll(w http.ResponseWriter, r *http.Request) {
+  left := 0
+  for _, a := range ad.assets() {
+    left += a.closeAll("Closed by admin")
+  }
+  // Positions with orders pending are not closed
+  writeJSON(w, http.StatusAccepted, map[string]int{"positions": left})
+}
+
+func (ad *Admin) postSaveState(w http.ResponseWriter, r *http.Request) {
+  // The database stops after the shutdown saves
+  if ad.shutdown.started.Load() {
+    writeError(w, http.StatusConflict, errors.New("Shutting down"))
+    return
+  }
+  if !ad.shutdown.sendQuery(&Query{Action: "save_state"}) {
+    writeError(w, http.StatusServiceUnavailable, errors.New("Database queue full or stopped"))
+    return
+  }
+  writeJSON(w, http.StatusAccepted, map[string]string{})
+}
+
+func (ad *Admin) postShutdown(w http.ResponseWriter, r *http.Request) {
+  policy := r.URL.Query().Get("mode")
+  if policy == "prompt" || !slices.Contains(config.ShutdownPolicies, policy) {
+    writeError(w, http.StatusBadRequest, fmt.Errorf("mode must be one of %v except prompt", config.ShutdownPolicies))
+    return
+  }
+  clear_table, _ := strconv.ParseBool(r.URL.Query().Get("clear_table"))
+  f := func() {
+    ad.shutdown.run(policy, "admin", clear_table && policy == "close_all")
+  }
+  if !ad.shutdown.request(f) {
+    writeError(w, http.StatusConflict, errors.New("Shutdown already requested"))
+    return
+  }
+  writeJSON(w, http.StatusAccepted, map[string]string{})
+}
+
+func (ad *Admin) handler() http.Handler {
+  mux := http.NewServeMux()
+  mux.HandleFunc("GET /assets", ad.getAssets)
+  mux.HandleFunc("GET /window", ad.getWindow)
+  mux.HandleFunc("GET /positions", ad.getPositions)
+  mux.HandleFunc("GET /symbols", ad.getSymbols)
+  mux.HandleFunc("POST /symbols", ad.postSymbol)
+  mux.HandleFunc("DELETE /symbols", ad.deleteSymbol)
+  mux.HandleFunc("GET /no-new-positions", ad.getNoNewPositions)
+  mux.HandleFunc("POST /no-new-positions", ad.postNoNewPositions)
+  mux.HandleFunc("POST /close", ad.postClose)
+  mux.HandleFunc("POST /close-all", ad.postCloseAll)
+  mux.HandleFunc("POST /save-state", ad.postSaveState)
+  mux.HandleFunc("POST /shutdown", ad.postShutdown)
+  return authorize(mux)
+}
+
+// Header that requests changing state must carry when no token is set. Browsers
+// only send it cross origin after a preflight, which the API does not answer.
+const adminHeader = "X-Admin-Request"
+
+func authorize(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    token := config.C.AdminToken
+    switch {
+    case token != "":
+      given := r.Header.Get("Authorization")
+      if subtle.ConstantTimeCompare([]byte(given), []byte("Bearer " + token)) != 1 {
+        writeError(w, http.StatusUnauthorized, errors.New("Invalid or missing bearer token"))
+        return
+      }
+    case r.Method != http.MethodGet && r.Header.Get(adminHeader) == "":
+      writeError(w, http.StatusForbidden, errors.New(adminHeader + " header required"))
+      return
+    }
+    next.ServeHTTP(w, r)
+  })
+}
+
+// Serves the API on addr until ctx is canceled. Should be given a context that
+// outlives the markets, so that the API is available while shutting down.
+func (ad *Admin) listenHTTP(wg *sync.WaitGroup, ctx context.Context, addr string) {
+  defer wg.Done()
+  ln, err := net.Listen("tcp", addr)
+  if err != nil {
+    util.Warning(err, "Details", "Admin API not started")
+    return
+  }
+  server := &http.Server{Handler: ad.handler(), ReadHeaderTimeout: 10 * time.Second}
+  go func() {
+    <-ctx.Done()
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+    defer cancel()
+    _ = server.Shutdown(shutdownCtx)
+  }()
+  util.Ok("Admin API on http://" + ln.Addr().String())
+  if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+    util.Warning(err, "Details", "Admin API stopped")
+  }
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+  "strings"
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "github.com/stretchr/testify/assert"
+  "github.com/shopspring/decimal"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+)
+
+func newAdminTesting() (*Admin, *Asset, *Shutdown) {
+  a := newAssetTesting()
+  a.Symbol = "BTC/USD"
+  a.Class = "crypto"
+  a.Positions = make(map[string]*Position)
+  a.C[len(a.C)-1] = 100
+  markets := map[string]*Market{"crypto": NewMarket("crypto", "", map[string]*Asset{"BTC/USD": a})}
+  s := NewShutdown(func() {}, func() {}, nil, make(chan *Query, 1))
+  return NewAdmin(markets, s, nil, nil), a, s
+}
+
+func adminRequest(ad *Admin, method string, target string) *httptest.ResponseRecorder {
+  r := httptest.NewRequest(method, target, nil)
+  r.Header.Set(adminHeader, "1")
+  w := httptest.NewRecorder()
+  ad.handler().ServeHTTP(w, r)
+  return w
+}
+
+func TestAdminAuth(t *testing.T) {
+  ad, _, _ := newAdminTesting()
+  request := func(method string, header string, value string) int {
+    r := httptest.NewRequest(method, "/no-new-positions?enabled=false", nil)
+    if header != "" {
+      r.Header.Set(header, value)
+    }
+    w := httptest.NewRecorder()
+    ad.handler().ServeHTTP(w, r)
+    return w.Code
+  }
+  t.Cleanup(func() {
+    NNP.NoNewPositionsFalse("Admin")
+  })
+
+  assert.Equal(t, http.StatusOK, request("GET", "", ""))
+  assert.Equal(t, http.StatusForbidden, request("POST", "", ""), "Plain cross origin posts are refused")
+  assert.Equal(t, http.StatusOK, request("POST", adminHeader, "1"))
+
+  saved := config.C.AdminToken
+  config.C.AdminToken = "secret"
+  t.Cleanup(func() {
+    config.C.AdminToken = saved
+  })
+  assert.Equal(t, http.StatusUnauthorized, request("GET", "", ""))
+  assert.Equal(t, http.StatusUnauthorized, request("POST", "Authorization", "Bearer wrong"))
+  assert.Equal(t, http.StatusOK, request("POST", "Authorization", "Bearer secret"))
+}
+
+func TestAdminInspect(t *testing.T) {
+  ad, a, _ := newAdminTesting()
+  pos := NewPosition("BTC/USD")
+  pos.OpenOrderPending = false
+  pos.OpenSide = "long"
+  pos.Qty = decimal.NewFromInt(2)
+  a.Positions["foo"] = pos
+
+  w := adminRequest(ad, "GET", "/assets")
+  assert.Equal(t, http.StatusOK, w.Code)
+  assert.Contains(t, w.Body.String(), `"symbol":"BTC/USD","class":"crypto"`)
+  assert.Contains(t, w.Body.String(), `"close":100`)
+
+  w = adminRequest(ad, "GET", "/window?symbol=BTC/USD&n=2")
+  assert.Equal(t, http.StatusOK, w.Code)
+  assert.Contains(t, w.Body.String(), `"c":[0,100]`)
+  assert.Equal(t, http.StatusNotFound, adminRequest(ad, "GET", "/window?symbol=ETH/USD").Code)
+  assert.Equal(t, http.StatusBadRequest, adminRequest(ad, "GET", "/window?symbol=BTC/USD&n=0").Code)
+
+  w = adminRequest(ad, "GET", "/positions")
+  assert.Contains(t, w.Body.String(), `"strategy":"foo","side":"long","qty":"2"`)
+
+  w = adminRequest(ad, "GET", "/symbols")
+  assert.Equal(t, `{"crypto":["BTC/USD"]}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestAdminControl(t *testing.T) {
+  ad, a, s := newAdminTesting()
+  pos := NewPosition("BTC/USD")
+  pos.OpenOrderPending = false
+  a.Positions["foo"] = pos
+  var closed []string
+  a.close = func(params request.OrderParams, strat_name string) {
+    closed = append(closed, strat_name)
+  }
+
+  assert.Equal(t, http.StatusNotFound, adminRequest(ad, "POST", "/close?symbol=ETH/USD&strategy=foo").Code)
+  assert.Equal(t, http.StatusConflict, adminRequest(ad, "POST", "/close?symbol=BTC/USD&strategy=bar").Code)
+  assert.Equal(t, http.StatusAccepted, adminRequest(ad, "POST", "/close?symbol=BTC/USD&strategy=foo").Code)
+  assert.Equal(t, []string{"foo"}, closed)
+
+  pos.CloseOrderPending = true
+  assert.Equal(t, http.StatusConflict, adminRequest(ad, "POST", "/close?symbol=BTC/USD&strategy=foo").Code)
+  w := adminRequest(ad, "POST", "/close-all")
+  assert.Contains(t, w.Body.String(), `"positions":1`)
+  assert.Len(t, closed, 1, "Pending positions are not closed")
+
+  t.Cleanup(func() {
+    NNP.NoNewPositionsFalse("Admin")
+  })
+  w = adminRequest(ad, "POST", "/no-new-positions?enabled=true")
+  assert.Contains(t, w.Body.String(), `"no_new_positions":true`)
+  assert.True(t, NNP.Flag)
+  adminRequest(ad, "POST", "/no-new-positions?enabled=false")
+  assert.False(t, NNP.Flag)
+  assert.Equal(t, http.StatusBadRequest, adminRequest(ad, "POST", "/no-new-positions").Code)
+
+  assert.Equal(t, http.StatusAccepted, adminRequest(ad, "POST", "/save-state").Code)
+  assert.Equal(t, "save_state", (<-s.db_chan).Action)
+
+  assert.Equal(t, http.StatusBadRequest, adminRequest(ad, "POST", "/shutdown?mode=prompt").Code)
+  assert.Equal(t, http.StatusAccepted, adminRequest(ad, "POST", "/shutdown?mode=close_all&clear_table=true").Code)
+  assert.Len(t, s.requested, 1)
+  assert.Equal(t, http.StatusConflict, adminRequest(ad, "POST", "/shutdown?mode=save").Code, "Only one shutdown")
+  assert.Equal(t, http.StatusConflict, adminRequest(ad, "POST", "/save-state").Code)
+  assert.Equal(t, http.StatusMethodNotAllowed, adminRequest(ad, "GET", "/shutdown").Code)
+}
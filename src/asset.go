@@ -2,26 +2,31 @@ package main
 
 import (
   "log"
+  "log/slog"
   "time"
   "sync"
+  "sync/atomic"
   "errors"
   "github.com/shopspring/decimal"
   "github.com/Kjellemann1/AlgoTrader-Go/request"
-  "github.com/Kjellemann1/AlgoTrader-Go/constant"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
   "github.com/Kjellemann1/AlgoTrader-Go/util"
+  "github.com/Kjellemann1/AlgoTrader-Go/logging"
+  "github.com/Kjellemann1/AlgoTrader-Go/indicator"
+  "github.com/Kjellemann1/AlgoTrader-Go/sizing"
 )
 
 func prepAssetsMap() map[string]map[string]*Asset {
   assets := make(map[string]map[string]*Asset)
-  if len(constant.STOCK_SYMBOLS) > 0 {
+  if len(config.C.StockSymbols) > 0 {
     assets["stock"] = make(map[string]*Asset)
-    for _, symbol := range constant.STOCK_SYMBOLS {
+    for _, symbol := range config.C.StockSymbols {
       assets["stock"][symbol] = newAsset("stock", symbol)
     }
   }
-  if len(constant.CRYPTO_SYMBOLS) > 0 {
+  if len(config.C.CryptoSymbols) > 0 {
     assets["crypto"] = make(map[string]*Asset)
-    for _, symbol := range constant.CRYPTO_SYMBOLS {
+    for _, symbol := range config.C.CryptoSymbols {
       assets["crypto"][symbol] = newAsset("crypto", symbol)
     }
   }
@@ -42,6 +47,21 @@ func pendingOrders(assets map[string]map[string]*Asset) map[string][]*Position {
   return pending
 }
 
+// Positions with take profit or stop loss legs that could be filled at any time.
+func positionsWithLegs(assets map[string]map[string]*Asset) map[string][]*Position {
+  legs := make(map[string][]*Position)
+  for _, asset_class := range assets {
+    for _, asset := range asset_class {
+      for _, pos := range asset.Positions {
+        if pos.hasLegs() && !pos.OpenOrderPending && !pos.CloseOrderPending {
+          legs[pos.Symbol] = append(legs[pos.Symbol], pos)
+        }
+      }
+    }
+  }
+  return legs
+}
+
 func positionsSymbols(positions map[string][]*Position) map[string]map[string]int {
   symbols := make(map[string]map[string]int)
   for _, l := range positions {
@@ -83,35 +103,70 @@ func (a *Asset) sumNoPendingPosQtys() decimal.Decimal {
 
 // Moves each element one step to the left, and inserts the new value at the tail.
 func rollFloat(arr *[]float64, v float64) {
-  copy((*arr)[:constant.WINDOW_SIZE-1], (*arr)[1:])
-  (*arr)[constant.WINDOW_SIZE-1] = v
+  copy((*arr)[:config.C.WindowSize-1], (*arr)[1:])
+  (*arr)[config.C.WindowSize-1] = v
 }
 
-func (a *Asset) fillMissingMinutes(t time.Time) {
+// Minutes between the last update and t without data. For stocks only minutes within
+// the session are missing, or minutes of the same day if the calendar does not cover
+// both times.
+func (a *Asset) missingMinutes(t time.Time) []time.Time {
   if a.Time.IsZero() {
-    return
+    return nil
   }
-  if a.Class == "stock" {
+  covered := a.Class == "stock" && MarketCalendar.Covers(a.Time) && MarketCalendar.Covers(t)
+  if a.Class == "stock" && !covered {
     if a.Time.Day() != t.Day() {
-      return
+      return nil
     }
   }
-  missingMinutes := int(t.Sub(a.Time).Minutes()) - 1
-  if missingMinutes > 0 {
-    for range missingMinutes {
-      if a.lastCloseIsTrade {
-        a.C[constant.WINDOW_SIZE-1] = a.C[constant.WINDOW_SIZE-2]
-      } else {
-        rollFloat(&a.C, a.C[constant.WINDOW_SIZE-1])
-      }
-      rollFloat(&a.O, a.O[constant.WINDOW_SIZE-1])
-      rollFloat(&a.H, a.H[constant.WINDOW_SIZE-1])
-      rollFloat(&a.L, a.L[constant.WINDOW_SIZE-1])
-      a.lastCloseIsTrade = false
+  var minutes []time.Time
+  for k := range int(t.Sub(a.Time).Minutes()) - 1 {
+    m := a.Time.Add(time.Duration(k + 1) * time.Minute)
+    // Bars are stamped with their end time, so the start of the minute must be open
+    if covered && !MarketCalendar.IsOpen(m.Add(-time.Minute)) {
+      continue
     }
+    minutes = append(minutes, m)
   }
+  return minutes
+}
+
+func (a *Asset) fillMissingMinutes(t time.Time) {
+  for _, m := range a.missingMinutes(t) {
+    replace := a.lastCloseIsTrade
+    if a.lastCloseIsTrade {
+      a.C[config.C.WindowSize-1] = a.C[config.C.WindowSize-2]
+      a.V[config.C.WindowSize-1] = 0
+      a.VW[config.C.WindowSize-1] = a.C[config.C.WindowSize-1]
+      a.N[config.C.WindowSize-1] = 0
+    } else {
+      rollFloat(&a.C, a.C[config.C.WindowSize-1])
+      rollFloat(&a.V, 0)
+      rollFloat(&a.VW, a.C[config.C.WindowSize-1])
+      rollFloat(&a.N, 0)
+    }
+    rollFloat(&a.O, a.O[config.C.WindowSize-1])
+    rollFloat(&a.H, a.H[config.C.WindowSize-1])
+    rollFloat(&a.L, a.L[config.C.WindowSize-1])
+    a.lastCloseIsTrade = false
+    a.updateIndicators(replace, a.lastBar(m))
+    // Flat at the last close in the frames
+    c := a.C[config.C.WindowSize-1]
+    a.updateFrames(c, c, c, c, 0, m)
+  }
+}
+
+// Returns the current time used as trigger time for orders of the asset class.
+// Replaced by the journal clock when replaying recorded market data.
+var clockNow = func(asset_class string) time.Time {
+  return time.Now().UTC()
 }
 
+// Market order that is canceled if it can not be filled immediately. Used for all
+// opens and closes unless a strategy passes other order params.
+var IOC = request.Market("ioc")
+
 type strategyFunc func(*Asset)
 
 type Asset struct {
@@ -119,6 +174,8 @@ type Asset struct {
   Positions         map[string]*Position
   Qty               decimal.Decimal
   Class             string
+  Info              *AssetInfo    // Nil if the asset metadata is not loaded
+  Rules             sizing.Rules  // Order size rules that order qtys are rounded to
   Time              time.Time
   ReceivedTime      time.Time
   lastCloseIsTrade  bool
@@ -127,68 +184,147 @@ type Asset struct {
   H                 []float64
   L                 []float64
   C                 []float64
+  V                 []float64  // Volume, vwap and trade count move with C, so during a minute
+  VW                []float64  // with trades but no bar yet they hold the trades accumulated
+  N                 []float64  // so far. Synthesized minutes have zero volume and vwap at close.
+
+  indicators        map[string]indicator.Indicator
+  tradeBar          indicator.Bar  // Provisional bar of the current minute built from trades
+  quote             Quote          // Latest quote. Read with Quote.
+  Book              *OrderBook     // Nil unless crypto_orderbook is set for a crypto asset
+  retiring          atomic.Bool    // Set when the symbol is being removed. No new positions are opened.
+
+  frames            map[time.Duration]*Frame  // Higher timeframes declared by the strategies
+  closed            map[time.Duration]bool    // Timeframes with a bar ended by the last update
 
   strategies        []strategyFunc
+  triggers          []time.Duration  // Timeframe whose bar closes run the strategy. 0 runs it on every update.
   channels          []chan struct{}
+  stop              chan struct{}    // Closed to stop the strategy goroutines
 
   Rwm               sync.RWMutex
   Mutex             sync.Mutex
 
-  close            func(string, string)
-  open             func(string, string, string)
+  close            func(request.OrderParams, string)
+  open             func(string, request.OrderParams, string)
+  cancel           func(string)
+  exit             func(request.OrderParams, string)
 }
 
 func newAsset(asset_class string, symbol string) (a *Asset) {
+  a = allocAsset(asset_class, symbol)
+  a.close = a.closeFunc
+  a.open = a.openFunc
+  a.cancel = a.cancelFunc
+  a.exit = a.exitFunc
+  if !replaying {
+    a.startStrategies()
+  }
+  return
+}
+
+// Allocates the asset and its rolling windows without wiring order functions
+// or starting the strategy goroutines.
+func allocAsset(asset_class string, symbol string) (a *Asset) {
   a = &Asset{
     lastCloseIsTrade: false,
     Positions: make(map[string]*Position),
     Class: asset_class,
     Symbol: symbol,
     Qty: decimal.NewFromInt(0),
-    O: make([]float64, constant.WINDOW_SIZE),
-    H: make([]float64, constant.WINDOW_SIZE),
-    L: make([]float64, constant.WINDOW_SIZE),
-    C: make([]float64, constant.WINDOW_SIZE),
-    strategies: []strategyFunc{
-      // Add strategies here
-      (*Asset).rand,
-    },
+    Info: AssetInfos[symbol],
+    Rules: AssetInfos[symbol].rules(asset_class),
+    O: make([]float64, config.C.WindowSize),
+    H: make([]float64, config.C.WindowSize),
+    L: make([]float64, config.C.WindowSize),
+    C: make([]float64, config.C.WindowSize),
+    V: make([]float64, config.C.WindowSize),
+    VW: make([]float64, config.C.WindowSize),
+    N: make([]float64, config.C.WindowSize),
+    indicators: make(map[string]indicator.Indicator),
+    frames: make(map[time.Duration]*Frame),
+    closed: make(map[time.Duration]bool),
+  }
+  if asset_class == "crypto" && config.C.CryptoOrderbook {
+    a.Book = newOrderBook()
+  }
+  for _, s := range registeredFor(asset_class, symbol) {
+    a.strategies = append(a.strategies, s.run)
+    a.triggers = append(a.triggers, s.Trigger)
+    for _, size := range s.Timeframes {
+      if a.frames[size] == nil {
+        a.frames[size] = newFrame(size)
+      }
+    }
   }
-  a.close = a.closeFunc
-  a.open = a.openFunc
-  a.startStrategies()
   return
 }
 
 func (a *Asset) startStrategies() {
   n := len(a.strategies)
   a.channels = make([]chan struct{}, n)
+  a.stop = make(chan struct{})
   for i := range n {
     a.channels[i] = make(chan struct{})
     go func(idx int) {
-      for range a.channels[idx] {
-        a.strategies[idx](a)
+      for {
+        select {
+        case <-a.stop:
+          return
+        case <-a.channels[idx]:
+          a.strategies[idx](a)
+        }
       }
     }(i)
   }
 }
 
-func (a *Asset) checkForSignal() {
-  for i := range a.channels {
-    if i >= 0 && i < len(a.channels) {
-      a.channels[i] <- struct{}{}
+// Stops the strategy goroutines. Signals sent after are dropped. Called once.
+func (a *Asset) stopStrategies() {
+  if a.stop != nil {
+    close(a.stop)
+  }
+}
+
+// Runs the strategies with the given indexes, as returned by the window update
+func (a *Asset) checkForSignal(triggered []int) {
+  // Strategies are run synchronously on the calling goroutine if they have not
+  // been started, which is the case when backtesting.
+  if a.channels == nil {
+    for _, i := range triggered {
+      a.strategies[i](a)
+    }
+    return
+  }
+  for _, i := range triggered {
+    if i >= len(a.channels) {
+      continue
+    }
+    select {
+    case a.channels[i] <- struct{}{}:
+    case <-a.stop:
+      return
     }
   }
 }
 
-func (a *Asset) updateWindowOnBar(o float64, h float64, l float64, c float64, t time.Time, received_time time.Time) {
+// Returns the indexes of the strategies triggered by the bar
+func (a *Asset) updateWindowOnBar(o float64, h float64, l float64, c float64, v float64, vw float64, n float64, t time.Time, received_time time.Time) []int {
   a.Rwm.Lock()
   defer a.Rwm.Unlock()
+  clear(a.closed)
   a.fillMissingMinutes(t)
+  replace := a.lastCloseIsTrade
   if a.lastCloseIsTrade {
-    a.C[constant.WINDOW_SIZE-1] = c
+    a.C[config.C.WindowSize-1] = c
+    a.V[config.C.WindowSize-1] = v
+    a.VW[config.C.WindowSize-1] = vw
+    a.N[config.C.WindowSize-1] = n
   } else {
     rollFloat(&a.C, c)
+    rollFloat(&a.V, v)
+    rollFloat(&a.VW, vw)
+    rollFloat(&a.N, n)
     a.Time = t
   }
   rollFloat(&a.O, o)
@@ -197,19 +333,37 @@ func (a *Asset) updateWindowOnBar(o float64, h float64, l float64, c float64, t
   a.Time = t
   a.ReceivedTime = received_time
   a.lastCloseIsTrade = false
+  a.updateIndicators(replace, a.lastBar(t))
+  a.updateFrames(o, h, l, c, v, t)
+  return a.triggeredStrategies()
 }
 
-func (a *Asset) updateWindowOnTrade(c float64, t time.Time, received_time time.Time) {
+// Trades within the same minute are accumulated into the volume, vwap and trade count
+// of the minute, which are replaced by the values of the bar when it arrives. Returns
+// the indexes of the strategies triggered by the trade.
+func (a *Asset) updateWindowOnTrade(c float64, size float64, t time.Time, received_time time.Time) []int {
   a.Rwm.Lock()
   defer a.Rwm.Unlock()
+  clear(a.closed)
+  a.updateIndicatorsOnTrade(c, size, t)
   if a.lastCloseIsTrade {
-    a.C[constant.WINDOW_SIZE - 1] = c
+    last := config.C.WindowSize - 1
+    a.C[last] = c
+    if v := a.V[last] + size; v > 0 {
+      a.VW[last] = (a.VW[last] * a.V[last] + c * size) / v
+    }
+    a.V[last] += size
+    a.N[last]++
   } else {
     rollFloat(&a.C, c)
+    rollFloat(&a.V, size)
+    rollFloat(&a.VW, c)
+    rollFloat(&a.N, 1)
   }
   a.Time = t
   a.ReceivedTime = received_time
   a.lastCloseIsTrade = true
+  return a.triggeredStrategies()
 }
 
 func (a *Asset) createPositionID(strat_name string) string {
@@ -218,18 +372,19 @@ func (a *Asset) createPositionID(strat_name string) string {
   return position_id
 }
 
-func (a *Asset) initiatePositionObject(strat_name string, order_type string, side string, order_id string, trigger_time time.Time) {
+func (a *Asset) initiatePositionObject(strat_name string, params request.OrderParams, side string, order_id string, trigger_time time.Time, notional float64) {
   a.Rwm.Lock()
   a.Positions[strat_name] = NewPosition(a.Symbol)
   a.Rwm.Unlock()
   a.Mutex.Lock()
   defer a.Mutex.Unlock()
   if a.Positions[strat_name] == nil {
-    util.Error(errors.New("Position object is nil for symbol: " + a.Symbol),
-      "StratName", strat_name, "OrderType", order_type, "Side", side, "OrderID", order_id,
+    util.Emergency(errors.New("Position object is nil for symbol: " + a.Symbol),
+      "StratName", strat_name, "OrderType", params.String(), "Side", side, "OrderID", order_id,
       "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...",
     )
     request.CloseAllPositions(2, 0)
+    util.Flush()
     log.Panicln("SHUTTING DOWN")
   }
   pos := a.Positions[strat_name]
@@ -240,95 +395,159 @@ func (a *Asset) initiatePositionObject(strat_name string, order_type string, sid
   pos.StratName = strat_name
   pos.PositionID = order_id
   pos.OpenSide = side
-  pos.OpenOrderType = order_type
-  pos.OpenTriggerPrice = a.C[constant.WINDOW_SIZE-1]
+  pos.OpenOrderType = params.String()
+  pos.OpenTriggerPrice = a.C[config.C.WindowSize-1]
   pos.OpenTriggerTime = trigger_time
+  pos.OpenNotional = notional
   pos.OpenPriceTime = a.Time
   pos.OpenPriceReceivedTime = a.ReceivedTime
 }
 
+// Removes a position whose qty is below the minimum order size, and its qty from
+// the asset qty so that the sum of the positions still matches it. The dust is
+// picked up by the next position of the asset. Called with the position locked.
+func (a *Asset) dropDust(strat_name string, pos *Position) {
+  a.logEvent("info", strat_name, "Position closed with qty below minimum order size",
+    "Position ID", pos.PositionID, "Qty", pos.Qty,
+  )
+  a.Rwm.Lock()
+  a.Qty = a.Qty.Sub(pos.Qty)
+  delete(a.Positions, strat_name)
+  a.Rwm.Unlock()
+  if dbQueue != nil {
+    dbQueue <- &Query{Action: "delete_position", Symbol: a.Symbol, StratName: strat_name}
+  }
+}
+
 func (a *Asset) removePosition(strat_name string) {
   a.Rwm.Lock()
   defer a.Rwm.Unlock()
   delete(a.Positions, strat_name)
 }
 
-func (a *Asset) sendOpenOrder(order_type string, position_id string, symbol string, asset_class string, last_close float64) (string, int, error) {
-  switch order_type {
-    case "IOC":
-      body, status, err := request.OpenLongIOC(symbol, asset_class, position_id, last_close)
-      return body, status, err
+// Order side that opens a position with the given open side.
+func openOrderSide(open_side string) string {
+  if open_side == "short" {
+    return "sell"
   }
-  return "", 0, nil
+  return "buy"
 }
 
-func (a *Asset) sendCloseOrder(open_side, order_type string, order_id string, symbol string, qty decimal.Decimal) (string, int, error) {
-  var side string
-  switch open_side {
-  case "long":
-    side = "sell"
-  case "short":
-    side = "buy"
+// Order side that closes a position with the given open side.
+func closeOrderSide(open_side string) string {
+  if open_side == "short" {
+    return "buy"
   }
-  switch order_type {
-    case "IOC":
-      body, status, err := request.CloseIOC(side, symbol, order_id, qty)
-      return body, status, err
+  return "sell"
+}
+
+func (a *Asset) sendOpenOrder(open_side string, params request.OrderParams, position_id string, symbol string, asset_class string, qty decimal.Decimal) (string, int, error) {
+  return request.OpenOrder(openOrderSide(open_side), symbol, asset_class, position_id, qty, params)
+}
+
+// Qty is the signed position qty, which is negative for short positions.
+func (a *Asset) sendCloseOrder(open_side string, params request.OrderParams, client_order_id string, symbol string, asset_class string, qty decimal.Decimal) (string, int, error) {
+  return request.CloseOrder(closeOrderSide(open_side), symbol, asset_class, client_order_id, a.Rules.Round(qty.Abs()), params)
+}
+
+// Stores the order id assigned by the broker, so that resting orders can be canceled,
+// and the ids of bracket legs so that their fills are attributed to the position.
+func (a *Asset) setOrderID(strat_name string, body string, close bool) {
+  a.Rwm.RLock()
+  pos, ok := a.Positions[strat_name]
+  a.Rwm.RUnlock()
+  if !ok {
+    return
+  }
+  pos.Rwm.Lock()
+  defer pos.Rwm.Unlock()
+  if close {
+    pos.CloseOrderID = request.ParseOrderID(body)
+  } else {
+    pos.OpenOrderID = request.ParseOrderID(body)
+    pos.TakeProfitOrderID, pos.StopLossOrderID = request.ParseLegIDs(body)
   }
-  return "", 0, nil
 }
 
 //////////////////////// Methods below this point are for being called from strategy functions
 
 func (a *Asset) i(num int) (index int) {
-	index = constant.WINDOW_SIZE - 1 - num
+	index = config.C.WindowSize - 1 - num
 	return
 }
 
 func (a *Asset) s(arr *[]float64, from int, to int) (slice []float64) {
-  slice = (*arr)[(constant.WINDOW_SIZE - 1 - to):(constant.WINDOW_SIZE - from)]
+  slice = (*arr)[(config.C.WindowSize - 1 - to):(config.C.WindowSize - from)]
   return
 }
 
-func (a *Asset) openChecks(strat_name string, trigger_time time.Time) bool {
+// Logs an event of a strategy on the asset as the caller, with the symbol and strat
+func (a *Asset) logEvent(event string, strat_name string, msg string, details ...any) {
+  logging.Log(slog.LevelInfo, 1, msg, append([]any{"Event", event, "Symbol", a.Symbol, "Strat", strat_name}, details...)...)
+}
+
+func (a *Asset) openChecks(side string, strat_name string, trigger_time time.Time) bool {
+  // Lifts the daily loss stop on a new day before the flag is checked
+  Risk.rollDay(trigger_time)
   if NNP.Flag {
     return false
   }
 
+  if a.retiring.Load() {
+    a.logEvent("cancel", strat_name, "Symbol being removed")
+    return false
+  }
+
+  if side == "short" && a.Class == "crypto" {
+    a.logEvent("cancel", strat_name, "Crypto can not be sold short")
+    return false
+  }
+
+  if side == "short" && !a.Info.shortable() {
+    a.logEvent("cancel", strat_name, "Not shortable")
+    return false
+  }
+
   if _, ok := a.Positions[strat_name]; ok {
     return false
   }
 
-  if a.ReceivedTime.Sub(a.Time) > constant.MAX_RECEIVED_TIME_DIFF_MS {
-    log.Printf("[ CANCEL ]\t%s\t%s\tReceived time diff",
-      util.AddWhitespace(a.Symbol, 10), strat_name,
-    )
+  if a.Class == "stock" && !stockOpenAllowed(trigger_time) {
+    a.logEvent("cancel", strat_name, "Market closed or closing")
+    return false
+  }
+
+  if a.ReceivedTime.Sub(a.Time) > config.C.MaxReceivedTimeDiff {
+    a.logEvent("cancel", strat_name, "Received time diff")
     return false
   } 
 
-  if trigger_time.Sub(a.Time) > constant.MAX_TRIGGER_TIME_DIFF_MS {
-    log.Printf("[ CANCEL ]\t%s\t%s\tTrigger time diff",
-      util.AddWhitespace(a.Symbol, 10), strat_name,
-    )
+  if trigger_time.Sub(a.Time) > config.C.MaxTriggerTimeDiff {
+    a.logEvent("cancel", strat_name, "Trigger time diff")
+    return false
+  }
+
+  if err := Risk.check(a, strat_name, trigger_time); err != nil {
+    a.logEvent("cancel", strat_name, err.Error())
     return false
   }
 
   return true
 }
 
-func (a *Asset) sendOpen(order_type string, position_id string, symbol string, asset_class string, strat_name string, last_close float64) {
+func (a *Asset) sendOpen(side string, params request.OrderParams, position_id string, symbol string, asset_class string, strat_name string, qty decimal.Decimal) {
   // TODO: Log retries
   backoff_sec := 1.0
   retries := 0
 
   for {
     if retries > 1 {
-      log.Println("[ CANCEL ]\t" +  util.AddWhitespace(symbol, 10) + "\tOpen failed on retry")
+      a.logEvent("cancel", strat_name, "Open failed on retry", "Position ID", position_id)
       a.removePosition(strat_name)
       return
     }
 
-    body, status, err := a.sendOpenOrder(order_type, position_id, symbol, asset_class, last_close)
+    body, status, err := a.sendOpenOrder(side, params, position_id, symbol, asset_class, qty)
     if err != nil {
       util.Error(err, "Symbol", symbol, "Body", body)
       a.removePosition(strat_name)
@@ -338,21 +557,20 @@ func (a *Asset) sendOpen(order_type string, position_id string, symbol string, a
     switch status {
     case 200:
       if retries >= 1 {
-        log.Printf("[ INFO ]\t%s\t%s\tSending Open order successful on retry\n",
-          util.AddWhitespace(symbol, 10), strat_name,
-        )
+        a.logEvent("info", strat_name, "Sending Open order successful on retry", "Position ID", position_id)
       }
+      a.setOrderID(strat_name, body, false)
       return
     case 403:
-      log.Printf("[ INFO ]\t%s\t%s\t%s\tForbidden block when sending Open order\tRetrying in (%.0f) seconds ...",
-        util.AddWhitespace(symbol, 10), strat_name, body, backoff_sec,
+      a.logEvent("info", strat_name, "Forbidden block when sending Open order",
+        "Position ID", position_id, "Body", body, "Retrying in (seconds)", backoff_sec,
       )
       util.Backoff(&backoff_sec)
     case 429:
       NNP.RateLimitSleep()
       util.Warning(errors.New("Rate limit exceeded on Open"),
         "Symbol", symbol, "Strat", strat_name,
-        "Setting NoNewPositionsFlag to true for (seconds)", constant.RATE_LIMIT_SLEEP_SEC,
+        "Setting NoNewPositionsFlag to true for (seconds)", config.C.RateLimitSleep,
         util.AddWhitespace(symbol, 10), strat_name,
       )
     default:
@@ -366,36 +584,68 @@ func (a *Asset) sendOpen(order_type string, position_id string, symbol string, a
   }
 }
 
-func (a *Asset) openFunc(side string, order_type string, strat_name string) {
-  trigger_time := time.Now().UTC()
-  if !a.openChecks(strat_name, trigger_time) {
+func (a *Asset) openFunc(side string, params request.OrderParams, strat_name string) {
+  trigger_time := clockNow(a.Class)
+  if !a.openChecks(side, strat_name, trigger_time) {
+    return
+  }
+  if err := params.Validate(a.Class); err != nil {
+    util.Warning(err, "Symbol", a.Symbol, "Strat", strat_name)
+    return
+  }
+  if err := params.ValidateExits(openOrderSide(side)); err != nil {
+    util.Warning(err, "Symbol", a.Symbol, "Strat", strat_name)
+    return
+  }
+  if err := a.spreadCheck(params, trigger_time); err != nil {
+    a.logEvent("cancel", strat_name, err.Error())
+    return
+  }
+  last_close := a.C[config.C.WindowSize-1]
+  qty, err := a.openQty(params, last_close, accountEquity.get)
+  if err != nil || qty.IsZero() {
+    a.logEvent("cancel", strat_name, "No open qty", "Error", err)
+    return
+  }
+  if err := a.slippageCheck(openOrderSide(side), params, qty.InexactFloat64()); err != nil {
+    a.logEvent("cancel", strat_name, err.Error())
+    return
+  }
+  notional := qty.InexactFloat64() * last_close
+  if err := Risk.checkOrder(a, notional, trigger_time); err != nil {
+    a.logEvent("cancel", strat_name, err.Error())
     return
   }
-  last_close := a.C[constant.WINDOW_SIZE-1]
   symbol := a.Symbol
   asset_class := a.Class
   position_id := a.createPositionID(strat_name)
   a.Mutex.Unlock()
-  a.initiatePositionObject(strat_name, order_type, side, position_id, trigger_time)
-  a.sendOpen(order_type, position_id, symbol, asset_class, strat_name, last_close)
+  a.initiatePositionObject(strat_name, params, side, position_id, trigger_time, notional)
+  // The pending position counts towards the exposure from here
+  Risk.release(notional)
+  a.sendOpen(side, params, position_id, symbol, asset_class, strat_name, qty)
   a.Mutex.Lock()
 }
 
-func (a *Asset) closeUpdatePosition(pos *Position, trigger_time time.Time, order_type string) (string, string, decimal.Decimal, string) {
+// Returns the open side, symbol, qty and the client order id of the close
+func (a *Asset) closeUpdatePosition(pos *Position, trigger_time time.Time, params request.OrderParams) (string, string, decimal.Decimal, string) {
   open_side := pos.OpenSide
   symbol := pos.Symbol
   qty := pos.Qty
-  position_id := pos.PositionID
+  pos.CloseAttempts++
+  close_id := request.CloseClientOrderID(pos.PositionID, pos.CloseAttempts)
   pos.CloseOrderPending = true
   pos.CloseTriggerTime = trigger_time
-  pos.CloseOrderType = order_type
-  pos.CloseTriggerPrice = a.C[constant.WINDOW_SIZE-1]
+  pos.CloseOrderType = params.String()
+  pos.CloseParams = params
+  pos.CloseOrderID = ""
+  pos.CloseTriggerPrice = a.C[config.C.WindowSize-1]
   pos.ClosePriceTime = a.Time
   pos.ClosePriceReceivedTime = a.ReceivedTime
-  return open_side, symbol, qty, position_id
+  return open_side, symbol, qty, close_id
 }
 
-func (a *Asset) sendClose(strat_name string, open_side string, order_type string, position_id string, symbol string, qty decimal.Decimal) {
+func (a *Asset) sendClose(strat_name string, open_side string, params request.OrderParams, close_id string, symbol string, asset_class string, qty decimal.Decimal) {
   // TODD: Log retries
   backoff_sec := 1.0
   backoff_max := 20.0
@@ -407,7 +657,7 @@ func (a *Asset) sendClose(strat_name string, open_side string, order_type string
       util.Info("Retry count", "Retries", retries)
     }
 
-    body, status, err := a.sendCloseOrder(open_side, order_type, position_id, symbol, qty)
+    body, status, err := a.sendCloseOrder(open_side, params, close_id, symbol, asset_class, qty)
     if err != nil {
       util.Error(err, "Symbol", symbol, "Strat", strat_name)
     }
@@ -415,31 +665,32 @@ func (a *Asset) sendClose(strat_name string, open_side string, order_type string
     switch status {
     case 200:
       if retries == 1 {
-        log.Printf("[ INFO ]\t%s\t%s\tSending Close order succesfull on retry",
-          util.AddWhitespace(symbol, 10), strat_name,
-        )
+        a.logEvent("info", strat_name, "Sending Close order succesfull on retry", "Order ID", close_id)
       } else if retries > 1 {
         util.Info("Close successful after retries",
           "Symbol", symbol, "Strat", strat_name, "Retries", retries,
         )
         NNP.NoNewPositionsFalse("Close")
       }
+      a.setOrderID(strat_name, body, true)
       return
     case 403:
-      log.Printf("[ INFO ]\t%s\t%s\t%s\tForbidden block on Close\tRetrying in (%.0f) seconds ...",
-        util.AddWhitespace(symbol, 10), strat_name, body, backoff_sec,
+      a.logEvent("info", strat_name, "Forbidden block on Close",
+        "Order ID", close_id, "Body", body, "Retrying in (seconds)", backoff_sec,
       )
       util.BackoffWithMax(&backoff_sec, backoff_max)
     case 422:
+      // Not pending, so that the position is closed again on the next signal
       util.Error(errors.New("Close order unprocessable"),
-        "Symbol", symbol, "Strat", strat_name, "Body", body, "Retrying in (seconds)", backoff_sec,
+        "Symbol", symbol, "Strat", strat_name, "Order ID", close_id, "Body", body,
       )
+      a.closeRejected(strat_name)
       return
     case 429:
       NNP.RateLimitSleep()
       util.Warning(errors.New("Rate limit exceeded on Close"),
         "Symbol", symbol, "Strat", strat_name, "Retrying in (seconds)", backoff_sec,
-        "Setting NoNewPositionsFlag to true for (seconds)", constant.RATE_LIMIT_SLEEP_SEC,
+        "Setting NoNewPositionsFlag to true for (seconds)", config.C.RateLimitSleep,
         util.AddWhitespace(symbol, 10), strat_name, backoff_sec,
       )
       util.BackoffWithMax(&backoff_sec, backoff_max)
@@ -454,21 +705,146 @@ func (a *Asset) sendClose(strat_name string, open_side string, order_type string
   }
 }
 
-func (a *Asset) closeFunc(order_type string, strat_name string) {
-  trigger_time := time.Now().UTC()
+func (a *Asset) closeFunc(params request.OrderParams, strat_name string) {
+  trigger_time := clockNow(a.Class)
   if _, ok := a.Positions[strat_name]; !ok {
     return
   }
   pos := a.Positions[strat_name]
   pos.Rwm.Lock()
   if pos.CloseOrderPending || pos.OpenOrderPending {
-    log.Printf("[ INFO ]\t%s\t%s\tClose cancelled due to order pending", util.AddWhitespace(a.Symbol, 10), strat_name)
+    a.logEvent("info", strat_name, "Close cancelled due to order pending")
     pos.Rwm.Unlock()
     return
   }
-  open_side, symbol, qty, position_id := a.closeUpdatePosition(pos, trigger_time, order_type)
+  if err := params.Validate(a.Class); err != nil {
+    util.Warning(err, "Symbol", a.Symbol, "Strat", strat_name)
+    pos.Rwm.Unlock()
+    return
+  }
+  // Left over after rounding a previous close, e.g. when fees are paid in the asset.
+  // It can not be closed, so the position is treated as flat.
+  if a.Rules.Round(pos.Qty.Abs()).IsZero() {
+    a.dropDust(strat_name, pos)
+    pos.Rwm.Unlock()
+    return
+  }
+  // The qty of the position is held by its legs until they are canceled. The
+  // position is unlocked meanwhile so that order updates are not held up. Closes of
+  // the asset are serialized by a.Mutex.
+  if legs := pos.legIDs(); len(legs) > 0 {
+    pos.Rwm.Unlock()
+    if !a.cancelLegs(strat_name, legs) {
+      return
+    }
+    pos.Rwm.Lock()
+    // A leg may have been filled before it was canceled
+    if pos.CloseOrderPending || pos.OpenOrderPending || pos.Qty.IsZero() {
+      pos.Rwm.Unlock()
+      return
+    }
+    pos.CanceledLegIDs = append(pos.CanceledLegIDs, legs...)
+    pos.clearLegs()
+  }
+  open_side, symbol, qty, close_id := a.closeUpdatePosition(pos, trigger_time, params)
   pos.Rwm.Unlock()
-  a.sendClose(strat_name, open_side, order_type, position_id, symbol, qty)
+  a.sendClose(strat_name, open_side, params, close_id, symbol, a.Class, qty)
+}
+
+// Hands a position whose close was rejected back to the close logic
+func (a *Asset) closeRejected(strat_name string) {
+  a.Rwm.RLock()
+  pos, ok := a.Positions[strat_name]
+  a.Rwm.RUnlock()
+  if !ok {
+    return
+  }
+  pos.Rwm.Lock()
+  defer pos.Rwm.Unlock()
+  pos.CloseOrderPending = false
+  pos.CloseOrderID = ""
+}
+
+// Cancels the take profit and stop loss legs of a position. Returns false if a leg
+// could not be canceled, which is the case if it is already filled. The fill is then
+// handled by Account. Called without the position locked.
+func (a *Asset) cancelLegs(strat_name string, order_ids []string) bool {
+  for _, order_id := range order_ids {
+    status, err := request.CancelOrder(order_id)
+    if err != nil || (status != 204 && status != 404) {
+      a.logEvent("info", strat_name, "Close cancelled due to leg not cancelable",
+        "Order ID", order_id, "Status", status,
+      )
+      return false
+    }
+  }
+  return true
+}
+
+// Attaches OCO take profit and stop loss orders to an open position without legs.
+// The take profit must be above, and the stop loss below, the last price for long
+// positions, and the other way around for short positions, so that the exit is not
+// filled before the leg ids are stored on the position.
+func (a *Asset) exitFunc(params request.OrderParams, strat_name string) {
+  pos, ok := a.Positions[strat_name]
+  if !ok {
+    return
+  }
+  pos.Rwm.Lock()
+  defer pos.Rwm.Unlock()
+  if pos.OpenOrderPending || pos.CloseOrderPending || pos.hasLegs() {
+    return
+  }
+
+  side := closeOrderSide(pos.OpenSide)
+  last := a.C[a.i(0)]
+  if side == "sell" && (params.TakeProfit <= last || params.StopLoss >= last) ||
+    side == "buy" && (params.TakeProfit >= last || params.StopLoss <= last) {
+    util.Warning(errors.New("Exit prices on wrong side of last price"), "Symbol", a.Symbol, "Strat", strat_name, "Last", last)
+    return
+  }
+
+  body, status, err := request.ExitOrder(side, a.Symbol, a.Class, a.Rules.Round(pos.Qty.Abs()), params)
+  if err != nil || status != 200 {
+    util.Warning(errors.New("Sending exit order failed"), "Symbol", a.Symbol, "Strat", strat_name, "Status", status, "Body", body, "Error", err)
+    return
+  }
+  pos.TakeProfitOrderID, pos.StopLossOrderID = request.ParseLegIDs(body)
+  a.logEvent("info", strat_name, "Exit attached")
+}
+
+// Cancels the pending open or close order of the position. The position is updated
+// when the canceled event arrives in Account.
+func (a *Asset) cancelFunc(strat_name string) {
+  pos, ok := a.Positions[strat_name]
+  if !ok {
+    return
+  }
+  pos.Rwm.RLock()
+  order_id := pos.OpenOrderID
+  if pos.CloseOrderPending {
+    order_id = pos.CloseOrderID
+  } else if !pos.OpenOrderPending {
+    order_id = ""
+  }
+  pos.Rwm.RUnlock()
+  if order_id == "" {
+    return
+  }
+
+  status, err := request.CancelOrder(order_id)
+  if err != nil {
+    util.Warning(err, "Symbol", a.Symbol, "Strat", strat_name, "Order ID", order_id)
+    return
+  }
+  switch status {
+  case 204:
+    a.logEvent("info", strat_name, "Cancel requested")
+  case 422:
+    a.logEvent("info", strat_name, "Order no longer cancelable")
+  default:
+    util.Warning(errors.New("Cancel order failed"), "Symbol", a.Symbol, "Strat", strat_name, "Status", status)
+  }
 }
 
 func (a *Asset) priceDeviation(fill_price float64) float64 {
@@ -478,32 +854,43 @@ func (a *Asset) priceDeviation(fill_price float64) float64 {
   return (a.C[a.i(0)] / fill_price - 1) * 100
 }
 
+// Price deviation in the direction of the position, so that a positive value is
+// a gain for both long and short positions.
+func (a *Asset) positionDeviation(pos *Position, base_price float64) float64 {
+  if pos.OpenSide == "short" {
+    return a.priceDeviation(base_price) * -1
+  }
+  return a.priceDeviation(base_price)
+}
+
 func (a *Asset) stopLoss(percent float64, strat_name string) {
   // TODO:
   //   -> Log if stop loss triggered to db
-  //   -> Implement logic for short positions (This requires significant changes throughout the code)
   if _, ok := a.Positions[strat_name]; !ok {
     return
   }
-  if a.priceDeviation(a.Positions[strat_name].OpenFilledAvgPrice) < (percent * -1) {
-    a.close("IOC", strat_name)
-    log.Printf("[ INFO ]\t%s\t%s\tStopLoss", a.Symbol, strat_name)
+  pos := a.Positions[strat_name]
+  if a.positionDeviation(pos, pos.OpenFilledAvgPrice) < (percent * -1) {
+    a.close(IOC, strat_name)
+    a.logEvent("info", strat_name, "StopLoss")
   }
 }
 
 func (a *Asset) takeProfit(percent float64, strat_name string) {
   // TODO:
   //   -> Log if take profit triggered to db
-  //   -> Implement logic for short positions
   if _, ok := a.Positions[strat_name]; !ok {
     return
   }
-  if a.priceDeviation(a.Positions[strat_name].OpenFilledAvgPrice) > percent {
-    a.close("IOC", strat_name)
-    log.Printf("[ INFO ]\t%s\t%s\tTakeProfit", a.Symbol, strat_name)
+  pos := a.Positions[strat_name]
+  if a.positionDeviation(pos, pos.OpenFilledAvgPrice) > percent {
+    a.close(IOC, strat_name)
+    a.logEvent("info", strat_name, "TakeProfit")
   }
 }
 
+// The trailing stop base is the highest price seen for long positions, and the
+// lowest price seen for short positions.
 func (a *Asset) trailingStop(percent float64, strat_name string) {
   if _, ok := a.Positions[strat_name]; !ok {
     return
@@ -511,13 +898,18 @@ func (a *Asset) trailingStop(percent float64, strat_name string) {
   pos := a.Positions[strat_name]
 
   p := a.C[a.i(0)]
-  if p > pos.TrailingStopBase {
+  if pos.OpenSide == "short" {
+    if pos.TrailingStopBase == 0 || p < pos.TrailingStopBase {
+      pos.TrailingStopBase = p
+      return
+    }
+  } else if p > pos.TrailingStopBase {
     pos.TrailingStopBase = p
     return
   }
 
-  if a.priceDeviation(pos.TrailingStopBase) < (percent * -1) {
-    a.close("IOC", strat_name)
-    log.Printf("[ INFO ]\t%s\t%s\tTrailingStop", a.Symbol, strat_name)
+  if a.positionDeviation(pos, pos.TrailingStopBase) < (percent * -1) {
+    a.close(IOC, strat_name)
+    a.logEvent("info", strat_name, "TrailingStop")
   }
 }
@@ -0,0 +1,123 @@
+// Asset metadata from the /v2/assets endpoint of the trading API. Loaded at startup
+// so that inactive symbols are never subscribed to, shorts are only sent for
+// shortable stocks, and crypto order qtys follow the increments of the asset.
+
+package main
+
+import (
+  "fmt"
+  "log"
+  "errors"
+  "github.com/valyala/fastjson"
+  "github.com/shopspring/decimal"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+  "github.com/Kjellemann1/AlgoTrader-Go/sizing"
+  "github.com/Kjellemann1/AlgoTrader-Go/util"
+)
+
+type AssetInfo struct {
+  Status             string  // "active" or "inactive"
+  Tradable           bool
+  Shortable          bool
+  EasyToBorrow       bool
+  Fractionable       bool
+  MinOrderSize       decimal.Decimal  // Crypto only. Zero if not set.
+  MinTradeIncrement  decimal.Decimal
+}
+
+// Nil until loaded, in which case every symbol is assumed to be tradable
+var AssetInfos map[string]*AssetInfo
+
+func parseAssetInfo(v *fastjson.Value) *AssetInfo {
+  info := &AssetInfo{
+    Status: string(v.GetStringBytes("status")),
+    Tradable: v.GetBool("tradable"),
+    Shortable: v.GetBool("shortable"),
+    EasyToBorrow: v.GetBool("easy_to_borrow"),
+    Fractionable: v.GetBool("fractionable"),
+  }
+  info.MinOrderSize, _ = decimal.NewFromString(string(v.GetStringBytes("min_order_size")))
+  info.MinTradeIncrement, _ = decimal.NewFromString(string(v.GetStringBytes("min_trade_increment")))
+  return info
+}
+
+func (info *AssetInfo) active() bool {
+  return info.Status == "active" && info.Tradable
+}
+
+// Whether the asset can be sold short. Hard to borrow stocks can not be shorted
+// through the API.
+func (info *AssetInfo) shortable() bool {
+  return info == nil || info.Shortable && info.EasyToBorrow
+}
+
+// Order size rules of the asset. Stocks are traded in whole shares, as fractional
+// orders must be day orders. Crypto uses the increments of the asset if known.
+func (info *AssetInfo) rules(asset_class string) sizing.Rules {
+  rules := sizing.DefaultRules(asset_class)
+  if info == nil || asset_class != "crypto" {
+    return rules
+  }
+  if info.MinTradeIncrement.IsPositive() {
+    rules.Increment = info.MinTradeIncrement
+  }
+  rules.MinQty = info.MinOrderSize
+  return rules
+}
+
+func loadAssetInfos() error {
+  infos := make(map[string]*AssetInfo)
+  for asset_class, symbols := range map[string][]string{
+    "stock": config.C.StockSymbols,
+    "crypto": config.C.CryptoSymbols,
+  } {
+    if len(symbols) == 0 {
+      continue
+    }
+    arr, err := request.GetAssets(asset_class)
+    if err != nil {
+      return err
+    }
+    if arr == nil {
+      return fmt.Errorf("No %s assets returned", asset_class)
+    }
+    for _, v := range arr {
+      infos[string(v.GetStringBytes("symbol"))] = parseAssetInfo(v)
+    }
+  }
+  AssetInfos = infos
+  return nil
+}
+
+// Symbols that are active and tradable. Symbols that are unknown to the broker
+// are dropped as well.
+func tradableSymbols(symbols []string) []string {
+  tradable := []string{}
+  for _, symbol := range symbols {
+    info, ok := AssetInfos[symbol]
+    switch {
+    case !ok:
+      util.Warning(errors.New("Unknown symbol. Not subscribing."), "Symbol", symbol)
+    case !info.active():
+      util.Warning(errors.New("Symbol not tradable. Not subscribing."), "Symbol", symbol, "Status", info.Status)
+    default:
+      tradable = append(tradable, symbol)
+    }
+  }
+  return tradable
+}
+
+// Loads the asset metadata and removes the symbols that can not be traded from the
+// config. Trading continues with all symbols if the metadata can not be loaded.
+func filterTradableSymbols() {
+  if err := loadAssetInfos(); err != nil {
+    util.Warning(err, "Details", "Asset metadata not loaded. All symbols are assumed tradable.")
+    return
+  }
+  config.C.StockSymbols = tradableSymbols(config.C.StockSymbols)
+  config.C.CryptoSymbols = tradableSymbols(config.C.CryptoSymbols)
+  if len(config.C.StockSymbols) + len(config.C.CryptoSymbols) == 0 {
+    log.Panicln("No tradable symbols")
+  }
+}
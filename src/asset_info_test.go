@@ -0,0 +1,131 @@
+package main
+
+import (
+  "io"
+  "time"
+  "strings"
+  "testing"
+  "net/http"
+  "github.com/valyala/fastjson"
+  "github.com/shopspring/decimal"
+  "github.com/stretchr/testify/assert"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+)
+
+func withAssetInfos(t *testing.T, infos map[string]*AssetInfo) {
+  saved := AssetInfos
+  AssetInfos = infos
+  t.Cleanup(func() {
+    AssetInfos = saved
+  })
+}
+
+func TestParseAssetInfo(t *testing.T) {
+  v := fastjson.MustParse(`{
+    "symbol": "BTC/USD", "class": "crypto", "status": "active", "tradable": true,
+    "shortable": false, "fractionable": true,
+    "min_order_size": "0.0001", "min_trade_increment": "0.000000001", "price_increment": "1"
+  }`)
+  info := parseAssetInfo(v)
+  assert.True(t, info.active())
+  assert.False(t, info.shortable())
+  assert.Equal(t, "0.0001", info.MinOrderSize.String())
+
+  rules := info.rules("crypto")
+  assert.Equal(t, "0.0001", rules.MinQty.String())
+  assert.Equal(t, "0.000000001", rules.Increment.String())
+  assert.True(t, rules.Round(decimal.RequireFromString("0.00005")).IsZero())
+
+  // Stocks are traded in whole shares even if fractionable
+  stock := parseAssetInfo(fastjson.MustParse(`{"status": "active", "tradable": true, "fractionable": true}`))
+  assert.Equal(t, "1", stock.rules("stock").Increment.String())
+  assert.True(t, stock.MinTradeIncrement.IsZero())
+
+  var unknown *AssetInfo
+  assert.True(t, unknown.shortable())
+  assert.Equal(t, "1", unknown.rules("stock").MinQty.String())
+}
+
+func TestFilterTradableSymbols(t *testing.T) {
+  withAssetInfos(t, nil)
+  saved := *config.C
+  t.Cleanup(func() {
+    *config.C = saved
+  })
+  config.C.StockSymbols = []string{"AAPL", "OLD", "NOPE"}
+  config.C.CryptoSymbols = []string{"BTC/USD"}
+
+  request.HttpClient = &http.Client{
+    Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+      body := `[{"symbol": "BTC/USD", "status": "active", "tradable": true, "min_order_size": "0.0001"}]`
+      if req.URL.Query().Get("asset_class") == "us_equity" {
+        body = `[
+          {"symbol": "AAPL", "status": "active", "tradable": true, "shortable": true, "easy_to_borrow": true},
+          {"symbol": "OLD", "status": "inactive", "tradable": false}
+        ]`
+      }
+      return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+    }),
+  }
+
+  filterTradableSymbols()
+  assert.Equal(t, []string{"AAPL"}, config.C.StockSymbols)
+  assert.Equal(t, []string{"BTC/USD"}, config.C.CryptoSymbols)
+
+  a := allocAsset("crypto", "BTC/USD")
+  assert.NotNil(t, a.Info)
+  assert.Equal(t, "0.0001", a.Rules.MinQty.String())
+}
+
+func TestOpenChecksShortable(t *testing.T) {
+  a := newAssetTesting()
+  a.Positions = make(map[string]*Position)
+  a.Class = "stock"
+  a.Time = time.Now().UTC()
+  a.ReceivedTime = a.Time
+
+  a.Info = &AssetInfo{Status: "active", Tradable: true, Shortable: true}
+  assert.False(t, a.openChecks("short", "foo", a.Time), "Hard to borrow")
+  a.Info.EasyToBorrow = true
+  assert.True(t, a.openChecks("short", "foo", a.Time))
+  a.Info.Shortable = false
+  assert.False(t, a.openChecks("short", "foo", a.Time))
+  assert.True(t, a.openChecks("long", "foo", a.Time))
+}
+
+func TestCloseRoundedToIncrement(t *testing.T) {
+  var payload string
+  request.HttpClient = &http.Client{
+    Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+      body, _ := io.ReadAll(req.Body)
+      payload = string(body)
+      return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"id": "abc"}`))}, nil
+    }),
+  }
+
+  a := newAssetTesting()
+  a.Class = "crypto"
+  a.Symbol = "BTC/USD"
+  a.Rules = (&AssetInfo{
+    MinOrderSize: decimal.RequireFromString("0.0001"),
+    MinTradeIncrement: decimal.RequireFromString("0.0001"),
+  }).rules("crypto")
+  pos := NewPosition(a.Symbol)
+  pos.OpenOrderPending = false
+  pos.OpenSide = "long"
+  pos.Qty = decimal.RequireFromString("0.00123456")
+  a.Positions = map[string]*Position{"foo": pos}
+
+  a.closeFunc(IOC, "foo")
+  assert.Contains(t, payload, `"qty":"0.0012"`)
+  assert.True(t, pos.CloseOrderPending)
+
+  // The remainder is below the minimum order size
+  payload = ""
+  pos.CloseOrderPending = false
+  pos.Qty = decimal.RequireFromString("0.00003456")
+  a.closeFunc(IOC, "foo")
+  assert.Equal(t, "", payload)
+  assert.False(t, pos.CloseOrderPending)
+}
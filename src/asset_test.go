@@ -1,21 +1,34 @@
 package main
 
 import (
+  "io"
+  "strings"
   "testing"
   "time"
+  "net/http"
+  "github.com/valyala/fastjson"
   "github.com/shopspring/decimal"
   "github.com/stretchr/testify/assert"
   "github.com/qdm12/reprint"
-  "github.com/Kjellemann1/AlgoTrader-Go/constant"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/sizing"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+  "github.com/Kjellemann1/AlgoTrader-Go/indicator"
 )
 
 func newAssetTesting() (a *Asset) {
   a = &Asset{
     Symbol: "Foo",
-    O: make([]float64, constant.WINDOW_SIZE),
-    H: make([]float64, constant.WINDOW_SIZE),
-    L: make([]float64, constant.WINDOW_SIZE),
-    C: make([]float64, constant.WINDOW_SIZE),
+    O: make([]float64, config.C.WindowSize),
+    H: make([]float64, config.C.WindowSize),
+    L: make([]float64, config.C.WindowSize),
+    C: make([]float64, config.C.WindowSize),
+    V: make([]float64, config.C.WindowSize),
+    VW: make([]float64, config.C.WindowSize),
+    N: make([]float64, config.C.WindowSize),
+    indicators: make(map[string]indicator.Indicator),
+    frames: make(map[time.Duration]*Frame),
+    closed: make(map[time.Duration]bool),
   }
   return
 }
@@ -23,7 +36,7 @@ func newAssetTesting() (a *Asset) {
 func TestIndexingMethods(t *testing.T) {
   a := newAssetTesting()
   pos := 0
-  for i := range constant.WINDOW_SIZE {
+  for i := range config.C.WindowSize {
     a.C[i] = float64(i)
   }
 
@@ -45,48 +58,128 @@ func TestIndexingMethods(t *testing.T) {
 
 func TestWindowUpdate(t *testing.T) {
   t.Run("onBar", func(t *testing.T) {
-    test_size := constant.WINDOW_SIZE * 2
-    base_array := make([]float64, constant.WINDOW_SIZE)
+    test_size := config.C.WindowSize * 2
+    base_array := make([]float64, config.C.WindowSize)
     x := float64(test_size) - 1
-    for i := constant.WINDOW_SIZE - 1; i >= 0; i-- {
+    for i := config.C.WindowSize - 1; i >= 0; i-- {
       base_array[i] = x
       x--
     }
     a := newAssetTesting()
     for i := range test_size {
       j := float64(i)
-      a.updateWindowOnBar(j, j, j, j, time.Now(), time.Now())
+      a.updateWindowOnBar(j, j, j, j, j, j, j, time.Now(), time.Now())
     }
     assert.Equal(t, base_array, a.O)
     assert.Equal(t, base_array, a.H)
     assert.Equal(t, base_array, a.L)
     assert.Equal(t, base_array, a.C)
+    assert.Equal(t, base_array, a.V)
+    assert.Equal(t, base_array, a.VW)
+    assert.Equal(t, base_array, a.N)
+  })
+
+  t.Run("onTrade", func(t *testing.T) {
+    a := newAssetTesting()
+    t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    a.updateWindowOnBar(10, 11, 9, 10, 100, 10, 5, t0, t0)
+    a.updateWindowOnTrade(12, 1, t0.Add(10 * time.Second), t0)
+    a.updateWindowOnTrade(14, 3, t0.Add(20 * time.Second), t0)
+    assert.Equal(t, 14.0, a.C[a.i(0)])
+    assert.Equal(t, 4.0, a.V[a.i(0)])
+    assert.Equal(t, 13.5, a.VW[a.i(0)])
+    assert.Equal(t, 2.0, a.N[a.i(0)])
+    assert.Equal(t, 100.0, a.V[a.i(1)])
+    assert.Equal(t, 9.0, a.L[a.i(0)])
+
+    // The bar of the minute replaces the accumulated trades
+    t1 := t0.Add(time.Minute)
+    a.updateWindowOnBar(12, 14, 11, 13, 50, 12.5, 7, t1, t1)
+    assert.Equal(t, 13.0, a.C[a.i(0)])
+    assert.Equal(t, 50.0, a.V[a.i(0)])
+    assert.Equal(t, 12.5, a.VW[a.i(0)])
+    assert.Equal(t, 7.0, a.N[a.i(0)])
+    assert.Equal(t, 100.0, a.V[a.i(1)])
+    assert.Equal(t, 11.0, a.L[a.i(0)])
+
+    // Trades of a minute without a bar are dropped when the gap is filled
+    a.updateWindowOnTrade(15, 2, t1.Add(10 * time.Second), t1)
+    t3 := t1.Add(3 * time.Minute)
+    a.updateWindowOnBar(13, 14, 12, 13.5, 20, 13, 3, t3, t3)
+    assert.Equal(t, []float64{50, 0, 20}, a.s(&a.V, 0, 2))
+    assert.Equal(t, []float64{12.5, 13, 13}, a.s(&a.VW, 0, 2))
+    assert.Equal(t, []float64{7, 0, 3}, a.s(&a.N, 0, 2))
+    assert.Equal(t, []float64{13, 13, 13.5}, a.s(&a.C, 0, 2))
   })
 }
 
+// Indicators updated along with the windows must match indicators warmed up from
+// the windows afterwards.
+func TestIndicatorsIncremental(t *testing.T) {
+  a := newAssetTesting()
+  a.Class = "crypto"
+  start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+  a.updateWindowOnBar(10, 11, 9, 10, 100, 10, 5, start, start)
+  sma := a.sma(5)
+  atr := a.atr(5)
+  stoch := a.stochastic(5, 3)
+
+  ts := start
+  for i := range 60 {
+    p := 10 + float64(i % 7) - float64(i % 3)
+    ts = ts.Add(time.Minute)
+    if i % 10 == 9 {
+      // Gap of two minutes
+      ts = ts.Add(2 * time.Minute)
+    }
+    if i % 4 == 0 {
+      a.updateWindowOnTrade(p + 0.5, 2, ts.Add(10 * time.Second), ts)
+      a.updateWindowOnTrade(p - 0.5, 3, ts.Add(20 * time.Second), ts)
+    }
+    if i % 5 != 4 {
+      a.updateWindowOnBar(p, p + 1, p - 1, p + 0.25, 10 + p, p, 4, ts, ts)
+    }
+  }
+  // Provisional point from a trade in the current minute
+  ts = ts.Add(time.Minute)
+  a.updateWindowOnTrade(12, 1, ts, ts)
+
+  check := func(ind indicator.Indicator, fresh indicator.Indicator) {
+    a.warmUp(fresh)
+    for i := range 60 {
+      assert.InDelta(t, fresh.Value(i), ind.Value(i), 1e-9, "index %d", i)
+    }
+  }
+  check(sma, indicator.NewSMA(5, config.C.WindowSize))
+  check(atr, indicator.NewATR(5, config.C.WindowSize))
+  check(stoch, indicator.NewStochastic(5, 3, config.C.WindowSize))
+  check(a.vwap(), indicator.NewVWAP(config.C.WindowSize))
+  assert.InDelta(t, a.C[config.C.WindowSize - 1], a.sma(1).Value(0), 1e-9)
+}
+
 func TestPrepAssetsMap(t *testing.T) {
   assets := prepAssetsMap()
   assert.NotEmpty(t, assets)
-  assert.Equal(t, len(constant.STOCK_SYMBOLS), len(assets["stock"]))
-  assert.Equal(t, len(constant.CRYPTO_SYMBOLS), len(assets["crypto"]))
+  assert.Equal(t, len(config.C.StockSymbols), len(assets["stock"]))
+  assert.Equal(t, len(config.C.CryptoSymbols), len(assets["crypto"]))
 }
 
 func TestFillMissingMinutes(t *testing.T) {
   asset := newAssetTesting()
-  for i := constant.WINDOW_SIZE - 1; i >= 0; i-- {
+  for i := config.C.WindowSize - 1; i >= 0; i-- {
     asset.C[i] = float64(i)
     asset.O[i] = float64(i)
     asset.H[i] = float64(i)
     asset.L[i] = float64(i)
   }
 
-  baseArray := make([]float64, constant.WINDOW_SIZE)
-  for i := constant.WINDOW_SIZE - 1; i >= 0; i-- {
+  baseArray := make([]float64, config.C.WindowSize)
+  for i := config.C.WindowSize - 1; i >= 0; i-- {
     baseArray[i] = float64(i)
   }
 
   t.Run("Different day for stock", func(t *testing.T) {
-    b := make([]float64, constant.WINDOW_SIZE)
+    b := make([]float64, config.C.WindowSize)
     copy(b, baseArray)
     a, _ := reprint.This(asset).(*Asset)
 		a.Class = "stock"
@@ -100,11 +193,11 @@ func TestFillMissingMinutes(t *testing.T) {
 	})
 
   for range 3 {
-    rollFloat(&baseArray, asset.C[constant.WINDOW_SIZE-1])
+    rollFloat(&baseArray, asset.C[config.C.WindowSize-1])
   }
 
 	t.Run("Different day for crypto", func(t *testing.T) {
-    b := make([]float64, constant.WINDOW_SIZE)
+    b := make([]float64, config.C.WindowSize)
     copy(b, baseArray)
     a, _ := reprint.This(asset).(*Asset)
 		a.Time = time.Date(2001, 1, 1, 23, 59, 0, 0, time.UTC)
@@ -118,7 +211,7 @@ func TestFillMissingMinutes(t *testing.T) {
 	})
 
 	t.Run("Same day", func(t *testing.T) {
-    b := make([]float64, constant.WINDOW_SIZE)
+    b := make([]float64, config.C.WindowSize)
     copy(b, baseArray)
     a, _ := reprint.This(asset).(*Asset)
 		a.Time = time.Date(2001, 1, 1, 0, 1, 0, 0, time.UTC)
@@ -196,7 +289,7 @@ func TestSumNoPendingPosQtys(t *testing.T) {
 
 func TestPriceDeviation(t *testing.T) {
   a := newAssetTesting()
-  p := &a.C[constant.WINDOW_SIZE - 1]
+  p := &a.C[config.C.WindowSize - 1]
   base_price := 100.0
 
   *p = 100
@@ -213,8 +306,8 @@ func TestStopLoss(t *testing.T) {
   var accum int
 
   a := newAssetTesting()
-  p := &a.C[constant.WINDOW_SIZE - 1]
-  a.close = func(string, string) {
+  p := &a.C[config.C.WindowSize - 1]
+  a.close = func(request.OrderParams, string) {
     accum+= 1
   }
 
@@ -241,8 +334,8 @@ func TestTakeProfit(t *testing.T) {
   var accum int
 
   a := newAssetTesting()
-  p := &a.C[constant.WINDOW_SIZE - 1]
-  a.close = func(string, string) {
+  p := &a.C[config.C.WindowSize - 1]
+  a.close = func(request.OrderParams, string) {
     accum+= 1
   }
 
@@ -270,7 +363,7 @@ func TestTrailingStop(t *testing.T) {
 
   a := newAssetTesting()
   p := &a.C[a.i(0)]
-  a.close = func(string, string) {
+  a.close = func(request.OrderParams, string) {
     accum+= 1
   }
 
@@ -292,3 +385,119 @@ func TestTrailingStop(t *testing.T) {
   a.trailingStop(5, "foo")
   assert.Equal(t, 1, accum)
 }
+
+func TestShortStops(t *testing.T) {
+  var accum int
+
+  a := newAssetTesting()
+  p := &a.C[a.i(0)]
+  a.close = func(request.OrderParams, string) {
+    accum+= 1
+  }
+  a.Positions = make(map[string]*Position)
+  a.Positions["foo"] = &Position{OpenFilledAvgPrice: 100, OpenSide: "short"}
+
+  t.Run("stopLoss", func(t *testing.T) {
+    accum = 0
+    *p = 90
+    a.stopLoss(5, "foo")
+    assert.Equal(t, 0, accum)
+    *p = 110
+    a.stopLoss(5, "foo")
+    assert.Equal(t, 1, accum)
+  })
+
+  t.Run("takeProfit", func(t *testing.T) {
+    accum = 0
+    *p = 110
+    a.takeProfit(5, "foo")
+    assert.Equal(t, 0, accum)
+    *p = 90
+    a.takeProfit(5, "foo")
+    assert.Equal(t, 1, accum)
+  })
+
+  t.Run("trailingStop", func(t *testing.T) {
+    accum = 0
+    *p = 100
+    a.trailingStop(5, "foo")
+    assert.Equal(t, 100.0, a.Positions["foo"].TrailingStopBase)
+    *p = 80
+    a.trailingStop(5, "foo")
+    assert.Equal(t, 80.0, a.Positions["foo"].TrailingStopBase)
+    assert.Equal(t, 0, accum)
+    *p = 82
+    a.trailingStop(5, "foo")
+    assert.Equal(t, 0, accum)
+    *p = 90
+    a.trailingStop(5, "foo")
+    assert.Equal(t, 1, accum)
+  })
+}
+
+func TestOpenChecksShortCrypto(t *testing.T) {
+  a := newAssetTesting()
+  a.Positions = make(map[string]*Position)
+  a.Time = time.Now().UTC()
+  a.ReceivedTime = a.Time
+
+  a.Class = "crypto"
+  assert.False(t, a.openChecks("short", "foo", a.Time))
+  assert.True(t, a.openChecks("long", "foo", a.Time))
+
+  a.Class = "stock"
+  assert.True(t, a.openChecks("short", "foo", a.Time))
+}
+
+func TestCloseClientOrderIDs(t *testing.T) {
+  var ids []string
+  status := 422
+  request.HttpClient = &http.Client{
+    Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+      body, _ := io.ReadAll(req.Body)
+      ids = append(ids, fastjson.GetString(body, "client_order_id"))
+      return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(`{"id":"abc"}`))}, nil
+    }),
+  }
+  a := newAssetTesting()
+  a.Class = "crypto"
+  pos := NewPosition(a.Symbol)
+  pos.StratName = "foo"
+  pos.PositionID = "symbol[Foo]_strat[foo]_time[2024-07-02 14:00:00]"
+  pos.OpenSide = "long"
+  pos.OpenOrderPending = false
+  pos.Qty = decimal.NewFromInt(2)
+  a.Positions = map[string]*Position{"foo": pos}
+
+  a.closeFunc(IOC, "foo")
+  assert.False(t, pos.CloseOrderPending, "Rejected close is not pending")
+  status = 200
+  a.closeFunc(IOC, "foo")
+  assert.True(t, pos.CloseOrderPending)
+  assert.Equal(t, []string{pos.PositionID + "_close1", pos.PositionID + "_close2"}, ids)
+  assert.Equal(t, "foo", *grepStratName(&ids[1]))
+}
+
+func TestCloseDust(t *testing.T) {
+  saved := dbQueue
+  dbQueue = make(chan *Query, 1)
+  t.Cleanup(func() {
+    dbQueue = saved
+  })
+  a := newAssetTesting()
+  a.Class = "crypto"
+  a.Rules = sizing.Rules{MinQty: decimal.New(1, -3), Increment: decimal.New(1, -9)}
+  pos := NewPosition(a.Symbol)
+  pos.OpenOrderPending = false
+  pos.Qty = decimal.New(5, -4)
+  a.Qty = pos.Qty
+  a.Positions = map[string]*Position{"foo": pos}
+
+  a.closeFunc(IOC, "foo")
+  assert.Nil(t, a.Positions["foo"])
+  assert.True(t, a.Qty.IsZero())
+  assert.True(t, a.sumPosQtysEqAssetQty())
+  query := <-dbQueue
+  assert.Equal(t, "delete_position", query.Action)
+  assert.Equal(t, "foo", query.StratName)
+}
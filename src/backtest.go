@@ -0,0 +1,678 @@
+// The backtester replays historical 1-minute bars through the same Asset, Position
+// and strategy code that is used in live trading. Orders from strategies are routed
+// to a simulated fill engine instead of the broker, which fills market orders at the
+// last close of the asset adjusted for slippage and commission. Limit and stop orders
+// that are not marketable rest until a later bar reaches their price.
+
+package main
+
+import (
+  "os"
+  "io"
+  "log"
+  "fmt"
+  "sort"
+  "time"
+  "errors"
+  "strconv"
+  "encoding/csv"
+  "github.com/valyala/fastjson"
+  "github.com/shopspring/decimal"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+)
+
+type BacktestBar struct {
+  Symbol  string
+  Time    time.Time  // Start time of the bar, as returned by the API
+  O       float64
+  H       float64
+  L       float64
+  C       float64
+  V       float64
+  VW      float64
+  N       float64
+}
+
+type BacktestTrade struct {
+  Symbol      string
+  StratName   string
+  Side        string
+  Qty         decimal.Decimal
+  OpenTime    time.Time
+  OpenPrice   float64
+  CloseTime   time.Time
+  ClosePrice  float64
+  Commission  float64
+  PnL         float64
+}
+
+type EquityPoint struct {
+  Time    time.Time
+  Equity  float64
+}
+
+type Backtest struct {
+  assets           map[string]map[string]*Asset
+  cash             float64
+  start_cash       float64
+  commission_pct   float64
+  slippage_pct     float64
+  warmup           int
+  n_bars           map[string]int
+  orders           []*backtestOrder
+
+  Trades           []*BacktestTrade
+  Equity           []EquityPoint
+}
+
+func NewBacktest(start_cash float64, commission_pct float64, slippage_pct float64) *Backtest {
+  return &Backtest{
+    assets: make(map[string]map[string]*Asset),
+    cash: start_cash,
+    start_cash: start_cash,
+    commission_pct: commission_pct,
+    slippage_pct: slippage_pct,
+    warmup: config.C.WindowSize,
+    n_bars: make(map[string]int),
+  }
+}
+
+// Adds an asset whose open and close calls are routed to the simulated fill engine.
+// The strategies are not started, so checkForSignal runs them synchronously.
+func (bt *Backtest) addAsset(asset_class string, symbol string) *Asset {
+  a := allocAsset(asset_class, symbol)
+  a.open = bt.openFunc(a)
+  a.close = bt.closeFunc(a)
+  a.cancel = bt.cancelFunc(a)
+  a.exit = bt.exitFunc(a)
+  if _, ok := bt.assets[asset_class]; !ok {
+    bt.assets[asset_class] = make(map[string]*Asset)
+  }
+  bt.assets[asset_class][symbol] = a
+  return a
+}
+
+func (bt *Backtest) fillPrice(a *Asset, side string) float64 {
+  return bt.slip(a.C[a.i(0)], side)
+}
+
+func (bt *Backtest) slip(price float64, side string) float64 {
+  if side == "buy" {
+    return price * (1 + bt.slippage_pct / 100)
+  }
+  return price * (1 - bt.slippage_pct / 100)
+}
+
+func (bt *Backtest) commission(price float64, qty decimal.Decimal) float64 {
+  return price * qty.Abs().InexactFloat64() * bt.commission_pct / 100
+}
+
+// Price an order would be filled at if sent at price p, which is the last close for
+// new orders and the open of the bar for resting orders. Returns false if the order
+// is not marketable at p. Limit orders are filled without slippage.
+func (bt *Backtest) marketablePrice(o *backtestOrder, p float64) (float64, bool) {
+  buy := o.side == "buy"
+  params := o.params
+  if params.Type == "stop" || (params.Type == "stop_limit" && !o.triggered) {
+    if (buy && p < params.StopPrice) || (!buy && p > params.StopPrice) {
+      return 0, false
+    }
+    if params.Type == "stop" {
+      return bt.slip(p, o.side), true
+    }
+    o.triggered = true
+  }
+  if params.Type == "limit" || params.Type == "stop_limit" {
+    if (buy && p > params.LimitPrice) || (!buy && p < params.LimitPrice) {
+      return 0, false
+    }
+    return p, true
+  }
+  return bt.slip(p, o.side), true
+}
+
+// Price a resting order is filled at within the bar, or false if the bar does not
+// reach the order. Gaps through the order price are filled at the open.
+func (bt *Backtest) restingFillPrice(o *backtestOrder, bar BacktestBar) (float64, bool) {
+  if price, ok := bt.marketablePrice(o, bar.O); ok {
+    return price, true
+  }
+  buy := o.side == "buy"
+  params := o.params
+  if params.Type == "stop" || (params.Type == "stop_limit" && !o.triggered) {
+    if (buy && bar.H < params.StopPrice) || (!buy && bar.L > params.StopPrice) {
+      return 0, false
+    }
+    if params.Type == "stop" {
+      return bt.slip(params.StopPrice, o.side), true
+    }
+    o.triggered = true
+    if (buy && params.StopPrice <= params.LimitPrice) || (!buy && params.StopPrice >= params.LimitPrice) {
+      return params.StopPrice, true
+    }
+  }
+  if (buy && bar.L <= params.LimitPrice) || (!buy && bar.H >= params.LimitPrice) {
+    return params.LimitPrice, true
+  }
+  return 0, false
+}
+
+// Order resting in the simulated book until it is filled, canceled or expires.
+type backtestOrder struct {
+  a           *Asset
+  strat_name  string
+  side        string  // "buy" or "sell"
+  close       bool
+  params      request.OrderParams
+  qty         decimal.Decimal
+  placed      time.Time
+  triggered   bool    // Stop price of a stop limit order has been reached
+  leg         string  // "take_profit" or "stop_loss" for legs of bracket and OCO orders
+}
+
+func (bt *Backtest) openFunc(a *Asset) func(string, request.OrderParams, string) {
+  return func(side string, params request.OrderParams, strat_name string) {
+    if !a.openChecks(side, strat_name, a.Time) {
+      return
+    }
+    if err := params.Validate(a.Class); err != nil {
+      return
+    }
+
+    order_side := openOrderSide(side)
+    if err := params.ValidateExits(order_side); err != nil {
+      return
+    }
+    qty, err := a.openQty(params, bt.fillPrice(a, order_side), func() (float64, error) {
+      return bt.equity(), nil
+    })
+    if err != nil || qty.IsZero() {
+      return
+    }
+    notional := qty.InexactFloat64() * a.C[a.i(0)]
+    if Risk.checkOrder(a, notional, a.Time) != nil {
+      return
+    }
+    // Backtests open one position at a time
+    Risk.release(notional)
+    if side == "short" {
+      qty = qty.Neg()
+    }
+
+    pos := NewPosition(a.Symbol)
+    pos.Symbol = a.Symbol
+    pos.AssetClass = a.Class
+    pos.StratName = strat_name
+    pos.PositionID = a.createPositionID(strat_name)
+    pos.OpenSide = side
+    pos.OpenOrderType = params.String()
+    pos.OpenTriggerPrice = a.C[a.i(0)]
+    pos.OpenTriggerTime = a.Time
+    pos.OpenNotional = notional
+    pos.OpenPriceTime = a.Time
+    pos.OpenPriceReceivedTime = a.ReceivedTime
+
+    o := &backtestOrder{a: a, strat_name: strat_name, side: order_side, params: params, qty: qty, placed: a.Time}
+    price, ok := bt.marketablePrice(o, a.C[a.i(0)])
+    if ok {
+      bt.fillOpen(a, pos, qty, price, a.Time)
+      bt.placeLegs(a, pos, params)
+      return
+    }
+    if params.Immediate() {
+      return
+    }
+    a.Rwm.Lock()
+    a.Positions[strat_name] = pos
+    a.Rwm.Unlock()
+    bt.orders = append(bt.orders, o)
+  }
+}
+
+func (bt *Backtest) fillOpen(a *Asset, pos *Position, qty decimal.Decimal, price float64, t time.Time) {
+  pos.OpenFillTime = t
+  pos.OpenFilledAvgPrice = price
+  pos.OpenOrderPending = false
+  pos.Qty = qty
+
+  bt.cash -= price * qty.InexactFloat64() + bt.commission(price, qty)
+
+  a.Rwm.Lock()
+  a.Positions[pos.StratName] = pos
+  a.Qty = a.Qty.Add(qty)
+  a.Rwm.Unlock()
+}
+
+// Places the take profit and stop loss legs of a bracket or OCO order as resting close
+// orders. The stop loss is placed first, so it is assumed to be reached first when a
+// bar reaches both legs.
+func (bt *Backtest) placeLegs(a *Asset, pos *Position, params request.OrderParams) {
+  if params.OrderClass != "bracket" && params.OrderClass != "oco" {
+    return
+  }
+  side := closeOrderSide(pos.OpenSide)
+  stop_loss := request.Stop(params.StopLoss, params.TimeInForce)
+  if params.StopLossLimit > 0 {
+    stop_loss = request.StopLimit(params.StopLoss, params.StopLossLimit, params.TimeInForce)
+  }
+  bt.orders = append(bt.orders,
+    &backtestOrder{a: a, strat_name: pos.StratName, side: side, close: true, params: stop_loss, placed: a.Time, leg: "stop_loss"},
+    &backtestOrder{a: a, strat_name: pos.StratName, side: side, close: true, params: request.Limit(params.TakeProfit, params.TimeInForce), placed: a.Time, leg: "take_profit"},
+  )
+  pos.StopLossOrderID = pos.PositionID + "_stop_loss"
+  pos.TakeProfitOrderID = pos.PositionID + "_take_profit"
+}
+
+func (bt *Backtest) removeLegs(a *Asset, pos *Position) {
+  remaining := bt.orders[:0]
+  for _, o := range bt.orders {
+    if !(o.a == a && o.strat_name == pos.StratName && o.leg != "") {
+      remaining = append(remaining, o)
+    }
+  }
+  bt.orders = remaining
+  pos.clearLegs()
+}
+
+func (bt *Backtest) exitFunc(a *Asset) func(request.OrderParams, string) {
+  return func(params request.OrderParams, strat_name string) {
+    pos, ok := a.Positions[strat_name]
+    if !ok || pos.OpenOrderPending || pos.CloseOrderPending || pos.hasLegs() {
+      return
+    }
+    if params.OrderClass != "oco" || params.Validate(a.Class) != nil || params.ValidateExits(closeOrderSide(pos.OpenSide)) != nil {
+      return
+    }
+    bt.placeLegs(a, pos, params)
+  }
+}
+
+func (bt *Backtest) closeFunc(a *Asset) func(request.OrderParams, string) {
+  return func(params request.OrderParams, strat_name string) {
+    pos, ok := a.Positions[strat_name]
+    if !ok || pos.OpenOrderPending || pos.CloseOrderPending {
+      return
+    }
+    if err := params.Validate(a.Class); err != nil {
+      return
+    }
+    bt.removeLegs(a, pos)
+
+    order_side := closeOrderSide(pos.OpenSide)
+    o := &backtestOrder{a: a, strat_name: strat_name, side: order_side, close: true, params: params, placed: a.Time}
+    price, ok := bt.marketablePrice(o, a.C[a.i(0)])
+    if ok {
+      bt.fillClose(a, pos, price, a.Time)
+      return
+    }
+    if params.Immediate() {
+      return
+    }
+    pos.CloseOrderPending = true
+    pos.CloseOrderType = params.String()
+    pos.CloseParams = params
+    bt.orders = append(bt.orders, o)
+  }
+}
+
+func (bt *Backtest) fillClose(a *Asset, pos *Position, price float64, t time.Time) {
+  commission := bt.commission(price, pos.Qty)
+  bt.cash += price * pos.Qty.InexactFloat64() - commission
+
+  open_commission := bt.commission(pos.OpenFilledAvgPrice, pos.Qty)
+  bt.Trades = append(bt.Trades, &BacktestTrade{
+    Symbol: a.Symbol,
+    StratName: pos.StratName,
+    Side: pos.OpenSide,
+    Qty: pos.Qty,
+    OpenTime: pos.OpenFillTime,
+    OpenPrice: pos.OpenFilledAvgPrice,
+    CloseTime: t,
+    ClosePrice: price,
+    Commission: open_commission + commission,
+    PnL: (price - pos.OpenFilledAvgPrice) * pos.Qty.InexactFloat64() - open_commission - commission,
+  })
+  Risk.recordClose(pos.Qty, pos.OpenFilledAvgPrice, price, t)
+
+  a.Rwm.Lock()
+  a.Qty = a.Qty.Sub(pos.Qty)
+  delete(a.Positions, pos.StratName)
+  a.Rwm.Unlock()
+}
+
+// Cancels the resting order of the position. A canceled open removes the position,
+// and a canceled close leaves it open. Legs are canceled by closing the position.
+func (bt *Backtest) cancelFunc(a *Asset) func(string) {
+  return func(strat_name string) {
+    for i, o := range bt.orders {
+      if o.a == a && o.strat_name == strat_name && o.leg == "" {
+        bt.orders = append(bt.orders[:i], bt.orders[i+1:]...)
+        bt.orderDone(o)
+        return
+      }
+    }
+  }
+}
+
+func (bt *Backtest) orderDone(o *backtestOrder) {
+  pos, ok := o.a.Positions[o.strat_name]
+  if !ok {
+    return
+  }
+  switch {
+  case o.leg == "take_profit":
+    pos.TakeProfitOrderID = ""
+    return
+  case o.leg == "stop_loss":
+    pos.StopLossOrderID = ""
+    return
+  case o.close:
+    pos.CloseOrderPending = false
+    return
+  }
+  o.a.Rwm.Lock()
+  delete(o.a.Positions, o.strat_name)
+  o.a.Rwm.Unlock()
+}
+
+// Fills resting orders of the bar's symbol that are reached within the bar, and
+// expires day orders placed on an earlier date. Called before the bar is added to
+// the window, so orders are filled in the bar after the one they were placed in.
+func (bt *Backtest) fillResting(asset_class string, bar BacktestBar, t time.Time) {
+  orders := bt.orders
+  bt.orders = nil
+  for _, o := range orders {
+    if o.a.Class != asset_class || o.a.Symbol != bar.Symbol {
+      bt.orders = append(bt.orders, o)
+      continue
+    }
+    if o.params.TimeInForce == "day" && o.placed.Format(time.DateOnly) != bar.Time.Format(time.DateOnly) {
+      bt.orderDone(o)
+      continue
+    }
+    // The other leg of a filled leg, which the broker cancels
+    pos, ok := o.a.Positions[o.strat_name]
+    if !ok || o.leg != "" && !pos.hasLegs() {
+      continue
+    }
+    price, ok := bt.restingFillPrice(o, bar)
+    if !ok {
+      bt.orders = append(bt.orders, o)
+      continue
+    }
+    if o.close {
+      pos.clearLegs()
+      bt.fillClose(o.a, pos, price, t)
+    } else {
+      bt.fillOpen(o.a, pos, o.qty, price, t)
+      bt.placeLegs(o.a, pos, o.params)
+    }
+  }
+
+  // Legs of positions closed in this bar
+  remaining := bt.orders[:0]
+  for _, o := range bt.orders {
+    if _, ok := o.a.Positions[o.strat_name]; ok || !o.close {
+      remaining = append(remaining, o)
+    }
+  }
+  bt.orders = remaining
+}
+
+func (bt *Backtest) equity() float64 {
+  equity := bt.cash
+  for _, asset_class := range bt.assets {
+    for _, a := range asset_class {
+      equity += a.Qty.InexactFloat64() * a.C[a.i(0)]
+    }
+  }
+  return equity
+}
+
+// Feeds the bars through the asset windows in time order. Bars with the same time
+// are processed before the equity curve is updated.
+func (bt *Backtest) run(asset_class string, bars []BacktestBar) {
+  sort.SliceStable(bars, func(i, j int) bool {
+    if bars[i].Time.Equal(bars[j].Time) {
+      return bars[i].Symbol < bars[j].Symbol
+    }
+    return bars[i].Time.Before(bars[j].Time)
+  })
+
+  for i, bar := range bars {
+    a, ok := bt.assets[asset_class][bar.Symbol]
+    if !ok {
+      a = bt.addAsset(asset_class, bar.Symbol)
+    }
+
+    // Live bars are stamped with their end time in onMarketBarUpdate
+    t := bar.Time.Add(1 * time.Minute)
+    bt.fillResting(asset_class, bar, t)
+    triggered := a.updateWindowOnBar(bar.O, bar.H, bar.L, bar.C, bar.V, bar.VW, bar.N, t, t)
+
+    bt.n_bars[bar.Symbol]++
+    if bt.n_bars[bar.Symbol] >= bt.warmup {
+      a.checkForSignal(triggered)
+    }
+
+    if i == len(bars) - 1 || !bars[i+1].Time.Equal(bar.Time) {
+      bt.Equity = append(bt.Equity, EquityPoint{Time: t, Equity: bt.equity()})
+    }
+  }
+}
+
+// Cancels resting orders and closes any positions still open at the end of the
+// backtest at the last close.
+func (bt *Backtest) closeAll() {
+  for _, o := range bt.orders {
+    bt.orderDone(o)
+  }
+  bt.orders = nil
+  for _, asset_class := range bt.assets {
+    for _, a := range asset_class {
+      for strat_name := range a.Positions {
+        a.close(IOC, strat_name)
+      }
+    }
+  }
+  if len(bt.Equity) > 0 {
+    bt.Equity[len(bt.Equity) - 1].Equity = bt.equity()
+  }
+}
+
+func (bt *Backtest) summary() {
+  var wins int
+  var pnl float64
+  for _, trade := range bt.Trades {
+    pnl += trade.PnL
+    if trade.PnL > 0 {
+      wins++
+    }
+  }
+
+  var max_drawdown, peak float64
+  for _, point := range bt.Equity {
+    peak = max(peak, point.Equity)
+    if peak > 0 {
+      max_drawdown = max(max_drawdown, (peak - point.Equity) / peak * 100)
+    }
+  }
+
+  win_rate := 0.0
+  if len(bt.Trades) > 0 {
+    win_rate = float64(wins) / float64(len(bt.Trades)) * 100
+  }
+
+  log.Printf("[ BACKTEST ]\tTrades: %d\tWin rate: %.1f%%\tPnL: %.2f\tReturn: %.2f%%\tMax drawdown: %.2f%%\n",
+    len(bt.Trades), win_rate, pnl, (bt.equity() / bt.start_cash - 1) * 100, max_drawdown,
+  )
+}
+
+func (bt *Backtest) writeTrades(path string) error {
+  f, err := os.Create(path)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+
+  w := csv.NewWriter(f)
+  _ = w.Write([]string{
+    "symbol", "strat_name", "side", "qty", "open_time", "open_price",
+    "close_time", "close_price", "commission", "pnl",
+  })
+  for _, t := range bt.Trades {
+    _ = w.Write([]string{
+      t.Symbol, t.StratName, t.Side, t.Qty.String(),
+      t.OpenTime.Format(time.RFC3339), strconv.FormatFloat(t.OpenPrice, 'f', -1, 64),
+      t.CloseTime.Format(time.RFC3339), strconv.FormatFloat(t.ClosePrice, 'f', -1, 64),
+      strconv.FormatFloat(t.Commission, 'f', -1, 64), strconv.FormatFloat(t.PnL, 'f', -1, 64),
+    })
+  }
+  w.Flush()
+  return w.Error()
+}
+
+func (bt *Backtest) writeEquity(path string) error {
+  f, err := os.Create(path)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+
+  w := csv.NewWriter(f)
+  _ = w.Write([]string{"time", "equity"})
+  for _, point := range bt.Equity {
+    _ = w.Write([]string{point.Time.Format(time.RFC3339), strconv.FormatFloat(point.Equity, 'f', -1, 64)})
+  }
+  w.Flush()
+  return w.Error()
+}
+
+// Reads bars from a csv file with the header: symbol,time,open,high,low,close
+// optionally followed by volume,vwap,trade_count. Time is the start time of the bar
+// in RFC3339. Without volume columns the bars have zero volume and vwap at close.
+func loadBarsCSV(r io.Reader) ([]BacktestBar, error) {
+  records, err := csv.NewReader(r).ReadAll()
+  if err != nil {
+    return nil, err
+  }
+  if len(records) == 0 {
+    return nil, errors.New("No records in bar file")
+  }
+
+  bars := make([]BacktestBar, 0, len(records) - 1)
+  for n, rec := range records[1:] {
+    if len(rec) < 6 {
+      return nil, fmt.Errorf("Too few fields on line %d", n + 2)
+    }
+    t, err := time.Parse(time.RFC3339, rec[1])
+    if err != nil {
+      return nil, fmt.Errorf("Invalid time on line %d: %w", n + 2, err)
+    }
+    var ohlc [4]float64
+    for i := range 4 {
+      ohlc[i], err = strconv.ParseFloat(rec[i + 2], 64)
+      if err != nil {
+        return nil, fmt.Errorf("Invalid price on line %d: %w", n + 2, err)
+      }
+    }
+    bar := BacktestBar{
+      Symbol: rec[0], Time: t.UTC(), O: ohlc[0], H: ohlc[1], L: ohlc[2], C: ohlc[3], VW: ohlc[3],
+    }
+    if len(rec) >= 9 {
+      var vwn [3]float64
+      for i := range 3 {
+        vwn[i], err = strconv.ParseFloat(rec[i + 6], 64)
+        if err != nil {
+          return nil, fmt.Errorf("Invalid volume on line %d: %w", n + 2, err)
+        }
+      }
+      bar.V, bar.VW, bar.N = vwn[0], vwn[1], vwn[2]
+    }
+    bars = append(bars, bar)
+  }
+  return bars, nil
+}
+
+// Downloads 1-minute bars from the historical data API in the same format used
+// by getHistBars.
+func downloadBars(asset_class string, symbols []string, start time.Time, end time.Time) ([]BacktestBar, error) {
+  var bars []BacktestBar
+  p := fastjson.Parser{}
+  page_token := "start"
+  for page_token != "" {
+    body, err := request.GetReq(urlBars(asset_class, symbols, "1Min", start, end, page_token))
+    if err != nil {
+      return nil, err
+    }
+    parsed, err := p.ParseBytes(body)
+    if err != nil {
+      return nil, err
+    }
+    obj, err := parsed.Get("bars").Object()
+    if err != nil {
+      return nil, err
+    }
+    obj.Visit(func(symbol []byte, value *fastjson.Value) {
+      for _, bar := range value.GetArray() {
+        t, _ := time.Parse("2006-01-02T15:04:05Z", string(bar.GetStringBytes("t")))
+        bars = append(bars, BacktestBar{
+          Symbol: string(symbol),
+          Time: t,
+          O: bar.GetFloat64("o"),
+          H: bar.GetFloat64("h"),
+          L: bar.GetFloat64("l"),
+          C: bar.GetFloat64("c"),
+          V: bar.GetFloat64("v"),
+          VW: bar.GetFloat64("vw"),
+          N: bar.GetFloat64("n"),
+        })
+      }
+    })
+    page_token = string(parsed.GetStringBytes("next_page_token"))
+  }
+  return bars, nil
+}
+
+// Entry point for backtest mode. Source is either a path to a csv file, or "alpaca"
+// to download the bars of the configured symbols between start and end.
+func runBacktest(source string, asset_class string, start time.Time, end time.Time, out_dir string) {
+  var bars []BacktestBar
+  var err error
+  if source == "alpaca" {
+    if start.IsZero() {
+      start = time.Now().UTC().AddDate(0, 0, -config.C.HistDays)
+    }
+    symbols := config.C.CryptoSymbols
+    if asset_class == "stock" {
+      symbols = config.C.StockSymbols
+    }
+    bars, err = downloadBars(asset_class, symbols, start, end)
+  } else {
+    var f *os.File
+    f, err = os.Open(source)
+    if err == nil {
+      defer f.Close()
+      bars, err = loadBarsCSV(f)
+    }
+  }
+  if err != nil {
+    log.Panicln(err)
+  }
+
+  log.Printf("[ BACKTEST ]\tReplaying %d bars for %s\n", len(bars), asset_class)
+
+  bt := NewBacktest(config.C.BacktestCash, config.C.BacktestCommissionPct, config.C.BacktestSlippagePct)
+  Risk = NewRiskManager(bt.assets)
+  bt.run(asset_class, bars)
+  bt.closeAll()
+  bt.summary()
+
+  if err := bt.writeTrades(out_dir + "/backtest_trades.csv"); err != nil {
+    log.Println("[ ERROR ]\tFailed to write trades:", err)
+  }
+  if err := bt.writeEquity(out_dir + "/backtest_equity.csv"); err != nil {
+    log.Println("[ ERROR ]\tFailed to write equity curve:", err)
+  }
+}
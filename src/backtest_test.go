@@ -0,0 +1,188 @@
+package main
+
+import (
+  "testing"
+  "strings"
+  "time"
+  "github.com/stretchr/testify/assert"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+)
+
+const backtestCSV = `symbol,time,open,high,low,close
+FOO,2025-01-02T10:00:00Z,100,100,100,100
+FOO,2025-01-02T10:01:00Z,100,100,100,100
+FOO,2025-01-02T10:02:00Z,110,110,110,110
+FOO,2025-01-02T10:03:00Z,120,120,120,120
+FOO,2025-01-02T10:04:00Z,90,90,90,90
+FOO,2025-01-02T10:05:00Z,90,90,90,90
+`
+
+// Opens when the close crosses above 105 and closes when it drops below 95
+func testBacktestStrat(a *Asset) {
+  a.Mutex.Lock()
+  defer a.Mutex.Unlock()
+  c := a.C[a.i(0)]
+  if c > 105 {
+    a.open("long", IOC, "test")
+  } else if c < 95 {
+    a.close(IOC, "test")
+  }
+}
+
+func TestLoadBarsCSV(t *testing.T) {
+  bars, err := loadBarsCSV(strings.NewReader(backtestCSV))
+  assert.Nil(t, err)
+  assert.Equal(t, 6, len(bars))
+  assert.Equal(t, "FOO", bars[2].Symbol)
+  assert.Equal(t, 110.0, bars[2].C)
+  assert.Equal(t, time.Date(2025, 1, 2, 10, 2, 0, 0, time.UTC), bars[2].Time)
+
+  assert.Equal(t, 0.0, bars[2].V)
+  assert.Equal(t, 110.0, bars[2].VW)
+
+  _, err = loadBarsCSV(strings.NewReader("symbol,time,open,high,low,close\nFOO,bad,1,1,1,1\n"))
+  assert.NotNil(t, err)
+
+  bars, err = loadBarsCSV(strings.NewReader(
+    "symbol,time,open,high,low,close,volume,vwap,trade_count\n" +
+    "FOO,2025-01-02T10:00:00Z,1,2,0.5,1.5,300,1.2,12\n",
+  ))
+  assert.Nil(t, err)
+  assert.Equal(t, 300.0, bars[0].V)
+  assert.Equal(t, 1.2, bars[0].VW)
+  assert.Equal(t, 12.0, bars[0].N)
+}
+
+func TestBacktestRun(t *testing.T) {
+  bars, _ := loadBarsCSV(strings.NewReader(backtestCSV))
+
+  bt := NewBacktest(1000, 0, 0)
+  bt.warmup = 1
+  a := bt.addAsset("crypto", "FOO")
+  a.strategies = []strategyFunc{testBacktestStrat}
+
+  bt.run("crypto", bars)
+
+  assert.Equal(t, 1, len(bt.Trades))
+  trade := bt.Trades[0]
+  assert.Equal(t, "test", trade.StratName)
+  assert.Equal(t, 110.0, trade.OpenPrice)
+  assert.Equal(t, 90.0, trade.ClosePrice)
+  assert.InDelta(t, -20 * trade.Qty.InexactFloat64(), trade.PnL, 1e-9)
+  assert.Empty(t, a.Positions)
+  assert.True(t, a.Qty.IsZero())
+
+  assert.Equal(t, 6, len(bt.Equity))
+  assert.InDelta(t, 1000.0, bt.Equity[0].Equity, 1e-9)
+  assert.InDelta(t, 1000.0 + trade.PnL, bt.Equity[5].Equity, 1e-9)
+}
+
+func TestBacktestCommissionAndSlippage(t *testing.T) {
+  bars, _ := loadBarsCSV(strings.NewReader(backtestCSV))
+
+  bt := NewBacktest(1000, 1, 1)
+  bt.warmup = 1
+  a := bt.addAsset("crypto", "FOO")
+  a.strategies = []strategyFunc{testBacktestStrat}
+
+  bt.run("crypto", bars)
+
+  assert.Equal(t, 1, len(bt.Trades))
+  trade := bt.Trades[0]
+  assert.InDelta(t, 110 * 1.01, trade.OpenPrice, 1e-9)
+  assert.InDelta(t, 90 * 0.99, trade.ClosePrice, 1e-9)
+  qty := trade.Qty.InexactFloat64()
+  commission := (trade.OpenPrice + trade.ClosePrice) * qty * 0.01
+  assert.InDelta(t, commission, trade.Commission, 1e-9)
+  assert.InDelta(t, bt.equity(), 1000 + trade.PnL, 1e-9)
+}
+
+func TestBacktestCloseAll(t *testing.T) {
+  bars, _ := loadBarsCSV(strings.NewReader(backtestCSV))
+
+  bt := NewBacktest(1000, 0, 0)
+  bt.warmup = 1
+  a := bt.addAsset("crypto", "FOO")
+  a.strategies = []strategyFunc{testBacktestStrat}
+
+  bt.run("crypto", bars[:4])
+  assert.Equal(t, 1, len(a.Positions))
+
+  bt.closeAll()
+  assert.Equal(t, 1, len(bt.Trades))
+  assert.Empty(t, a.Positions)
+  assert.InDelta(t, 1000 + bt.Trades[0].PnL, bt.Equity[len(bt.Equity) - 1].Equity, 1e-9)
+}
+
+const restingCSV = `symbol,time,open,high,low,close
+FOO,2025-01-02T15:00:00Z,10,10,10,10
+FOO,2025-01-02T15:01:00Z,10,10.1,9.7,9.9
+FOO,2025-01-02T15:02:00Z,9.9,10.3,9.8,10.2
+FOO,2025-01-02T15:03:00Z,10.2,10.6,10.1,10.5
+FOO,2025-01-03T15:00:00Z,10.5,10.5,10.5,10.5
+`
+
+func TestBacktestRestingOrders(t *testing.T) {
+  bars, _ := loadBarsCSV(strings.NewReader(restingCSV))
+
+  bt := NewBacktest(1000, 0, 0)
+  bt.warmup = 1
+  a := bt.addAsset("stock", "FOO")
+  a.strategies = []strategyFunc{func(a *Asset) {
+    a.open("long", request.Limit(9.8, "day"), "limit")
+    a.close(request.Limit(10.4, "gtc"), "limit")
+    a.open("long", request.Stop(10.25, "gtc"), "stop")
+    a.open("long", request.Limit(9, "day"), "expire")
+    a.open("long", request.Limit(9, "ioc"), "ioc")
+  }}
+
+  bt.run("stock", bars[:1])
+  assert.True(t, a.Positions["limit"].OpenOrderPending)
+  assert.NotContains(t, a.Positions, "ioc", "Non marketable IOC orders are canceled")
+
+  bt.run("stock", bars[1:4])
+  assert.Equal(t, 1, len(bt.Trades))
+  assert.Equal(t, "limit", bt.Trades[0].StratName)
+  assert.Equal(t, 9.8, bt.Trades[0].OpenPrice)
+  assert.Equal(t, 10.4, bt.Trades[0].ClosePrice)
+  assert.Equal(t, 10.25, a.Positions["stop"].OpenFilledAvgPrice)
+  assert.True(t, a.Positions["limit"].OpenOrderPending, "Reopened after the close")
+  assert.True(t, a.Positions["expire"].OpenOrderPending)
+
+  a.strategies = nil
+  bt.run("stock", bars[4:])
+  assert.NotContains(t, a.Positions, "expire", "Day orders expire on the next day")
+  assert.NotContains(t, a.Positions, "limit")
+
+  a.cancel("stop")
+  assert.Contains(t, a.Positions, "stop", "Filled positions are not canceled")
+}
+
+func TestBacktestBracketAndOCO(t *testing.T) {
+  bars, _ := loadBarsCSV(strings.NewReader(restingCSV))
+
+  bt := NewBacktest(1000, 0, 0)
+  bt.warmup = 1
+  a := bt.addAsset("stock", "FOO")
+  a.strategies = []strategyFunc{func(a *Asset) {
+    a.open("long", IOC, "oco")
+    a.exit(request.OCO(10.05, 9.75, "gtc"), "oco")
+    a.open("long", request.Market("gtc").Bracket(10.25, 9.5), "bracket")
+  }}
+
+  bt.run("stock", bars[:1])
+  assert.True(t, a.Positions["bracket"].hasLegs())
+  assert.True(t, a.Positions["oco"].hasLegs())
+  a.strategies = nil
+
+  // Both legs of the OCO exit are reached in the second bar, and the stop loss is
+  // assumed to be filled first. The bracket take profit is reached in the third bar.
+  bt.run("stock", bars[1:3])
+  assert.Equal(t, 2, len(bt.Trades))
+  assert.Equal(t, "oco", bt.Trades[0].StratName)
+  assert.Equal(t, 9.75, bt.Trades[0].ClosePrice)
+  assert.Equal(t, "bracket", bt.Trades[1].StratName)
+  assert.Equal(t, 10.25, bt.Trades[1].ClosePrice)
+  assert.Empty(t, a.Positions)
+  assert.Empty(t, bt.orders, "Other legs are canceled")
+}
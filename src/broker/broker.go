@@ -0,0 +1,819 @@
+// Package broker is a local stand-in for the parts of the Alpaca trading API used by
+// the bot: the orders and positions REST endpoints and the trade_updates stream.
+// Orders are filled against the latest price returned by the price source. Limit and
+// stop orders that are not marketable rest until the price reaches them. Bracket and
+// OCO orders are supported for stocks, with legs that are held until the entry fills.
+// Pointing config.C.Endpoint and config.C.WssAccount at the broker lets the system
+// run end to end without access to paper-api.alpaca.markets.
+
+package broker
+
+import (
+  "io"
+  "log"
+  "net"
+  "sort"
+  "sync"
+  "time"
+  "strconv"
+  "strings"
+  "net/http"
+  "crypto/rand"
+  "encoding/hex"
+  "encoding/json"
+  "github.com/gorilla/websocket"
+  "github.com/shopspring/decimal"
+)
+
+// Returns the latest price for a symbol, and false if no price is known.
+type PriceFunc func(symbol string) (float64, bool)
+
+type Order struct {
+  ID              string
+  ClientOrderID   string
+  Symbol          string
+  AssetClass      string
+  Side            string
+  Type            string
+  TimeInForce     string
+  OrderClass      string
+  LimitPrice      float64
+  StopPrice       float64
+  Triggered       bool  // Stop price of a stop or stop limit order has been reached
+  Status          string
+  Qty             decimal.Decimal
+  FilledQty       decimal.Decimal
+  FilledAvgPrice  float64
+  CreatedAt       time.Time
+  FilledAt        time.Time
+  CanceledAt      time.Time
+  ExpiredAt       time.Time
+
+  legs            []*Order
+  parent          *Order
+}
+
+type position struct {
+  symbol          string
+  asset_class     string
+  qty             decimal.Decimal
+  avg_entry_price float64
+}
+
+type orderRequest struct {
+  Symbol        string `json:"symbol"`
+  ClientOrderID string `json:"client_order_id"`
+  Qty           string `json:"qty"`
+  Side          string `json:"side"`
+  Type          string `json:"type"`
+  TimeInForce   string `json:"time_in_force"`
+  OrderClass    string `json:"order_class"`
+  LimitPrice    string `json:"limit_price"`
+  StopPrice     string `json:"stop_price"`
+  TakeProfit    struct {
+    LimitPrice  string `json:"limit_price"`
+  } `json:"take_profit"`
+  StopLoss      struct {
+    StopPrice   string `json:"stop_price"`
+    LimitPrice  string `json:"limit_price"`
+  } `json:"stop_loss"`
+}
+
+type Broker struct {
+  price       PriceFunc
+  orders      []*Order
+  client_ids  map[string]*Order
+  positions   map[string]*position
+  clients     map[*websocket.Conn]bool
+  updates     [][]byte       // Trade updates not yet broadcast. Guarded by mutex.
+  wake        chan struct{}  // Signaled when updates are added
+  upgrader    websocket.Upgrader
+  mutex       sync.Mutex
+  client_mutex sync.Mutex
+
+  // Fraction of the remaining qty filled on each fill attempt. Values outside (0, 1)
+  // fill the whole order at once. IOC orders are canceled after the first attempt.
+  PartialFillRatio float64
+}
+
+func New(price PriceFunc) *Broker {
+  return &Broker{
+    price: price,
+    client_ids: make(map[string]*Order),
+    positions: make(map[string]*position),
+    clients: make(map[*websocket.Conn]bool),
+    wake: make(chan struct{}, 1),
+  }
+}
+
+func (b *Broker) Handler() http.Handler {
+  mux := http.NewServeMux()
+  mux.HandleFunc("POST /v2/orders", b.auth(b.postOrder))
+  mux.HandleFunc("GET /v2/orders", b.auth(b.getOrders))
+  mux.HandleFunc("DELETE /v2/orders/{id}", b.auth(b.deleteOrder))
+  mux.HandleFunc("GET /v2/positions", b.auth(b.getPositions))
+  mux.HandleFunc("DELETE /v2/positions", b.auth(b.deletePositions))
+  mux.HandleFunc("GET /stream", b.stream)
+  return mux
+}
+
+// Starts the broker on addr, and retries resting orders every fill_interval.
+// Returns the address the broker listens on, which is useful when addr has port 0.
+func (b *Broker) Start(addr string, fill_interval time.Duration) (string, error) {
+  ln, err := net.Listen("tcp", addr)
+  if err != nil {
+    return "", err
+  }
+  go b.broadcast()
+  go func() {
+    if err := http.Serve(ln, b.Handler()); err != nil {
+      log.Println("[ ERROR ]\tBroker stopped:", err)
+    }
+  }()
+  go func() {
+    ticker := time.NewTicker(fill_interval)
+    defer ticker.Stop()
+    for range ticker.C {
+      b.FillResting()
+    }
+  }()
+  log.Println("[ OK ]\tSimulated broker listening on " + ln.Addr().String())
+  return ln.Addr().String(), nil
+}
+
+func (b *Broker) auth(next http.HandlerFunc) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if r.Header.Get("APCA-API-KEY-ID") == "" || r.Header.Get("APCA-API-SECRET-KEY") == "" {
+      w.WriteHeader(http.StatusForbidden)
+      _, _ = w.Write([]byte(`{"message":"forbidden."}`))
+      return
+    }
+    next(w, r)
+  }
+}
+
+func newID() string {
+  b := make([]byte, 16)
+  _, _ = rand.Read(b)
+  h := hex.EncodeToString(b)
+  return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  _ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+  writeJSON(w, status, map[string]any{"code": status * 10000, "message": message})
+}
+
+func assetClass(symbol string) string {
+  if strings.Contains(symbol, "/") {
+    return "crypto"
+  }
+  return "us_equity"
+}
+
+func timeOrNil(t time.Time) any {
+  if t.IsZero() {
+    return nil
+  }
+  return t.Format(time.RFC3339Nano)
+}
+
+func priceOrNil(p float64) any {
+  if p == 0 {
+    return nil
+  }
+  return strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+func (o *Order) json() map[string]any {
+  var filled_avg_price any
+  if !o.FilledQty.IsZero() {
+    filled_avg_price = strconv.FormatFloat(o.FilledAvgPrice, 'f', -1, 64)
+  }
+  return map[string]any{
+    "id": o.ID,
+    "client_order_id": o.ClientOrderID,
+    "created_at": timeOrNil(o.CreatedAt),
+    "updated_at": timeOrNil(time.Now().UTC()),
+    "submitted_at": timeOrNil(o.CreatedAt),
+    "filled_at": timeOrNil(o.FilledAt),
+    "canceled_at": timeOrNil(o.CanceledAt),
+    "expired_at": timeOrNil(o.ExpiredAt),
+    "symbol": o.Symbol,
+    "asset_class": o.AssetClass,
+    "qty": o.Qty.String(),
+    "filled_qty": o.FilledQty.String(),
+    "filled_avg_price": filled_avg_price,
+    "order_class": o.OrderClass,
+    "order_type": o.Type,
+    "type": o.Type,
+    "side": o.Side,
+    "time_in_force": o.TimeInForce,
+    "limit_price": priceOrNil(o.LimitPrice),
+    "stop_price": priceOrNil(o.StopPrice),
+    "status": o.Status,
+    "legs": o.legsJSON(),
+  }
+}
+
+func (o *Order) legsJSON() any {
+  if len(o.legs) == 0 {
+    return nil
+  }
+  arr := make([]map[string]any, len(o.legs))
+  for i, leg := range o.legs {
+    arr[i] = leg.json()
+  }
+  return arr
+}
+
+// Held orders are legs of a bracket order waiting for the entry to fill.
+func (o *Order) open() bool {
+  return o.Status == "new" || o.Status == "partially_filled" || o.Status == "held"
+}
+
+// Returns the other open orders of the bracket or OCO group of the order, which are
+// canceled when the order is filled or canceled.
+func (o *Order) siblings() []*Order {
+  var group []*Order
+  switch {
+  case o.parent != nil && o.parent.OrderClass == "oco":
+    group = append([]*Order{o.parent}, o.parent.legs...)
+  case o.parent != nil:
+    group = o.parent.legs
+  case o.OrderClass == "oco" || o.Status != "filled":
+    group = o.legs
+  }
+  siblings := make([]*Order, 0, len(group))
+  for _, s := range group {
+    if s != o && s.open() {
+      siblings = append(siblings, s)
+    }
+  }
+  return siblings
+}
+
+func parsePrice(s string) (float64, bool) {
+  p, err := strconv.ParseFloat(s, 64)
+  return p, err == nil && p > 0
+}
+
+func (b *Broker) postOrder(w http.ResponseWriter, r *http.Request) {
+  body, err := io.ReadAll(r.Body)
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+
+  var req orderRequest
+  if err := json.Unmarshal(body, &req); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid order payload")
+    return
+  }
+
+  qty, err := decimal.NewFromString(req.Qty)
+  if err != nil || !qty.IsPositive() {
+    writeError(w, http.StatusUnprocessableEntity, "qty must be > 0")
+    return
+  }
+  if req.Side != "buy" && req.Side != "sell" {
+    writeError(w, http.StatusUnprocessableEntity, "invalid side")
+    return
+  }
+
+  var limit_price, stop_price float64
+  var ok bool
+  switch req.Type {
+  case "market":
+  case "limit":
+    // The limit price of an OCO order is the take profit price
+    if limit_price, ok = parsePrice(req.LimitPrice); !ok && req.OrderClass != "oco" {
+      writeError(w, http.StatusUnprocessableEntity, "limit_price is required")
+      return
+    }
+  case "stop":
+    if stop_price, ok = parsePrice(req.StopPrice); !ok {
+      writeError(w, http.StatusUnprocessableEntity, "stop_price is required")
+      return
+    }
+  case "stop_limit":
+    limit_price, ok = parsePrice(req.LimitPrice)
+    if stop_price, ok = parsePrice(req.StopPrice); !ok || limit_price == 0 {
+      writeError(w, http.StatusUnprocessableEntity, "stop_price and limit_price are required")
+      return
+    }
+  default:
+    writeError(w, http.StatusUnprocessableEntity, "invalid order type")
+    return
+  }
+
+  switch req.TimeInForce {
+  case "ioc", "gtc":
+  case "day", "fok":
+    if assetClass(req.Symbol) == "crypto" {
+      writeError(w, http.StatusUnprocessableEntity, "invalid crypto time_in_force")
+      return
+    }
+  default:
+    writeError(w, http.StatusUnprocessableEntity, "invalid time_in_force")
+    return
+  }
+
+  var take_profit, stop_loss, stop_loss_limit float64
+  switch req.OrderClass {
+  case "", "simple":
+    req.OrderClass = "simple"
+  case "bracket", "oco":
+    if assetClass(req.Symbol) == "crypto" {
+      writeError(w, http.StatusUnprocessableEntity, "crypto orders must be simple")
+      return
+    }
+    if req.TimeInForce != "day" && req.TimeInForce != "gtc" {
+      writeError(w, http.StatusUnprocessableEntity, "bracket orders must be day or gtc")
+      return
+    }
+    if req.OrderClass == "bracket" && req.Type != "market" && req.Type != "limit" ||
+      req.OrderClass == "oco" && req.Type != "limit" {
+      writeError(w, http.StatusUnprocessableEntity, "invalid order type for order class")
+      return
+    }
+    var ok_tp, ok_sl bool
+    take_profit, ok_tp = parsePrice(req.TakeProfit.LimitPrice)
+    stop_loss, ok_sl = parsePrice(req.StopLoss.StopPrice)
+    if !ok_tp || !ok_sl {
+      writeError(w, http.StatusUnprocessableEntity, "take_profit and stop_loss are required")
+      return
+    }
+    stop_loss_limit, _ = parsePrice(req.StopLoss.LimitPrice)
+    if req.OrderClass == "oco" {
+      limit_price = take_profit
+    }
+  default:
+    writeError(w, http.StatusUnprocessableEntity, "invalid order_class")
+    return
+  }
+
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+
+  if _, ok := b.client_ids[req.ClientOrderID]; ok && req.ClientOrderID != "" {
+    writeError(w, http.StatusUnprocessableEntity, "client_order_id must be unique")
+    return
+  }
+
+  o := &Order{
+    ID: newID(),
+    ClientOrderID: req.ClientOrderID,
+    Symbol: req.Symbol,
+    AssetClass: assetClass(req.Symbol),
+    Side: req.Side,
+    Type: req.Type,
+    TimeInForce: req.TimeInForce,
+    OrderClass: req.OrderClass,
+    LimitPrice: limit_price,
+    StopPrice: stop_price,
+    Status: "new",
+    Qty: qty,
+    FilledQty: decimal.Zero,
+    CreatedAt: time.Now().UTC(),
+  }
+  if o.ClientOrderID == "" {
+    o.ClientOrderID = newID()
+  }
+
+  // Crypto can not be sold short
+  if o.AssetClass == "crypto" && o.Side == "sell" {
+    held := decimal.Zero
+    if pos, ok := b.positions[o.Symbol]; ok {
+      held = pos.qty
+    }
+    if qty.GreaterThan(held) {
+      writeError(w, http.StatusForbidden, "insufficient balance for "+o.Symbol)
+      return
+    }
+  }
+
+  b.orders = append(b.orders, o)
+  b.client_ids[o.ClientOrderID] = o
+  if req.OrderClass != "simple" {
+    b.addLegs(o, take_profit, stop_loss, stop_loss_limit)
+  }
+  b.emit("new", o, decimal.Zero, 0)
+
+  b.tryFill(o)
+
+  writeJSON(w, http.StatusOK, o.json())
+}
+
+// Adds the legs of a bracket or OCO order. The take profit of an OCO order is the
+// order itself, so only the stop loss is added as a leg.
+func (b *Broker) addLegs(o *Order, take_profit float64, stop_loss float64, stop_loss_limit float64) {
+  side, status := o.Side, "new"
+  if o.OrderClass == "bracket" {
+    status = "held"
+    side = "buy"
+    if o.Side == "buy" {
+      side = "sell"
+    }
+    o.legs = append(o.legs, b.newLeg(o, side, "limit", take_profit, 0, status))
+  }
+  if stop_loss_limit > 0 {
+    o.legs = append(o.legs, b.newLeg(o, side, "stop_limit", stop_loss_limit, stop_loss, status))
+  } else {
+    o.legs = append(o.legs, b.newLeg(o, side, "stop", 0, stop_loss, status))
+  }
+  for _, leg := range o.legs {
+    b.orders = append(b.orders, leg)
+    b.client_ids[leg.ClientOrderID] = leg
+  }
+}
+
+func (b *Broker) newLeg(parent *Order, side string, order_type string, limit_price float64, stop_price float64, status string) *Order {
+  return &Order{
+    ID: newID(),
+    ClientOrderID: newID(),
+    Symbol: parent.Symbol,
+    AssetClass: parent.AssetClass,
+    Side: side,
+    Type: order_type,
+    TimeInForce: parent.TimeInForce,
+    OrderClass: parent.OrderClass,
+    LimitPrice: limit_price,
+    StopPrice: stop_price,
+    Status: status,
+    Qty: parent.Qty,
+    FilledQty: decimal.Zero,
+    CreatedAt: parent.CreatedAt,
+    parent: parent,
+  }
+}
+
+// Whether the order can be filled at price. Stop orders are triggered when the price
+// reaches the stop price, and are then handled as market or limit orders.
+func (o *Order) marketable(price float64) bool {
+  buy := o.Side == "buy"
+  if (o.Type == "stop" || o.Type == "stop_limit") && !o.Triggered {
+    if buy && price < o.StopPrice || !buy && price > o.StopPrice {
+      return false
+    }
+    o.Triggered = true
+  }
+  if o.Type == "limit" || o.Type == "stop_limit" {
+    return buy && price <= o.LimitPrice || !buy && price >= o.LimitPrice
+  }
+  return true
+}
+
+// Fills as much of the order as allowed by PartialFillRatio against the latest price.
+// FOK orders are filled completely or not at all. Must be called with the mutex held.
+func (b *Broker) tryFill(o *Order) {
+  if o.Status == "held" {
+    return
+  }
+  price, ok := b.price(o.Symbol)
+  remaining := o.Qty.Sub(o.FilledQty)
+
+  if ok && price > 0 && o.marketable(price) {
+    fill_qty := remaining
+    if b.PartialFillRatio > 0 && b.PartialFillRatio < 1 && o.TimeInForce != "fok" {
+      fill_qty = remaining.Mul(decimal.NewFromFloat(b.PartialFillRatio)).RoundDown(9)
+      if fill_qty.IsZero() {
+        fill_qty = remaining
+      }
+    }
+    b.fill(o, fill_qty, price)
+  }
+
+  if (o.TimeInForce == "ioc" || o.TimeInForce == "fok") && o.Status != "filled" {
+    b.cancel(o)
+  }
+}
+
+// Cancels the order together with the rest of its bracket or OCO group.
+func (b *Broker) cancel(o *Order) {
+  siblings := o.siblings()
+  o.Status = "canceled"
+  o.CanceledAt = time.Now().UTC()
+  b.emit("canceled", o, decimal.Zero, 0)
+  for _, s := range siblings {
+    if s.open() {
+      b.cancel(s)
+    }
+  }
+}
+
+func (b *Broker) fill(o *Order, qty decimal.Decimal, price float64) {
+  filled_before := o.FilledQty.InexactFloat64()
+  o.FilledQty = o.FilledQty.Add(qty)
+  o.FilledAvgPrice = (o.FilledAvgPrice * filled_before + price * qty.InexactFloat64()) / o.FilledQty.InexactFloat64()
+  o.FilledAt = time.Now().UTC()
+
+  delta := qty
+  if o.Side == "sell" {
+    delta = qty.Neg()
+  }
+
+  pos, ok := b.positions[o.Symbol]
+  if !ok {
+    pos = &position{symbol: o.Symbol, asset_class: o.AssetClass, qty: decimal.Zero}
+    b.positions[o.Symbol] = pos
+  }
+
+  new_qty := pos.qty.Add(delta)
+  switch {
+  case pos.qty.IsZero() || new_qty.Sign() != pos.qty.Sign() && !new_qty.IsZero():
+    // Opening or flipping the position
+    pos.avg_entry_price = price
+  case new_qty.Abs().GreaterThan(pos.qty.Abs()):
+    old := pos.qty.Abs().InexactFloat64()
+    pos.avg_entry_price = (pos.avg_entry_price * old + price * qty.InexactFloat64()) / new_qty.Abs().InexactFloat64()
+  }
+  pos.qty = new_qty
+  if pos.qty.IsZero() {
+    delete(b.positions, o.Symbol)
+  }
+
+  event := "partial_fill"
+  if o.FilledQty.Equal(o.Qty) {
+    o.Status = "filled"
+    event = "fill"
+  } else {
+    o.Status = "partially_filled"
+  }
+  b.emit(event, o, qty, price)
+
+  if o.Status != "filled" {
+    return
+  }
+  // Release the legs of a filled bracket entry, or cancel the other leg of a filled leg
+  if o.OrderClass == "bracket" && o.parent == nil {
+    for _, leg := range o.legs {
+      leg.Status = "new"
+      b.emit("new", leg, decimal.Zero, 0)
+    }
+    return
+  }
+  for _, s := range o.siblings() {
+    if s.open() {
+      b.cancel(s)
+    }
+  }
+}
+
+// Retries filling resting orders against the latest prices. Day orders created on an
+// earlier date expire instead.
+func (b *Broker) FillResting() {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  now := time.Now().UTC()
+  for _, o := range b.orders {
+    if !o.open() {
+      continue
+    }
+    if o.TimeInForce == "day" && o.CreatedAt.Format(time.DateOnly) != now.Format(time.DateOnly) {
+      o.Status = "expired"
+      o.ExpiredAt = now
+      b.emit("expired", o, decimal.Zero, 0)
+      continue
+    }
+    b.tryFill(o)
+  }
+}
+
+func (b *Broker) deleteOrder(w http.ResponseWriter, r *http.Request) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+
+  for _, o := range b.orders {
+    if o.ID != r.PathValue("id") {
+      continue
+    }
+    if !o.open() {
+      writeError(w, http.StatusUnprocessableEntity, "order is not cancelable")
+      return
+    }
+    b.cancel(o)
+    w.WriteHeader(http.StatusNoContent)
+    return
+  }
+  writeError(w, http.StatusNotFound, "order not found")
+}
+
+func (b *Broker) emit(event string, o *Order, qty decimal.Decimal, price float64) {
+  position_qty := decimal.Zero
+  if pos, ok := b.positions[o.Symbol]; ok {
+    position_qty = pos.qty
+  }
+  data := map[string]any{
+    "event": event,
+    "timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+    "order": o.json(),
+  }
+  if event == "fill" || event == "partial_fill" {
+    data["price"] = strconv.FormatFloat(price, 'f', -1, 64)
+    data["qty"] = qty.String()
+    data["position_qty"] = position_qty.String()
+  }
+  msg, err := json.Marshal(map[string]any{"stream": "trade_updates", "data": data})
+  if err != nil {
+    log.Println("[ ERROR ]\tFailed to encode trade update:", err)
+    return
+  }
+  // Queued, since emit is called with the mutex held and the clients may be slow
+  b.updates = append(b.updates, msg)
+  select {
+  case b.wake <- struct{}{}:
+  default:
+  }
+}
+
+// Clients that do not take an update within this time are disconnected
+const writeTimeout = 5 * time.Second
+
+func (b *Broker) broadcast() {
+  for range b.wake {
+    b.mutex.Lock()
+    list := b.updates
+    b.updates = nil
+    b.mutex.Unlock()
+
+    b.client_mutex.Lock()
+    for _, msg := range list {
+      for conn := range b.clients {
+        _ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+        if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+          delete(b.clients, conn)
+          conn.Close()
+        }
+      }
+    }
+    b.client_mutex.Unlock()
+  }
+}
+
+func (b *Broker) getOrders(w http.ResponseWriter, r *http.Request) {
+  q := r.URL.Query()
+  status := q.Get("status")
+  limit, err := strconv.Atoi(q.Get("limit"))
+  if err != nil || limit <= 0 || limit > 500 {
+    limit = 50
+  }
+  var symbols map[string]bool
+  if s := q.Get("symbols"); s != "" {
+    symbols = make(map[string]bool)
+    for _, symbol := range strings.Split(s, ",") {
+      symbols[symbol] = true
+    }
+  }
+
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+
+  arr := make([]map[string]any, 0)
+  for _, o := range b.orders {
+    closed := !o.open()
+    if status == "closed" && !closed || status == "open" && closed {
+      continue
+    }
+    if symbols != nil && !symbols[o.Symbol] {
+      continue
+    }
+    arr = append(arr, o.json())
+  }
+
+  if q.Get("direction") != "asc" {
+    for i, j := 0, len(arr) - 1; i < j; i, j = i+1, j-1 {
+      arr[i], arr[j] = arr[j], arr[i]
+    }
+  }
+  if len(arr) > limit {
+    arr = arr[:limit]
+  }
+
+  writeJSON(w, http.StatusOK, arr)
+}
+
+func (b *Broker) getPositions(w http.ResponseWriter, r *http.Request) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+
+  symbols := make([]string, 0, len(b.positions))
+  for symbol := range b.positions {
+    symbols = append(symbols, symbol)
+  }
+  sort.Strings(symbols)
+
+  arr := make([]map[string]any, 0, len(symbols))
+  for _, symbol := range symbols {
+    pos := b.positions[symbol]
+    side := "long"
+    if pos.qty.IsNegative() {
+      side = "short"
+    }
+    arr = append(arr, map[string]any{
+      // Positions use the symbol without slash for crypto
+      "symbol": strings.Replace(pos.symbol, "/", "", 1),
+      "asset_class": pos.asset_class,
+      "qty": pos.qty.String(),
+      "qty_available": pos.qty.String(),
+      "side": side,
+      "avg_entry_price": strconv.FormatFloat(pos.avg_entry_price, 'f', -1, 64),
+    })
+  }
+
+  writeJSON(w, http.StatusOK, arr)
+}
+
+func (b *Broker) deletePositions(w http.ResponseWriter, r *http.Request) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+
+  if r.URL.Query().Get("cancel_orders") == "true" {
+    for _, o := range b.orders {
+      if o.open() {
+        b.cancel(o)
+      }
+    }
+  }
+
+  arr := make([]map[string]any, 0, len(b.positions))
+  for symbol, pos := range b.positions {
+    side := "sell"
+    if pos.qty.IsNegative() {
+      side = "buy"
+    }
+    o := &Order{
+      ID: newID(),
+      ClientOrderID: newID(),
+      Symbol: symbol,
+      AssetClass: pos.asset_class,
+      Side: side,
+      Type: "market",
+      TimeInForce: "gtc",
+      OrderClass: "simple",
+      Status: "new",
+      Qty: pos.qty.Abs(),
+      FilledQty: decimal.Zero,
+      CreatedAt: time.Now().UTC(),
+    }
+    b.orders = append(b.orders, o)
+    b.client_ids[o.ClientOrderID] = o
+    b.tryFill(o)
+    arr = append(arr, map[string]any{"symbol": symbol, "status": 200, "body": o.json()})
+  }
+
+  writeJSON(w, http.StatusMultiStatus, arr)
+}
+
+func (b *Broker) stream(w http.ResponseWriter, r *http.Request) {
+  conn, err := b.upgrader.Upgrade(w, r, nil)
+  if err != nil {
+    return
+  }
+
+  var msg struct {
+    Action string `json:"action"`
+    Key    string `json:"key"`
+    Secret string `json:"secret"`
+    Data   struct {
+      Streams []string `json:"streams"`
+    } `json:"data"`
+  }
+
+  authenticated := false
+  for {
+    _, message, err := conn.ReadMessage()
+    if err != nil {
+      b.client_mutex.Lock()
+      delete(b.clients, conn)
+      b.client_mutex.Unlock()
+      conn.Close()
+      return
+    }
+    if err := json.Unmarshal(message, &msg); err != nil {
+      continue
+    }
+
+    b.client_mutex.Lock()
+    switch {
+    case msg.Action == "auth" && msg.Key != "" && msg.Secret != "":
+      authenticated = true
+      _ = conn.WriteMessage(websocket.TextMessage,
+        []byte(`{"stream":"authorization","data":{"status":"authorized","action":"authenticate"}}`))
+    case msg.Action == "auth":
+      _ = conn.WriteMessage(websocket.TextMessage,
+        []byte(`{"stream":"authorization","data":{"status":"unauthorized","action":"authenticate"}}`))
+    case msg.Action == "listen" && authenticated:
+      b.clients[conn] = true
+      streams, _ := json.Marshal(msg.Data.Streams)
+      _ = conn.WriteMessage(websocket.TextMessage,
+        []byte(`{"stream":"listening","data":{"streams":` + string(streams) + `}}`))
+    }
+    b.client_mutex.Unlock()
+  }
+}
@@ -0,0 +1,297 @@
+package broker
+
+import (
+  "io"
+  "time"
+  "strings"
+  "strconv"
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "github.com/gorilla/websocket"
+  "github.com/valyala/fastjson"
+  "github.com/stretchr/testify/assert"
+)
+
+func newTestBroker(prices map[string]float64) (*Broker, *httptest.Server) {
+  b := New(func(symbol string) (float64, bool) {
+    p, ok := prices[symbol]
+    return p, ok
+  })
+  go b.broadcast()
+  return b, httptest.NewServer(b.Handler())
+}
+
+func do(t *testing.T, method string, url string, body string) (int, *fastjson.Value) {
+  req, _ := http.NewRequest(method, url, strings.NewReader(body))
+  req.Header.Set("APCA-API-KEY-ID", "key")
+  req.Header.Set("APCA-API-SECRET-KEY", "secret")
+  resp, err := http.DefaultClient.Do(req)
+  assert.Nil(t, err)
+  defer resp.Body.Close()
+  b, _ := io.ReadAll(resp.Body)
+  parsed, _ := fastjson.ParseBytes(b)
+  return resp.StatusCode, parsed
+}
+
+func order(symbol string, id string, qty string, side string, tif string) string {
+  return `{"symbol": "` + symbol + `", "client_order_id": "` + id + `", "qty": "` + qty + `", ` +
+    `"side": "` + side + `", "type": "market", "time_in_force": "` + tif + `", "order_class": "simple"}`
+}
+
+func listen(t *testing.T, server *httptest.Server) *websocket.Conn {
+  conn, _, err := websocket.DefaultDialer.Dial("ws" + strings.TrimPrefix(server.URL, "http") + "/stream", nil)
+  assert.Nil(t, err)
+  _ = conn.WriteMessage(websocket.TextMessage, []byte(`{"action":"auth","key":"key","secret":"secret"}`))
+  _, msg, _ := conn.ReadMessage()
+  assert.Contains(t, string(msg), `"authorized"`)
+  _ = conn.WriteMessage(websocket.TextMessage, []byte(`{"action":"listen","data":{"streams":["trade_updates"]}}`))
+  _, msg, _ = conn.ReadMessage()
+  assert.Contains(t, string(msg), `"listening"`)
+  return conn
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) *fastjson.Value {
+  _ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+  _, msg, err := conn.ReadMessage()
+  assert.Nil(t, err)
+  parsed, err := fastjson.ParseBytes(msg)
+  assert.Nil(t, err)
+  return parsed.Get("data")
+}
+
+func TestAuth(t *testing.T) {
+  _, server := newTestBroker(nil)
+  defer server.Close()
+
+  resp, err := http.Get(server.URL + "/v2/positions")
+  assert.Nil(t, err)
+  assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestUpdatesDoNotBlock(t *testing.T) {
+  // Updates are not broadcast
+  b := New(func(symbol string) (float64, bool) {
+    return 100, true
+  })
+  server := httptest.NewServer(b.Handler())
+  defer server.Close()
+
+  done := make(chan struct{})
+  go func() {
+    defer close(done)
+    for i := 0; i < 600; i++ {
+      do(t, "POST", server.URL + "/v2/orders", order("BTC/USD", "id" + strconv.Itoa(i), "0.1", "buy", "ioc"))
+    }
+  }()
+  select {
+  case <-done:
+  case <-time.After(10 * time.Second):
+    t.Fatal("Orders blocked by the updates")
+  }
+}
+
+func TestMarketOrderFill(t *testing.T) {
+  _, server := newTestBroker(map[string]float64{"BTC/USD": 100})
+  defer server.Close()
+  conn := listen(t, server)
+  defer conn.Close()
+
+  status, resp := do(t, "POST", server.URL + "/v2/orders", order("BTC/USD", "open1", "0.5", "buy", "ioc"))
+  assert.Equal(t, 200, status)
+  assert.Equal(t, "filled", string(resp.GetStringBytes("status")))
+
+  assert.Equal(t, "new", string(readEvent(t, conn).GetStringBytes("event")))
+  fill := readEvent(t, conn)
+  assert.Equal(t, "fill", string(fill.GetStringBytes("event")))
+  assert.Equal(t, "0.5", string(fill.GetStringBytes("position_qty")))
+  assert.Equal(t, "open1", string(fill.Get("order").GetStringBytes("client_order_id")))
+  assert.Equal(t, "crypto", string(fill.Get("order").GetStringBytes("asset_class")))
+  assert.Equal(t, "100", string(fill.Get("order").GetStringBytes("filled_avg_price")))
+
+  status, positions := do(t, "GET", server.URL + "/v2/positions", "")
+  assert.Equal(t, 200, status)
+  assert.Equal(t, 1, len(positions.GetArray()))
+  assert.Equal(t, "BTCUSD", string(positions.GetArray()[0].GetStringBytes("symbol")))
+  assert.Equal(t, "0.5", string(positions.GetArray()[0].GetStringBytes("qty")))
+
+  status, _ = do(t, "POST", server.URL + "/v2/orders", order("BTC/USD", "open1", "0.5", "buy", "ioc"))
+  assert.Equal(t, 422, status, "Duplicate client_order_id")
+
+  status, _ = do(t, "POST", server.URL + "/v2/orders", order("BTC/USD", "close1", "1", "sell", "ioc"))
+  assert.Equal(t, 403, status, "Crypto can not be sold short")
+}
+
+func TestIOCWithoutPriceIsCanceled(t *testing.T) {
+  _, server := newTestBroker(map[string]float64{})
+  defer server.Close()
+  conn := listen(t, server)
+  defer conn.Close()
+
+  status, resp := do(t, "POST", server.URL + "/v2/orders", order("FOO", "open1", "1", "buy", "ioc"))
+  assert.Equal(t, 200, status)
+  assert.Equal(t, "canceled", string(resp.GetStringBytes("status")))
+
+  readEvent(t, conn)
+  canceled := readEvent(t, conn)
+  assert.Equal(t, "canceled", string(canceled.GetStringBytes("event")))
+  assert.Equal(t, "us_equity", string(canceled.Get("order").GetStringBytes("asset_class")))
+}
+
+func TestPartialFill(t *testing.T) {
+  b, server := newTestBroker(map[string]float64{"FOO": 10})
+  defer server.Close()
+  b.PartialFillRatio = 0.5
+  conn := listen(t, server)
+  defer conn.Close()
+
+  status, resp := do(t, "POST", server.URL + "/v2/orders", order("FOO", "gtc1", "4", "buy", "gtc"))
+  assert.Equal(t, 200, status)
+  assert.Equal(t, "partially_filled", string(resp.GetStringBytes("status")))
+  readEvent(t, conn)
+  partial := readEvent(t, conn)
+  assert.Equal(t, "partial_fill", string(partial.GetStringBytes("event")))
+  assert.Equal(t, "2", string(partial.GetStringBytes("position_qty")))
+
+  b.FillResting()
+  b.PartialFillRatio = 0
+  b.FillResting()
+  readEvent(t, conn)
+  fill := readEvent(t, conn)
+  assert.Equal(t, "fill", string(fill.GetStringBytes("event")))
+  assert.Equal(t, "4", string(fill.GetStringBytes("position_qty")))
+
+  status, resp = do(t, "POST", server.URL + "/v2/orders", order("FOO", "ioc1", "4", "sell", "ioc"))
+  assert.Equal(t, 200, status)
+  assert.Equal(t, "filled", string(resp.GetStringBytes("status")))
+}
+
+func TestShortAndCloseAll(t *testing.T) {
+  _, server := newTestBroker(map[string]float64{"FOO": 10, "BAR": 20})
+  defer server.Close()
+
+  do(t, "POST", server.URL + "/v2/orders", order("FOO", "a", "3", "sell", "ioc"))
+  do(t, "POST", server.URL + "/v2/orders", order("BAR", "b", "2", "buy", "ioc"))
+
+  _, positions := do(t, "GET", server.URL + "/v2/positions", "")
+  assert.Equal(t, 2, len(positions.GetArray()))
+  assert.Equal(t, "-3", string(positions.GetArray()[1].GetStringBytes("qty")))
+  assert.Equal(t, "short", string(positions.GetArray()[1].GetStringBytes("side")))
+
+  status, _ := do(t, "DELETE", server.URL + "/v2/positions?cancel_orders=true", "")
+  assert.Equal(t, http.StatusMultiStatus, status)
+
+  _, positions = do(t, "GET", server.URL + "/v2/positions", "")
+  assert.Equal(t, 0, len(positions.GetArray()))
+}
+
+func TestClosedOrders(t *testing.T) {
+  _, server := newTestBroker(map[string]float64{"BTC/USD": 100, "FOO": 10})
+  defer server.Close()
+
+  do(t, "POST", server.URL + "/v2/orders", order("BTC/USD", "a", "1", "buy", "ioc"))
+  do(t, "POST", server.URL + "/v2/orders", order("FOO", "b", "1", "buy", "ioc"))
+  do(t, "POST", server.URL + "/v2/orders", order("BTC/USD", "c", "1", "sell", "ioc"))
+
+  _, orders := do(t, "GET", server.URL + "/v2/orders?status=closed&limit=500&direction=desc&symbols=BTC%2FUSD", "")
+  arr := orders.GetArray()
+  assert.Equal(t, 2, len(arr))
+  assert.Equal(t, "c", string(arr[0].GetStringBytes("client_order_id")))
+  assert.Equal(t, "a", string(arr[1].GetStringBytes("client_order_id")))
+  assert.NotNil(t, arr[0].GetStringBytes("filled_at"))
+}
+
+func TestLimitStopAndCancel(t *testing.T) {
+  prices := map[string]float64{"FOO": 10}
+  b, server := newTestBroker(prices)
+  defer server.Close()
+
+  limit := `{"symbol": "FOO", "client_order_id": "l", "qty": "2", "side": "buy", "type": "limit", "time_in_force": "gtc", "limit_price": "9.5"}`
+  status, resp := do(t, "POST", server.URL + "/v2/orders", limit)
+  assert.Equal(t, 200, status)
+  assert.Equal(t, "new", string(resp.GetStringBytes("status")))
+  assert.Equal(t, "9.5", string(resp.GetStringBytes("limit_price")))
+
+  stop := `{"symbol": "FOO", "client_order_id": "s", "qty": "1", "side": "buy", "type": "stop", "time_in_force": "day", "stop_price": "11"}`
+  _, resp = do(t, "POST", server.URL + "/v2/orders", stop)
+  stop_id := string(resp.GetStringBytes("id"))
+
+  fok := `{"symbol": "FOO", "client_order_id": "f", "qty": "1", "side": "buy", "type": "limit", "time_in_force": "fok", "limit_price": "9"}`
+  _, resp = do(t, "POST", server.URL + "/v2/orders", fok)
+  assert.Equal(t, "canceled", string(resp.GetStringBytes("status")))
+
+  status, _ = do(t, "POST", server.URL + "/v2/orders", `{"symbol": "FOO", "qty": "1", "side": "buy", "type": "limit", "time_in_force": "gtc"}`)
+  assert.Equal(t, 422, status, "Limit order without limit price")
+
+  status, _ = do(t, "POST", server.URL + "/v2/orders", `{"symbol": "BTC/USD", "qty": "1", "side": "buy", "type": "market", "time_in_force": "day"}`)
+  assert.Equal(t, 422, status, "Day orders are not supported for crypto")
+
+  prices["FOO"] = 9.4
+  b.FillResting()
+  _, positions := do(t, "GET", server.URL + "/v2/positions", "")
+  assert.Equal(t, "2", string(positions.GetArray()[0].GetStringBytes("qty")))
+
+  status, _ = do(t, "DELETE", server.URL + "/v2/orders/" + stop_id, "")
+  assert.Equal(t, http.StatusNoContent, status)
+  status, _ = do(t, "DELETE", server.URL + "/v2/orders/" + stop_id, "")
+  assert.Equal(t, 422, status, "Canceled orders are not cancelable")
+
+  prices["FOO"] = 12
+  b.FillResting()
+  _, positions = do(t, "GET", server.URL + "/v2/positions", "")
+  assert.Equal(t, "2", string(positions.GetArray()[0].GetStringBytes("qty")), "Canceled stop is not filled")
+
+  stop = `{"symbol": "FOO", "client_order_id": "s2", "qty": "1", "side": "sell", "type": "stop", "time_in_force": "gtc", "stop_price": "11"}`
+  _, resp = do(t, "POST", server.URL + "/v2/orders", stop)
+  assert.Equal(t, "new", string(resp.GetStringBytes("status")))
+  prices["FOO"] = 10.9
+  b.FillResting()
+  _, positions = do(t, "GET", server.URL + "/v2/positions", "")
+  assert.Equal(t, "1", string(positions.GetArray()[0].GetStringBytes("qty")))
+}
+
+func TestBracketAndOCO(t *testing.T) {
+  prices := map[string]float64{"FOO": 10}
+  b, server := newTestBroker(prices)
+  defer server.Close()
+
+  bracket := `{"symbol": "FOO", "client_order_id": "b", "qty": "2", "side": "buy", "type": "market", "time_in_force": "gtc", ` +
+    `"order_class": "bracket", "take_profit": {"limit_price": "11"}, "stop_loss": {"stop_price": "9"}}`
+  status, resp := do(t, "POST", server.URL + "/v2/orders", bracket)
+  assert.Equal(t, 200, status)
+  assert.Equal(t, "filled", string(resp.GetStringBytes("status")))
+  legs := resp.GetArray("legs")
+  assert.Equal(t, 2, len(legs))
+  assert.Equal(t, "limit", string(legs[0].GetStringBytes("type")))
+  assert.Equal(t, "sell", string(legs[0].GetStringBytes("side")))
+  assert.Equal(t, "stop", string(legs[1].GetStringBytes("type")))
+
+  prices["FOO"] = 11.5
+  b.FillResting()
+  _, positions := do(t, "GET", server.URL + "/v2/positions", "")
+  assert.Equal(t, 0, len(positions.GetArray()), "Take profit filled")
+
+  _, orders := do(t, "GET", server.URL + "/v2/orders?status=closed&direction=asc", "")
+  arr := orders.GetArray()
+  assert.Equal(t, "filled", string(arr[1].GetStringBytes("status")))
+  assert.Equal(t, "canceled", string(arr[2].GetStringBytes("status")), "Stop loss canceled by the fill")
+
+  // OCO exit for an open position, canceled as a group
+  do(t, "POST", server.URL + "/v2/orders", order("FOO", "o", "3", "buy", "ioc"))
+  oco := `{"symbol": "FOO", "qty": "3", "side": "sell", "type": "limit", "time_in_force": "gtc", ` +
+    `"order_class": "oco", "take_profit": {"limit_price": "13"}, "stop_loss": {"stop_price": "10", "limit_price": "9.9"}}`
+  status, resp = do(t, "POST", server.URL + "/v2/orders", oco)
+  assert.Equal(t, 200, status)
+  assert.Equal(t, "new", string(resp.GetStringBytes("status")))
+  assert.Equal(t, "13", string(resp.GetStringBytes("limit_price")))
+  assert.Equal(t, "stop_limit", string(resp.GetArray("legs")[0].GetStringBytes("type")))
+
+  status, _ = do(t, "DELETE", server.URL + "/v2/orders/" + string(resp.GetArray("legs")[0].GetStringBytes("id")), "")
+  assert.Equal(t, http.StatusNoContent, status)
+  _, orders = do(t, "GET", server.URL + "/v2/orders?status=open", "")
+  assert.Equal(t, 0, len(orders.GetArray()))
+
+  status, _ = do(t, "POST", server.URL + "/v2/orders", `{"symbol": "BTC/USD", "qty": "1", "side": "buy", "type": "market", ` +
+    `"time_in_force": "gtc", "order_class": "bracket", "take_profit": {"limit_price": "11"}, "stop_loss": {"stop_price": "9"}}`)
+  assert.Equal(t, 422, status)
+}
@@ -0,0 +1,235 @@
+// Package calendar holds the trading sessions of the stock market, including early
+// closes and holidays, as returned by the /v2/calendar endpoint of the trading API.
+// Local calendar files use the same JSON format, so a saved API response can be used.
+//
+// Days inside the loaded range without a session are holidays or weekends. Times
+// outside the loaded range are unknown, which callers treat as open so that a
+// missing calendar never blocks trading.
+
+package calendar
+
+import (
+  "fmt"
+  "sync"
+  "time"
+  "strings"
+  _ "time/tzdata"
+  "github.com/valyala/fastjson"
+)
+
+var NewYork, _ = time.LoadLocation("America/New_York")
+
+type Session struct {
+  Date       time.Time  // Midnight in New York of the trading day
+  Open       time.Time  // Regular session
+  Close      time.Time
+  PreOpen    time.Time  // Extended hours
+  PostClose  time.Time
+}
+
+type Calendar struct {
+  sessions  map[string]Session
+  first     time.Time  // First and last day of the loaded range
+  last      time.Time
+  extended  bool       // Whether pre and post market count as open
+  rwm       sync.RWMutex
+}
+
+func New(extended bool) *Calendar {
+  return &Calendar{
+    sessions: make(map[string]Session),
+    extended: extended,
+  }
+}
+
+func dayKey(t time.Time) string {
+  return t.In(NewYork).Format(time.DateOnly)
+}
+
+// Parses a time of day as either "09:30" or "0930" on the given date in New York.
+func parseClock(date time.Time, s string) (time.Time, error) {
+  t, err := time.Parse("1504", strings.ReplaceAll(s, ":", ""))
+  if err != nil {
+    return time.Time{}, err
+  }
+  return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0, NewYork), nil
+}
+
+// Parses the body of a calendar response. Extended hours default to the regular
+// session if they are missing.
+func Parse(body []byte) ([]Session, error) {
+  var p fastjson.Parser
+  v, err := p.ParseBytes(body)
+  if err != nil {
+    return nil, err
+  }
+  arr, err := v.Array()
+  if err != nil {
+    return nil, err
+  }
+
+  sessions := make([]Session, 0, len(arr))
+  for _, day := range arr {
+    date, err := time.ParseInLocation(time.DateOnly, string(day.GetStringBytes("date")), NewYork)
+    if err != nil {
+      return nil, fmt.Errorf("Invalid calendar date: %w", err)
+    }
+    s := Session{Date: date}
+    if s.Open, err = parseClock(date, string(day.GetStringBytes("open"))); err != nil {
+      return nil, fmt.Errorf("Invalid open on %s: %w", date.Format(time.DateOnly), err)
+    }
+    if s.Close, err = parseClock(date, string(day.GetStringBytes("close"))); err != nil {
+      return nil, fmt.Errorf("Invalid close on %s: %w", date.Format(time.DateOnly), err)
+    }
+    s.PreOpen, s.PostClose = s.Open, s.Close
+    if pre := day.GetStringBytes("session_open"); pre != nil {
+      if s.PreOpen, err = parseClock(date, string(pre)); err != nil {
+        return nil, fmt.Errorf("Invalid session_open on %s: %w", date.Format(time.DateOnly), err)
+      }
+    }
+    if post := day.GetStringBytes("session_close"); post != nil {
+      if s.PostClose, err = parseClock(date, string(post)); err != nil {
+        return nil, fmt.Errorf("Invalid session_close on %s: %w", date.Format(time.DateOnly), err)
+      }
+    }
+    sessions = append(sessions, s)
+  }
+  return sessions, nil
+}
+
+// Adds sessions covering the days from first to last. Days in the range without
+// a session are closed.
+func (c *Calendar) Load(sessions []Session, first time.Time, last time.Time) {
+  c.rwm.Lock()
+  defer c.rwm.Unlock()
+  for _, s := range sessions {
+    c.sessions[dayKey(s.Date)] = s
+  }
+  first, _ = time.ParseInLocation(time.DateOnly, dayKey(first), NewYork)
+  last, _ = time.ParseInLocation(time.DateOnly, dayKey(last), NewYork)
+  if c.first.IsZero() || first.Before(c.first) {
+    c.first = first
+  }
+  if last.After(c.last) {
+    c.last = last
+  }
+}
+
+// Adds the sessions, using the first and last session as the range.
+func (c *Calendar) LoadSessions(sessions []Session) {
+  if len(sessions) == 0 {
+    return
+  }
+  first, last := sessions[0].Date, sessions[0].Date
+  for _, s := range sessions {
+    if s.Date.Before(first) {
+      first = s.Date
+    }
+    if s.Date.After(last) {
+      last = s.Date
+    }
+  }
+  c.Load(sessions, first, last)
+}
+
+// Whether the calendar has data for the day of t
+func (c *Calendar) Covers(t time.Time) bool {
+  if c == nil {
+    return false
+  }
+  c.rwm.RLock()
+  defer c.rwm.RUnlock()
+  if c.first.IsZero() {
+    return false
+  }
+  key := dayKey(t)
+  return key >= dayKey(c.first) && key <= dayKey(c.last)
+}
+
+// Session of the trading day of t. False if the market is closed that day or the
+// day is not covered.
+func (c *Calendar) Session(t time.Time) (Session, bool) {
+  if c == nil {
+    return Session{}, false
+  }
+  c.rwm.RLock()
+  defer c.rwm.RUnlock()
+  s, ok := c.sessions[dayKey(t)]
+  return s, ok
+}
+
+// Whether the market is open at t, including pre and post market if extended hours
+// are enabled. Times that are not covered are treated as open.
+func (c *Calendar) IsOpen(t time.Time) bool {
+  if !c.Covers(t) {
+    return true
+  }
+  s, ok := c.Session(t)
+  if !ok {
+    return false
+  }
+  open, close := s.Open, s.Close
+  if c.extended {
+    open, close = s.PreOpen, s.PostClose
+  }
+  return !t.Before(open) && t.Before(close)
+}
+
+// End of trading on the day of t, which is the post market close if extended hours
+// are enabled. False if the market is closed that day or the day is not covered.
+func (c *Calendar) CloseOf(t time.Time) (time.Time, bool) {
+  s, ok := c.Session(t)
+  if !ok {
+    return time.Time{}, false
+  }
+  if c.extended {
+    return s.PostClose, true
+  }
+  return s.Close, true
+}
+
+// Time left until the close of the session t is in, which is the post market close
+// if extended hours are enabled, as in CloseOf. False if t is not within trading
+// hours or not covered.
+func (c *Calendar) UntilClose(t time.Time) (time.Duration, bool) {
+  s, ok := c.Session(t)
+  if !ok {
+    return 0, false
+  }
+  open, close := s.Open, s.Close
+  if c.extended {
+    open, close = s.PreOpen, s.PostClose
+  }
+  if t.Before(open) || !t.Before(close) {
+    return 0, false
+  }
+  return close.Sub(t), true
+}
+
+type Clock struct {
+  Timestamp  time.Time
+  IsOpen     bool
+  NextOpen   time.Time
+  NextClose  time.Time
+}
+
+// Parses the body of a /v2/clock response
+func ParseClock(body []byte) (Clock, error) {
+  var p fastjson.Parser
+  v, err := p.ParseBytes(body)
+  if err != nil {
+    return Clock{}, err
+  }
+  var c Clock
+  c.IsOpen = v.GetBool("is_open")
+  for _, f := range []struct{ name string; t *time.Time }{
+    {"timestamp", &c.Timestamp},
+    {"next_open", &c.NextOpen},
+    {"next_close", &c.NextClose},
+  } {
+    if *f.t, err = time.Parse(time.RFC3339Nano, string(v.GetStringBytes(f.name))); err != nil {
+      return Clock{}, fmt.Errorf("Invalid %s in clock: %w", f.name, err)
+    }
+  }
+  return c, nil
+}
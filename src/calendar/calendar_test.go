@@ -0,0 +1,111 @@
+package calendar
+
+import (
+  "time"
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+// Regular day, early close on the 3rd, holiday on the 4th
+const calendarJSON = `[
+  {"date":"2024-07-02","open":"09:30","close":"16:00","session_open":"0400","session_close":"2000"},
+  {"date":"2024-07-03","open":"09:30","close":"13:00","session_open":"0400","session_close":"1700"},
+  {"date":"2024-07-05","open":"09:30","close":"16:00"}
+]`
+
+func ny(day int, hour int, min int) time.Time {
+  return time.Date(2024, 7, day, hour, min, 0, 0, NewYork)
+}
+
+func TestParse(t *testing.T) {
+  sessions, err := Parse([]byte(calendarJSON))
+  assert.Nil(t, err)
+  assert.Equal(t, 3, len(sessions))
+  assert.True(t, sessions[1].Close.Equal(ny(3, 13, 0)))
+  assert.True(t, sessions[1].PostClose.Equal(ny(3, 17, 0)))
+  assert.True(t, sessions[0].PreOpen.Equal(ny(2, 4, 0)))
+  // Extended hours default to the regular session
+  assert.True(t, sessions[2].PreOpen.Equal(sessions[2].Open))
+
+  _, err = Parse([]byte(`[{"date":"2024-07-02","open":"9.30","close":"16:00"}]`))
+  assert.NotNil(t, err)
+}
+
+func TestIsOpen(t *testing.T) {
+  sessions, _ := Parse([]byte(calendarJSON))
+  c := New(false)
+  c.LoadSessions(sessions)
+
+  assert.False(t, c.IsOpen(ny(2, 9, 29)))
+  assert.True(t, c.IsOpen(ny(2, 9, 30)))
+  assert.True(t, c.IsOpen(ny(2, 15, 59)))
+  assert.False(t, c.IsOpen(ny(2, 16, 0)))
+  assert.True(t, c.IsOpen(ny(2, 14, 0).UTC()))
+  // Early close
+  assert.False(t, c.IsOpen(ny(3, 14, 0)))
+  // Holiday
+  assert.True(t, c.Covers(ny(4, 12, 0)))
+  assert.False(t, c.IsOpen(ny(4, 12, 0)))
+  // Not covered
+  assert.False(t, c.Covers(ny(8, 12, 0)))
+  assert.True(t, c.IsOpen(ny(8, 3, 0)))
+
+  c = New(true)
+  c.LoadSessions(sessions)
+  assert.True(t, c.IsOpen(ny(2, 4, 0)))
+  assert.True(t, c.IsOpen(ny(3, 16, 59)))
+  assert.False(t, c.IsOpen(ny(3, 17, 0)))
+
+  var empty *Calendar
+  assert.True(t, empty.IsOpen(ny(2, 3, 0)))
+}
+
+func TestUntilClose(t *testing.T) {
+  sessions, _ := Parse([]byte(calendarJSON))
+  c := New(false)
+  c.Load(sessions, ny(1, 0, 0), ny(10, 0, 0))
+
+  left, ok := c.UntilClose(ny(3, 12, 45))
+  assert.True(t, ok)
+  assert.Equal(t, 15 * time.Minute, left)
+
+  _, ok = c.UntilClose(ny(3, 13, 30))
+  assert.False(t, ok)
+  _, ok = c.UntilClose(ny(4, 12, 0))
+  assert.False(t, ok)
+  // Closed on the 9th even though the calendar has no session for it
+  assert.False(t, c.IsOpen(ny(9, 12, 0)))
+
+  // Until the post market close with extended hours
+  c = New(true)
+  c.Load(sessions, ny(1, 0, 0), ny(10, 0, 0))
+  left, ok = c.UntilClose(ny(3, 16, 45))
+  assert.True(t, ok)
+  assert.Equal(t, 15 * time.Minute, left)
+  _, ok = c.UntilClose(ny(3, 17, 0))
+  assert.False(t, ok)
+  _, ok = c.UntilClose(ny(3, 3, 59))
+  assert.False(t, ok)
+}
+
+func TestCloseOf(t *testing.T) {
+  sessions, _ := Parse([]byte(calendarJSON))
+  c := New(false)
+  c.Load(sessions, ny(1, 0, 0), ny(10, 0, 0))
+  close, ok := c.CloseOf(ny(3, 8, 0))
+  assert.True(t, ok)
+  assert.Equal(t, ny(3, 13, 0), close)
+  _, ok = c.CloseOf(ny(4, 12, 0))
+  assert.False(t, ok)
+}
+
+func TestParseClock(t *testing.T) {
+  clock, err := ParseClock([]byte(`{"timestamp":"2024-07-02T10:00:00.123-04:00","is_open":true,` +
+    `"next_open":"2024-07-03T09:30:00-04:00","next_close":"2024-07-02T16:00:00-04:00"}`))
+  assert.Nil(t, err)
+  assert.True(t, clock.IsOpen)
+  assert.True(t, clock.NextClose.Equal(ny(2, 16, 0)))
+
+  _, err = ParseClock([]byte(`{"is_open":false}`))
+  assert.NotNil(t, err)
+}
@@ -0,0 +1,488 @@
+// Package config loads the runtime configuration from a YAML file, with environment
+// variables taking precedence over the file and defaults used for anything not set.
+//
+// Every field can be overridden by the environment variable in its env tag. Lists
+// are comma separated and durations use Go syntax, e.g. ALGO_HTTP_TIMEOUT=5s.
+
+package config
+
+import (
+  "io"
+  "os"
+  "fmt"
+  "time"
+  "bytes"
+  "errors"
+  "slices"
+  "strings"
+  "strconv"
+  "net"
+  "net/url"
+  "reflect"
+  "log/slog"
+  "gopkg.in/yaml.v3"
+)
+
+type Config struct {
+  Live                 bool           `yaml:"live"                   env:"ALGO_LIVE"`  // Trade with the live account instead of paper
+
+  StockSymbols         []string       `yaml:"stock_symbols"          env:"ALGO_STOCK_SYMBOLS"`
+  CryptoSymbols        []string       `yaml:"crypto_symbols"         env:"ALGO_CRYPTO_SYMBOLS"`
+  NotionalUSD          float64        `yaml:"notional_usd"           env:"ALGO_NOTIONAL_USD"`
+  WindowSize           int            `yaml:"window_size"            env:"ALGO_WINDOW_SIZE"`
+  HistDays             int            `yaml:"hist_days"              env:"ALGO_HIST_DAYS"`
+  HistLimit            int            `yaml:"hist_limit"             env:"ALGO_HIST_LIMIT"`
+
+  // Left empty to use the paper or live endpoints depending on Live
+  Endpoint             string         `yaml:"endpoint"               env:"ALGO_ENDPOINT"`
+  WssAccount           string         `yaml:"wss_account"            env:"ALGO_WSS_ACCOUNT"`
+  WssStock             string         `yaml:"wss_stock"              env:"ALGO_WSS_STOCK"`
+  WssCrypto            string         `yaml:"wss_crypto"             env:"ALGO_WSS_CRYPTO"`
+  DataEndpoint         string         `yaml:"data_endpoint"          env:"ALGO_DATA_ENDPOINT"`
+
+  HTTPTimeout          time.Duration  `yaml:"http_timeout"           env:"ALGO_HTTP_TIMEOUT"`
+  MaxReceivedTimeDiff  time.Duration  `yaml:"max_received_time_diff" env:"ALGO_MAX_RECEIVED_TIME_DIFF"`
+  MaxTriggerTimeDiff   time.Duration  `yaml:"max_trigger_time_diff"  env:"ALGO_MAX_TRIGGER_TIME_DIFF"`
+  ReadDeadline         time.Duration  `yaml:"read_deadline"          env:"ALGO_READ_DEADLINE"`
+  PingInterval         time.Duration  `yaml:"ping_interval"          env:"ALGO_PING_INTERVAL"`
+  RateLimitSleep       time.Duration  `yaml:"rate_limit_sleep"       env:"ALGO_RATE_LIMIT_SLEEP"`
+  RequestRetries       int            `yaml:"request_retries"        env:"ALGO_REQUEST_RETRIES"`
+
+  BacktestCash         float64        `yaml:"backtest_cash"           env:"ALGO_BACKTEST_CASH"`
+  BacktestCommissionPct float64       `yaml:"backtest_commission_pct" env:"ALGO_BACKTEST_COMMISSION_PCT"`
+  BacktestSlippagePct  float64        `yaml:"backtest_slippage_pct"   env:"ALGO_BACKTEST_SLIPPAGE_PCT"`
+
+  StrategiesFile       string         `yaml:"strategies_file"        env:"ALGO_STRATEGIES_FILE"`
+
+  AdminSocket          string         `yaml:"admin_socket"           env:"ALGO_ADMIN_SOCKET"`  // Unix socket for admin commands. Empty disables them.
+  AdminAddr            string         `yaml:"admin_addr"             env:"ALGO_ADMIN_ADDR"`  // Address of the HTTP admin API. Empty disables it.
+  AdminToken           string         `yaml:"admin_token"            env:"ALGO_ADMIN_TOKEN"`  // Bearer token required by the admin API. Needed off loopback.
+  MetricsAddr          string         `yaml:"metrics_addr"           env:"ALGO_METRICS_ADDR"`  // Address to serve Prometheus metrics on. Empty disables them.
+
+  CalendarFile         string         `yaml:"calendar_file"          env:"ALGO_CALENDAR_FILE"`  // Read instead of the calendar endpoint if set
+  ExtendedHours        bool           `yaml:"extended_hours"         env:"ALGO_EXTENDED_HOURS"`  // Trade stocks in pre and post market
+  FlattenBeforeClose   time.Duration  `yaml:"flatten_before_close"   env:"ALGO_FLATTEN_BEFORE_CLOSE"`  // Close stock positions this long before the close. 0 disables.
+
+  // Risk limits checked before every open. 0 disables a limit.
+  MaxGrossExposureUSD     float64     `yaml:"max_gross_exposure_usd"     env:"ALGO_MAX_GROSS_EXPOSURE_USD"`
+  MaxPositionsPerSymbol   int         `yaml:"max_positions_per_symbol"   env:"ALGO_MAX_POSITIONS_PER_SYMBOL"`
+  MaxPositionsPerStrategy int         `yaml:"max_positions_per_strategy" env:"ALGO_MAX_POSITIONS_PER_STRATEGY"`
+  MaxOpenPositions        int         `yaml:"max_open_positions"         env:"ALGO_MAX_OPEN_POSITIONS"`
+  DailyLossLimitUSD       float64     `yaml:"daily_loss_limit_usd"       env:"ALGO_DAILY_LOSS_LIMIT_USD"`  // Stops new positions for the rest of the day
+  MaxOrdersPerMinute      int         `yaml:"max_orders_per_minute"      env:"ALGO_MAX_ORDERS_PER_MINUTE"`
+
+  // Market opens are refused when the bid/ask spread in percent of the mid price is
+  // wider, or the last quote is older than MaxQuoteAge. 0 disables the guard.
+  MaxSpreadPctStock       float64        `yaml:"max_spread_pct_stock"       env:"ALGO_MAX_SPREAD_PCT_STOCK"`
+  MaxSpreadPctCrypto      float64        `yaml:"max_spread_pct_crypto"      env:"ALGO_MAX_SPREAD_PCT_CRYPTO"`
+  MaxQuoteAge             time.Duration  `yaml:"max_quote_age"              env:"ALGO_MAX_QUOTE_AGE"`
+
+  // Screens the configured symbols at startup and every ScreenInterval, and trades
+  // the ScreenTopN best of each asset class that pass the filters. 0 disables the
+  // screener. Filters set to 0 are not applied.
+  ScreenTopN              int            `yaml:"screen_top_n"               env:"ALGO_SCREEN_TOP_N"`
+  ScreenInterval          time.Duration  `yaml:"screen_interval"            env:"ALGO_SCREEN_INTERVAL"`  // 0 screens at startup only
+  ScreenLookbackDays      int            `yaml:"screen_lookback_days"       env:"ALGO_SCREEN_LOOKBACK_DAYS"`
+  ScreenMinPrice          float64        `yaml:"screen_min_price"           env:"ALGO_SCREEN_MIN_PRICE"`
+  ScreenMaxPrice          float64        `yaml:"screen_max_price"           env:"ALGO_SCREEN_MAX_PRICE"`
+  ScreenMinDollarVolume   float64        `yaml:"screen_min_dollar_volume"   env:"ALGO_SCREEN_MIN_DOLLAR_VOLUME"`
+  ScreenMinVolatilityPct  float64        `yaml:"screen_min_volatility_pct"  env:"ALGO_SCREEN_MIN_VOLATILITY_PCT"`
+  ScreenMaxSpreadPct      float64        `yaml:"screen_max_spread_pct"      env:"ALGO_SCREEN_MAX_SPREAD_PCT"`
+  ScreenFile              string         `yaml:"screen_file"                env:"ALGO_SCREEN_FILE"`  // Result of the last screen. Empty disables.
+
+  // Keeps an L2 order book of the crypto assets. Market opens are refused when the
+  // slippage estimated from the book is above MaxSlippagePct. 0 disables the check.
+  CryptoOrderbook         bool           `yaml:"crypto_orderbook"           env:"ALGO_CRYPTO_ORDERBOOK"`
+  MaxSlippagePct          float64        `yaml:"max_slippage_pct"           env:"ALGO_MAX_SLIPPAGE_PCT"`
+
+  // Shutdown policy run on each signal, one of ShutdownPolicies. Empty leaves the
+  // signal to its default action. Policies other than prompt run unattended, and
+  // stop waiting for orders after ShutdownDeadline.
+  ShutdownSIGINT          string         `yaml:"shutdown_sigint"            env:"ALGO_SHUTDOWN_SIGINT"`
+  ShutdownSIGTERM         string         `yaml:"shutdown_sigterm"           env:"ALGO_SHUTDOWN_SIGTERM"`
+  ShutdownSIGUSR1         string         `yaml:"shutdown_sigusr1"           env:"ALGO_SHUTDOWN_SIGUSR1"`
+  ShutdownSIGUSR2         string         `yaml:"shutdown_sigusr2"           env:"ALGO_SHUTDOWN_SIGUSR2"`
+  ShutdownDeadline        time.Duration  `yaml:"shutdown_deadline"          env:"ALGO_SHUTDOWN_DEADLINE"`
+
+  // Logs are written as text or json. Levels are debug, info, warn or error, and
+  // components without a level of their own log at LogLevel.
+  LogFormat               string         `yaml:"log_format"                 env:"ALGO_LOG_FORMAT"`
+  LogLevel                string         `yaml:"log_level"                  env:"ALGO_LOG_LEVEL"`
+  LogLevelMarket          string         `yaml:"log_level_market"           env:"ALGO_LOG_LEVEL_MARKET"`
+  LogLevelAccount         string         `yaml:"log_level_account"          env:"ALGO_LOG_LEVEL_ACCOUNT"`
+  LogLevelDatabase        string         `yaml:"log_level_database"         env:"ALGO_LOG_LEVEL_DATABASE"`
+  LogLevelStrategy        string         `yaml:"log_level_strategy"         env:"ALGO_LOG_LEVEL_STRATEGY"`
+  LogMaxSizeMB            int            `yaml:"log_max_size_mb"            env:"ALGO_LOG_MAX_SIZE_MB"`  // Rotates the log file at this size. 0 disables.
+  LogRotateDaily          bool           `yaml:"log_rotate_daily"           env:"ALGO_LOG_ROTATE_DAILY"`
+
+  // Notifications are sent by each backend with its credentials in the environment,
+  // at or above its level, one of NotifyLevels or off. The components limit them to
+  // those sent from market, account, database, strategy or main if not empty.
+  NotifyPushoverLevel        string         `yaml:"notify_pushover_level"         env:"ALGO_NOTIFY_PUSHOVER_LEVEL"`
+  NotifyPushoverComponents   []string       `yaml:"notify_pushover_components"    env:"ALGO_NOTIFY_PUSHOVER_COMPONENTS"`
+  NotifySlackLevel           string         `yaml:"notify_slack_level"            env:"ALGO_NOTIFY_SLACK_LEVEL"`
+  NotifySlackComponents      []string       `yaml:"notify_slack_components"       env:"ALGO_NOTIFY_SLACK_COMPONENTS"`
+  NotifyDiscordLevel         string         `yaml:"notify_discord_level"          env:"ALGO_NOTIFY_DISCORD_LEVEL"`
+  NotifyDiscordComponents    []string       `yaml:"notify_discord_components"     env:"ALGO_NOTIFY_DISCORD_COMPONENTS"`
+  NotifyTelegramLevel        string         `yaml:"notify_telegram_level"         env:"ALGO_NOTIFY_TELEGRAM_LEVEL"`
+  NotifyTelegramComponents   []string       `yaml:"notify_telegram_components"    env:"ALGO_NOTIFY_TELEGRAM_COMPONENTS"`
+  NotifyEmailLevel           string         `yaml:"notify_email_level"            env:"ALGO_NOTIFY_EMAIL_LEVEL"`
+  NotifyEmailComponents      []string       `yaml:"notify_email_components"       env:"ALGO_NOTIFY_EMAIL_COMPONENTS"`
+  NotifyWebhookLevel         string         `yaml:"notify_webhook_level"          env:"ALGO_NOTIFY_WEBHOOK_LEVEL"`
+  NotifyWebhookComponents    []string       `yaml:"notify_webhook_components"     env:"ALGO_NOTIFY_WEBHOOK_COMPONENTS"`
+  NotifyEmailSMTP            string         `yaml:"notify_email_smtp"             env:"ALGO_NOTIFY_EMAIL_SMTP"`  // host:port. Empty disables email.
+  NotifyEmailFrom            string         `yaml:"notify_email_from"             env:"ALGO_NOTIFY_EMAIL_FROM"`
+  NotifyEmailTo              []string       `yaml:"notify_email_to"               env:"ALGO_NOTIFY_EMAIL_TO"`
+  NotifyEmergencyRetry       time.Duration  `yaml:"notify_emergency_retry"        env:"ALGO_NOTIFY_EMERGENCY_RETRY"`  // Pushover repeats emergencies this often
+  NotifyEmergencyExpire      time.Duration  `yaml:"notify_emergency_expire"       env:"ALGO_NOTIFY_EMERGENCY_EXPIRE"`  // until acknowledged or expired
+
+  // Notifications other than emergencies are sent from a queue of NotifyQueueSize.
+  // Identical ones within NotifyDedupWindow are sent once with a count of repeats,
+  // and info notifications are sent as a digest every NotifyDigestInterval. Zero
+  // durations disable either.
+  NotifyQueueSize            int            `yaml:"notify_queue_size"             env:"ALGO_NOTIFY_QUEUE_SIZE"`
+  NotifyDedupWindow          time.Duration  `yaml:"notify_dedup_window"           env:"ALGO_NOTIFY_DEDUP_WINDOW"`
+  NotifyDigestInterval       time.Duration  `yaml:"notify_digest_interval"        env:"ALGO_NOTIFY_DIGEST_INTERVAL"`
+}
+
+// Policies that can be mapped to signals. prompt asks on stdin, save waits for
+// pending orders and saves the positions, the flatten policies close the positions
+// of all or one asset class through the strategies first, and close_all closes
+// every position at the broker.
+var ShutdownPolicies = []string{"prompt", "save", "flatten", "flatten_stock", "flatten_crypto", "close_all"}
+
+// Notification levels, from the least severe. Emergencies are for events like
+// closing all positions.
+var NotifyLevels = []string{"info", "message", "warning", "error", "emergency"}
+
+// The loaded configuration. Holds the defaults until Load is called at startup.
+var C = Default()
+
+func Default() *Config {
+  c := &Config{
+    CryptoSymbols: []string{
+      "BTC/USD",
+      "ETH/USD",
+      "USDT/USD",
+      "SOL/USD",
+      "USDC/USD",
+      "DOGE/USD",
+      "LINK/USD",
+      "AVAX/USD",
+      // Currencies below this line are quite illiquid
+      "LTC/USD",
+      "SHIB/USD",
+      "DOT/USD",
+      "BCH/USD",
+      "UNI/USD",
+      "AAVE/USD",
+      "YFI/USD",
+      "MKR/USD",
+      "GRT/USD",
+      "XTZ/USD",
+      "BAT/USD",
+      "SUSHI/USD",
+      "CRV/USD",
+    },
+    StockSymbols: []string{},
+    NotionalUSD: 50,
+    WindowSize: 500,
+    HistDays: 1,
+    HistLimit: 10000,
+    WssStock: "wss://stream.data.alpaca.markets/v2/iex",
+    WssCrypto: "wss://stream.data.alpaca.markets/v1beta3/crypto/us",
+    DataEndpoint: "https://data.alpaca.markets",
+    HTTPTimeout: 5 * time.Second,
+    MaxReceivedTimeDiff: 100 * time.Millisecond,
+    MaxTriggerTimeDiff: 100 * time.Millisecond,
+    ReadDeadline: 20 * time.Second,
+    PingInterval: 10 * time.Second,
+    RateLimitSleep: 30 * time.Second,
+    RequestRetries: 4,
+    BacktestCash: 10000,
+    BacktestCommissionPct: 0.25,
+    BacktestSlippagePct: 0.05,
+    StrategiesFile: "strategies.yaml",
+    MaxQuoteAge: time.Minute,
+    ScreenInterval: 24 * time.Hour,
+    ScreenLookbackDays: 20,
+    ScreenFile: "screener.json",
+    ShutdownSIGINT: "prompt",
+    ShutdownSIGTERM: "save",
+    ShutdownDeadline: 2 * time.Minute,
+    LogFormat: "text",
+    LogLevel: "info",
+    LogMaxSizeMB: 100,
+    LogRotateDaily: true,
+    NotifyPushoverLevel: "info",
+    NotifySlackLevel: "warning",
+    NotifyDiscordLevel: "warning",
+    NotifyTelegramLevel: "warning",
+    NotifyEmailLevel: "error",
+    NotifyWebhookLevel: "warning",
+    NotifyEmergencyRetry: time.Minute,
+    NotifyEmergencyExpire: time.Hour,
+    NotifyQueueSize: 256,
+    NotifyDedupWindow: 5 * time.Minute,
+    NotifyDigestInterval: 15 * time.Minute,
+  }
+  c.fillEndpoints()
+  return c
+}
+
+// Sets the trading endpoints that are not configured to those of the paper or
+// live account.
+func (c *Config) fillEndpoints() {
+  host := "paper-api.alpaca.markets"
+  if c.Live {
+    host = "api.alpaca.markets"
+  }
+  if c.Endpoint == "" {
+    c.Endpoint = "https://" + host + "/v2"
+  }
+  if c.WssAccount == "" {
+    c.WssAccount = "wss://" + host + "/stream"
+  }
+}
+
+// Reads the config file at path, applies environment overrides and validates the
+// result. A missing file is not an error, in which case only the defaults and the
+// environment are used.
+func Load(path string) (*Config, error) {
+  c := Default()
+  // Cleared so that the endpoints follow Live unless configured explicitly
+  c.Endpoint, c.WssAccount = "", ""
+
+  data, err := os.ReadFile(path)
+  if err != nil && !errors.Is(err, os.ErrNotExist) {
+    return nil, err
+  }
+  if err == nil {
+    dec := yaml.NewDecoder(bytes.NewReader(data))
+    dec.KnownFields(true)
+    if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+      return nil, fmt.Errorf("Invalid config file %s: %w", path, err)
+    }
+  }
+
+  if err := c.applyEnv(os.LookupEnv); err != nil {
+    return nil, err
+  }
+  c.fillEndpoints()
+  if err := c.Validate(); err != nil {
+    return nil, err
+  }
+  return c, nil
+}
+
+// Overrides fields with the environment variables named in their env tags.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+  v := reflect.ValueOf(c).Elem()
+  t := v.Type()
+  for i := range t.NumField() {
+    name := t.Field(i).Tag.Get("env")
+    if name == "" {
+      continue
+    }
+    s, ok := lookup(name)
+    if !ok {
+      continue
+    }
+    if err := setField(v.Field(i), strings.TrimSpace(s)); err != nil {
+      return fmt.Errorf("Invalid value %q for %s: %w", s, name, err)
+    }
+  }
+  return nil
+}
+
+func setField(f reflect.Value, s string) error {
+  switch f.Interface().(type) {
+  case string:
+    f.SetString(s)
+  case bool:
+    b, err := strconv.ParseBool(s)
+    if err != nil {
+      return err
+    }
+    f.SetBool(b)
+  case int:
+    n, err := strconv.Atoi(s)
+    if err != nil {
+      return err
+    }
+    f.SetInt(int64(n))
+  case float64:
+    x, err := strconv.ParseFloat(s, 64)
+    if err != nil {
+      return err
+    }
+    f.SetFloat(x)
+  case time.Duration:
+    d, err := time.ParseDuration(s)
+    if err != nil {
+      return err
+    }
+    f.SetInt(int64(d))
+  case []string:
+    list := []string{}
+    for _, item := range strings.Split(s, ",") {
+      if item = strings.TrimSpace(item); item != "" {
+        list = append(list, item)
+      }
+    }
+    f.Set(reflect.ValueOf(list))
+  default:
+    return fmt.Errorf("Unsupported type %s", f.Type())
+  }
+  return nil
+}
+
+func checkURL(name string, s string, schemes ...string) error {
+  u, err := url.Parse(s)
+  if err != nil || u.Host == "" || !slices.Contains(schemes, u.Scheme) {
+    return fmt.Errorf("%s must be a %s url, got %q", name, strings.Join(schemes, " or "), s)
+  }
+  return nil
+}
+
+// Returns all problems with the config joined into one error
+// Whether addr is host:port with a loopback host. An empty host listens on all
+// interfaces.
+func isLoopback(addr string) bool {
+  host, _, err := net.SplitHostPort(addr)
+  if err != nil {
+    return false
+  }
+  if host == "localhost" {
+    return true
+  }
+  ip := net.ParseIP(host)
+  return ip != nil && ip.IsLoopback()
+}
+
+func (c *Config) Validate() error {
+  var errs []error
+  check := func(ok bool, format string, args ...any) {
+    if !ok {
+      errs = append(errs, fmt.Errorf(format, args...))
+    }
+  }
+
+  check(len(c.StockSymbols) + len(c.CryptoSymbols) > 0, "No stock or crypto symbols configured")
+  seen := make(map[string]bool)
+  for _, s := range c.StockSymbols {
+    check(s != "" && !strings.Contains(s, "/"), "Invalid stock symbol %q", s)
+    check(!seen[s], "Duplicate symbol %q", s)
+    seen[s] = true
+  }
+  for _, s := range c.CryptoSymbols {
+    check(strings.Count(s, "/") == 1, "Invalid crypto symbol %q, expected e.g. BTC/USD", s)
+    check(!seen[s], "Duplicate symbol %q", s)
+    seen[s] = true
+  }
+
+  check(c.NotionalUSD > 0, "notional_usd must be positive")
+  // fillMissingMinutes looks two points back
+  check(c.WindowSize >= 2, "window_size must be at least 2")
+  check(c.HistDays >= 0, "hist_days can not be negative")
+  check(c.HistLimit >= 1 && c.HistLimit <= 10000, "hist_limit must be between 1 and 10000")
+  check(c.RequestRetries >= 1, "request_retries must be at least 1")
+
+  check(c.HTTPTimeout > 0, "http_timeout must be positive")
+  check(c.MaxReceivedTimeDiff > 0, "max_received_time_diff must be positive")
+  check(c.MaxTriggerTimeDiff > 0, "max_trigger_time_diff must be positive")
+  check(c.ReadDeadline > 0, "read_deadline must be positive")
+  check(c.PingInterval > 0, "ping_interval must be positive")
+  check(c.RateLimitSleep > 0, "rate_limit_sleep must be positive")
+  check(c.PingInterval < c.ReadDeadline, "ping_interval must be shorter than read_deadline")
+
+  for _, err := range []error{
+    checkURL("endpoint", c.Endpoint, "http", "https"),
+    checkURL("data_endpoint", c.DataEndpoint, "http", "https"),
+    checkURL("wss_account", c.WssAccount, "ws", "wss"),
+    checkURL("wss_stock", c.WssStock, "ws", "wss"),
+    checkURL("wss_crypto", c.WssCrypto, "ws", "wss"),
+  } {
+    if err != nil {
+      errs = append(errs, err)
+    }
+  }
+
+  check(c.AdminAddr == "" || c.AdminToken != "" || isLoopback(c.AdminAddr),
+    "admin_addr must be a loopback address unless admin_token is set",
+  )
+
+  check(c.FlattenBeforeClose >= 0, "flatten_before_close can not be negative")
+
+  check(c.MaxGrossExposureUSD >= 0, "max_gross_exposure_usd can not be negative")
+  check(c.MaxPositionsPerSymbol >= 0, "max_positions_per_symbol can not be negative")
+  check(c.MaxPositionsPerStrategy >= 0, "max_positions_per_strategy can not be negative")
+  check(c.MaxOpenPositions >= 0, "max_open_positions can not be negative")
+  check(c.DailyLossLimitUSD >= 0, "daily_loss_limit_usd can not be negative")
+  check(c.MaxOrdersPerMinute >= 0, "max_orders_per_minute can not be negative")
+
+  check(c.MaxSpreadPctStock >= 0, "max_spread_pct_stock can not be negative")
+  check(c.MaxSpreadPctCrypto >= 0, "max_spread_pct_crypto can not be negative")
+  check(c.MaxQuoteAge > 0, "max_quote_age must be positive")
+  check(c.MaxSlippagePct >= 0, "max_slippage_pct can not be negative")
+
+  check(c.ScreenTopN >= 0, "screen_top_n can not be negative")
+  check(c.ScreenInterval >= 0, "screen_interval can not be negative")
+  check(c.ScreenLookbackDays >= 2, "screen_lookback_days must be at least 2")
+  check(c.ScreenMinPrice >= 0, "screen_min_price can not be negative")
+  check(c.ScreenMaxPrice >= 0, "screen_max_price can not be negative")
+  check(c.ScreenMinDollarVolume >= 0, "screen_min_dollar_volume can not be negative")
+  check(c.ScreenMinVolatilityPct >= 0, "screen_min_volatility_pct can not be negative")
+  check(c.ScreenMaxSpreadPct >= 0, "screen_max_spread_pct can not be negative")
+
+  for _, f := range []struct{ name, policy string }{
+    {"shutdown_sigint", c.ShutdownSIGINT},
+    {"shutdown_sigterm", c.ShutdownSIGTERM},
+    {"shutdown_sigusr1", c.ShutdownSIGUSR1},
+    {"shutdown_sigusr2", c.ShutdownSIGUSR2},
+  } {
+    check(f.policy == "" || slices.Contains(ShutdownPolicies, f.policy), "%s must be empty or one of %v", f.name, ShutdownPolicies)
+  }
+  check(c.ShutdownDeadline > 0, "shutdown_deadline must be positive")
+
+  check(c.LogFormat == "text" || c.LogFormat == "json", "log_format must be text or json")
+  for _, f := range []struct{ name, level string }{
+    {"log_level", c.LogLevel},
+    {"log_level_market", c.LogLevelMarket},
+    {"log_level_account", c.LogLevelAccount},
+    {"log_level_database", c.LogLevelDatabase},
+    {"log_level_strategy", c.LogLevelStrategy},
+  } {
+    // Components log at log_level when empty
+    if f.level == "" && f.name != "log_level" {
+      continue
+    }
+    var level slog.Level
+    check(level.UnmarshalText([]byte(f.level)) == nil, "%s must be debug, info, warn or error", f.name)
+  }
+  check(c.LogMaxSizeMB >= 0, "log_max_size_mb can not be negative")
+
+  for _, f := range []struct{ name, level string }{
+    {"notify_pushover_level", c.NotifyPushoverLevel},
+    {"notify_slack_level", c.NotifySlackLevel},
+    {"notify_discord_level", c.NotifyDiscordLevel},
+    {"notify_telegram_level", c.NotifyTelegramLevel},
+    {"notify_email_level", c.NotifyEmailLevel},
+    {"notify_webhook_level", c.NotifyWebhookLevel},
+  } {
+    check(f.level == "off" || slices.Contains(NotifyLevels, f.level), "%s must be off or one of %v", f.name, NotifyLevels)
+  }
+  check(c.NotifyEmailSMTP == "" || len(c.NotifyEmailTo) > 0 && c.NotifyEmailFrom != "", "notify_email_from and notify_email_to must be set with notify_email_smtp")
+  // Pushover requires at least 30 seconds between retries
+  check(c.NotifyEmergencyRetry >= 30 * time.Second, "notify_emergency_retry must be at least 30s")
+  check(c.NotifyEmergencyExpire >= c.NotifyEmergencyRetry && c.NotifyEmergencyExpire <= 3 * time.Hour, "notify_emergency_expire must be between notify_emergency_retry and 3h")
+  check(c.NotifyQueueSize > 0, "notify_queue_size must be positive")
+  check(c.NotifyDedupWindow >= 0, "notify_dedup_window can not be negative")
+  check(c.NotifyDigestInterval >= 0, "notify_digest_interval can not be negative")
+
+  check(c.BacktestCash > 0, "backtest_cash must be positive")
+  check(c.BacktestCommissionPct >= 0, "backtest_commission_pct can not be negative")
+  check(c.BacktestSlippagePct >= 0, "backtest_slippage_pct can not be negative")
+
+  return errors.Join(errs...)
+}
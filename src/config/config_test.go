@@ -0,0 +1,133 @@
+package config
+
+import (
+  "os"
+  "time"
+  "testing"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) string {
+  path := filepath.Join(t.TempDir(), "config.yaml")
+  if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+    t.Fatal(err)
+  }
+  return path
+}
+
+func TestDefault(t *testing.T) {
+  c := Default()
+  assert.Nil(t, c.Validate())
+  assert.Equal(t, 500, c.WindowSize)
+  assert.Equal(t, "https://paper-api.alpaca.markets/v2", c.Endpoint)
+  assert.Equal(t, "wss://paper-api.alpaca.markets/stream", c.WssAccount)
+}
+
+func TestLoad(t *testing.T) {
+  t.Run("Missing and empty file", func(t *testing.T) {
+    c, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+    assert.Nil(t, err)
+    assert.Equal(t, Default(), c)
+
+    c, err = Load(writeConfig(t, "# nothing set\n"))
+    assert.Nil(t, err)
+    assert.Equal(t, Default(), c)
+  })
+
+  t.Run("File", func(t *testing.T) {
+    c, err := Load(writeConfig(t, `
+crypto_symbols: [BTC/USD]
+stock_symbols: [AAPL, MSFT]
+notional_usd: 25.5
+http_timeout: 2s
+live: true
+`))
+    assert.Nil(t, err)
+    assert.Equal(t, []string{"BTC/USD"}, c.CryptoSymbols)
+    assert.Equal(t, []string{"AAPL", "MSFT"}, c.StockSymbols)
+    assert.Equal(t, 25.5, c.NotionalUSD)
+    assert.Equal(t, 2 * time.Second, c.HTTPTimeout)
+    assert.Equal(t, 500, c.WindowSize)
+    assert.Equal(t, "https://api.alpaca.markets/v2", c.Endpoint)
+    assert.Equal(t, "wss://api.alpaca.markets/stream", c.WssAccount)
+  })
+
+  t.Run("Explicit endpoint when live", func(t *testing.T) {
+    c, err := Load(writeConfig(t, "live: true\nendpoint: http://127.0.0.1:8089/v2\n"))
+    assert.Nil(t, err)
+    assert.Equal(t, "http://127.0.0.1:8089/v2", c.Endpoint)
+    assert.Equal(t, "wss://api.alpaca.markets/stream", c.WssAccount)
+  })
+
+  t.Run("Env overrides file", func(t *testing.T) {
+    t.Setenv("ALGO_CRYPTO_SYMBOLS", "ETH/USD, SOL/USD")
+    t.Setenv("ALGO_WINDOW_SIZE", "100")
+    t.Setenv("ALGO_READ_DEADLINE", "1m")
+    c, err := Load(writeConfig(t, "crypto_symbols: [BTC/USD]\nwindow_size: 50\n"))
+    assert.Nil(t, err)
+    assert.Equal(t, []string{"ETH/USD", "SOL/USD"}, c.CryptoSymbols)
+    assert.Equal(t, 100, c.WindowSize)
+    assert.Equal(t, time.Minute, c.ReadDeadline)
+  })
+
+  t.Run("Invalid env", func(t *testing.T) {
+    t.Setenv("ALGO_WINDOW_SIZE", "big")
+    _, err := Load(writeConfig(t, ""))
+    assert.ErrorContains(t, err, "ALGO_WINDOW_SIZE")
+  })
+
+  t.Run("Unknown key", func(t *testing.T) {
+    _, err := Load(writeConfig(t, "notional: 10\n"))
+    assert.NotNil(t, err)
+  })
+}
+
+func TestValidate(t *testing.T) {
+  c := Default()
+  c.CryptoSymbols = []string{"BTCUSD", "ETH/USD", "ETH/USD"}
+  c.StockSymbols = []string{"BRK/B"}
+  c.WindowSize = 1
+  c.HistLimit = 20000
+  c.PingInterval = time.Minute
+  c.Endpoint = "paper-api.alpaca.markets"
+  c.WssStock = "https://stream.data.alpaca.markets/v2/iex"
+  c.ShutdownSIGUSR1 = "flatten_all"
+  c.LogLevelMarket = "verbose"
+  c.NotifySlackLevel = "critical"
+  c.NotifyEmailSMTP = "smtp.example.com:587"
+  c.NotifyEmergencyRetry = 10 * time.Second
+  c.NotifyQueueSize = 0
+  c.AdminAddr = ":8090"
+  err := c.Validate()
+  for _, msg := range []string{
+    `Invalid crypto symbol "BTCUSD"`,
+    `Duplicate symbol "ETH/USD"`,
+    `Invalid stock symbol "BRK/B"`,
+    "window_size",
+    "hist_limit",
+    "ping_interval must be shorter",
+    "endpoint must be",
+    "wss_stock must be",
+    "shutdown_sigusr1 must be empty or one of",
+    "log_level_market must be",
+    "notify_slack_level must be",
+    "notify_email_from and notify_email_to must be set",
+    "notify_emergency_retry must be at least",
+    "notify_queue_size must be positive",
+    "admin_addr must be a loopback address",
+  } {
+    assert.ErrorContains(t, err, msg)
+  }
+
+  c = Default()
+  c.CryptoSymbols = nil
+  assert.ErrorContains(t, c.Validate(), "No stock or crypto symbols")
+
+  c = Default()
+  c.AdminAddr = "127.0.0.1:8090"
+  assert.Nil(t, c.Validate())
+  c.AdminAddr = "0.0.0.0:8090"
+  c.AdminToken = "secret"
+  assert.Nil(t, c.Validate())
+}
@@ -12,6 +12,7 @@ import (
   "github.com/shopspring/decimal"
   "github.com/Kjellemann1/AlgoTrader-Go/util"
   "github.com/Kjellemann1/AlgoTrader-Go/constant"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
   "github.com/Kjellemann1/AlgoTrader-Go/request"
 )
 
@@ -34,8 +35,15 @@ type Query struct {
   ReceivedTime      *time.Time
   TriggerTime       *time.Time
   FillTime          *time.Time
+  TakeProfitOrderID string
+  StopLossOrderID   string
+  Outcome           *ShutdownOutcome  // Set for "shutdown"
 }
 
+// Queue of the database for queries sent from assets, which are not given it. Set
+// in main. Nil in tests, where the queries are not sent.
+var dbQueue chan *Query
+
 type Database struct {
   conn                          *sql.DB
   db_chan chan                  *Query
@@ -43,6 +51,8 @@ type Database struct {
   insert_position               *sql.Stmt
   delete_position               *sql.Stmt
   update_n_close_orders         *sql.Stmt
+  update_legs                   *sql.Stmt
+  insert_shutdown               *sql.Stmt
   assets                        map[string]map[string]*Asset
 }
 
@@ -96,8 +106,10 @@ func (db *Database) prepQueries() error {
       filled_avg_price,
       trailing_stop,
       bad_for_analysis,
-      n_close_orders
-    ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+      n_close_orders,
+      take_profit_order_id,
+      stop_loss_order_id
+    ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
   `)
   if err != nil {
     return err
@@ -117,6 +129,28 @@ func (db *Database) prepQueries() error {
     return err
   }
 
+  db.update_legs, err = db.conn.Prepare(`
+    UPDATE positions SET take_profit_order_id = ?, stop_loss_order_id = ? WHERE symbol = ? AND strat_name = ?;
+  `)
+  if err != nil {
+    return err
+  }
+
+  db.insert_shutdown, err = db.conn.Prepare(`
+    INSERT INTO shutdowns (
+      policy,
+      trigger_source,
+      start_time,
+      end_time,
+      positions_left,
+      orders_pending,
+      deadline_reached
+    ) VALUES (?, ?, ?, ?, ?, ?, ?);
+  `)
+  if err != nil {
+    return err
+  }
+
   return nil
 }
 
@@ -145,14 +179,16 @@ func (db *Database) errorHandler(
       util.Backoff(backoff_sec)
       db.errorHandler(err, func_name, response, query, retries + 1, backoff_sec)
     } else {
-      util.Error(err, "MAX RETRIES REACHED", retries, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
+      util.Emergency(err, "MAX RETRIES REACHED", retries, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
       request.CloseAllPositions(2, 0)
+      util.Flush()
       log.Panicln("SHUTTING DOWN")
     }
   }
   if retries > 3 {
-    util.Warning(nil, "MAX RETRIES REACHED", retries, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
+    util.Emergency(errors.New("Max retries reached"), "Retries", retries, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
     request.CloseAllPositions(2, 0)
+    util.Flush()
     log.Panicln("SHUTTING DOWN")
   }
   db.queryHandler(query, *backoff_sec, retries + 1)
@@ -206,6 +242,8 @@ func (db *Database) insertPosition(query *Query, backoff_sec float64, retries in
     query.TrailingStop,
     query.BadForAnalysis,
     query.NCloseOrders,
+    query.TakeProfitOrderID,
+    query.StopLossOrderID,
   )
   if err != nil {
     db.errorHandler(err, "insertPosition", response, query, retries, &backoff_sec)
@@ -235,6 +273,29 @@ func (db *Database) updateNCloseOrders(query *Query, backoff_sec float64, retrie
   }
 }
 
+func (db *Database) updateLegs(query *Query, backoff_sec float64, retries int) {
+  response, err := db.update_legs.Exec(query.TakeProfitOrderID, query.StopLossOrderID, query.Symbol, query.StratName)
+  if err != nil {
+    db.errorHandler(err, "updateLegs", response, query, retries, &backoff_sec)
+  }
+}
+
+func (db *Database) insertShutdown(query *Query, backoff_sec float64, retries int) {
+  o := query.Outcome
+  response, err := db.insert_shutdown.Exec(
+    o.Policy,
+    o.Trigger,
+    o.StartTime,
+    o.EndTime,
+    o.PositionsLeft,
+    o.OrdersPending,
+    o.DeadlineReached,
+  )
+  if err != nil {
+    db.errorHandler(err, "insertShutdown", response, query, retries, &backoff_sec)
+  }
+}
+
 func (db *Database) queryHandler(query *Query, backoff_sec float64, retries int) {
   switch query.Action {
     case "open":
@@ -250,12 +311,21 @@ func (db *Database) queryHandler(query *Query, backoff_sec float64, retries int)
         db.deletePosition(query, backoff_sec, retries)
       }
 
+    case "legs":
+      db.updateLegs(query, backoff_sec, retries)
+
+    case "delete_position":
+      db.deletePosition(query, backoff_sec, retries)
+
     case "delete_all_positions":
       db.deleteAllPositions(backoff_sec, retries)
 
     case "save_state":
       db.saveState()
 
+    case "shutdown":
+      db.insertShutdown(query, backoff_sec, retries)
+
     default:
       util.Error(errors.New("Invalid query type"), "Query", query)
   }
@@ -289,11 +359,13 @@ func (db *Database) retrieveState() {
   for response.Next() {
     var (
       positionID, symbol, assetClass, side, stratName, orderType string
+      takeProfitOrderID, stopLossOrderID string
       qty decimal.Decimal
       triggerPrice, filledAvgPrice, trailingStopBase float64
       priceTime, receivedTime, triggerTime, fillTime time.Time
       badForAnalysis, openOrderPending, closeOrderPending bool
       nCloseOrders int8
+      closeAttempts int
     )
 
     err = response.Scan(
@@ -315,12 +387,15 @@ func (db *Database) retrieveState() {
       &nCloseOrders,
       &openOrderPending,
       &closeOrderPending,
+      &takeProfitOrderID,
+      &stopLossOrderID,
+      &closeAttempts,
     )
     if err != nil {
       util.ErrorPanic(err)
     }
 
-    if !( slices.Contains(constant.CRYPTO_SYMBOLS, symbol) || slices.Contains(constant.STOCK_SYMBOLS, symbol) ) {
+    if !( slices.Contains(config.C.CryptoSymbols, symbol) || slices.Contains(config.C.StockSymbols, symbol) ) {
       util.ErrorPanic(errors.New("Symbol of retrieved position not in subscription list"))
     }
 
@@ -341,8 +416,11 @@ func (db *Database) retrieveState() {
       OpenFillTime: fillTime,
       OpenFilledAvgPrice: filledAvgPrice,
       CloseOrderPending: closeOrderPending,
+      CloseAttempts: closeAttempts,
       NCloseOrders: nCloseOrders,
       TrailingStopBase: trailingStopBase,
+      TakeProfitOrderID: takeProfitOrderID,
+      StopLossOrderID: stopLossOrderID,
     }
 
     db.assets[assetClass][symbol].Qty = db.assets[assetClass][symbol].Qty.Add(qty)
@@ -362,7 +440,10 @@ func (db *Database) saveState() {
     UPDATE positions SET
       open_order_pending = ?, 
       close_order_pending = ?,
-      trailing_stop = ?
+      trailing_stop = ?,
+      take_profit_order_id = ?,
+      stop_loss_order_id = ?,
+      close_attempts = ?
     WHERE symbol = ? AND strat_name = ?;
   `)
   if err != nil {
@@ -377,6 +458,9 @@ func (db *Database) saveState() {
           pos.OpenOrderPending, 
           pos.CloseOrderPending, 
           pos.TrailingStopBase, 
+          pos.TakeProfitOrderID,
+          pos.StopLossOrderID,
+          pos.CloseAttempts,
           pos.Symbol, pos.StratName,
         )
         if err != nil {
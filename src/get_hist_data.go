@@ -1,33 +1,45 @@
 package main
 
 import (
+  "maps"
+  "slices"
   "time"
   "fmt"
   "strings"
   "log"
   "github.com/valyala/fastjson"
-  "github.com/Kjellemann1/AlgoTrader-Go/constant"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
   "github.com/Kjellemann1/AlgoTrader-Go/request"
 )
 
-func urlHistBars(asset_class string, page_token string) string {
-  t := time.Now().UTC().AddDate(0, 0, -constant.HIST_DAYS).Format("2006-01-02T15:04:05Z")
+func urlHistBars(asset_class string, symbols []string, page_token string) string {
+  start := time.Now().UTC().AddDate(0, 0, -config.C.HistDays)
+  return urlBars(asset_class, symbols, "1Min", start, time.Time{}, page_token)
+}
+
+// Builds the url for bars of the given symbols and timeframe from start. A zero end
+// leaves the end of the range open.
+func urlBars(asset_class string, symbols []string, timeframe string, start time.Time, end time.Time, page_token string) string {
+  t := start.UTC().Format("2006-01-02T15:04:05Z")
   var url string
   switch asset_class {
     case "stock":
       url = fmt.Sprintf(
-        "https://data.alpaca.markets/v2/stocks/bars?symbols=%s" +
-        "&timeframe=1Min&start=%s&limit=%d&adjustment=all&feed=iex&",
-        strings.Join(constant.STOCK_SYMBOLS, "%2C"), t, constant.HIST_LIMIT,
+        "%s/v2/stocks/bars?symbols=%s" +
+        "&timeframe=%s&start=%s&limit=%d&adjustment=all&feed=iex&",
+        config.C.DataEndpoint, strings.Join(symbols, "%2C"), timeframe, t, config.C.HistLimit,
       )
     case "crypto":
       url = fmt.Sprintf(
-        "https://data.alpaca.markets/v1beta3/crypto/us/bars?symbols=%s" +
-        "&timeframe=1Min&start=%s&limit=%d&",
-        strings.Replace(strings.Join(constant.CRYPTO_SYMBOLS, "%2C"), "/", "%2F", len(constant.CRYPTO_SYMBOLS)), 
-        t, constant.HIST_LIMIT,
+        "%s/v1beta3/crypto/us/bars?symbols=%s" +
+        "&timeframe=%s&start=%s&limit=%d&",
+        config.C.DataEndpoint, strings.Replace(strings.Join(symbols, "%2C"), "/", "%2F", len(symbols)), 
+        timeframe, t, config.C.HistLimit,
       )
   }
+  if !end.IsZero() {
+    url += fmt.Sprintf("end=%s&", end.UTC().Format("2006-01-02T15:04:05Z"))
+  }
   if page_token != "start" {
     url += fmt.Sprintf("page_token=%s&", page_token)
   }
@@ -35,35 +47,37 @@ func urlHistBars(asset_class string, page_token string) string {
   return url
 }
 
-func makeRequest(asset_class string, page_token string) *fastjson.Value {
-  url := urlHistBars(asset_class, page_token)
-  body, err := request.GetReq(url)
-  if err != nil {
-    log.Fatal(err.Error())
+// Builds the url for the latest quote, trade and bars of the given symbols
+func urlSnapshots(asset_class string, symbols []string) string {
+  if asset_class == "crypto" {
+    return fmt.Sprintf("%s/v1beta3/crypto/us/snapshots?symbols=%s",
+      config.C.DataEndpoint, strings.Replace(strings.Join(symbols, "%2C"), "/", "%2F", len(symbols)),
+    )
   }
-  p := fastjson.Parser{}
-  parsed, err := p.ParseBytes(body)
-  if err != nil {
-    log.Fatal(err.Error())
-  }
-  return parsed
+  return fmt.Sprintf("%s/v2/stocks/snapshots?symbols=%s&feed=iex", config.C.DataEndpoint, strings.Join(symbols, "%2C"))
 }
 
-func fillRollingWindows (assets map[string]map[string]*Asset) {
-  for k, v := range assets {
-    getHistBars(v, k)
+func makeRequest(url string) (*fastjson.Value, error) {
+  body, err := request.GetReq(url)
+  if err != nil {
+    return nil, err
   }
+  p := fastjson.Parser{}
+  return p.ParseBytes(body)
 }
 
-func getHistBars(assets map[string]*Asset, asset_class string) {
+// Requests all pages and calls visit for every bar in time order per symbol
+func visitBars(url func(page_token string) string, visit func(symbol string, bar *fastjson.Value)) error {
   var arr []*fastjson.Value
   page_token := "start"
   for page_token != "" {
-    parsed := makeRequest(asset_class, page_token)
+    parsed, err := makeRequest(url(page_token))
+    if err != nil {
+      return err
+    }
     arr = append(arr, parsed.Get("bars"))
     page_token = string(parsed.GetStringBytes("next_page_token"))
   }
-  temp_time := time.Now().UTC()
   for _, bars := range arr {
     if bars == nil {
       log.Println("[ WARNING ]\tBars is nil")
@@ -71,34 +85,135 @@ func getHistBars(assets map[string]*Asset, asset_class string) {
     }
     obj, err := bars.Object()
     if err != nil {
-      log.Fatalf(
-        "[ ERROR ]\tFailed to get object in GetHistBars()\n" +
-        "  -> Error: %s\n",
-      err)
+      return fmt.Errorf("Failed to get bars object: %w", err)
     }
     obj.Visit(func(symbol []byte, value *fastjson.Value) {
-      x := value.GetArray()
-      for _, bar := range x {
-        t, _ := time.Parse("2006-01-02T15:04:05Z", string(bar.GetStringBytes("t")))
-        assets[string(symbol)].updateWindowOnBar(
-          bar.GetFloat64("o"),
-          bar.GetFloat64("h"),
-          bar.GetFloat64("l"),
-          bar.GetFloat64("c"),
-          t,
-          temp_time,
-        )
+      for _, bar := range value.GetArray() {
+        visit(string(symbol), bar)
       }
     })
   }
+  return nil
+}
+
+func fillRollingWindows (assets map[string]map[string]*Asset) {
+  for k, v := range assets {
+    if err := backfill(v, k); err != nil {
+      log.Fatalf("[ ERROR ]\tFailed to get historical bars\n  -> Error: %s\n", err)
+    }
+  }
+}
+
+// Fills the windows and frames of the assets from the market data API
+func backfill(assets map[string]*Asset, asset_class string) error {
+  if err := getHistBars(assets, asset_class); err != nil {
+    return err
+  }
+  return getHistFrames(assets, asset_class)
+}
+
+func getHistBars(assets map[string]*Asset, asset_class string) error {
+  temp_time := time.Now().UTC()
+  symbols := slices.Collect(maps.Keys(assets))
+  url := func(page_token string) string {
+    return urlHistBars(asset_class, symbols, page_token)
+  }
+  err := visitBars(url, func(symbol string, bar *fastjson.Value) {
+    t, _ := time.Parse("2006-01-02T15:04:05Z", string(bar.GetStringBytes("t")))
+    if asset_class == "stock" && !MarketCalendar.IsOpen(t) {
+      return
+    }
+    // Stamped with the end time like the live bars in onMarketBarUpdate
+    assets[symbol].updateWindowOnBar(
+      bar.GetFloat64("o"),
+      bar.GetFloat64("h"),
+      bar.GetFloat64("l"),
+      bar.GetFloat64("c"),
+      bar.GetFloat64("v"),
+      bar.GetFloat64("vw"),
+      bar.GetFloat64("n"),
+      t.Add(1 * time.Minute),
+      temp_time,
+    )
+  })
+  if err != nil {
+    return err
+  }
 
   checkForZeroVals(assets)
+  return nil
+}
+
+// Time back needed for a full window of bars. Stocks trade 6.5 of 24 hours on 5 of
+// 7 days, so intraday stock bars need about six times as long.
+func histLookback(asset_class string, size time.Duration) time.Duration {
+  lookback := size * time.Duration(config.C.WindowSize)
+  if asset_class == "stock" {
+    if size < oneDay {
+      lookback *= 6
+    } else {
+      lookback = lookback * 3 / 2
+    }
+  }
+  return lookback
+}
+
+// Longest time back that stock bars are loaded for, so that the calendar covers it
+func stockHistLookback() time.Duration {
+  lookback := time.Duration(config.C.HistDays) * oneDay
+  for _, s := range strategyRegistry {
+    if len(s.Classes) > 0 && !slices.Contains(s.Classes, "stock") {
+      continue
+    }
+    for _, size := range s.Timeframes {
+      lookback = max(lookback, histLookback("stock", size))
+    }
+  }
+  return lookback
+}
+
+// Preloads the higher timeframe windows with bars of the same timeframe. The last
+// bar may be in progress, in which case the live minute bars are added to it.
+func getHistFrames(assets map[string]*Asset, asset_class string) error {
+  symbols := make(map[time.Duration][]string)
+  for symbol, a := range assets {
+    // Replaces the bars aggregated from the minute bars of getHistBars, which would
+    // be followed by older bars
+    a.Rwm.Lock()
+    for size := range a.frames {
+      a.frames[size] = newFrame(size)
+      symbols[size] = append(symbols[size], symbol)
+    }
+    a.Rwm.Unlock()
+  }
+  for size, list := range symbols {
+    start := time.Now().UTC().Add(-histLookback(asset_class, size))
+    url := func(page_token string) string {
+      return urlBars(asset_class, list, apiTimeframe(size), start, time.Time{}, page_token)
+    }
+    err := visitBars(url, func(symbol string, bar *fastjson.Value) {
+      t, _ := time.Parse("2006-01-02T15:04:05Z", string(bar.GetStringBytes("t")))
+      // Intraday bars entirely outside the session
+      if asset_class == "stock" && size < oneDay && !MarketCalendar.IsOpen(t) && !MarketCalendar.IsOpen(t.Add(size - time.Minute)) {
+        return
+      }
+      a := assets[symbol]
+      f := a.frames[size]
+      a.Rwm.Lock()
+      f.load(bar.GetFloat64("o"), bar.GetFloat64("h"), bar.GetFloat64("l"), bar.GetFloat64("c"), bar.GetFloat64("v"), f.barStart(asset_class, t))
+      a.Rwm.Unlock()
+    })
+    if err != nil {
+      return err
+    }
+  }
+  return nil
 }
 
 func checkForZeroVals(assets map[string]*Asset) {
   // API returns zero in place of missing data
   for _, asset := range assets {
-    for i := range constant.WINDOW_SIZE {
+    for i := range config.C.WindowSize {
       if asset.O[i] == 0 || asset.H[i] == 0 || asset.L[i] == 0 || asset.C[i] == 0 {
         log.Println("[ ERROR ]\tZero values in window")
       }
@@ -0,0 +1,198 @@
+// Package indicator implements technical indicators that are updated incrementally,
+// one point at a time, instead of being recomputed over the whole window on every
+// update. Each indicator keeps the state before the latest point, so the latest
+// point can be replaced as often as needed, which is the case while the close of
+// the current minute is updated by trades.
+//
+// Values are NaN until the indicator has seen enough points.
+
+package indicator
+
+import (
+  "math"
+  "time"
+)
+
+type Bar struct {
+  Time  time.Time
+  O     float64
+  H     float64
+  L     float64
+  C     float64
+  V     float64
+}
+
+type Indicator interface {
+  // Adds a new point
+  Push(b Bar)
+  // Replaces the latest point. Same as Push if no point has been added.
+  Replace(b Bar)
+  // Value i points back, where 0 is the latest value
+  Value(i int) float64
+}
+
+// Fixed size history of values, where index 0 is the latest value.
+type Series struct {
+  values  []float64
+  head    int
+  n       int
+}
+
+func NewSeries(size int) *Series {
+  return &Series{values: make([]float64, size), head: size - 1}
+}
+
+func (s *Series) Push(v float64) {
+  s.head = (s.head + 1) % len(s.values)
+  s.values[s.head] = v
+  if s.n < len(s.values) {
+    s.n++
+  }
+}
+
+// Replaces the latest value
+func (s *Series) Set(v float64) {
+  if s.n == 0 {
+    s.Push(v)
+    return
+  }
+  s.values[s.head] = v
+}
+
+// Value i points back, or NaN if there is no such value
+func (s *Series) At(i int) float64 {
+  if i < 0 || i >= s.n {
+    return math.NaN()
+  }
+  return s.values[(s.head - i + len(s.values)) % len(s.values)]
+}
+
+// Number of values, at most the size of the series
+func (s *Series) Len() int {
+  return s.n
+}
+
+func (s *Series) Full() bool {
+  return s.n == len(s.values)
+}
+
+// Sum and sum of squares over the last n values. The sums are recomputed each time
+// the ring wraps around to avoid accumulating floating point errors.
+type rolling struct {
+  values  *Series
+  sum     float64
+  sum_sq  float64
+}
+
+func newRolling(n int) *rolling {
+  return &rolling{values: NewSeries(n)}
+}
+
+func (r *rolling) push(v float64) {
+  if r.values.Full() {
+    old := r.values.At(r.values.Len() - 1)
+    r.sum -= old
+    r.sum_sq -= old * old
+  }
+  r.values.Push(v)
+  r.sum += v
+  r.sum_sq += v * v
+  if r.values.head == 0 {
+    r.recompute()
+  }
+}
+
+func (r *rolling) replace(v float64) {
+  if r.values.Len() == 0 {
+    r.push(v)
+    return
+  }
+  old := r.values.At(0)
+  r.values.Set(v)
+  r.sum += v - old
+  r.sum_sq += v * v - old * old
+}
+
+func (r *rolling) recompute() {
+  r.sum, r.sum_sq = 0, 0
+  for i := range r.values.Len() {
+    v := r.values.At(i)
+    r.sum += v
+    r.sum_sq += v * v
+  }
+}
+
+func (r *rolling) mean() float64 {
+  if !r.values.Full() {
+    return math.NaN()
+  }
+  return r.sum / float64(r.values.Len())
+}
+
+// Population standard deviation
+func (r *rolling) std() float64 {
+  if !r.values.Full() {
+    return math.NaN()
+  }
+  n := float64(r.values.Len())
+  mean := r.sum / n
+  return math.Sqrt(math.Max(0, r.sum_sq / n - mean * mean))
+}
+
+// Highest or lowest of the last n values, kept with a monotonic deque of the
+// values before the latest one, so that pushing is amortized O(1) and the latest
+// value can be replaced without touching the deque.
+type extreme struct {
+  n       int
+  sign    float64  // 1 for the highest value, -1 for the lowest
+  count   int
+  latest  float64
+  older   []point  // Indexes increasing, values decreasing after sign
+}
+
+type point struct {
+  i  int
+  v  float64
+}
+
+func newMax(n int) *extreme {
+  return &extreme{n: n, sign: 1}
+}
+
+func newMin(n int) *extreme {
+  return &extreme{n: n, sign: -1}
+}
+
+func (e *extreme) push(v float64) {
+  if e.count > 0 {
+    // Values that are not above the previous latest can never be the extreme again
+    for len(e.older) > 0 && e.sign * e.older[len(e.older)-1].v <= e.sign * e.latest {
+      e.older = e.older[:len(e.older)-1]
+    }
+    e.older = append(e.older, point{e.count - 1, e.latest})
+  }
+  e.count++
+  e.latest = v
+  for len(e.older) > 0 && e.older[0].i <= e.count - 1 - e.n {
+    e.older = e.older[1:]
+  }
+}
+
+func (e *extreme) replace(v float64) {
+  if e.count == 0 {
+    e.push(v)
+    return
+  }
+  e.latest = v
+}
+
+// NaN until n values have been pushed
+func (e *extreme) value() float64 {
+  if e.count < e.n {
+    return math.NaN()
+  }
+  if len(e.older) > 0 && e.sign * e.older[0].v > e.sign * e.latest {
+    return e.older[0].v
+  }
+  return e.latest
+}
@@ -0,0 +1,294 @@
+package indicator
+
+import (
+  "math"
+  "math/rand"
+  "testing"
+  "time"
+  "github.com/stretchr/testify/assert"
+)
+
+const size = 100
+
+func randomBars(n int, seed int64) []Bar {
+  r := rand.New(rand.NewSource(seed))
+  bars := make([]Bar, n)
+  t := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+  c := 100.0
+  for i := range n {
+    o := c
+    c = o + r.NormFloat64()
+    h := math.Max(o, c) + r.Float64()
+    l := math.Min(o, c) - r.Float64()
+    bars[i] = Bar{Time: t, O: o, H: h, L: l, C: c, V: float64(r.Intn(1000))}
+    t = t.Add(time.Minute)
+  }
+  return bars
+}
+
+func closes(bars []Bar) []float64 {
+  c := make([]float64, len(bars))
+  for i, b := range bars {
+    c[i] = b.C
+  }
+  return c
+}
+
+func push(ind Indicator, bars []Bar) {
+  for _, b := range bars {
+    ind.Push(b)
+  }
+}
+
+// Values of the indicator from oldest to newest
+func values(f func(int) float64, n int) []float64 {
+  v := make([]float64, n)
+  for i := range n {
+    v[n - 1 - i] = f(i)
+  }
+  return v
+}
+
+func assertValues(t *testing.T, expected []float64, actual []float64) {
+  t.Helper()
+  assert.Equal(t, len(expected), len(actual))
+  for i := range expected {
+    if math.IsNaN(expected[i]) {
+      assert.True(t, math.IsNaN(actual[i]), "index %d: expected NaN, got %v", i, actual[i])
+    } else {
+      assert.InDelta(t, expected[i], actual[i], 1e-9, "index %d", i)
+    }
+  }
+}
+
+func TestSeries(t *testing.T) {
+  s := NewSeries(3)
+  assert.True(t, math.IsNaN(s.At(0)))
+  s.Set(1)
+  assert.Equal(t, 1, s.Len())
+  for _, v := range []float64{2, 3, 4} {
+    s.Push(v)
+  }
+  assert.Equal(t, 3, s.Len())
+  assert.Equal(t, 4.0, s.At(0))
+  assert.Equal(t, 2.0, s.At(2))
+  assert.True(t, math.IsNaN(s.At(3)))
+  s.Set(5)
+  assert.Equal(t, 5.0, s.At(0))
+  assert.Equal(t, 3.0, s.At(1))
+}
+
+func TestSMA(t *testing.T) {
+  bars := randomBars(size, 1)
+  c := closes(bars)
+  sma := NewSMA(10, size)
+  push(sma, bars)
+
+  expected := make([]float64, size)
+  for i := range size {
+    if i < 9 {
+      expected[i] = math.NaN()
+      continue
+    }
+    sum := 0.0
+    for _, v := range c[i - 9:i + 1] {
+      sum += v
+    }
+    expected[i] = sum / 10
+  }
+  assertValues(t, expected, values(sma.Value, size))
+}
+
+func TestEMA(t *testing.T) {
+  ema := NewEMA(3, 10)
+  for _, c := range []float64{2, 4, 6, 8} {
+    ema.Push(Bar{C: c})
+  }
+  assertValues(t, []float64{math.NaN(), math.NaN(), 4, 6}, values(ema.Value, 4))
+}
+
+func TestRSI(t *testing.T) {
+  rsi := NewRSI(3, 10)
+  for _, c := range []float64{1, 2, 3, 4, 5} {
+    rsi.Push(Bar{C: c})
+  }
+  assertValues(t, []float64{math.NaN(), math.NaN(), math.NaN(), 100, 100}, values(rsi.Value, 5))
+
+  rsi = NewRSI(2, 10)
+  for _, c := range []float64{10, 12, 11, 10} {
+    rsi.Push(Bar{C: c})
+  }
+  // Averages after the first two changes are gain 1 and loss 0.5, then
+  // gain (1 + 0) / 2 = 0.5 and loss (0.5 + 1) / 2 = 0.75
+  assert.InDelta(t, 100 - 100 / (1 + 1 / 0.5), rsi.Value(1), 1e-9)
+  assert.InDelta(t, 100 - 100 / (1 + 0.5 / 0.75), rsi.Value(0), 1e-9)
+}
+
+func TestMACD(t *testing.T) {
+  bars := randomBars(size, 2)
+  macd := NewMACD(3, 6, 4, size)
+  fast := NewEMA(3, size)
+  slow := NewEMA(6, size)
+  push(macd, bars)
+  push(fast, bars)
+  push(slow, bars)
+
+  for i := range size - 5 {
+    assert.InDelta(t, fast.Value(i) - slow.Value(i), macd.Value(i), 1e-9)
+  }
+  assert.True(t, math.IsNaN(macd.Value(size - 5)))
+  // The signal line needs 4 points of the MACD line
+  assert.False(t, math.IsNaN(macd.Signal(size - 9)))
+  assert.True(t, math.IsNaN(macd.Signal(size - 8)))
+  assert.InDelta(t, macd.Value(0) - macd.Signal(0), macd.Hist(0), 1e-9)
+}
+
+func TestBollinger(t *testing.T) {
+  bb := NewBollinger(4, 2, 10)
+  for _, c := range []float64{1, 2, 3, 4, 5} {
+    bb.Push(Bar{C: c})
+  }
+  std := math.Sqrt(1.25)
+  assert.InDelta(t, 3.5, bb.Value(0), 1e-9)
+  assert.InDelta(t, 3.5 + 2 * std, bb.Upper(0), 1e-9)
+  assert.InDelta(t, 3.5 - 2 * std, bb.Lower(0), 1e-9)
+  assert.True(t, math.IsNaN(bb.Upper(2)))
+}
+
+func TestATR(t *testing.T) {
+  atr := NewATR(2, 10)
+  atr.Push(Bar{H: 11, L: 9, C: 10})
+  atr.Push(Bar{H: 13, L: 11, C: 12})  // TR 3
+  atr.Push(Bar{H: 12, L: 11, C: 11})  // TR 1
+  assert.True(t, math.IsNaN(atr.Value(2)))
+  assert.InDelta(t, 2.5, atr.Value(1), 1e-9)
+  assert.InDelta(t, 1.75, atr.Value(0), 1e-9)
+}
+
+func TestStochastic(t *testing.T) {
+  st := NewStochastic(3, 2, 10)
+  st.Push(Bar{H: 10, L: 8, C: 9})
+  st.Push(Bar{H: 12, L: 9, C: 11})
+  st.Push(Bar{H: 11, L: 10, C: 10})
+  st.Push(Bar{H: 14, L: 10, C: 14})
+  assert.True(t, math.IsNaN(st.Value(2)))
+  assert.InDelta(t, 50, st.Value(1), 1e-9)
+  assert.InDelta(t, 100, st.Value(0), 1e-9)
+  assert.True(t, math.IsNaN(st.D(1)))
+  assert.InDelta(t, 75, st.D(0), 1e-9)
+}
+
+func TestVWAP(t *testing.T) {
+  day := time.Date(2024, 1, 1, 23, 59, 0, 0, time.UTC)
+  vwap := NewVWAP(10)
+  vwap.Push(Bar{Time: day, H: 10, L: 10, C: 10})
+  assert.True(t, math.IsNaN(vwap.Value(0)))
+  vwap.Push(Bar{Time: day, H: 12, L: 6, C: 9, V: 1})
+  vwap.Push(Bar{Time: day, H: 13, L: 11, C: 12, V: 3})
+  assert.InDelta(t, (9 + 12 * 3) / 4.0, vwap.Value(0), 1e-9)
+  // Resets at the start of a new day
+  vwap.Push(Bar{Time: day.Add(time.Minute), H: 20, L: 20, C: 20, V: 1})
+  assert.InDelta(t, 20, vwap.Value(0), 1e-9)
+}
+
+func TestADX(t *testing.T) {
+  bars := make([]Bar, 30)
+  for i := range bars {
+    p := float64(i)
+    bars[i] = Bar{O: p, H: p + 1, L: p - 0.5, C: p + 0.5}
+  }
+  adx := NewADX(5, 30)
+  push(adx, bars)
+  assert.True(t, math.IsNaN(adx.Value(30 - 9)))
+  assert.False(t, math.IsNaN(adx.Value(30 - 10)))
+  // Steady uptrend without downward movement
+  assert.InDelta(t, 100, adx.Value(0), 1e-9)
+}
+
+// Replacing a provisional point any number of times must give the same result as
+// pushing the final point directly.
+func TestReplace(t *testing.T) {
+  type indicator struct {
+    name    string
+    create  func() Indicator
+    extra   func(Indicator) []func(int) float64
+  }
+  indicators := []indicator{
+    {"sma", func() Indicator { return NewSMA(7, size) }, nil},
+    {"ema", func() Indicator { return NewEMA(7, size) }, nil},
+    {"rsi", func() Indicator { return NewRSI(7, size) }, nil},
+    {"macd", func() Indicator { return NewMACD(4, 9, 3, size) }, func(i Indicator) []func(int) float64 {
+      return []func(int) float64{i.(*MACD).Signal}
+    }},
+    {"bollinger", func() Indicator { return NewBollinger(7, 2, size) }, func(i Indicator) []func(int) float64 {
+      return []func(int) float64{i.(*Bollinger).Upper, i.(*Bollinger).Lower}
+    }},
+    {"atr", func() Indicator { return NewATR(7, size) }, nil},
+    {"stochastic", func() Indicator { return NewStochastic(7, 3, size) }, func(i Indicator) []func(int) float64 {
+      return []func(int) float64{i.(*Stochastic).D}
+    }},
+    {"vwap", func() Indicator { return NewVWAP(size) }, nil},
+    {"adx", func() Indicator { return NewADX(7, size) }, nil},
+  }
+
+  bars := randomBars(size * 2, 3)
+  r := rand.New(rand.NewSource(4))
+
+  for _, ind := range indicators {
+    t.Run(ind.name, func(t *testing.T) {
+      pushed := ind.create()
+      replaced := ind.create()
+      for _, b := range bars {
+        pushed.Push(b)
+        provisional := Bar{Time: b.Time, O: b.O, H: b.O, L: b.O, C: b.O}
+        replaced.Push(provisional)
+        for range r.Intn(3) {
+          provisional.C = b.L + r.Float64() * (b.H - b.L)
+          provisional.H = math.Max(provisional.H, provisional.C)
+          provisional.L = math.Min(provisional.L, provisional.C)
+          provisional.V += 1
+          replaced.Replace(provisional)
+        }
+        replaced.Replace(b)
+      }
+      assertValues(t, values(pushed.Value, size), values(replaced.Value, size))
+      if ind.extra != nil {
+        p, r := ind.extra(pushed), ind.extra(replaced)
+        for i := range p {
+          assertValues(t, values(p[i], size), values(r[i], size))
+        }
+      }
+    })
+  }
+}
+
+func TestExtreme(t *testing.T) {
+  r := rand.New(rand.NewSource(5))
+  n := 5
+  high, low := newMax(n), newMin(n)
+  var window []float64
+  for i := range 500 {
+    v := float64(r.Intn(20))
+    if i > 0 && r.Intn(3) == 0 {
+      window[len(window)-1] = v
+      high.replace(v)
+      low.replace(v)
+    } else {
+      window = append(window, v)
+      high.push(v)
+      low.push(v)
+    }
+    if len(window) < n {
+      assert.True(t, math.IsNaN(high.value()))
+      assert.True(t, math.IsNaN(low.value()))
+      continue
+    }
+    last := window[len(window)-n:]
+    want_high, want_low := last[0], last[0]
+    for _, w := range last {
+      want_high, want_low = math.Max(want_high, w), math.Min(want_low, w)
+    }
+    assert.Equal(t, want_high, high.value(), "High at %d", i)
+    assert.Equal(t, want_low, low.value(), "Low at %d", i)
+  }
+}
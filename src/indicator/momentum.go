@@ -0,0 +1,227 @@
+package indicator
+
+import (
+  "math"
+)
+
+type rsiState struct {
+  count      int
+  prev_close float64
+  avg_gain   float64
+  avg_loss   float64
+}
+
+func (s rsiState) step(n int, c float64) rsiState {
+  s.count++
+  prev_close := s.prev_close
+  s.prev_close = c
+  if s.count == 1 {
+    return s
+  }
+
+  change := c - prev_close
+  gain := math.Max(change, 0)
+  loss := math.Max(-change, 0)
+  if s.count <= n + 1 {
+    // Sum of the first n changes, averaged once complete
+    s.avg_gain += gain
+    s.avg_loss += loss
+    if s.count == n + 1 {
+      s.avg_gain /= float64(n)
+      s.avg_loss /= float64(n)
+    }
+  } else {
+    s.avg_gain = (s.avg_gain * float64(n - 1) + gain) / float64(n)
+    s.avg_loss = (s.avg_loss * float64(n - 1) + loss) / float64(n)
+  }
+  return s
+}
+
+func (s rsiState) value(n int) float64 {
+  if s.count < n + 1 {
+    return math.NaN()
+  }
+  if s.avg_loss == 0 {
+    if s.avg_gain == 0 {
+      return 50
+    }
+    return 100
+  }
+  return 100 - 100 / (1 + s.avg_gain / s.avg_loss)
+}
+
+// Relative strength index (Wilder) of the close
+type RSI struct {
+  n     int
+  prev  rsiState
+  cur   rsiState
+  out   *Series
+}
+
+func NewRSI(n, size int) *RSI {
+  return &RSI{n: n, out: NewSeries(size)}
+}
+
+func (r *RSI) Push(b Bar) {
+  r.prev = r.cur
+  r.cur = r.prev.step(r.n, b.C)
+  r.out.Push(r.cur.value(r.n))
+}
+
+func (r *RSI) Replace(b Bar) {
+  if r.out.Len() == 0 {
+    r.Push(b)
+    return
+  }
+  r.cur = r.prev.step(r.n, b.C)
+  r.out.Set(r.cur.value(r.n))
+}
+
+func (r *RSI) Value(i int) float64 {
+  return r.out.At(i)
+}
+
+type macdState struct {
+  fast    emaState
+  slow    emaState
+  signal  emaState
+}
+
+func (s macdState) step(fast, slow, signal int, c float64) macdState {
+  s.fast = s.fast.step(fast, c)
+  s.slow = s.slow.step(slow, c)
+  if s.slow.count >= slow {
+    s.signal = s.signal.step(signal, s.fast.value - s.slow.value)
+  }
+  return s
+}
+
+// Moving average convergence divergence of the close. Value is the MACD line.
+type MACD struct {
+  fast    int
+  slow    int
+  signal  int
+  prev    macdState
+  cur     macdState
+  line    *Series
+  sig     *Series
+}
+
+func NewMACD(fast, slow, signal, size int) *MACD {
+  return &MACD{
+    fast: fast,
+    slow: slow,
+    signal: signal,
+    line: NewSeries(size),
+    sig: NewSeries(size),
+  }
+}
+
+func (m *MACD) values() (float64, float64) {
+  if m.cur.slow.count < m.slow {
+    return math.NaN(), math.NaN()
+  }
+  return m.cur.fast.value - m.cur.slow.value, m.cur.signal.value
+}
+
+func (m *MACD) Push(b Bar) {
+  m.prev = m.cur
+  m.cur = m.prev.step(m.fast, m.slow, m.signal, b.C)
+  line, sig := m.values()
+  m.line.Push(line)
+  m.sig.Push(sig)
+}
+
+func (m *MACD) Replace(b Bar) {
+  if m.line.Len() == 0 {
+    m.Push(b)
+    return
+  }
+  m.cur = m.prev.step(m.fast, m.slow, m.signal, b.C)
+  line, sig := m.values()
+  m.line.Set(line)
+  m.sig.Set(sig)
+}
+
+func (m *MACD) Value(i int) float64 {
+  return m.line.At(i)
+}
+
+func (m *MACD) Signal(i int) float64 {
+  return m.sig.At(i)
+}
+
+// MACD line minus signal line
+func (m *MACD) Hist(i int) float64 {
+  return m.line.At(i) - m.sig.At(i)
+}
+
+// Stochastic oscillator. Value is %K over the last k points, D is the simple
+// average of %K over the last d points.
+type Stochastic struct {
+  k       int
+  count   int
+  highs   *extreme
+  lows    *extreme
+  d_roll  *rolling
+  k_out   *Series
+  d_out   *Series
+}
+
+func NewStochastic(k, d, size int) *Stochastic {
+  return &Stochastic{
+    k: k,
+    highs: newMax(k),
+    lows: newMin(k),
+    d_roll: newRolling(d),
+    k_out: NewSeries(size),
+    d_out: NewSeries(size),
+  }
+}
+
+func (s *Stochastic) percentK(c float64) float64 {
+  high := s.highs.value()
+  low := s.lows.value()
+  if math.IsNaN(high) || math.IsNaN(low) {
+    return math.NaN()
+  }
+  if high == low {
+    return 50
+  }
+  return 100 * (c - low) / (high - low)
+}
+
+func (s *Stochastic) Push(b Bar) {
+  s.count++
+  s.highs.push(b.H)
+  s.lows.push(b.L)
+  k := s.percentK(b.C)
+  if s.count >= s.k {
+    s.d_roll.push(k)
+  }
+  s.k_out.Push(k)
+  s.d_out.Push(s.d_roll.mean())
+}
+
+func (s *Stochastic) Replace(b Bar) {
+  if s.count == 0 {
+    s.Push(b)
+    return
+  }
+  s.highs.replace(b.H)
+  s.lows.replace(b.L)
+  k := s.percentK(b.C)
+  if s.count >= s.k {
+    s.d_roll.replace(k)
+  }
+  s.k_out.Set(k)
+  s.d_out.Set(s.d_roll.mean())
+}
+
+func (s *Stochastic) Value(i int) float64 {
+  return s.k_out.At(i)
+}
+
+func (s *Stochastic) D(i int) float64 {
+  return s.d_out.At(i)
+}
@@ -0,0 +1,193 @@
+package indicator
+
+import (
+  "math"
+)
+
+// Simple moving average of the close
+type SMA struct {
+  roll  *rolling
+  out   *Series
+}
+
+func NewSMA(n, size int) *SMA {
+  return &SMA{roll: newRolling(n), out: NewSeries(size)}
+}
+
+func (s *SMA) Push(b Bar) {
+  s.roll.push(b.C)
+  s.out.Push(s.roll.mean())
+}
+
+func (s *SMA) Replace(b Bar) {
+  if s.out.Len() == 0 {
+    s.Push(b)
+    return
+  }
+  s.roll.replace(b.C)
+  s.out.Set(s.roll.mean())
+}
+
+func (s *SMA) Value(i int) float64 {
+  return s.out.At(i)
+}
+
+// State of an exponential moving average seeded with the simple average of the
+// first n values. Shared by EMA and MACD.
+type emaState struct {
+  count  int
+  sum    float64
+  value  float64
+}
+
+func (s emaState) step(n int, v float64) emaState {
+  s.count++
+  if s.count < n {
+    s.sum += v
+    s.value = math.NaN()
+  } else if s.count == n {
+    s.sum += v
+    s.value = s.sum / float64(n)
+  } else {
+    k := 2 / float64(n + 1)
+    s.value += k * (v - s.value)
+  }
+  return s
+}
+
+// Exponential moving average of the close
+type EMA struct {
+  n     int
+  prev  emaState
+  cur   emaState
+  out   *Series
+}
+
+func NewEMA(n, size int) *EMA {
+  return &EMA{n: n, out: NewSeries(size)}
+}
+
+func (e *EMA) Push(b Bar) {
+  e.prev = e.cur
+  e.cur = e.prev.step(e.n, b.C)
+  e.out.Push(e.cur.value)
+}
+
+func (e *EMA) Replace(b Bar) {
+  if e.out.Len() == 0 {
+    e.Push(b)
+    return
+  }
+  e.cur = e.prev.step(e.n, b.C)
+  e.out.Set(e.cur.value)
+}
+
+func (e *EMA) Value(i int) float64 {
+  return e.out.At(i)
+}
+
+type adxState struct {
+  count   int
+  prev    Bar
+  tr      float64  // Wilder smoothed true range and directional movement
+  plus    float64
+  minus   float64
+  n_dx    int
+  sum_dx  float64
+  adx     float64
+}
+
+func (s adxState) step(n int, b Bar) adxState {
+  s.count++
+  prev := s.prev
+  s.prev = b
+  if s.count == 1 {
+    return s
+  }
+
+  up := b.H - prev.H
+  down := prev.L - b.L
+  var plus, minus float64
+  if up > down && up > 0 {
+    plus = up
+  }
+  if down > up && down > 0 {
+    minus = down
+  }
+  tr := trueRange(b, prev.C)
+
+  if s.count <= n + 1 {
+    s.tr += tr
+    s.plus += plus
+    s.minus += minus
+    if s.count < n + 1 {
+      return s
+    }
+  } else {
+    s.tr += tr - s.tr / float64(n)
+    s.plus += plus - s.plus / float64(n)
+    s.minus += minus - s.minus / float64(n)
+  }
+
+  var dx float64
+  if s.tr > 0 {
+    plus_di := 100 * s.plus / s.tr
+    minus_di := 100 * s.minus / s.tr
+    if plus_di + minus_di > 0 {
+      dx = 100 * math.Abs(plus_di - minus_di) / (plus_di + minus_di)
+    }
+  }
+
+  s.n_dx++
+  if s.n_dx < n {
+    s.sum_dx += dx
+  } else if s.n_dx == n {
+    s.sum_dx += dx
+    s.adx = s.sum_dx / float64(n)
+  } else {
+    s.adx = (s.adx * float64(n - 1) + dx) / float64(n)
+  }
+  return s
+}
+
+func (s adxState) value(n int) float64 {
+  if s.n_dx < n {
+    return math.NaN()
+  }
+  return s.adx
+}
+
+// Average directional index (Wilder). Needs 2n points before the first value.
+type ADX struct {
+  n     int
+  prev  adxState
+  cur   adxState
+  out   *Series
+}
+
+func NewADX(n, size int) *ADX {
+  return &ADX{n: n, out: NewSeries(size)}
+}
+
+func (a *ADX) Push(b Bar) {
+  a.prev = a.cur
+  a.cur = a.prev.step(a.n, b)
+  a.out.Push(a.cur.value(a.n))
+}
+
+func (a *ADX) Replace(b Bar) {
+  if a.out.Len() == 0 {
+    a.Push(b)
+    return
+  }
+  a.cur = a.prev.step(a.n, b)
+  a.out.Set(a.cur.value(a.n))
+}
+
+func (a *ADX) Value(i int) float64 {
+  return a.out.At(i)
+}
+
+func trueRange(b Bar, prev_close float64) float64 {
+  return math.Max(b.H - b.L, math.Max(math.Abs(b.H - prev_close), math.Abs(b.L - prev_close)))
+}
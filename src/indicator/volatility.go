@@ -0,0 +1,123 @@
+package indicator
+
+import (
+  "math"
+)
+
+// Bollinger bands of the close. Value is the middle band.
+type Bollinger struct {
+  k       float64
+  roll    *rolling
+  middle  *Series
+  upper   *Series
+  lower   *Series
+}
+
+func NewBollinger(n int, k float64, size int) *Bollinger {
+  return &Bollinger{
+    k: k,
+    roll: newRolling(n),
+    middle: NewSeries(size),
+    upper: NewSeries(size),
+    lower: NewSeries(size),
+  }
+}
+
+func (b *Bollinger) bands() (float64, float64, float64) {
+  mean := b.roll.mean()
+  std := b.roll.std()
+  return mean, mean + b.k * std, mean - b.k * std
+}
+
+func (b *Bollinger) Push(bar Bar) {
+  b.roll.push(bar.C)
+  middle, upper, lower := b.bands()
+  b.middle.Push(middle)
+  b.upper.Push(upper)
+  b.lower.Push(lower)
+}
+
+func (b *Bollinger) Replace(bar Bar) {
+  if b.middle.Len() == 0 {
+    b.Push(bar)
+    return
+  }
+  b.roll.replace(bar.C)
+  middle, upper, lower := b.bands()
+  b.middle.Set(middle)
+  b.upper.Set(upper)
+  b.lower.Set(lower)
+}
+
+func (b *Bollinger) Value(i int) float64 {
+  return b.middle.At(i)
+}
+
+func (b *Bollinger) Upper(i int) float64 {
+  return b.upper.At(i)
+}
+
+func (b *Bollinger) Lower(i int) float64 {
+  return b.lower.At(i)
+}
+
+type atrState struct {
+  count       int
+  prev_close  float64
+  atr         float64
+}
+
+func (s atrState) step(n int, b Bar) atrState {
+  s.count++
+  tr := b.H - b.L
+  if s.count > 1 {
+    tr = trueRange(b, s.prev_close)
+  }
+  s.prev_close = b.C
+  if s.count < n {
+    s.atr += tr
+  } else if s.count == n {
+    s.atr = (s.atr + tr) / float64(n)
+  } else {
+    s.atr = (s.atr * float64(n - 1) + tr) / float64(n)
+  }
+  return s
+}
+
+func (s atrState) value(n int) float64 {
+  if s.count < n {
+    return math.NaN()
+  }
+  return s.atr
+}
+
+// Average true range (Wilder). The first point uses high minus low as true range.
+type ATR struct {
+  n     int
+  prev  atrState
+  cur   atrState
+  out   *Series
+}
+
+func NewATR(n, size int) *ATR {
+  return &ATR{n: n, out: NewSeries(size)}
+}
+
+func (a *ATR) Push(b Bar) {
+  a.prev = a.cur
+  a.cur = a.prev.step(a.n, b)
+  a.out.Push(a.cur.value(a.n))
+}
+
+func (a *ATR) Replace(b Bar) {
+  if a.out.Len() == 0 {
+    a.Push(b)
+    return
+  }
+  a.cur = a.prev.step(a.n, b)
+  a.out.Set(a.cur.value(a.n))
+}
+
+func (a *ATR) Value(i int) float64 {
+  return a.out.At(i)
+}
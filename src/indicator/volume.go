@@ -0,0 +1,61 @@
+package indicator
+
+import (
+  "math"
+  "time"
+)
+
+type vwapState struct {
+  day   time.Time
+  pv    float64
+  v     float64
+}
+
+func (s vwapState) step(b Bar) vwapState {
+  day := b.Time.UTC().Truncate(24 * time.Hour)
+  if !day.Equal(s.day) {
+    s = vwapState{day: day}
+  }
+  typical := (b.H + b.L + b.C) / 3
+  s.pv += typical * b.V
+  s.v += b.V
+  return s
+}
+
+func (s vwapState) value() float64 {
+  if s.v == 0 {
+    return math.NaN()
+  }
+  return s.pv / s.v
+}
+
+// Volume weighted average of the typical price, reset at the start of each UTC day.
+// NaN until volume has been traded during the day.
+type VWAP struct {
+  prev  vwapState
+  cur   vwapState
+  out   *Series
+}
+
+func NewVWAP(size int) *VWAP {
+  return &VWAP{out: NewSeries(size)}
+}
+
+func (v *VWAP) Push(b Bar) {
+  v.prev = v.cur
+  v.cur = v.prev.step(b)
+  v.out.Push(v.cur.value())
+}
+
+func (v *VWAP) Replace(b Bar) {
+  if v.out.Len() == 0 {
+    v.Push(b)
+    return
+  }
+  v.cur = v.prev.step(b)
+  v.out.Set(v.cur.value())
+}
+
+func (v *VWAP) Value(i int) float64 {
+  return v.out.At(i)
+}
@@ -0,0 +1,159 @@
+// Technical indicators for use in strategy functions. An indicator is created the
+// first time a strategy asks for it, warmed up from the rolling windows, and from
+// then on updated incrementally together with the windows.
+//
+//   rsi := a.rsi(14)
+//   if rsi.Value(0) < 30 && rsi.Value(1) >= 30 { ... }
+
+package main
+
+import (
+  "fmt"
+  "time"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/indicator"
+)
+
+// Latest point of the windows as a bar. Only valid when the last close is not a trade.
+func (a *Asset) lastBar(t time.Time) indicator.Bar {
+  return indicator.Bar{
+    Time: t,
+    O: a.O[config.C.WindowSize-1],
+    H: a.H[config.C.WindowSize-1],
+    L: a.L[config.C.WindowSize-1],
+    C: a.C[config.C.WindowSize-1],
+    V: a.V[config.C.WindowSize-1],
+  }
+}
+
+// Adds the latest point to all indicators, or replaces the latest point if it
+// was a provisional point from a trade.
+func (a *Asset) updateIndicators(replace bool, b indicator.Bar) {
+  for _, ind := range a.indicators {
+    if replace {
+      ind.Replace(b)
+    } else {
+      ind.Push(b)
+    }
+  }
+}
+
+// Trades update a provisional bar for the current minute, which is replaced by the
+// bar when it arrives.
+func (a *Asset) updateIndicatorsOnTrade(c float64, size float64, t time.Time) {
+  if a.lastCloseIsTrade {
+    a.tradeBar.H = max(a.tradeBar.H, c)
+    a.tradeBar.L = min(a.tradeBar.L, c)
+    a.tradeBar.C = c
+    a.tradeBar.V += size
+  } else {
+    a.tradeBar = indicator.Bar{Time: t, O: c, H: c, L: c, C: c, V: size}
+  }
+  a.updateIndicators(a.lastCloseIsTrade, a.tradeBar)
+}
+
+// Feeds the points already in the windows to a new indicator. The windows have no
+// timestamps, so each point is assumed to be one minute after the previous.
+func (a *Asset) warmUp(ind indicator.Indicator) {
+  last := config.C.WindowSize - 1
+  offset := 0
+  if a.lastCloseIsTrade {
+    // The close of each completed bar is one step to the left of its open, high and low
+    offset = 1
+  }
+  end := a.Time.Truncate(time.Minute).Add(-time.Duration(offset) * time.Minute)
+
+  started := false
+  for i := offset; i <= last; i++ {
+    c := a.C[i - offset]
+    if !started && c == 0 {
+      continue
+    }
+    started = true
+    ind.Push(indicator.Bar{
+      Time: end.Add(-time.Duration(last - i) * time.Minute),
+      O: a.O[i],
+      H: a.H[i],
+      L: a.L[i],
+      C: c,
+      V: a.V[i - offset],
+    })
+  }
+  if a.lastCloseIsTrade {
+    ind.Push(a.tradeBar)
+  }
+}
+
+// Returns the indicator registered under key, creating and warming it up if needed.
+func (a *Asset) indicator(key string, create func() indicator.Indicator) indicator.Indicator {
+  a.Rwm.RLock()
+  ind, ok := a.indicators[key]
+  a.Rwm.RUnlock()
+  if ok {
+    return ind
+  }
+
+  a.Rwm.Lock()
+  defer a.Rwm.Unlock()
+  if ind, ok := a.indicators[key]; ok {
+    return ind
+  }
+  ind = create()
+  a.warmUp(ind)
+  a.indicators[key] = ind
+  return ind
+}
+
+func (a *Asset) sma(n int) *indicator.SMA {
+  return a.indicator(fmt.Sprintf("sma_%d", n), func() indicator.Indicator {
+    return indicator.NewSMA(n, config.C.WindowSize)
+  }).(*indicator.SMA)
+}
+
+func (a *Asset) ema(n int) *indicator.EMA {
+  return a.indicator(fmt.Sprintf("ema_%d", n), func() indicator.Indicator {
+    return indicator.NewEMA(n, config.C.WindowSize)
+  }).(*indicator.EMA)
+}
+
+func (a *Asset) rsi(n int) *indicator.RSI {
+  return a.indicator(fmt.Sprintf("rsi_%d", n), func() indicator.Indicator {
+    return indicator.NewRSI(n, config.C.WindowSize)
+  }).(*indicator.RSI)
+}
+
+func (a *Asset) macd(fast, slow, signal int) *indicator.MACD {
+  return a.indicator(fmt.Sprintf("macd_%d_%d_%d", fast, slow, signal), func() indicator.Indicator {
+    return indicator.NewMACD(fast, slow, signal, config.C.WindowSize)
+  }).(*indicator.MACD)
+}
+
+func (a *Asset) bollinger(n int, k float64) *indicator.Bollinger {
+  return a.indicator(fmt.Sprintf("bollinger_%d_%g", n, k), func() indicator.Indicator {
+    return indicator.NewBollinger(n, k, config.C.WindowSize)
+  }).(*indicator.Bollinger)
+}
+
+func (a *Asset) atr(n int) *indicator.ATR {
+  return a.indicator(fmt.Sprintf("atr_%d", n), func() indicator.Indicator {
+    return indicator.NewATR(n, config.C.WindowSize)
+  }).(*indicator.ATR)
+}
+
+func (a *Asset) stochastic(k, d int) *indicator.Stochastic {
+  return a.indicator(fmt.Sprintf("stochastic_%d_%d", k, d), func() indicator.Indicator {
+    return indicator.NewStochastic(k, d, config.C.WindowSize)
+  }).(*indicator.Stochastic)
+}
+
+func (a *Asset) vwap() *indicator.VWAP {
+  return a.indicator("vwap", func() indicator.Indicator {
+    return indicator.NewVWAP(config.C.WindowSize)
+  }).(*indicator.VWAP)
+}
+
+func (a *Asset) adx(n int) *indicator.ADX {
+  return a.indicator(fmt.Sprintf("adx_%d", n), func() indicator.Indicator {
+    return indicator.NewADX(n, config.C.WindowSize)
+  }).(*indicator.ADX)
+}
@@ -3,11 +3,14 @@ package main
 import (
   "log"
   "os"
-  "time"
-  "io"
+  "log/slog"
   "net/http"
   "github.com/joho/godotenv"
   "github.com/Kjellemann1/AlgoTrader-Go/constant"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+  "github.com/Kjellemann1/AlgoTrader-Go/logging"
+  "github.com/Kjellemann1/AlgoTrader-Go/push"
 )
 
 func init() {
@@ -17,29 +20,99 @@ func init() {
   }
 }
 
+// Loads the config file named by AlgoConfig, or config.yaml by default. Runs after
+// the .env file is loaded so that it can hold config overrides as well.
 func init() {
-  name := os.Getenv("LogPath") + time.Now().In(time.UTC).Format(time.DateTime) + ".log"
-  logfile, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+  path := os.Getenv("AlgoConfig")
+  if path == "" {
+    path = "config.yaml"
+  }
+  c, err := config.Load(path)
   if err != nil {
     log.Panicln(err)
   }
-  multiWriter := io.MultiWriter(os.Stdout, logfile)
-  log.SetOutput(multiWriter)
+  config.C = c
+  request.HttpClient.Timeout = config.C.HTTPTimeout
+}
+
+// Component of each source file, for the component log levels
+var logComponents = map[string]string{
+  "market.go": "market",
+  "journal.go": "market",
+  "quote.go": "market",
+  "orderbook.go": "market",
+  "get_hist_data.go": "market",
+  "account.go": "account",
+  "database.go": "database",
+  "asset.go": "strategy",
+  "strategy.go": "strategy",
+  "strats.go": "strategy",
+  "position.go": "strategy",
+  "indicators.go": "strategy",
+  "timeframe.go": "strategy",
+  "sizing.go": "strategy",
+  "risk.go": "strategy",
+}
+
+// Sets up logging once the config is loaded. Levels are validated by the config.
+func init() {
+  level, _ := parseLogLevel(config.C.LogLevel)
+  levels := map[string]slog.Level{}
+  for component, text := range map[string]string{
+    "market": config.C.LogLevelMarket,
+    "account": config.C.LogLevelAccount,
+    "database": config.C.LogLevelDatabase,
+    "strategy": config.C.LogLevelStrategy,
+  } {
+    if text != "" {
+      levels[component], _ = parseLogLevel(text)
+    }
+  }
+  _, err := logging.Setup(logging.Options{
+    Format: config.C.LogFormat,
+    Level: level,
+    Levels: levels,
+    Components: logComponents,
+    File: os.Getenv("LogPath"),
+    MaxSize: int64(config.C.LogMaxSizeMB) << 20,
+    Daily: config.C.LogRotateDaily,
+  }, os.Stdout)
+  if err != nil {
+    log.Panicln(err)
+  }
+}
+
+func parseLogLevel(text string) (slog.Level, error) {
+  var level slog.Level
+  err := level.UnmarshalText([]byte(text))
+  return level, err
 }
 
 func init() {
   constant.PUSH_TOKEN = os.Getenv("PushoverToken")
   constant.PUSH_USER = os.Getenv("PushoverUser")
+  constant.SLACK_WEBHOOK = os.Getenv("SlackWebhook")
+  constant.DISCORD_WEBHOOK = os.Getenv("DiscordWebhook")
+  constant.TELEGRAM_TOKEN = os.Getenv("TelegramToken")
+  constant.TELEGRAM_CHAT = os.Getenv("TelegramChat")
+  constant.SMTP_USER = os.Getenv("SMTPUser")
+  constant.SMTP_PASSWORD = os.Getenv("SMTPPassword")
+  constant.NOTIFY_WEBHOOK = os.Getenv("NotifyWebhook")
 
-  constant.KEY = os.Getenv("PaperKey")
-  constant.SECRET = os.Getenv("PaperSecret")
+  if config.C.Live {
+    constant.KEY = os.Getenv("LiveKey")
+    constant.SECRET = os.Getenv("LiveSecret")
+  } else {
+    constant.KEY = os.Getenv("PaperKey")
+    constant.SECRET = os.Getenv("PaperSecret")
+  }
 
   constant.DB_USER = os.Getenv("DBUser")
   constant.DB_PASSWORD = os.Getenv("DBPassword")
   constant.DB_NAME = os.Getenv("DBDatabase")
 
   if constant.KEY == "" || constant.SECRET == "" {
-    log.Panicln("Missing PaperKey or PaperSecret")
+    log.Panicln("Missing API key or secret")
   }
 
   constant.AUTH_HEADERS = http.Header{
@@ -48,3 +121,69 @@ func init() {
     "APCA-API-SECRET-KEY": {constant.SECRET},
   }
 }
+
+// Routes notifications to the backends with credentials set. Runs after the
+// credentials are read.
+func init() {
+  push.SetRoutes(notifyRoutes())
+}
+
+func notifyRoutes() []push.Route {
+  var routes []push.Route
+  for _, b := range []struct {
+    enabled     bool
+    level       string
+    components  []string
+    notifier    push.Notifier
+  }{
+    {
+      constant.PUSH_TOKEN != "" && constant.PUSH_USER != "",
+      config.C.NotifyPushoverLevel, config.C.NotifyPushoverComponents,
+      &push.Pushover{
+        Token: constant.PUSH_TOKEN,
+        User: constant.PUSH_USER,
+        Retry: config.C.NotifyEmergencyRetry,
+        Expire: config.C.NotifyEmergencyExpire,
+      },
+    },
+    {
+      constant.SLACK_WEBHOOK != "",
+      config.C.NotifySlackLevel, config.C.NotifySlackComponents,
+      &push.Slack{URL: constant.SLACK_WEBHOOK},
+    },
+    {
+      constant.DISCORD_WEBHOOK != "",
+      config.C.NotifyDiscordLevel, config.C.NotifyDiscordComponents,
+      &push.Discord{URL: constant.DISCORD_WEBHOOK},
+    },
+    {
+      constant.TELEGRAM_TOKEN != "" && constant.TELEGRAM_CHAT != "",
+      config.C.NotifyTelegramLevel, config.C.NotifyTelegramComponents,
+      &push.Telegram{Token: constant.TELEGRAM_TOKEN, ChatID: constant.TELEGRAM_CHAT},
+    },
+    {
+      config.C.NotifyEmailSMTP != "",
+      config.C.NotifyEmailLevel, config.C.NotifyEmailComponents,
+      &push.Email{
+        Addr: config.C.NotifyEmailSMTP,
+        User: constant.SMTP_USER,
+        Password: constant.SMTP_PASSWORD,
+        From: config.C.NotifyEmailFrom,
+        To: config.C.NotifyEmailTo,
+      },
+    },
+    {
+      constant.NOTIFY_WEBHOOK != "",
+      config.C.NotifyWebhookLevel, config.C.NotifyWebhookComponents,
+      &push.Webhook{URL: constant.NOTIFY_WEBHOOK},
+    },
+  } {
+    if !b.enabled || b.level == "off" {
+      continue
+    }
+    // Validated by the config
+    level, _ := push.ParseLevel(b.level)
+    routes = append(routes, push.Route{Notifier: b.notifier, Level: level, Components: b.components})
+  }
+  return routes
+}
@@ -0,0 +1,216 @@
+// The journal records every raw market message together with the time it was received,
+// so that a session can be replayed through Market.messageHandler exactly as it was
+// received. Each record is stored as:
+//
+//   [8 byte received time in unix nanoseconds][4 byte message length][message]
+//
+// with integers in big endian. Files start with the journalMagic header.
+
+package main
+
+import (
+  "os"
+  "io"
+  "log"
+  "sync"
+  "time"
+  "bufio"
+  "errors"
+  "context"
+  "sync/atomic"
+  "path/filepath"
+  "encoding/binary"
+  "github.com/Kjellemann1/AlgoTrader-Go/util"
+)
+
+const journalMagic = "ATJ1"
+
+// Received time of the message currently being replayed, in unix nanoseconds, by
+// asset class. The journals of the asset classes are replayed independently.
+var replayTimes = map[string]*atomic.Int64{"stock": {}, "crypto": {}}
+
+// Set when replaying. The strategies are then not started, so that they run on the
+// replaying goroutine as in backtests, and signals are handled in journal order.
+var replaying bool
+
+// Makes clockNow follow the replayed messages of the asset class, so the time diff
+// checks in openChecks compare against the time the message was originally received.
+func useReplayClock() {
+  clockNow = func(asset_class string) time.Time {
+    return time.Unix(0, replayTimes[asset_class].Load()).UTC()
+  }
+}
+
+type JournalWriter struct {
+  file   *os.File
+  w      *bufio.Writer
+  mutex  sync.Mutex
+}
+
+// Opens the journal at path for appending, and writes the header if the file is new.
+func NewJournalWriter(path string) (*JournalWriter, error) {
+  file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+  if err != nil {
+    return nil, err
+  }
+  info, err := file.Stat()
+  if err != nil {
+    file.Close()
+    return nil, err
+  }
+  j := &JournalWriter{file: file, w: bufio.NewWriterSize(file, 1 << 16)}
+  if info.Size() == 0 {
+    if _, err := j.w.WriteString(journalMagic); err != nil {
+      file.Close()
+      return nil, err
+    }
+  }
+  return j, nil
+}
+
+func (j *JournalWriter) Write(mm MarketMessage) error {
+  var header [12]byte
+  binary.BigEndian.PutUint64(header[0:8], uint64(mm.received_time.UnixNano()))
+  binary.BigEndian.PutUint32(header[8:12], uint32(len(mm.message)))
+
+  j.mutex.Lock()
+  defer j.mutex.Unlock()
+  if _, err := j.w.Write(header[:]); err != nil {
+    return err
+  }
+  _, err := j.w.Write(mm.message)
+  return err
+}
+
+func (j *JournalWriter) Flush() error {
+  j.mutex.Lock()
+  defer j.mutex.Unlock()
+  return j.w.Flush()
+}
+
+func (j *JournalWriter) Close() error {
+  if err := j.Flush(); err != nil {
+    return err
+  }
+  return j.file.Close()
+}
+
+// Flushes the buffer every interval until ctx is done, then closes the journal.
+func (j *JournalWriter) flushLoop(ctx context.Context, interval time.Duration) {
+  ticker := time.NewTicker(interval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-ctx.Done():
+      if err := j.Close(); err != nil {
+        util.Warning(err)
+      }
+      return
+    case <-ticker.C:
+      if err := j.Flush(); err != nil {
+        util.Warning(err)
+      }
+    }
+  }
+}
+
+type JournalReader struct {
+  r *bufio.Reader
+}
+
+func NewJournalReader(r io.Reader) (*JournalReader, error) {
+  j := &JournalReader{r: bufio.NewReaderSize(r, 1 << 16)}
+  magic := make([]byte, len(journalMagic))
+  if _, err := io.ReadFull(j.r, magic); err != nil {
+    return nil, err
+  }
+  if string(magic) != journalMagic {
+    return nil, errors.New("Not a market data journal")
+  }
+  return j, nil
+}
+
+// Returns the next record, or io.EOF when the journal is exhausted.
+func (j *JournalReader) Next() (MarketMessage, error) {
+  var header [12]byte
+  if _, err := io.ReadFull(j.r, header[:]); err != nil {
+    return MarketMessage{}, err
+  }
+  received_time := time.Unix(0, int64(binary.BigEndian.Uint64(header[0:8]))).UTC()
+  message := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+  if _, err := io.ReadFull(j.r, message); err != nil {
+    if err == io.EOF {
+      err = io.ErrUnexpectedEOF
+    }
+    return MarketMessage{}, err
+  }
+  return MarketMessage{message, received_time}, nil
+}
+
+// Replays a journal in place of the websocket connection. With speed 1 the original
+// timing between messages is preserved, higher values replay faster, and speed 0
+// replays as fast as possible. Messages are handled in journal order on the calling
+// goroutine, and so are the strategies when replaying, so the replay is deterministic.
+func (m *Market) replayJournal(ctx context.Context, r io.Reader, speed float64) error {
+  j, err := NewJournalReader(r)
+  if err != nil {
+    return err
+  }
+
+  var prev time.Time
+  var n int
+  for {
+    mm, err := j.Next()
+    if err == io.EOF {
+      break
+    } else if err != nil {
+      return err
+    }
+
+    if speed > 0 && !prev.IsZero() {
+      delay := time.Duration(float64(mm.received_time.Sub(prev)) / speed)
+      select {
+      case <-ctx.Done():
+        return nil
+      case <-time.After(delay):
+      }
+    } else if ctx.Err() != nil {
+      return nil
+    }
+    prev = mm.received_time
+
+    replayTimes[m.asset_class].Store(mm.received_time.UnixNano())
+    if err := m.messageHandler(mm); err != nil {
+      util.Warning(err, "Message", string(mm.message))
+    }
+    n++
+  }
+
+  log.Printf("[ OK ]\tReplayed %d messages for %s\n", n, m.asset_class)
+  return nil
+}
+
+// Sets up recording to, or replaying from, <dir>/<asset class>.journal.
+// The opened journal is closed when ctx is done.
+func (m *Market) setupJournal(ctx context.Context, record_dir string, replay_dir string, speed float64) {
+  if replay_dir != "" {
+    f, err := os.Open(filepath.Join(replay_dir, m.asset_class + ".journal"))
+    if err != nil {
+      log.Panicln(err)
+    }
+    go func() {
+      <-ctx.Done()
+      f.Close()
+    }()
+    m.replay = f
+    m.replay_speed = speed
+  } else if record_dir != "" {
+    j, err := NewJournalWriter(filepath.Join(record_dir, m.asset_class + ".journal"))
+    if err != nil {
+      log.Panicln(err)
+    }
+    go j.flushLoop(ctx, 1 * time.Second)
+    m.recorder = j
+    util.Ok("Recording market data for " + m.asset_class)
+  }
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+  "io"
+  "os"
+  "bytes"
+  "context"
+  "testing"
+  "time"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestJournalRoundTrip(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "crypto.journal")
+  t0 := time.Date(2025, 1, 2, 10, 0, 0, 123, time.UTC)
+
+  j, err := NewJournalWriter(path)
+  assert.Nil(t, err)
+  assert.Nil(t, j.Write(MarketMessage{[]byte(`[{"T":"t"}]`), t0}))
+  assert.Nil(t, j.Close())
+
+  // Appending to an existing journal does not write the header again
+  j, err = NewJournalWriter(path)
+  assert.Nil(t, err)
+  assert.Nil(t, j.Write(MarketMessage{[]byte(`[{"T":"b"}]`), t0.Add(time.Second)}))
+  assert.Nil(t, j.Close())
+
+  f, _ := os.Open(path)
+  defer f.Close()
+  r, err := NewJournalReader(f)
+  assert.Nil(t, err)
+
+  mm, err := r.Next()
+  assert.Nil(t, err)
+  assert.Equal(t, `[{"T":"t"}]`, string(mm.message))
+  assert.Equal(t, t0, mm.received_time)
+
+  mm, err = r.Next()
+  assert.Nil(t, err)
+  assert.Equal(t, `[{"T":"b"}]`, string(mm.message))
+  assert.Equal(t, t0.Add(time.Second), mm.received_time)
+
+  _, err = r.Next()
+  assert.Equal(t, io.EOF, err)
+}
+
+func TestJournalReaderErrors(t *testing.T) {
+  _, err := NewJournalReader(bytes.NewReader([]byte("FOO1")))
+  assert.NotNil(t, err)
+
+  // Record header promising more bytes than present
+  truncated := append([]byte(journalMagic), 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 10, 'x')
+  r, err := NewJournalReader(bytes.NewReader(truncated))
+  assert.Nil(t, err)
+  _, err = r.Next()
+  assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestReplayJournal(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "crypto.journal")
+  j, _ := NewJournalWriter(path)
+  t0 := time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
+  _ = j.Write(MarketMessage{[]byte(`[{"T":"b","S":"FOO","o":1,"h":2,"l":0.5,"c":1.5,"v":40,"vw":1.4,"n":6,"t":"2025-01-02T09:59:00Z"}]`), t0})
+  _ = j.Write(MarketMessage{[]byte(`[{"T":"t","S":"FOO","p":1.7,"s":0.25,"t":"2025-01-02T10:00:01Z"}]`), t0.Add(time.Second)})
+  _ = j.Close()
+
+  a := allocAsset("crypto", "FOO")
+  a.strategies = nil
+  m := NewMarket("crypto", "", map[string]*Asset{"FOO": a})
+
+  f, _ := os.Open(path)
+  defer f.Close()
+  assert.Nil(t, m.replayJournal(context.Background(), f, 0))
+
+  assert.Equal(t, 1.5, a.C[a.i(1)])
+  assert.Equal(t, 1.7, a.C[a.i(0)])
+  assert.Equal(t, 2.0, a.H[a.i(0)])
+  assert.Equal(t, 40.0, a.V[a.i(1)])
+  assert.Equal(t, 1.4, a.VW[a.i(1)])
+  assert.Equal(t, 6.0, a.N[a.i(1)])
+  assert.Equal(t, 0.25, a.V[a.i(0)])
+  assert.Equal(t, 1.0, a.N[a.i(0)])
+  assert.Equal(t, t0.Add(time.Second), a.ReceivedTime)
+  assert.Equal(t, t0.Add(time.Second).UnixNano(), replayTimes["crypto"].Load())
+}
+
+func TestReplayStrategiesNotStarted(t *testing.T) {
+  t.Cleanup(func() {
+    replaying = false
+  })
+  replaying = true
+  a := newAsset("crypto", "FOO")
+  // Run by checkForSignal on the replaying goroutine
+  assert.Nil(t, a.channels)
+}
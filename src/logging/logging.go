@@ -0,0 +1,341 @@
+// Package logging sets up the logger of the trader on log/slog. Records are written
+// as text or JSON to stdout and to a log file that is rotated by size and day.
+//
+// Every record gets the component that logged it, found from the source file of the
+// caller, and is dropped if below the level of the component. Lines written with the
+// standard log package are turned into records as well, with the level taken from
+// their "[ WARNING ]" style tag, which is kept as the event attribute.
+
+package logging
+
+import (
+  "io"
+  "os"
+  "fmt"
+  "sync"
+  "sync/atomic"
+  "time"
+  "strings"
+  "context"
+  "runtime"
+  "log"
+  "log/slog"
+  "path/filepath"
+)
+
+type Options struct {
+  Format      string                 // "text" or "json"
+  Level       slog.Level             // Of components without a level of their own
+  Levels      map[string]slog.Level  // By component
+  Components  map[string]string      // Component of each source file, by base name. Others are "main".
+  File        string                 // Prefix of the log file names. Empty logs to stdout only.
+  MaxSize     int64                  // Bytes written before the file is rotated. 0 disables.
+  Daily       bool                   // Rotates the file when the UTC date changes
+}
+
+// Logs to stdout and the log file as set in opts, and makes the standard log
+// package log through it. The returned closer closes the log file.
+func Setup(opts Options, stdout io.Writer) (io.Closer, error) {
+  w := stdout
+  var closer io.Closer = io.NopCloser(nil)
+  if opts.File != "" {
+    f, err := OpenRotatingFile(opts.File, opts.MaxSize, opts.Daily)
+    if err != nil {
+      return nil, err
+    }
+    w = io.MultiWriter(stdout, f)
+    closer = f
+  }
+  h, err := NewHandler(opts, w)
+  if err != nil {
+    return nil, err
+  }
+  slog.SetDefault(slog.New(h))
+  components.Store(&opts.Components)
+  // Replaces the writer that SetDefault points the log package at
+  log.SetFlags(0)
+  log.SetOutput(stdWriter{})
+  return closer, nil
+}
+
+type handler struct {
+  inner      slog.Handler
+  opts       *Options
+  min        slog.Level  // Lowest level of any component
+  component  string      // Set with a component attribute, otherwise found from the caller
+}
+
+func NewHandler(opts Options, w io.Writer) (slog.Handler, error) {
+  var inner slog.Handler
+  switch opts.Format {
+  case "json":
+    inner = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+  case "text", "":
+    inner = slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+  default:
+    return nil, fmt.Errorf("Unknown log format %q", opts.Format)
+  }
+  h := &handler{inner: inner, opts: &opts, min: opts.Level}
+  for _, level := range opts.Levels {
+    h.min = min(h.min, level)
+  }
+  return h, nil
+}
+
+func (h *handler) level(component string) slog.Level {
+  if level, ok := h.opts.Levels[component]; ok {
+    return level
+  }
+  return h.opts.Level
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+  if h.component != "" {
+    return level >= h.level(h.component)
+  }
+  return level >= h.min
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+  component := h.component
+  source := ""
+  if r.PC != 0 {
+    frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+    file := filepath.Base(frame.File)
+    source = fmt.Sprintf("%s:%d", file, frame.Line)
+    if component == "" {
+      component = h.opts.Components[file]
+    }
+  }
+  if component == "" {
+    component = "main"
+  }
+  if r.Level < h.level(component) {
+    return nil
+  }
+  if h.component == "" {
+    r.AddAttrs(slog.String("component", component))
+  }
+  if source != "" {
+    r.AddAttrs(slog.String("source", source))
+  }
+  return h.inner.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+  clone := *h
+  rest := make([]slog.Attr, 0, len(attrs))
+  for _, a := range attrs {
+    if a.Key == "component" {
+      clone.component = a.Value.String()
+    }
+    rest = append(rest, a)
+  }
+  clone.inner = h.inner.WithAttrs(rest)
+  return &clone
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+  clone := *h
+  clone.inner = h.inner.WithGroup(name)
+  return &clone
+}
+
+// Component of each source file as set up last
+var components atomic.Pointer[map[string]string]
+
+// Component of the caller skip frames above the caller of Component
+func Component(skip int) string {
+  _, file, _, ok := runtime.Caller(skip + 1)
+  if m := components.Load(); ok && m != nil {
+    if component, ok := (*m)[filepath.Base(file)]; ok {
+      return component
+    }
+  }
+  return "main"
+}
+
+// Logs with the source of the caller skip frames above the caller of Log, so that
+// helpers can log as their caller.
+func Log(level slog.Level, skip int, msg string, details ...any) {
+  var pcs [1]uintptr
+  runtime.Callers(skip + 2, pcs[:])
+  emit(level, pcs[0], msg, Attrs(details...)...)
+}
+
+func emit(level slog.Level, pc uintptr, msg string, args ...any) {
+  l := slog.Default()
+  if !l.Enabled(context.Background(), level) {
+    return
+  }
+  r := slog.NewRecord(time.Now(), level, msg, pc)
+  r.Add(args...)
+  _ = l.Handler().Handle(context.Background(), r)
+}
+
+// Canonical attribute keys of the fields shared across components
+var aliases = map[string]string{
+  "strategy": "strat",
+  "strat_name": "strat",
+  "stratname": "strat",
+  "positionid": "position_id",
+  "orderid": "order_id",
+  "client_order_id": "position_id",
+}
+
+// Attribute key of a detail key like "Position ID" or "Retrying in (seconds)"
+func Key(key string) string {
+  key = strings.ToLower(strings.TrimSpace(key))
+  key = strings.Map(func(r rune) rune {
+    switch {
+    case r == ' ' || r == '-' || r == '.':
+      return '_'
+    case r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_':
+      return r
+    }
+    return -1
+  }, key)
+  if alias, ok := aliases[key]; ok {
+    return alias
+  }
+  return key
+}
+
+// Maps key value details onto attributes. Values other than plain ones are
+// formatted like the details of the old text logs.
+func Attrs(details ...any) []any {
+  args := make([]any, 0, len(details))
+  for i := 0; i < len(details); i += 2 {
+    key := "!BADKEY"
+    if s, ok := details[i].(string); ok {
+      key = Key(s)
+    }
+    var value any
+    if i + 1 < len(details) {
+      value = details[i+1]
+    }
+    args = append(args, slog.Any(key, plain(value)))
+  }
+  return args
+}
+
+func plain(v any) any {
+  switch v := v.(type) {
+  case nil, string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64,
+    float32, float64, time.Time, time.Duration:
+    return v
+  case []byte:
+    return string(v)
+  case error:
+    return v.Error()
+  }
+  return fmt.Sprint(v)
+}
+
+// Levels of the tags of the old text logs. Other tags are info.
+var tagLevels = map[string]slog.Level{
+  "ERROR": slog.LevelError,
+  "FAIL": slog.LevelError,
+  "WARNING": slog.LevelWarn,
+}
+
+// Turns lines of the log package into records
+type stdWriter struct{}
+
+func (stdWriter) Write(p []byte) (int, error) {
+  msg := strings.TrimRight(string(p), "\n")
+  level := slog.LevelInfo
+  var args []any
+  if rest, ok := strings.CutPrefix(msg, "[ "); ok {
+    if tag, text, ok := strings.Cut(rest, " ]"); ok && tag != "" && !strings.Contains(tag, " ") {
+      if l, ok := tagLevels[tag]; ok {
+        level = l
+      }
+      args = append(args, slog.String("event", strings.ToLower(tag)))
+      msg = strings.TrimSpace(text)
+    }
+  }
+  emit(level, stdCaller(), msg, args...)
+  return len(p), nil
+}
+
+// First caller outside the log package. Called from Write.
+func stdCaller() uintptr {
+  var pcs [16]uintptr
+  // Skips Callers, stdCaller and Write
+  n := runtime.Callers(3, pcs[:])
+  frames := runtime.CallersFrames(pcs[:n])
+  for {
+    frame, more := frames.Next()
+    if !strings.HasPrefix(frame.Function, "log.") {
+      // Callers returns return addresses, which is what records expect
+      return frame.PC + 1
+    }
+    if !more {
+      return 0
+    }
+  }
+}
+
+// Rotated log file. Files are named by the prefix and the UTC time they are opened.
+type RotatingFile struct {
+  prefix    string
+  max_size  int64
+  daily     bool
+  file      *os.File
+  size      int64
+  day       string
+  now       func() time.Time
+  mutex     sync.Mutex
+}
+
+func OpenRotatingFile(prefix string, max_size int64, daily bool) (*RotatingFile, error) {
+  f := &RotatingFile{prefix: prefix, max_size: max_size, daily: daily, now: time.Now}
+  if err := f.rotate(); err != nil {
+    return nil, err
+  }
+  return f, nil
+}
+
+func (f *RotatingFile) rotate() error {
+  now := f.now().UTC()
+  name := f.prefix + now.Format(time.DateTime) + ".log"
+  // Rotated by size within the same second
+  for i := 1; ; i++ {
+    if _, err := os.Stat(name); os.IsNotExist(err) {
+      break
+    }
+    name = fmt.Sprintf("%s%s.%d.log", f.prefix, now.Format(time.DateTime), i)
+  }
+  file, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+  if err != nil {
+    return err
+  }
+  if f.file != nil {
+    f.file.Close()
+  }
+  f.file = file
+  f.size = 0
+  f.day = now.Format(time.DateOnly)
+  return nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+  f.mutex.Lock()
+  defer f.mutex.Unlock()
+  if f.daily && f.now().UTC().Format(time.DateOnly) != f.day ||
+    f.max_size > 0 && f.size > 0 && f.size + int64(len(p)) > f.max_size {
+    if err := f.rotate(); err != nil {
+      return 0, err
+    }
+  }
+  n, err := f.file.Write(p)
+  f.size += int64(n)
+  return n, err
+}
+
+func (f *RotatingFile) Close() error {
+  f.mutex.Lock()
+  defer f.mutex.Unlock()
+  return f.file.Close()
+}
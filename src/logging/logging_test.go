@@ -0,0 +1,121 @@
+package logging
+
+import (
+  "os"
+  "log"
+  "time"
+  "bytes"
+  "strings"
+  "testing"
+  "log/slog"
+  "path/filepath"
+  "encoding/json"
+  "github.com/stretchr/testify/assert"
+)
+
+func withLogger(t *testing.T, opts Options) *bytes.Buffer {
+  saved := slog.Default()
+  saved_writer := log.Writer()
+  saved_flags := log.Flags()
+  t.Cleanup(func() {
+    slog.SetDefault(saved)
+    log.SetOutput(saved_writer)
+    log.SetFlags(saved_flags)
+  })
+  var b bytes.Buffer
+  _, err := Setup(opts, &b)
+  assert.Nil(t, err)
+  return &b
+}
+
+func records(b *bytes.Buffer) []map[string]any {
+  var list []map[string]any
+  for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+    if line == "" {
+      continue
+    }
+    var r map[string]any
+    if err := json.Unmarshal([]byte(line), &r); err == nil {
+      list = append(list, r)
+    }
+  }
+  return list
+}
+
+func TestLevels(t *testing.T) {
+  b := withLogger(t, Options{
+    Format: "json",
+    Level: slog.LevelInfo,
+    Levels: map[string]slog.Level{"strategy": slog.LevelWarn, "market": slog.LevelDebug},
+    Components: map[string]string{"logging_test.go": "strategy"},
+  })
+  Log(slog.LevelInfo, 0, "Dropped")
+  Log(slog.LevelWarn, 0, "Kept", "Symbol", "BTC/USD", "Strat Name", "foo", "Position ID", "x", "Order ID", "y")
+  slog.Debug("Dropped")
+  slog.With("component", "market").Debug("Kept")
+
+  r := records(b)
+  assert.Len(t, r, 2)
+  assert.Equal(t, "Kept", r[0]["msg"])
+  assert.Equal(t, "WARN", r[0]["level"])
+  assert.Equal(t, "strategy", r[0]["component"])
+  assert.Equal(t, "BTC/USD", r[0]["symbol"])
+  assert.Equal(t, "foo", r[0]["strat"])
+  assert.Equal(t, "x", r[0]["position_id"])
+  assert.Equal(t, "y", r[0]["order_id"])
+  assert.Contains(t, r[0]["source"], "logging_test.go:")
+  assert.Equal(t, "market", r[1]["component"])
+  assert.Equal(t, "strategy", Component(0))
+}
+
+func TestStdLog(t *testing.T) {
+  b := withLogger(t, Options{Format: "json", Level: slog.LevelInfo})
+  log.Printf("[ WARNING ]\tDeadline reached\n")
+  log.Println("Waiting for pending orders:")
+
+  r := records(b)
+  assert.Len(t, r, 2)
+  assert.Equal(t, "WARN", r[0]["level"])
+  assert.Equal(t, "warning", r[0]["event"])
+  assert.Equal(t, "Deadline reached", r[0]["msg"])
+  assert.Equal(t, "main", r[0]["component"])
+  assert.Contains(t, r[0]["source"], "logging_test.go:")
+  assert.Equal(t, "Waiting for pending orders:", r[1]["msg"])
+}
+
+func TestAttrs(t *testing.T) {
+  args := Attrs("Retrying in (seconds)", 2.0, "Message", []byte("raw"), 3, "bad", "Strategy")
+  assert.Equal(t, []any{
+    slog.Any("retrying_in_seconds", 2.0),
+    slog.Any("message", "raw"),
+    slog.Any("!BADKEY", "bad"),
+    slog.Any("strat", nil),
+  }, args)
+}
+
+func TestRotatingFile(t *testing.T) {
+  prefix := filepath.Join(t.TempDir(), "algo ")
+  now := time.Date(2024, 7, 2, 23, 59, 59, 0, time.UTC)
+  f, err := OpenRotatingFile(prefix, 10, true)
+  assert.Nil(t, err)
+  f.now = func() time.Time { return now }
+  f.rotate()
+
+  f.Write([]byte("12345"))
+  f.Write([]byte("12345"))
+  f.Write([]byte("1"))  // Above the size
+  now = now.Add(time.Second)
+  f.Write([]byte("2"))  // New day
+  assert.Nil(t, f.Close())
+
+  files, _ := filepath.Glob(prefix + "*.log")
+  names := make([]string, len(files))
+  for i, file := range files {
+    names[i] = filepath.Base(file)
+  }
+  assert.Contains(t, names, "algo 2024-07-02 23:59:59.log")
+  assert.Contains(t, names, "algo 2024-07-02 23:59:59.1.log")
+  assert.Contains(t, names, "algo 2024-07-03 00:00:00.log")
+  data, _ := os.ReadFile(prefix + "2024-07-02 23:59:59.1.log")
+  assert.Equal(t, "1", string(data))
+}
@@ -2,51 +2,189 @@ package main
 
 import (
   "log"
+  "flag"
   "sync"
+  "time"
   "context"
-  "github.com/Kjellemann1/AlgoTrader-Go/constant"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/broker"
+  "github.com/Kjellemann1/AlgoTrader-Go/metrics"
+  "github.com/Kjellemann1/AlgoTrader-Go/util"
+  "github.com/Kjellemann1/AlgoTrader-Go/push"
 )
 
 var globRwm sync.RWMutex
 
 func main() {
+  backtest := flag.String("backtest", "", "Run a backtest on a csv file of 1-minute bars, or \"alpaca\" to download them")
+  backtest_class := flag.String("backtest-class", "crypto", "Asset class of the backtest bars")
+  backtest_start := flag.String("backtest-start", "", "Start date (YYYY-MM-DD) when downloading backtest bars")
+  backtest_end := flag.String("backtest-end", "", "End date (YYYY-MM-DD) when downloading backtest bars")
+  backtest_out := flag.String("backtest-out", ".", "Directory to write backtest trades and equity curve to")
+  sim_broker := flag.String("broker", "", "Run against a local simulated broker listening on this address, e.g. 127.0.0.1:8089")
+  record := flag.String("record", "", "Directory to record raw market data journals to")
+  replay := flag.String("replay", "", "Directory of market data journals to replay instead of connecting to the market websockets")
+  replay_speed := flag.Float64("replay-speed", 1, "Replay speed relative to the original timing. 0 replays as fast as possible")
+  strategies := flag.String("strategies", config.C.StrategiesFile, "Strategy config file with params, asset classes and symbols per strategy")
+  flag.Parse()
+
+  if err := loadStrategyConfig(*strategies); err != nil {
+    log.Panicln(err)
+  }
+
+  if *replay != "" && *sim_broker == "" {
+    log.Panicln("Replay must be run against the simulated broker (-broker)")
+  }
+
+  if *backtest != "" {
+    // The dates are only needed when downloading bars, so they may be left empty
+    parseDate := func(name string, value string) time.Time {
+      if value == "" {
+        return time.Time{}
+      }
+      date, err := time.Parse(time.DateOnly, value)
+      if err != nil {
+        log.Fatalf("Invalid -%s %q, expected YYYY-MM-DD", name, value)
+      }
+      return date
+    }
+    start := parseDate("backtest-start", *backtest_start)
+    end := parseDate("backtest-end", *backtest_end)
+    runBacktest(*backtest, *backtest_class, start, end, *backtest_out)
+    return
+  }
+
   log.Println("Starting AlgoTrader ...")
 
+  // Stopped after everything else so that the last notifications are sent
+  stop_notifications := push.StartQueue(push.QueueOptions{
+    Size: config.C.NotifyQueueSize,
+    DedupWindow: config.C.NotifyDedupWindow,
+    DigestInterval: config.C.NotifyDigestInterval,
+  })
+  defer stop_notifications()
+
   rootCtx, rootCancel := context.WithCancel(context.Background())
   defer rootCancel()
   marketCtx, marketCancel := context.WithCancel(rootCtx)
   accountCtx, accountCancel := context.WithCancel(rootCtx)
 
-  db_chan := make(chan *Query, len(constant.STOCK_SYMBOLS) + len(constant.CRYPTO_SYMBOLS))
+  db_chan := make(chan *Query, len(config.C.StockSymbols) + len(config.C.CryptoSymbols))
   defer close(db_chan)
 
   var wg sync.WaitGroup
   defer wg.Wait()
 
+  if *sim_broker == "" {
+    filterTradableSymbols()
+    screenUniverse()
+  }
+  // Strategies run on the replaying goroutines
+  replaying = *replay != ""
   assets := prepAssetsMap()
-  fillRollingWindows(assets)
+  Risk = NewRiskManager(assets)
+  if _, ok := assets["stock"]; ok {
+    if err := loadCalendar(); err != nil {
+      util.Warning(err, "Details", "Market calendar not loaded. Stocks are treated as always open.")
+    } else {
+      logClock()
+      if config.C.CalendarFile == "" {
+        wg.Add(1)
+        go refreshCalendar(&wg, accountCtx)
+      }
+      if config.C.FlattenBeforeClose > 0 {
+        wg.Add(1)
+        go flattenBeforeClose(&wg, marketCtx, assets["stock"])
+      }
+    }
+  }
+  if *replay != "" {
+    // The windows are filled by the replayed bars
+    useReplayClock()
+  } else {
+    fillRollingWindows(assets)
+  }
+
+  if *sim_broker != "" {
+    startSimulatedBroker(*sim_broker, assets)
+  }
+
+  if err := accountEquity.update(); err != nil {
+    util.Warning(err, "Details", "Account equity not fetched. Sizers using it cancel opens until it is.")
+  }
+  wg.Add(1)
+  go accountEquity.start(&wg, accountCtx)
 
+  shutdown := NewShutdown(marketCancel, accountCancel, assets, db_chan)
   wg.Add(1)
-  go shutdownHandler(&wg, marketCancel, accountCancel, assets, db_chan)
+  go shutdownHandler(&wg, shutdown)
 
   wg.Add(1)
   db := NewDatabase(db_chan, assets)
+  dbQueue = db_chan
   go db.start(&wg)
 
   wg.Add(1)
-  a := NewAccount(assets, constant.WSS_ACCOUNT, db_chan)
+  a := NewAccount(assets, config.C.WssAccount, db_chan)
 
   go a.start(&wg, accountCtx, 2)
 
+  markets := make(map[string]*Market)
   if _, ok := assets["stock"]; ok {
-    sm := NewMarket("stock", constant.WSS_STOCK, assets["stock"])
+    sm := NewMarket("stock", config.C.WssStock, assets["stock"])
+    sm.setupJournal(marketCtx, *record, *replay, *replay_speed)
+    markets["stock"] = sm
     wg.Add(1)
     go sm.start(&wg, marketCtx, 2)
   }
 
   if _, ok := assets["crypto"]; ok {
-    cm := NewMarket("crypto", constant.WSS_CRYPTO, assets["crypto"])
+    cm := NewMarket("crypto", config.C.WssCrypto, assets["crypto"])
+    cm.setupJournal(marketCtx, *record, *replay, *replay_speed)
+    markets["crypto"] = cm
     wg.Add(1)
     go cm.start(&wg, marketCtx, 2)
   } 
+
+  if config.C.ScreenTopN > 0 && config.C.ScreenInterval > 0 && *sim_broker == "" {
+    wg.Add(1)
+    go runScreener(&wg, marketCtx, markets)
+  }
+
+  admin := NewAdmin(markets, shutdown, &wg, marketCtx)
+  if config.C.AdminSocket != "" && *replay == "" {
+    wg.Add(1)
+    go admin.listen(&wg, config.C.AdminSocket)
+  }
+  if config.C.AdminAddr != "" {
+    wg.Add(1)
+    go admin.listenHTTP(&wg, accountCtx, config.C.AdminAddr)
+  }
+  if config.C.MetricsAddr != "" {
+    registerGauges(metrics.Default, assets, markets, db_chan)
+    wg.Add(1)
+    go serveMetrics(&wg, accountCtx, config.C.MetricsAddr)
+  }
+}
+
+// Starts the simulated broker in-process and points the order endpoints and the
+// account websocket at it. Orders are filled at the last close of the asset.
+func startSimulatedBroker(addr string, assets map[string]map[string]*Asset) {
+  b := broker.New(func(symbol string) (float64, bool) {
+    for _, asset_class := range assets {
+      if a, ok := asset_class[symbol]; ok {
+        a.Rwm.RLock()
+        defer a.Rwm.RUnlock()
+        price := a.C[config.C.WindowSize-1]
+        return price, price > 0
+      }
+    }
+    return 0, false
+  })
+  addr, err := b.Start(addr, 1 * time.Second)
+  if err != nil {
+    log.Panicln(err)
+  }
+  config.C.Endpoint = "http://" + addr + "/v2"
+  config.C.WssAccount = "ws://" + addr + "/stream"
 }
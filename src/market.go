@@ -1,6 +1,7 @@
 package main
 
 import (
+  "io"
   "sync"
   "log"
   "errors"
@@ -12,6 +13,7 @@ import (
   "github.com/valyala/fastjson"
   "github.com/gorilla/websocket"
   "github.com/Kjellemann1/AlgoTrader-Go/constant"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
   "github.com/Kjellemann1/AlgoTrader-Go/util"
   "github.com/Kjellemann1/AlgoTrader-Go/request"
 )
@@ -25,8 +27,13 @@ type Market struct {
   asset_class       string
   assets            map[string]*Asset
   conn              *websocket.Conn
+  conn_mutex        sync.Mutex  // Guards writes to conn
+  symbols_mutex     sync.Mutex  // Serializes symbols added and removed while running
   url               string
   worker_pool_chan  chan MarketMessage
+  recorder          *JournalWriter
+  replay            io.Reader
+  replay_speed      float64
 
   listen            func(*sync.WaitGroup, chan int8)
   pingPong          func(*sync.WaitGroup, context.Context, chan int8)
@@ -61,29 +68,39 @@ func (m *Market) initiateWorkerPool(n_workers int) {
   }
 }
 
+// Compares the symbols of a subscription message with the assets. Mismatches are
+// only warned about, since symbols added or removed while running are not in sync
+// with the assets until the server has confirmed the change.
 func (m *Market) checkAllSymbolsInSubscription(element *fastjson.Value) {
-  symbols := []string{}
-  for s := range m.assets {
-    symbols = append(symbols, s)
-  }
-
-  var subs = make(map[string][]string)
-  for _, sub_type := range []string{"bars", "trades"} {
+  symbols := m.symbols()
+  ok := true
+  for _, sub_type := range []string{"bars", "trades", "quotes", "orderbooks"} {
     fj_array := element.GetArray(sub_type)
-    subs[sub_type] = make([]string, len(fj_array))
+    subs := make([]string, len(fj_array))
 
     for i, fj_symbol := range fj_array {
-      subs[sub_type][i] = string(fj_symbol.GetStringBytes())
+      subs[i] = string(fj_symbol.GetStringBytes())
     }
 
-    for _, symbol := range subs[sub_type] {
+    for _, symbol := range subs {
       if !slices.Contains(symbols, symbol) {
-        log.Panicln("Missing symbol in subscription", symbol)
+        util.Warning(errors.New("Unexpected symbol in subscription"), "Symbol", symbol, "Type", sub_type)
+        ok = false
+      }
+    }
+    if sub_type == "bars" || sub_type == "trades" {
+      for _, symbol := range symbols {
+        if !slices.Contains(subs, symbol) {
+          util.Warning(errors.New("Missing symbol in subscription"), "Symbol", symbol, "Type", sub_type)
+          ok = false
+        }
       }
     }
   }
 
-  util.Ok(fmt.Sprintf("All symbols present in websocket subscription for %s", m.asset_class))
+  if ok {
+    util.Ok(fmt.Sprintf("All symbols present in websocket subscription for %s", m.asset_class))
+  }
 }
 
 func (m *Market) onInitialMessages(element *fastjson.Value) {
@@ -99,29 +116,86 @@ func (m *Market) onInitialMessages(element *fastjson.Value) {
 }
 
 func (m *Market) onMarketBarUpdate(element *fastjson.Value, received_time time.Time) {
-  // TODO: Check within opening hours if stock
-  asset := m.assets[string(element.GetStringBytes("S"))]
+  asset := m.asset(string(element.GetStringBytes("S")))
+  if asset == nil {
+    return
+  }
   t, _ := time.Parse(time.RFC3339, string(element.GetStringBytes("t")))
+  // Stock bars outside the session are dropped, so strategies only see session data
+  if m.asset_class == "stock" && !MarketCalendar.IsOpen(t) {
+    return
+  }
   t = t.Add(1 * time.Minute)
+  marketMessages.Inc(m.asset_class, asset.Symbol, "bar")
+  marketLatency.Observe(received_time.Sub(t).Seconds(), m.asset_class, "bar")
 
-  asset.updateWindowOnBar(
+  triggered := asset.updateWindowOnBar(
     element.GetFloat64("o"),
     element.GetFloat64("h"),
     element.GetFloat64("l"),
     element.GetFloat64("c"),
+    element.GetFloat64("v"),
+    element.GetFloat64("vw"),
+    element.GetFloat64("n"),
     t,
     received_time,
   )
-  asset.checkForSignal()
+  asset.checkForSignal(triggered)
 }
 
 func (m *Market) onMarketTradeUpdate(element *fastjson.Value, received_time time.Time) {
-  // TODO: Check within opening hours if stock
   t, _ := time.Parse(time.RFC3339, string(element.GetStringBytes("t")))
+  if m.asset_class == "stock" && !MarketCalendar.IsOpen(t) {
+    return
+  }
   price := element.GetFloat64("p")
-  asset := m.assets[string(element.GetStringBytes("S"))]
-  asset.updateWindowOnTrade(price, t, received_time)
-  asset.checkForSignal()
+  size := element.GetFloat64("s")
+  asset := m.asset(string(element.GetStringBytes("S")))
+  if asset == nil {
+    return
+  }
+  marketMessages.Inc(m.asset_class, asset.Symbol, "trade")
+  marketLatency.Observe(received_time.Sub(t).Seconds(), m.asset_class, "trade")
+  triggered := asset.updateWindowOnTrade(price, size, t, received_time)
+  asset.checkForSignal(triggered)
+}
+
+// Quotes only update the asset. Strategies read them on the next bar or trade.
+func (m *Market) onMarketQuoteUpdate(element *fastjson.Value) {
+  t, _ := time.Parse(time.RFC3339, string(element.GetStringBytes("t")))
+  asset := m.asset(string(element.GetStringBytes("S")))
+  if asset == nil {
+    return
+  }
+  asset.updateQuote(Quote{
+    Bid: element.GetFloat64("bp"),
+    BidSize: element.GetFloat64("bs"),
+    Ask: element.GetFloat64("ap"),
+    AskSize: element.GetFloat64("as"),
+    Time: t,
+  })
+}
+
+func parseLevels(arr []*fastjson.Value) []Level {
+  levels := make([]Level, len(arr))
+  for i, v := range arr {
+    levels[i] = Level{v.GetFloat64("p"), v.GetFloat64("s")}
+  }
+  return levels
+}
+
+func (m *Market) onMarketOrderbookUpdate(element *fastjson.Value) {
+  asset := m.asset(string(element.GetStringBytes("S")))
+  if asset == nil || asset.Book == nil {
+    return
+  }
+  t, _ := time.Parse(time.RFC3339, string(element.GetStringBytes("t")))
+  asset.Book.apply(
+    parseLevels(element.GetArray("b")),
+    parseLevels(element.GetArray("a")),
+    element.GetBool("r"),
+    t,
+  )
 }
 
 func (m *Market) parseMessage(mm MarketMessage) (*fastjson.Value, error) {
@@ -151,6 +225,10 @@ func (m *Market) messageHandler(mm MarketMessage) error {
         m.onMarketBarUpdate(element, mm.received_time)
       case "t":
         m.onMarketTradeUpdate(element, mm.received_time)
+      case "q":
+        m.onMarketQuoteUpdate(element)
+      case "o":
+        m.onMarketOrderbookUpdate(element)
       case "success", "subscription":
         m.onInitialMessages(element)
       case "error":
@@ -164,10 +242,13 @@ func (m *Market) messageHandler(mm MarketMessage) error {
 }
 
 func (m *Market) connect() (err error) {
-  m.conn, _, err = websocket.DefaultDialer.Dial(m.url, constant.AUTH_HEADERS)
+  conn, _, err := websocket.DefaultDialer.Dial(m.url, constant.AUTH_HEADERS)
   if err != nil {
     return err
   }
+  m.conn_mutex.Lock()
+  m.conn = conn
+  m.conn_mutex.Unlock()
   var message []byte
   for range 2 {
     _, message, err = m.conn.ReadMessage()
@@ -182,16 +263,21 @@ func (m *Market) connect() (err error) {
   return nil
 }
 
-func (m *Market) subscribe() (err error) {
-  symbols := []string{}
-  for s := range m.assets {
-    symbols = append(symbols, s)
-  }
+// Message subscribing to or unsubscribing from the streams of the symbols
+func (m *Market) subscriptionMessage(action string, symbols []string) []byte {
   sub_msg_symbols := strings.Join(symbols, "\",\"")
-  sub_msg := fmt.Appendf(make([]byte, 0), `{"action":"subscribe", "trades":["%s"], "bars":["%s"]}`, 
-    sub_msg_symbols, sub_msg_symbols, 
+  orderbooks := ""
+  if m.asset_class == "crypto" && config.C.CryptoOrderbook {
+    orderbooks = fmt.Sprintf(`, "orderbooks":["%s"]`, sub_msg_symbols)
+  }
+  return fmt.Appendf(make([]byte, 0), `{"action":"%s", "trades":["%s"], "bars":["%s"], "quotes":["%s"]%s}`, 
+    action, sub_msg_symbols, sub_msg_symbols, sub_msg_symbols, orderbooks,
   )
-  if err = m.conn.WriteMessage(websocket.TextMessage, sub_msg); err != nil {
+}
+
+func (m *Market) subscribe() (err error) {
+  sub_msg := m.subscriptionMessage("subscribe", m.symbols())
+  if err = m.write(sub_msg); err != nil {
     return
   }
   _, sub_msg, err = m.conn.ReadMessage()
@@ -205,22 +291,33 @@ func (m *Market) subscribe() (err error) {
   return
 }
 
+// Writes a text message. Messages are written by the connection loop and by symbol
+// changes while running, which must not write at the same time.
+func (m *Market) write(msg []byte) error {
+  m.conn_mutex.Lock()
+  defer m.conn_mutex.Unlock()
+  if m.conn == nil {
+    return errors.New("Not connected")
+  }
+  return m.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
 func (m *Market) pingPongFunc(connWg *sync.WaitGroup, ctx context.Context, err_chan chan int8) {
   defer connWg.Done()
 
-  if err := m.conn.SetReadDeadline(time.Now().Add(constant.READ_DEADLINE_SEC)); err != nil {
+  if err := m.conn.SetReadDeadline(time.Now().Add(config.C.ReadDeadline)); err != nil {
     util.Warning(err)
   }
 
   m.conn.SetPongHandler(func(string) error {
-    err := m.conn.SetReadDeadline(time.Now().Add(constant.READ_DEADLINE_SEC))
+    err := m.conn.SetReadDeadline(time.Now().Add(config.C.ReadDeadline))
     if err != nil {
       return err
     }
     return nil
   })
 
-  ticker := time.NewTicker(constant.PING_INTERVAL_SEC)
+  ticker := time.NewTicker(config.C.PingInterval)
   defer ticker.Stop()
   util.Ok(fmt.Sprintf("PingPong initiated for %s market websocket", m.asset_class))
 
@@ -258,7 +355,14 @@ func (m *Market) listenFunc(connWg *sync.WaitGroup, err_chan chan int8) {
       return
     }
 
-    m.worker_pool_chan <- MarketMessage{message, received_time}
+    mm := MarketMessage{message, received_time}
+    if m.recorder != nil {
+      if err := m.recorder.Write(mm); err != nil {
+        util.Warning(err, "Asset class", m.asset_class)
+      }
+    }
+
+    m.worker_pool_chan <- mm
   }
 }
 
@@ -266,6 +370,14 @@ func (m *Market) start(wg *sync.WaitGroup, ctx context.Context, backoff_sec_min
   defer wg.Done()
 
   defer close(m.worker_pool_chan)
+
+  if m.replay != nil {
+    if err := m.replayJournal(ctx, m.replay, m.replay_speed); err != nil {
+      util.Error(err, "Asset class", m.asset_class)
+    }
+    return
+  }
+
   m.initiateWorkerPool(len(m.assets))
 
   backoff_sec := backoff_sec_min
@@ -279,8 +391,9 @@ func (m *Market) start(wg *sync.WaitGroup, ctx context.Context, backoff_sec_min
         retries++
         continue
       } else {
-        util.Error(err, "Max retries reached", retries, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
+        util.Emergency(err, "Max retries reached", retries, "CLOSING ALL POSITIONS AND SHUTTING DOWN", "...")
         request.CloseAllPositions(2, 0)
+        util.Flush()
         log.Panicln("SHUTTING DOWN")
       }
     }
@@ -315,6 +428,7 @@ func (m *Market) start(wg *sync.WaitGroup, ctx context.Context, backoff_sec_min
       cancel()
       m.conn.Close()
       connWg.Wait()
+      reconnects.Inc("market_" + m.asset_class)
     }
   }
 }
@@ -0,0 +1,104 @@
+// Prometheus metrics, served on metrics_addr. Market data and reconnects are
+// counted as they happen, and order requests in the request package. Queue
+// backlogs, the no new positions flag and the positions are read when scraped.
+
+package main
+
+import (
+  "net"
+  "sync"
+  "time"
+  "context"
+  "net/http"
+  "github.com/Kjellemann1/AlgoTrader-Go/metrics"
+  "github.com/Kjellemann1/AlgoTrader-Go/util"
+)
+
+var (
+  marketMessages = metrics.NewCounter("algotrader_market_messages_total", "Bars and trades received by asset class, symbol and type", "class", "symbol", "type")
+  marketLatency = metrics.NewHistogram("algotrader_market_latency_seconds", "Time from the market data timestamp to receiving the message. Bars are timed from their end.", metrics.LatencyBuckets, "class", "type")
+  reconnects = metrics.NewCounter("algotrader_websocket_reconnects_total", "Websocket reconnects after a lost connection", "stream")
+)
+
+// Registers the gauges that are read from the running trader when scraped
+func registerGauges(r *metrics.Registry, assets map[string]map[string]*Asset, markets map[string]*Market, db_chan chan *Query) {
+  r.NewGaugeFunc("algotrader_queue_length", "Messages waiting in the market worker pools and the database queue", []string{"queue"}, func(set func(float64, ...string)) {
+    for asset_class, m := range markets {
+      set(float64(len(m.worker_pool_chan)), "market_" + asset_class)
+    }
+    set(float64(len(db_chan)), "database")
+  })
+
+  r.NewGaugeFunc("algotrader_no_new_positions", "1 when opening positions is stopped", nil, func(set func(float64, ...string)) {
+    NNP.rwm.RLock()
+    flag := NNP.Flag
+    NNP.rwm.RUnlock()
+    set(boolFloat(flag))
+  })
+  r.NewGaugeFunc("algotrader_no_new_positions_reason", "1 for each reason currently stopping new positions", []string{"reason"}, func(set func(float64, ...string)) {
+    for reason, on := range NNP.Reasons() {
+      set(boolFloat(on), reason)
+    }
+  })
+
+  r.NewGaugeFunc("algotrader_open_positions", "Positions by asset class and symbol, including those with orders pending", []string{"class", "symbol"}, func(set func(float64, ...string)) {
+    for _, a := range assetsList(assets) {
+      set(float64(len(a.positionsCopy())), a.Class, a.Symbol)
+    }
+  })
+  r.NewGaugeFunc("algotrader_unrealized_pnl_usd", "Unrealized pnl of the filled positions at the last close", []string{"class", "symbol"}, func(set func(float64, ...string)) {
+    for _, a := range assetsList(assets) {
+      a.Rwm.RLock()
+      last := a.C[len(a.C)-1]
+      a.Rwm.RUnlock()
+      pnl := 0.0
+      for _, pos := range a.positionsCopy() {
+        pos.Rwm.RLock()
+        pnl += pos.unrealized(last)
+        pos.Rwm.RUnlock()
+      }
+      set(pnl, a.Class, a.Symbol)
+    }
+  })
+}
+
+func boolFloat(b bool) float64 {
+  if b {
+    return 1
+  }
+  return 0
+}
+
+// The assets, collected with globRwm read locked
+func assetsList(assets map[string]map[string]*Asset) []*Asset {
+  globRwm.RLock()
+  defer globRwm.RUnlock()
+  var list []*Asset
+  for _, asset_class := range assets {
+    for _, a := range asset_class {
+      list = append(list, a)
+    }
+  }
+  return list
+}
+
+// Serves /metrics on addr until ctx is canceled
+func serveMetrics(wg *sync.WaitGroup, ctx context.Context, addr string) {
+  defer wg.Done()
+  ln, err := net.Listen("tcp", addr)
+  if err != nil {
+    util.Warning(err, "Details", "Metrics not served")
+    return
+  }
+  mux := http.NewServeMux()
+  mux.Handle("GET /metrics", metrics.Default.Handler())
+  server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+  go func() {
+    <-ctx.Done()
+    _ = server.Close()
+  }()
+  util.Ok("Metrics on http://" + ln.Addr().String() + "/metrics")
+  if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+    util.Warning(err, "Details", "Metrics stopped")
+  }
+}
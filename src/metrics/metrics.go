@@ -0,0 +1,242 @@
+// Package metrics keeps counters, gauges and histograms and writes them in the
+// Prometheus text exposition format. Metrics are created on a Registry, usually
+// Default, and written in the order they were created with their series sorted
+// by label values.
+//
+// Gauges that are cheaper to read when scraped than to keep up to date are created
+// with a collect function, which sets their series on every write.
+
+package metrics
+
+import (
+  "io"
+  "fmt"
+  "math"
+  "sync"
+  "sort"
+  "bufio"
+  "slices"
+  "strings"
+  "strconv"
+  "net/http"
+)
+
+// Buckets in seconds for latencies from a millisecond to ten seconds
+var LatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type series struct {
+  label_values  []string
+  value         float64
+  buckets       []uint64  // Observations in each bucket, not cumulative
+  count         uint64
+}
+
+type metric struct {
+  name     string
+  help     string
+  kind     string  // "counter", "gauge" or "histogram"
+  labels   []string
+  buckets  []float64
+  collect  func(set func(value float64, label_values ...string))
+  series   map[string]*series
+  mutex    sync.Mutex
+}
+
+// Series of the label values, created if new. Called with the metric locked.
+func (m *metric) get(label_values []string) *series {
+  if len(label_values) != len(m.labels) {
+    panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", m.name, len(m.labels), len(label_values)))
+  }
+  key := strings.Join(label_values, "\xff")
+  s, ok := m.series[key]
+  if !ok {
+    s = &series{label_values: slices.Clone(label_values)}
+    if m.kind == "histogram" {
+      s.buckets = make([]uint64, len(m.buckets))
+    }
+    m.series[key] = s
+  }
+  return s
+}
+
+type Registry struct {
+  metrics  []*metric
+  mutex    sync.Mutex
+}
+
+func NewRegistry() *Registry {
+  return &Registry{}
+}
+
+// The registry of the process
+var Default = NewRegistry()
+
+func (r *Registry) register(m *metric) *metric {
+  r.mutex.Lock()
+  defer r.mutex.Unlock()
+  for _, other := range r.metrics {
+    if other.name == m.name {
+      panic("metrics: " + m.name + " registered twice")
+    }
+  }
+  m.series = make(map[string]*series)
+  r.metrics = append(r.metrics, m)
+  return m
+}
+
+// Only goes up, e.g. the number of messages received
+type Counter struct {
+  m *metric
+}
+
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+  return &Counter{r.register(&metric{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+  return Default.NewCounter(name, help, labels...)
+}
+
+func (c *Counter) Add(v float64, label_values ...string) {
+  c.m.mutex.Lock()
+  defer c.m.mutex.Unlock()
+  c.m.get(label_values).value += v
+}
+
+func (c *Counter) Inc(label_values ...string) {
+  c.Add(1, label_values...)
+}
+
+// Goes up and down, e.g. the length of a queue
+type Gauge struct {
+  m *metric
+}
+
+func (r *Registry) NewGauge(name string, help string, labels ...string) *Gauge {
+  return &Gauge{r.register(&metric{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+  return Default.NewGauge(name, help, labels...)
+}
+
+func (g *Gauge) Set(v float64, label_values ...string) {
+  g.m.mutex.Lock()
+  defer g.m.mutex.Unlock()
+  g.m.get(label_values).value = v
+}
+
+// Gauge whose series are all set by collect when written. Series that collect does
+// not set are not written.
+func (r *Registry) NewGaugeFunc(name string, help string, labels []string, collect func(set func(value float64, label_values ...string))) {
+  r.register(&metric{name: name, help: help, kind: "gauge", labels: labels, collect: collect})
+}
+
+// Counts observations in buckets, e.g. latencies
+type Histogram struct {
+  m *metric
+}
+
+// Buckets are the upper bounds in increasing order. The +Inf bucket is added.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+  if !slices.IsSorted(buckets) {
+    panic("metrics: buckets of " + name + " not sorted")
+  }
+  return &Histogram{r.register(&metric{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})}
+}
+
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+  return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+func (h *Histogram) Observe(v float64, label_values ...string) {
+  h.m.mutex.Lock()
+  defer h.m.mutex.Unlock()
+  s := h.m.get(label_values)
+  if i, _ := slices.BinarySearch(h.m.buckets, v); i < len(s.buckets) {
+    s.buckets[i]++
+  }
+  s.value += v
+  s.count++
+}
+
+func formatFloat(v float64) string {
+  switch {
+  case math.IsInf(v, 1):
+    return "+Inf"
+  case math.IsInf(v, -1):
+    return "-Inf"
+  }
+  return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Label set in braces, empty if there are no labels. extra is a name and value
+// added last, like le of histogram buckets.
+func formatLabels(names []string, values []string, extra ...string) string {
+  if len(names) == 0 && len(extra) == 0 {
+    return ""
+  }
+  pairs := make([]string, 0, len(names) + 1)
+  for i, name := range names {
+    pairs = append(pairs, name + `="` + labelEscaper.Replace(values[i]) + `"`)
+  }
+  if len(extra) == 2 {
+    pairs = append(pairs, extra[0] + `="` + extra[1] + `"`)
+  }
+  return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (m *metric) write(w *bufio.Writer) {
+  m.mutex.Lock()
+  defer m.mutex.Unlock()
+  if m.collect != nil {
+    clear(m.series)
+    m.collect(func(value float64, label_values ...string) {
+      m.get(label_values).value = value
+    })
+  }
+  fmt.Fprintf(w, "# HELP %s %s\n", m.name, strings.ReplaceAll(m.help, "\n", " "))
+  fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+  keys := make([]string, 0, len(m.series))
+  for key := range m.series {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  for _, key := range keys {
+    s := m.series[key]
+    if m.kind != "histogram" {
+      fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.label_values), formatFloat(s.value))
+      continue
+    }
+    var cumulative uint64
+    for i, upper := range m.buckets {
+      cumulative += s.buckets[i]
+      fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.label_values, "le", formatFloat(upper)), cumulative)
+    }
+    fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.label_values, "le", "+Inf"), s.count)
+    fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.label_values), formatFloat(s.value))
+    fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.label_values), s.count)
+  }
+}
+
+// Writes all metrics in the text exposition format
+func (r *Registry) Write(w io.Writer) error {
+  r.mutex.Lock()
+  metrics := slices.Clone(r.metrics)
+  r.mutex.Unlock()
+  bw := bufio.NewWriter(w)
+  for _, m := range metrics {
+    m.write(bw)
+  }
+  return bw.Flush()
+}
+
+func (r *Registry) Handler() http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    _ = r.Write(w)
+  })
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+  "strings"
+  "testing"
+  "net/http/httptest"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+  r := NewRegistry()
+  c := r.NewCounter("messages_total", "Messages received", "symbol")
+  g := r.NewGauge("flag", "Flag")
+  h := r.NewHistogram("latency_seconds", "Latency", []float64{0.1, 1})
+  r.NewGaugeFunc("queue_length", "Queue length", []string{"queue"}, func(set func(float64, ...string)) {
+    set(3, "db")
+  })
+
+  c.Inc("BTC/USD")
+  c.Add(2, "BTC/USD")
+  c.Inc(`A"B`)
+  g.Set(1)
+  h.Observe(0.1)
+  h.Observe(0.5)
+  h.Observe(5)
+
+  var b strings.Builder
+  assert.Nil(t, r.Write(&b))
+  assert.Equal(t, `# HELP messages_total Messages received
+# TYPE messages_total counter
+messages_total{symbol="A\"B"} 1
+messages_total{symbol="BTC/USD"} 3
+# HELP flag Flag
+# TYPE flag gauge
+flag 1
+# HELP latency_seconds Latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.6
+latency_seconds_count 3
+# HELP queue_length Queue length
+# TYPE queue_length gauge
+queue_length{queue="db"} 3
+`, b.String())
+
+  w := httptest.NewRecorder()
+  r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+  assert.Contains(t, w.Header().Get("Content-Type"), "version=0.0.4")
+  assert.Contains(t, w.Body.String(), "flag 1")
+}
+
+func TestMisuse(t *testing.T) {
+  r := NewRegistry()
+  c := r.NewCounter("foo_total", "Foo", "symbol")
+  assert.Panics(t, func() {
+    c.Inc()
+  }, "Missing label value")
+  assert.Panics(t, func() {
+    r.NewGauge("foo_total", "Foo")
+  }, "Registered twice")
+  assert.Panics(t, func() {
+    r.NewHistogram("bar", "Bar", []float64{1, 0.1})
+  })
+}
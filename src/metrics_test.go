@@ -0,0 +1,56 @@
+package main
+
+import (
+  "time"
+  "strings"
+  "testing"
+  "github.com/stretchr/testify/assert"
+  "github.com/shopspring/decimal"
+  "github.com/Kjellemann1/AlgoTrader-Go/metrics"
+)
+
+func TestMarketMetrics(t *testing.T) {
+  a := newAssetTesting()
+  m := NewMarket("crypto", "", map[string]*Asset{"Foo": a})
+  msg := `[{"T":"t","S":"Foo","p":100,"s":1,"t":"2024-07-02T14:00:00Z"}]`
+  assert.Nil(t, m.messageHandler(MarketMessage{[]byte(msg), time.Date(2024, 7, 2, 14, 0, 0, 0, time.UTC).Add(20 * time.Millisecond)}))
+
+  var b strings.Builder
+  assert.Nil(t, metrics.Default.Write(&b))
+  assert.Contains(t, b.String(), `algotrader_market_messages_total{class="crypto",symbol="Foo",type="trade"}`)
+  assert.Contains(t, b.String(), `algotrader_market_latency_seconds_bucket{class="crypto",type="trade",le="0.025"}`)
+}
+
+func TestGauges(t *testing.T) {
+  a := newAssetTesting()
+  a.Class = "crypto"
+  a.C[len(a.C)-1] = 110
+  pos := NewPosition("Foo")
+  pos.Qty = decimal.NewFromInt(2)
+  pos.OpenFilledAvgPrice = 100
+  a.Positions = map[string]*Position{"foo": pos}
+  assets := map[string]map[string]*Asset{"crypto": {"Foo": a}}
+  m := NewMarket("crypto", "", assets["crypto"])
+  db_chan := make(chan *Query, 2)
+  db_chan <- &Query{}
+
+  t.Cleanup(func() {
+    NNP.NoNewPositionsFalse("Test")
+  })
+  NNP.NoNewPositionsTrue("Test")
+
+  r := metrics.NewRegistry()
+  registerGauges(r, assets, map[string]*Market{"crypto": m}, db_chan)
+  var b strings.Builder
+  assert.Nil(t, r.Write(&b))
+  for _, line := range []string{
+    `algotrader_queue_length{queue="database"} 1`,
+    `algotrader_queue_length{queue="market_crypto"} 0`,
+    `algotrader_no_new_positions 1`,
+    `algotrader_no_new_positions_reason{reason="Test"} 1`,
+    `algotrader_open_positions{class="crypto",symbol="Foo"} 1`,
+    `algotrader_unrealized_pnl_usd{class="crypto",symbol="Foo"} 20`,
+  } {
+    assert.Contains(t, b.String(), line + "\n")
+  }
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+  "maps"
   "sync"
   "time"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
 )
 
 var NNP = NewNoNewPositions()
@@ -32,6 +34,13 @@ func (n *NoNewPositions) NoNewPositionsFalse(id string) {
   n.Flag = false
 }
 
+// Every id that has set the flag, and whether it still holds it
+func (n *NoNewPositions) Reasons() map[string]bool {
+  n.rwm.RLock()
+  defer n.rwm.RUnlock()
+  return maps.Clone(n.m)
+}
+
 func NewNoNewPositions() (n *NoNewPositions) {
   n = &NoNewPositions{
     Flag: false,
@@ -43,7 +52,7 @@ func NewNoNewPositions() (n *NoNewPositions) {
 func (n *NoNewPositions) RateLimitSleep() {
   go func() {
     n.NoNewPositionsTrue("RateLimitSleep")
-    time.Sleep(30 * time.Second)
+    time.Sleep(config.C.RateLimitSleep)
     n.NoNewPositionsFalse("RateLimitSleep")
   }()
 }
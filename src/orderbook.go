@@ -0,0 +1,188 @@
+// L2 order book of crypto assets from the orderbooks stream, enabled with
+// crypto_orderbook. Strategies read the depth and imbalance from a.Book, and market
+// opens are refused when the estimated slippage is above max_slippage_pct.
+//
+// Updates are handled by the worker pool and may be applied out of order. Every
+// level therefore keeps the time of its last update, and older updates of a level
+// are ignored. Removed levels are kept with size zero for a while for the same reason.
+
+package main
+
+import (
+  "cmp"
+  "fmt"
+  "maps"
+  "math"
+  "sync"
+  "time"
+  "errors"
+  "slices"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+)
+
+// Removed levels are forgotten when they are older than this relative to the last update
+const bookTombstoneAge = 10 * time.Second
+
+type Level struct {
+  Price  float64
+  Size   float64
+}
+
+type bookLevel struct {
+  size  float64
+  time  time.Time
+}
+
+type OrderBook struct {
+  bids     map[float64]bookLevel
+  asks     map[float64]bookLevel
+  updated  time.Time  // Time of the last update
+  mutex    sync.RWMutex
+}
+
+func newOrderBook() *OrderBook {
+  return &OrderBook{
+    bids: make(map[float64]bookLevel),
+    asks: make(map[float64]bookLevel),
+  }
+}
+
+// Applies an update. A reset replaces the levels that are older than the update,
+// and a level with size zero is removed.
+func (b *OrderBook) apply(bids []Level, asks []Level, reset bool, t time.Time) {
+  b.mutex.Lock()
+  defer b.mutex.Unlock()
+  for _, side := range []struct {
+    book    map[float64]bookLevel
+    levels  []Level
+  }{{b.bids, bids}, {b.asks, asks}} {
+    if reset {
+      maps.DeleteFunc(side.book, func(price float64, l bookLevel) bool {
+        return l.time.Before(t)
+      })
+    }
+    for _, l := range side.levels {
+      if old, ok := side.book[l.Price]; ok && old.time.After(t) {
+        continue
+      }
+      side.book[l.Price] = bookLevel{l.Size, t}
+    }
+  }
+  if t.After(b.updated) {
+    b.updated = t
+    b.prune()
+  }
+}
+
+// Forgets old removed levels. Called with the book locked.
+func (b *OrderBook) prune() {
+  cutoff := b.updated.Add(-bookTombstoneAge)
+  for _, book := range []map[float64]bookLevel{b.bids, b.asks} {
+    maps.DeleteFunc(book, func(price float64, l bookLevel) bool {
+      return l.size == 0 && l.time.Before(cutoff)
+    })
+  }
+}
+
+// Levels with size sorted best first. All levels if n is 0.
+func sortedLevels(book map[float64]bookLevel, descending bool, n int) []Level {
+  levels := make([]Level, 0, len(book))
+  for price, l := range book {
+    if l.size > 0 {
+      levels = append(levels, Level{price, l.size})
+    }
+  }
+  slices.SortFunc(levels, func(x, y Level) int {
+    if descending {
+      return cmp.Compare(y.Price, x.Price)
+    }
+    return cmp.Compare(x.Price, y.Price)
+  })
+  if n > 0 && len(levels) > n {
+    levels = levels[:n]
+  }
+  return levels
+}
+
+// Time of the last update. Zero if the book is nil or has not been received.
+func (b *OrderBook) Updated() time.Time {
+  if b == nil {
+    return time.Time{}
+  }
+  b.mutex.RLock()
+  defer b.mutex.RUnlock()
+  return b.updated
+}
+
+// The n best bid and ask levels, best first. Empty if the book is nil.
+func (b *OrderBook) Depth(n int) (bids []Level, asks []Level) {
+  if b == nil {
+    return nil, nil
+  }
+  b.mutex.RLock()
+  defer b.mutex.RUnlock()
+  return sortedLevels(b.bids, true, n), sortedLevels(b.asks, false, n)
+}
+
+// Bid size minus ask size over their sum for the n best levels, from -1 with only
+// asks to 1 with only bids. NaN if the book is empty.
+func (b *OrderBook) Imbalance(n int) float64 {
+  bids, asks := b.Depth(n)
+  var bid_size, ask_size float64
+  for _, l := range bids {
+    bid_size += l.Size
+  }
+  for _, l := range asks {
+    ask_size += l.Size
+  }
+  if bid_size + ask_size == 0 {
+    return math.NaN()
+  }
+  return (bid_size - ask_size) / (bid_size + ask_size)
+}
+
+// Average fill price of a market order of qty walking the book, and its slippage
+// from the mid price in percent. Side is "buy" or "sell".
+func (b *OrderBook) Slippage(side string, qty float64) (avg_price float64, slippage_pct float64, err error) {
+  bids, asks := b.Depth(0)
+  if len(bids) == 0 || len(asks) == 0 {
+    return 0, 0, errors.New("Order book empty")
+  }
+  mid := (bids[0].Price + asks[0].Price) / 2
+  levels := asks
+  if side == "sell" {
+    levels = bids
+  }
+  remaining := qty
+  cost := 0.0
+  for _, l := range levels {
+    fill := min(remaining, l.Size)
+    cost += fill * l.Price
+    remaining -= fill
+    if remaining <= 0 {
+      break
+    }
+  }
+  if remaining > 0 {
+    return 0, 0, fmt.Errorf("Order book too thin for qty %g", qty)
+  }
+  avg_price = cost / qty
+  return avg_price, math.Abs(avg_price - mid) / mid * 100, nil
+}
+
+// Refuses market orders whose estimated slippage is above max_slippage_pct. Assets
+// without a book are not checked.
+func (a *Asset) slippageCheck(side string, params request.OrderParams, qty float64) error {
+  if a.Book == nil || params.Type != "market" || config.C.MaxSlippagePct == 0 {
+    return nil
+  }
+  _, pct, err := a.Book.Slippage(side, qty)
+  if err != nil {
+    return err
+  }
+  if pct > config.C.MaxSlippagePct {
+    return fmt.Errorf("Estimated slippage %.3f%% above %.3f%%", pct, config.C.MaxSlippagePct)
+  }
+  return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+  "math"
+  "time"
+  "testing"
+  "github.com/stretchr/testify/assert"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+)
+
+func TestOrderBook(t *testing.T) {
+  b := newOrderBook()
+  t0 := time.Date(2024, 7, 2, 14, 0, 0, 0, time.UTC)
+  assert.True(t, math.IsNaN(b.Imbalance(5)))
+
+  b.apply(
+    []Level{{99, 1}, {98, 2}, {97, 3}},
+    []Level{{101, 1}, {102, 1}},
+    true, t0,
+  )
+  bids, asks := b.Depth(2)
+  assert.Equal(t, []Level{{99, 1}, {98, 2}}, bids)
+  assert.Equal(t, []Level{{101, 1}, {102, 1}}, asks)
+  assert.Equal(t, 0.2, b.Imbalance(2))
+
+  // Incremental update removing and adding levels
+  b.apply([]Level{{99, 0}, {100, 1}}, nil, false, t0.Add(2 * time.Second))
+  bids, _ = b.Depth(2)
+  assert.Equal(t, []Level{{100, 1}, {98, 2}}, bids)
+
+  // An older update applied late does not revive the removed level
+  b.apply([]Level{{99, 5}, {96, 1}}, nil, false, t0.Add(time.Second))
+  bids, _ = b.Depth(0)
+  assert.Equal(t, []Level{{100, 1}, {98, 2}, {97, 3}, {96, 1}}, bids)
+
+  // A reset replaces the book
+  b.apply([]Level{{95, 1}}, []Level{{105, 1}}, true, t0.Add(3 * time.Second))
+  bids, asks = b.Depth(0)
+  assert.Equal(t, []Level{{95, 1}}, bids)
+  assert.Equal(t, []Level{{105, 1}}, asks)
+  assert.Equal(t, t0.Add(3 * time.Second), b.Updated())
+
+  var none *OrderBook
+  bids, _ = none.Depth(5)
+  assert.Nil(t, bids)
+}
+
+func TestSlippage(t *testing.T) {
+  b := newOrderBook()
+  b.apply([]Level{{99, 1}}, []Level{{101, 1}, {103, 1}}, true, time.Now())
+
+  avg, pct, err := b.Slippage("buy", 2)
+  assert.Nil(t, err)
+  assert.Equal(t, 102.0, avg)
+  assert.Equal(t, 2.0, pct)
+  _, _, err = b.Slippage("sell", 2)
+  assert.NotNil(t, err, "Too thin")
+
+  saved := *config.C
+  t.Cleanup(func() {
+    *config.C = saved
+  })
+  a := newAssetTesting()
+  assert.Nil(t, a.slippageCheck("buy", IOC, 2), "No book")
+  a.Book = b
+  assert.Nil(t, a.slippageCheck("buy", IOC, 2), "Disabled")
+  config.C.MaxSlippagePct = 1.5
+  assert.NotNil(t, a.slippageCheck("buy", IOC, 2))
+  assert.Nil(t, a.slippageCheck("buy", IOC, 1))
+  assert.Nil(t, a.slippageCheck("buy", request.Limit(101, "gtc"), 2))
+}
+
+func TestOrderbookMessage(t *testing.T) {
+  a := newAssetTesting()
+  a.Book = newOrderBook()
+  m := NewMarket("crypto", "", map[string]*Asset{"Foo": a})
+  msg := `[{"T":"o","S":"Foo","t":"2024-07-02T14:00:00.1Z","b":[{"p":99,"s":1.5}],"a":[{"p":101,"s":2}],"r":true}]`
+  assert.Nil(t, m.messageHandler(MarketMessage{[]byte(msg), time.Now()}))
+  bids, asks := a.Book.Depth(1)
+  assert.Equal(t, []Level{{99, 1.5}}, bids)
+  assert.Equal(t, []Level{{101, 2}}, asks)
+}
@@ -8,6 +8,7 @@ import (
   "sync"
   "github.com/shopspring/decimal"
   "github.com/Kjellemann1/AlgoTrader-Go/util"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
 )
 
 type Position struct {
@@ -21,10 +22,12 @@ type Position struct {
                                  // "client_order_id" in order updates. The strategy name in combination
                                  // with the symbol is unique to the position.
   OpenOrderPending       bool
+  OpenOrderID            string  // Order id assigned by the broker, used to cancel resting orders
   OpenTriggerTime        time.Time 
   OpenSide               string
   OpenOrderType          string
   OpenTriggerPrice       float64
+  OpenNotional           float64  // Value of the open order when sent, counted as exposure until filled
   OpenPriceTime          time.Time
   OpenPriceReceivedTime  time.Time
   OpenFillTime           time.Time
@@ -32,6 +35,9 @@ type Position struct {
 
   CloseOrderPending      bool
   CloseOrderType         string
+  CloseParams            request.OrderParams
+  CloseOrderID           string
+  CloseAttempts          int     // Close orders sent, numbering their client order ids. Saved with the state.
   CloseTriggerTime       time.Time
   CloseTriggerPrice      float64
   CloseFillTime          time.Time
@@ -42,6 +48,10 @@ type Position struct {
   NCloseOrders           int8
   TrailingStopBase       float64
 
+  TakeProfitOrderID      string  // Order ids of the take profit and stop loss legs of a bracket
+  StopLossOrderID        string  // or OCO order. Empty when the position has no such legs.
+  CanceledLegIDs         []string  // Legs canceled to close the position. Their events are ignored.
+
   Rwm                    sync.RWMutex
 }
 
@@ -56,6 +66,54 @@ func NewPosition(symbol string) *Position {
   }
 }
 
+// Pnl of the filled qty at price. Zero before the open is filled. Called with the
+// position locked.
+func (p *Position) unrealized(price float64) float64 {
+  if p.OpenFilledAvgPrice == 0 {
+    return 0
+  }
+  return p.Qty.InexactFloat64() * (price - p.OpenFilledAvgPrice)
+}
+
+// Whether the pending close is a limit or stop order. Positions without close params,
+// like those restored from the database, are treated as closed at market.
+func (p *Position) restingClose() bool {
+  return p.CloseParams.Type != "" && p.CloseParams.Type != "market"
+}
+
+// Returns "take_profit" or "stop_loss" if order_id is one of the legs of the position.
+func (p *Position) legOf(order_id string) string {
+  switch {
+  case order_id == "":
+    return ""
+  case order_id == p.TakeProfitOrderID:
+    return "take_profit"
+  case order_id == p.StopLossOrderID:
+    return "stop_loss"
+  }
+  return ""
+}
+
+func (p *Position) hasLegs() bool {
+  return p.TakeProfitOrderID != "" || p.StopLossOrderID != ""
+}
+
+// Order ids of the legs that are set
+func (p *Position) legIDs() []string {
+  var ids []string
+  for _, order_id := range []string{p.TakeProfitOrderID, p.StopLossOrderID} {
+    if order_id != "" {
+      ids = append(ids, order_id)
+    }
+  }
+  return ids
+}
+
+func (p *Position) clearLegs() {
+  p.TakeProfitOrderID = ""
+  p.StopLossOrderID = ""
+}
+
 func checkTimeNil(t time.Time) *time.Time {
   if t.IsZero() {
     return nil
@@ -65,7 +123,9 @@ func checkTimeNil(t time.Time) *time.Time {
 }
 
 func (p *Position) LogOpen() *Query {
-  util.Open(util.AddWhitespace(p.Symbol, 10) + "\t" + p.StratName)
+  util.Open("Position opened",
+    "Symbol", p.Symbol, "Strat", p.StratName, "Position ID", p.PositionID, "Order ID", p.OpenOrderID,
+  )
 
   return &Query{
     Action: "open",
@@ -83,11 +143,26 @@ func (p *Position) LogOpen() *Query {
     TriggerPrice: p.OpenTriggerPrice,
     FilledAvgPrice: p.OpenFilledAvgPrice,
     BadForAnalysis: p.BadForAnalysis,
+    TakeProfitOrderID: p.TakeProfitOrderID,
+    StopLossOrderID: p.StopLossOrderID,
+  }
+}
+
+// Updates the leg order ids of the position in the database.
+func (p *Position) LogLegs() *Query {
+  return &Query{
+    Action: "legs",
+    Symbol: p.Symbol,
+    StratName: p.StratName,
+    TakeProfitOrderID: p.TakeProfitOrderID,
+    StopLossOrderID: p.StopLossOrderID,
   }
 }
 
 func (p *Position) LogClose() *Query {
-  util.Close(util.AddWhitespace(p.Symbol, 10) + "\t" + p.StratName)
+  util.Close("Position closed",
+    "Symbol", p.Symbol, "Strat", p.StratName, "Position ID", p.PositionID, "Order ID", p.CloseOrderID,
+  )
 
   var side string
   if p.OpenSide == "long" {
@@ -0,0 +1,212 @@
+package push
+
+import (
+  "net"
+  "strings"
+  "time"
+  "net/smtp"
+  "crypto/tls"
+)
+
+// Priorities of the levels. Emergency notifications are repeated every Retry until
+// acknowledged or Expire has passed.
+var pushoverPriorities = []int{-1, 0, 0, 1, 2}
+
+const pushoverURL = "https://api.pushover.net/1/messages.json"
+
+type Pushover struct {
+  Token   string
+  User    string
+  Retry   time.Duration
+  Expire  time.Duration
+  URL     string  // Defaults to the Pushover API
+}
+
+type pushoverPayload struct {
+  Token     string  `json:"token"`
+  User      string  `json:"user"`
+  Title     string  `json:"title"`
+  Message   string  `json:"message"`
+  Priority  int     `json:"priority"`
+  Retry     int     `json:"retry,omitempty"`
+  Expire    int     `json:"expire,omitempty"`
+}
+
+func (p *Pushover) Name() string {
+  return "pushover"
+}
+
+func (p *Pushover) Notify(n Notification) error {
+  payload := pushoverPayload{
+    Token: p.Token,
+    User: p.User,
+    Title: n.Title,
+    Message: n.Message,
+    Priority: pushoverPriorities[n.Level],
+  }
+  if n.Level == LevelEmergency {
+    payload.Retry = int(p.Retry.Seconds())
+    payload.Expire = int(p.Expire.Seconds())
+  }
+  endpoint := p.URL
+  if endpoint == "" {
+    endpoint = pushoverURL
+  }
+  return postJSON(endpoint, payload)
+}
+
+// Slack incoming webhook
+type Slack struct {
+  URL  string
+}
+
+func (s *Slack) Name() string {
+  return "slack"
+}
+
+func (s *Slack) Notify(n Notification) error {
+  return postJSON(s.URL, map[string]string{"text": "*" + n.Title + "*\n" + n.Message})
+}
+
+// Discord webhook
+type Discord struct {
+  URL  string
+}
+
+func (d *Discord) Name() string {
+  return "discord"
+}
+
+func (d *Discord) Notify(n Notification) error {
+  return postJSON(d.URL, map[string]string{"content": "**" + n.Title + "**\n" + n.Message})
+}
+
+const telegramAPI = "https://api.telegram.org"
+
+// Telegram bot sending to a chat
+type Telegram struct {
+  Token   string
+  ChatID  string
+  API     string  // Defaults to the Telegram bot API
+}
+
+func (t *Telegram) Name() string {
+  return "telegram"
+}
+
+func (t *Telegram) Notify(n Notification) error {
+  api := t.API
+  if api == "" {
+    api = telegramAPI
+  }
+  return postJSON(api + "/bot" + t.Token + "/sendMessage", map[string]string{
+    "chat_id": t.ChatID,
+    "text": n.Title + "\n" + n.Message,
+  })
+}
+
+// Posts the notification as JSON to any endpoint
+type Webhook struct {
+  URL  string
+}
+
+type webhookPayload struct {
+  Time       time.Time  `json:"time"`
+  Level      string     `json:"level"`
+  Component  string     `json:"component"`
+  Title      string     `json:"title"`
+  Message    string     `json:"message"`
+}
+
+func (w *Webhook) Name() string {
+  return "webhook"
+}
+
+func (w *Webhook) Notify(n Notification) error {
+  return postJSON(w.URL, webhookPayload{
+    Time: time.Now().UTC(),
+    Level: n.Level.String(),
+    Component: n.Component,
+    Title: n.Title,
+    Message: n.Message,
+  })
+}
+
+var sendMail = sendMailTimeout
+
+// As smtp.SendMail, but bounded by the timeout of httpClient so that a hung server
+// can not hold up the queue
+func sendMailTimeout(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+  timeout := httpClient.Timeout
+  conn, err := net.DialTimeout("tcp", addr, timeout)
+  if err != nil {
+    return err
+  }
+  defer conn.Close()
+  if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+    return err
+  }
+  host, _, _ := strings.Cut(addr, ":")
+  c, err := smtp.NewClient(conn, host)
+  if err != nil {
+    return err
+  }
+  defer c.Close()
+  if ok, _ := c.Extension("STARTTLS"); ok {
+    if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+      return err
+    }
+  }
+  if a != nil {
+    if err := c.Auth(a); err != nil {
+      return err
+    }
+  }
+  if err := c.Mail(from); err != nil {
+    return err
+  }
+  for _, rcpt := range to {
+    if err := c.Rcpt(rcpt); err != nil {
+      return err
+    }
+  }
+  w, err := c.Data()
+  if err != nil {
+    return err
+  }
+  if _, err := w.Write(msg); err != nil {
+    return err
+  }
+  if err := w.Close(); err != nil {
+    return err
+  }
+  return c.Quit()
+}
+
+// Email sent through an SMTP server. Authenticates if User is set.
+type Email struct {
+  Addr      string  // host:port
+  User      string
+  Password  string
+  From      string
+  To        []string
+}
+
+func (e *Email) Name() string {
+  return "email"
+}
+
+func (e *Email) Notify(n Notification) error {
+  var auth smtp.Auth
+  if e.User != "" {
+    host, _, _ := strings.Cut(e.Addr, ":")
+    auth = smtp.PlainAuth("", e.User, e.Password, host)
+  }
+  msg := "From: " + e.From + "\r\n" +
+    "To: " + strings.Join(e.To, ", ") + "\r\n" +
+    "Subject: [AlgoTrader] " + n.Title + "\r\n" +
+    "Content-Type: text/plain; charset=utf-8\r\n" +
+    "\r\n" +
+    strings.ReplaceAll(n.Message, "\n", "\r\n") + "\r\n"
+  return sendMail(e.Addr, auth, e.From, e.To, []byte(msg))
+}
@@ -1,72 +1,166 @@
+// Package push sends notifications through the backends that are routed to with
+// SetRoutes. Each route sends the notifications at or above its level, and can be
+// limited to the components they are sent from. Once StartQueue is called they are
+// sent from a queue, see QueueOptions.
+
 package push
 
 import (
   "fmt"
   "log"
+  "sync"
+  "time"
   "bytes"
+  "errors"
+  "slices"
+  "net/url"
   "net/http"
+  "encoding/json"
+)
+
+type Level int
 
-  "github.com/Kjellemann1/AlgoTrader-Go/constant"
+const (
+  LevelInfo Level = iota
+  LevelMessage
+  LevelWarning
+  LevelError
+  LevelEmergency  // Repeated until acknowledged by backends that support it
 )
 
-var httpClient = &http.Client{}
-
-func push(message string, title string, prio int) {
-  url := "https://api.pushover.net/1/messages.json"
-  var payload string
-  if prio == 2 {
-    payload = `{` +
-      `"token": "` + constant.PUSH_TOKEN + `",` +
-      `"user": "` + constant.PUSH_USER + `",` +
-      `"title": "` + title + `",` +
-      `"message": "` + message + `",` +
-      `"priority": ` + fmt.Sprint(prio) + `,` +
-      `"expire": 3600,` +
-      `"retry": 60` +
-    `}`
-  } else {
-    payload = `{` +
-      `"token": "` + constant.PUSH_TOKEN + `",` +
-      `"user": "` + constant.PUSH_USER + `",` +
-      `"title": "` + title + `",` +
-      `"message": "` + message + `",` +
-      `"priority": ` + fmt.Sprint(prio) +
-    `}`
+// Names of the levels, in order
+var Levels = []string{"info", "message", "warning", "error", "emergency"}
+
+// Default titles of the levels
+var titles = []string{"UPDATE", "MESSAGE", "WARNING", "ERROR", "EMERGENCY"}
+
+func (l Level) String() string {
+  if l < 0 || int(l) >= len(Levels) {
+    return fmt.Sprintf("level(%d)", int(l))
   }
-  response, err := httpClient.Post(url, "application/json", bytes.NewBuffer([]byte(payload)))
-  if err != nil {
-    log.Printf(
-      "[ WARNING ]\tError making POST request\n  -> Error: %s\n  -> Response status: %s\n Payload: %s", err, response.Status, payload)
-    return
+  return Levels[l]
+}
+
+func ParseLevel(name string) (Level, error) {
+  i := slices.Index(Levels, name)
+  if i < 0 {
+    return 0, fmt.Errorf("Unknown notification level %q", name)
   }
-  defer response.Body.Close()
-  if response.StatusCode != http.StatusOK {
-    log.Printf(
-      "[ WARNING ]\tFailed to send push notification\n  -> Response status: %s\n  -> Payload: %s\n", response.Status, payload)
+  return Level(i), nil
+}
+
+type Notification struct {
+  Level      Level
+  Component  string  // Component of the trader it is sent from, as in the logs
+  Title      string
+  Message    string
+}
+
+type Notifier interface {
+  Name() string
+  Notify(n Notification) error
+}
+
+// Sends the notifications at or above Level to Notifier. Components limits them to
+// those sent from the listed components if not empty.
+type Route struct {
+  Notifier    Notifier
+  Level       Level
+  Components  []string
+}
+
+func (r Route) matches(n Notification) bool {
+  return n.Level >= r.Level && (len(r.Components) == 0 || slices.Contains(r.Components, n.Component))
+}
+
+var (
+  routes []Route
+  routesMutex sync.RWMutex
+)
+
+// Replaces the routes. Nothing is sent until routes are set.
+func SetRoutes(list []Route) {
+  routesMutex.Lock()
+  defer routesMutex.Unlock()
+  routes = list
+}
+
+// Sends n through every matching route, from the queue if started. Failures are
+// logged.
+func Send(n Notification) {
+  if n.Title == "" && n.Level >= 0 && int(n.Level) < len(titles) {
+    n.Title = titles[n.Level]
+  }
+  if !enqueue(n) {
+    deliver(n)
   }
 }
 
-func Info(message string) {
-  push(message, "UPDATE", -1)
+func deliver(n Notification) {
+  routesMutex.RLock()
+  list := routes
+  routesMutex.RUnlock()
+  for _, r := range list {
+    if !r.matches(n) {
+      continue
+    }
+    if err := r.Notifier.Notify(n); err != nil {
+      log.Printf("[ WARNING ]\tFailed to send notification\n  -> Backend: %s\n  -> Error: %v\n", r.Notifier.Name(), err)
+    }
+  }
+}
+
+func Info(component string, message string) {
+  Send(Notification{Level: LevelInfo, Component: component, Message: message})
+}
+
+func Message(component string, message string) {
+  Send(Notification{Level: LevelMessage, Component: component, Message: message})
 }
 
-func Message(message string) {  // Change to 0
-  push(message, "MESSAGE", 0)
+func Warning(component string, message string) {
+  Send(Notification{Level: LevelWarning, Component: component, Message: message})
 }
 
-func Warning(message string) {
-  push(message, "WARNING", 0)  // Change to 1
+func Error(component string, message string) {
+  Send(Notification{Level: LevelError, Component: component, Message: message})
 }
 
-func Error(message string) {
-  push(message, "ERROR", 0)  // Change to 2
+// For events that need action at once, like closing all positions
+func Emergency(component string, message string) {
+  Send(Notification{Level: LevelEmergency, Component: component, Message: message})
+}
+
+// Bounds how long a backend can hold up the queue
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Posts payload as JSON, and fails on statuses other than 2xx
+func postJSON(endpoint string, payload any) error {
+  body, err := json.Marshal(payload)
+  if err != nil {
+    return err
+  }
+  response, err := httpClient.Post(endpoint, "application/json", bytes.NewReader(body))
+  if err != nil {
+    // The url can hold a token
+    var url_err *url.Error
+    if errors.As(err, &url_err) {
+      return url_err.Err
+    }
+    return err
+  }
+  defer response.Body.Close()
+  if response.StatusCode < 200 || response.StatusCode > 299 {
+    return fmt.Errorf("Response status %s", response.Status)
+  }
+  return nil
 }
 
 // Everything below this line is for testing purposes
 func DisablePush() {
   httpClient = &http.Client{
     Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
-      return &http.Response{ StatusCode: 200 }, nil
+      return &http.Response{ StatusCode: 200, Body: http.NoBody }, nil
     }),
   }
 }
@@ -0,0 +1,160 @@
+package push
+
+import (
+  "io"
+  "net"
+  "time"
+  "errors"
+  "testing"
+  "net/http"
+  "net/smtp"
+  "encoding/json"
+  "github.com/stretchr/testify/assert"
+)
+
+// Records the requests sent through httpClient
+func withRequests(t *testing.T, status int) *[]*http.Request {
+  saved := httpClient
+  t.Cleanup(func() {
+    httpClient = saved
+  })
+  var list []*http.Request
+  httpClient = &http.Client{
+    Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+      list = append(list, req)
+      return &http.Response{StatusCode: status, Status: http.StatusText(status), Body: http.NoBody}, nil
+    }),
+  }
+  return &list
+}
+
+func body(t *testing.T, req *http.Request) map[string]any {
+  data, err := io.ReadAll(req.Body)
+  assert.Nil(t, err)
+  var m map[string]any
+  assert.Nil(t, json.Unmarshal(data, &m))
+  return m
+}
+
+type recorder struct {
+  sent  []Notification
+  err   error
+}
+
+func (r *recorder) Name() string {
+  return "recorder"
+}
+
+func (r *recorder) Notify(n Notification) error {
+  r.sent = append(r.sent, n)
+  return r.err
+}
+
+func TestPushover(t *testing.T) {
+  requests := withRequests(t, 200)
+  p := &Pushover{Token: "token", User: "user", Retry: time.Minute, Expire: time.Hour}
+
+  assert.Nil(t, p.Notify(Notification{Level: LevelWarning, Title: "WARNING", Message: "Order \"abc\" failed\n  -> Status: 403"}))
+  m := body(t, (*requests)[0])
+  assert.Equal(t, "Order \"abc\" failed\n  -> Status: 403", m["message"])
+  assert.Equal(t, 0.0, m["priority"])
+  assert.NotContains(t, m, "retry")
+
+  assert.Nil(t, p.Notify(Notification{Level: LevelEmergency, Title: "EMERGENCY", Message: "CLOSING ALL POSITIONS"}))
+  m = body(t, (*requests)[1])
+  assert.Equal(t, 2.0, m["priority"])
+  assert.Equal(t, 60.0, m["retry"])
+  assert.Equal(t, 3600.0, m["expire"])
+  assert.Equal(t, pushoverURL, (*requests)[1].URL.String())
+}
+
+func TestBackends(t *testing.T) {
+  requests := withRequests(t, 200)
+  n := Notification{Level: LevelError, Component: "account", Title: "ERROR", Message: "Lost connection"}
+
+  assert.Nil(t, (&Slack{URL: "http://slack"}).Notify(n))
+  assert.Equal(t, "*ERROR*\nLost connection", body(t, (*requests)[0])["text"])
+  assert.Nil(t, (&Discord{URL: "http://discord"}).Notify(n))
+  assert.Equal(t, "**ERROR**\nLost connection", body(t, (*requests)[1])["content"])
+  assert.Nil(t, (&Telegram{Token: "123:abc", ChatID: "42", API: "http://telegram"}).Notify(n))
+  assert.Equal(t, "http://telegram/bot123:abc/sendMessage", (*requests)[2].URL.String())
+  assert.Equal(t, "42", body(t, (*requests)[2])["chat_id"])
+  assert.Nil(t, (&Webhook{URL: "http://hook"}).Notify(n))
+  m := body(t, (*requests)[3])
+  assert.Equal(t, "error", m["level"])
+  assert.Equal(t, "account", m["component"])
+
+  withRequests(t, 500)
+  assert.ErrorContains(t, (&Slack{URL: "http://slack"}).Notify(n), "Response status")
+}
+
+func TestEmail(t *testing.T) {
+  saved := sendMail
+  t.Cleanup(func() {
+    sendMail = saved
+  })
+  var msg string
+  var to []string
+  sendMail = func(addr string, a smtp.Auth, from string, rcpt []string, data []byte) error {
+    assert.Equal(t, "smtp.example.com:587", addr)
+    assert.NotNil(t, a)
+    to = rcpt
+    msg = string(data)
+    return nil
+  }
+  e := &Email{Addr: "smtp.example.com:587", User: "u", Password: "p", From: "algo@example.com", To: []string{"me@example.com"}}
+  assert.Nil(t, e.Notify(Notification{Level: LevelError, Title: "ERROR", Message: "a\nb"}))
+  assert.Equal(t, []string{"me@example.com"}, to)
+  assert.Contains(t, msg, "Subject: [AlgoTrader] ERROR\r\n")
+  assert.Contains(t, msg, "\r\n\r\na\r\nb\r\n")
+}
+
+func TestEmailTimeout(t *testing.T) {
+  saved := httpClient
+  httpClient = &http.Client{Timeout: 50 * time.Millisecond}
+  t.Cleanup(func() {
+    httpClient = saved
+  })
+  // Accepts and never greets
+  l, err := net.Listen("tcp", "127.0.0.1:0")
+  assert.Nil(t, err)
+  defer l.Close()
+  go func() {
+    conn, err := l.Accept()
+    if err == nil {
+      defer conn.Close()
+      time.Sleep(time.Second)
+    }
+  }()
+  start := time.Now()
+  assert.NotNil(t, sendMailTimeout(l.Addr().String(), nil, "a@example.com", []string{"b@example.com"}, []byte("x")))
+  assert.Less(t, time.Since(start), 500 * time.Millisecond)
+}
+
+func TestRoutes(t *testing.T) {
+  t.Cleanup(func() {
+    SetRoutes(nil)
+  })
+  all := &recorder{}
+  market := &recorder{err: errors.New("Down")}
+  SetRoutes([]Route{
+    {Notifier: all, Level: LevelInfo},
+    {Notifier: market, Level: LevelWarning, Components: []string{"market"}},
+  })
+
+  Info("market", "Connected")
+  Warning("account", "Reconnecting")
+  Emergency("market", "Closing all positions")
+
+  assert.Len(t, all.sent, 3)
+  assert.Equal(t, "UPDATE", all.sent[0].Title)
+  assert.Len(t, market.sent, 1)
+  assert.Equal(t, LevelEmergency, market.sent[0].Level)
+  assert.Equal(t, "EMERGENCY", market.sent[0].Title)
+
+  level, err := ParseLevel("warning")
+  assert.Nil(t, err)
+  assert.Equal(t, LevelWarning, level)
+  _, err = ParseLevel("critical")
+  assert.NotNil(t, err)
+}
@@ -0,0 +1,248 @@
+package push
+
+import (
+  "fmt"
+  "sort"
+  "sync"
+  "time"
+  "strings"
+  "sync/atomic"
+)
+
+// Sends notifications from a bounded queue on its own goroutine, so that callers
+// are never held up by the backends. Identical notifications within DedupWindow are
+// sent once, followed by a count of the repeats when the window ends. Info
+// notifications are collected and sent as one digest per component every
+// DigestInterval. Errors and emergencies are sent first, and are never deduplicated
+// or dropped. Call Flush before exiting so that they are not lost.
+type QueueOptions struct {
+  Size            int            // Notifications waiting to be sent. Others are dropped and counted.
+  DedupWindow     time.Duration  // 0 sends every notification
+  DigestInterval  time.Duration  // 0 sends info notifications as they come
+}
+
+// How often repeats, dropped notifications and the digest are checked for
+var queueTick = time.Second
+
+// Most notifications listed in a digest. The rest are counted.
+const digestMax = 20
+
+// Longest Flush waits for the errors and emergencies to be sent
+var flushTimeout = 30 * time.Second
+
+type repeats struct {
+  until  time.Time
+  count  int
+}
+
+type notifyQueue struct {
+  opts         QueueOptions
+  in           chan Notification
+  dropped      atomic.Int64
+  // Errors and emergencies, unbounded. wake is signaled when they are added.
+  priority     []Notification
+  priority_mx  sync.Mutex
+  wake         chan struct{}
+  flushes      chan chan struct{}
+  // Only used by run
+  seen         map[Notification]*repeats
+  digest       []Notification
+  next_digest  time.Time
+}
+
+var (
+  queue *notifyQueue
+  queueMutex sync.RWMutex
+)
+
+func newQueue(opts QueueOptions, now time.Time) *notifyQueue {
+  return &notifyQueue{
+    opts: opts,
+    in: make(chan Notification, max(opts.Size, 1)),
+    wake: make(chan struct{}, 1),
+    flushes: make(chan chan struct{}),
+    seen: make(map[Notification]*repeats),
+    next_digest: now.Add(opts.DigestInterval),
+  }
+}
+
+// Starts the queue. Notifications are sent by the caller until it is started and
+// after it is stopped. The returned func sends what is left, including pending
+// repeat counts and the digest, and stops it.
+func StartQueue(opts QueueOptions) (stop func()) {
+  q := newQueue(opts, time.Now())
+  done := make(chan struct{})
+  go q.run(done)
+  queueMutex.Lock()
+  queue = q
+  queueMutex.Unlock()
+  return func() {
+    queueMutex.Lock()
+    queue = nil
+    close(q.in)
+    queueMutex.Unlock()
+    <-done
+  }
+}
+
+// Queues n, or returns false if it must be sent by the caller
+func enqueue(n Notification) bool {
+  queueMutex.RLock()
+  defer queueMutex.RUnlock()
+  if queue == nil {
+    return false
+  }
+  if n.Level >= LevelError {
+    queue.priority_mx.Lock()
+    queue.priority = append(queue.priority, n)
+    queue.priority_mx.Unlock()
+    select {
+    case queue.wake <- struct{}{}:
+    default:
+    }
+    return true
+  }
+  select {
+  case queue.in <- n:
+  default:
+    queue.dropped.Add(1)
+  }
+  return true
+}
+
+// Waits until the errors and emergencies queued so far are sent, or flushTimeout
+// has passed. Returns at once if the queue is not started.
+func Flush() {
+  queueMutex.RLock()
+  q := queue
+  queueMutex.RUnlock()
+  if q == nil {
+    return
+  }
+  done := make(chan struct{})
+  select {
+  case q.flushes <- done:
+  case <-time.After(flushTimeout):
+    return
+  }
+  select {
+  case <-done:
+  case <-time.After(flushTimeout):
+  }
+}
+
+func (q *notifyQueue) run(done chan struct{}) {
+  defer close(done)
+  ticker := time.NewTicker(queueTick)
+  defer ticker.Stop()
+  for {
+    q.sendPriority()
+    select {
+    case <-q.wake:
+    case flushed := <-q.flushes:
+      q.sendPriority()
+      close(flushed)
+    case n, ok := <-q.in:
+      if !ok {
+        q.sendPriority()
+        q.flush(time.Now(), true)
+        return
+      }
+      q.handle(n, time.Now())
+    case <-ticker.C:
+      q.flush(time.Now(), false)
+    }
+  }
+}
+
+// Sends the queued errors and emergencies
+func (q *notifyQueue) sendPriority() {
+  q.priority_mx.Lock()
+  list := q.priority
+  q.priority = nil
+  q.priority_mx.Unlock()
+  for _, n := range list {
+    deliver(n)
+  }
+}
+
+func (q *notifyQueue) handle(n Notification, now time.Time) {
+  if r, ok := q.seen[n]; ok {
+    r.count++
+    return
+  }
+  if q.opts.DedupWindow > 0 {
+    q.seen[n] = &repeats{until: now.Add(q.opts.DedupWindow)}
+  }
+  q.forward(n)
+}
+
+// Sends n, or adds it to the digest
+func (q *notifyQueue) forward(n Notification) {
+  if n.Level == LevelInfo && q.opts.DigestInterval > 0 {
+    q.digest = append(q.digest, n)
+    return
+  }
+  deliver(n)
+}
+
+// Sends the repeat counts of the windows that have ended, the number of dropped
+// notifications and the digest when due. all sends everything pending.
+func (q *notifyQueue) flush(now time.Time, all bool) {
+  for n, r := range q.seen {
+    if !all && now.Before(r.until) {
+      continue
+    }
+    delete(q.seen, n)
+    if r.count > 0 {
+      n.Message += fmt.Sprintf("\n  -> Repeated %d more times within %s", r.count, q.opts.DedupWindow)
+      q.forward(n)
+    }
+  }
+
+  if dropped := q.dropped.Swap(0); dropped > 0 {
+    deliver(Notification{
+      Level: LevelWarning,
+      Component: "main",
+      Title: titles[LevelWarning],
+      Message: fmt.Sprintf("Dropped %d notifications with the queue full", dropped),
+    })
+  }
+
+  if all || !now.Before(q.next_digest) {
+    q.sendDigest()
+    q.next_digest = now.Add(q.opts.DigestInterval)
+  }
+}
+
+// Sends the collected info notifications, one digest per component
+func (q *notifyQueue) sendDigest() {
+  by_component := make(map[string][]string)
+  for _, n := range q.digest {
+    by_component[n.Component] = append(by_component[n.Component], n.Message)
+  }
+  q.digest = nil
+
+  components := make([]string, 0, len(by_component))
+  for component := range by_component {
+    components = append(components, component)
+  }
+  sort.Strings(components)
+  for _, component := range components {
+    messages := by_component[component]
+    text := fmt.Sprintf("%d updates", len(messages))
+    for i, msg := range messages {
+      if i == digestMax {
+        text += fmt.Sprintf("\n\n... and %d more", len(messages) - digestMax)
+        break
+      }
+      text += "\n\n" + msg
+    }
+    deliver(Notification{
+      Level: LevelInfo,
+      Component: component,
+      Title: "DIGEST",
+      Message: strings.TrimSpace(text),
+    })
+  }
+}
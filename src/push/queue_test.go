@@ -0,0 +1,104 @@
+package push
+
+import (
+  "time"
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+func withRecorder(t *testing.T) *recorder {
+  t.Cleanup(func() {
+    SetRoutes(nil)
+  })
+  r := &recorder{}
+  SetRoutes([]Route{{Notifier: r, Level: LevelInfo}})
+  return r
+}
+
+func TestDedup(t *testing.T) {
+  r := withRecorder(t)
+  now := time.Date(2024, 7, 2, 14, 0, 0, 0, time.UTC)
+  q := newQueue(QueueOptions{Size: 10, DedupWindow: time.Minute}, now)
+
+  n := Notification{Level: LevelWarning, Component: "market", Title: "WARNING", Message: "Reconnecting"}
+  for i := 0; i < 4; i++ {
+    q.handle(n, now.Add(time.Duration(i) * time.Second))
+  }
+  q.handle(Notification{Level: LevelWarning, Component: "account", Title: "WARNING", Message: "Reconnecting"}, now)
+  assert.Len(t, r.sent, 2)
+
+  q.flush(now.Add(30 * time.Second), false)
+  assert.Len(t, r.sent, 2)
+  q.flush(now.Add(time.Minute), false)
+  assert.Len(t, r.sent, 3)
+  assert.Equal(t, "Reconnecting\n  -> Repeated 3 more times within 1m0s", r.sent[2].Message)
+  assert.Equal(t, "market", r.sent[2].Component)
+
+  // A new window
+  q.handle(n, now.Add(2 * time.Minute))
+  assert.Len(t, r.sent, 4)
+}
+
+func TestDigest(t *testing.T) {
+  r := withRecorder(t)
+  now := time.Date(2024, 7, 2, 14, 0, 0, 0, time.UTC)
+  q := newQueue(QueueOptions{Size: 10, DigestInterval: 15 * time.Minute}, now)
+
+  q.handle(Notification{Level: LevelInfo, Component: "strategy", Message: "Opened"}, now)
+  q.handle(Notification{Level: LevelInfo, Component: "strategy", Message: "Closed"}, now)
+  q.handle(Notification{Level: LevelInfo, Component: "market", Message: "Connected"}, now)
+  q.handle(Notification{Level: LevelError, Component: "market", Message: "Lost"}, now)
+  assert.Len(t, r.sent, 1)
+
+  q.flush(now.Add(15 * time.Minute), false)
+  assert.Len(t, r.sent, 3)
+  assert.Equal(t, "DIGEST", r.sent[1].Title)
+  assert.Equal(t, "market", r.sent[1].Component)
+  assert.Equal(t, "2 updates\n\nOpened\n\nClosed", r.sent[2].Message)
+}
+
+func TestQueue(t *testing.T) {
+  r := withRecorder(t)
+  stop := StartQueue(QueueOptions{Size: 1, DedupWindow: time.Hour, DigestInterval: time.Hour})
+
+  Emergency("account", "Closing all positions")
+  for i := 0; i < 100; i++ {
+    Warning("market", "Rate limit exceeded")
+  }
+  Info("strategy", "Opened")
+  // Neither deduplicated nor dropped with the queue full
+  for i := 0; i < 50; i++ {
+    Error("account", "Lost connection")
+  }
+  Flush()
+  stop()
+
+  // The flood of warnings is collapsed into the first one, a count of the repeats
+  // or the dropped, and the digest
+  levels := make(map[Level]int)
+  var dropped, digest bool
+  for _, n := range r.sent {
+    levels[n.Level]++
+    dropped = dropped || n.Title == "WARNING" && n.Component == "main"
+    digest = digest || n.Title == "DIGEST"
+  }
+  assert.Equal(t, 1, levels[LevelEmergency])
+  assert.Equal(t, 50, levels[LevelError])
+  assert.LessOrEqual(t, levels[LevelWarning], 3)
+  assert.True(t, dropped || digest)
+
+  // Sent by the caller once stopped
+  Warning("market", "After stop")
+  assert.Equal(t, "After stop", r.sent[len(r.sent)-1].Message)
+}
+
+func TestFlush(t *testing.T) {
+  r := withRecorder(t)
+  stop := StartQueue(QueueOptions{Size: 10})
+  defer stop()
+
+  Error("database", "Max retries reached")
+  Flush()
+  assert.Len(t, r.sent, 1)
+  assert.Equal(t, LevelError, r.sent[0].Level)
+}
@@ -0,0 +1,97 @@
+// Latest bid and ask of the assets from the quote stream. Strategies read the spread
+// through Quote, Spread and SpreadPct, and market opens are refused when the spread is
+// wider than max_spread_pct_stock or max_spread_pct_crypto.
+
+package main
+
+import (
+  "fmt"
+  "math"
+  "time"
+  "errors"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+)
+
+type Quote struct {
+  Bid      float64
+  BidSize  float64
+  Ask      float64
+  AskSize  float64
+  Time     time.Time
+}
+
+// Whether both sides are quoted and not crossed
+func (q Quote) valid() bool {
+  return q.Bid > 0 && q.Ask > 0 && q.Ask >= q.Bid
+}
+
+// Mid price. NaN if the quote is not valid.
+func (q Quote) Mid() float64 {
+  if !q.valid() {
+    return math.NaN()
+  }
+  return (q.Bid + q.Ask) / 2
+}
+
+// Spread in price. NaN if the quote is not valid.
+func (q Quote) Spread() float64 {
+  if !q.valid() {
+    return math.NaN()
+  }
+  return q.Ask - q.Bid
+}
+
+// Spread in percent of the mid price. NaN if the quote is not valid.
+func (q Quote) SpreadPct() float64 {
+  return q.Spread() / q.Mid() * 100
+}
+
+func (a *Asset) updateQuote(q Quote) {
+  a.Rwm.Lock()
+  defer a.Rwm.Unlock()
+  // Quotes of the workers may arrive out of order
+  if q.Time.Before(a.quote.Time) {
+    return
+  }
+  a.quote = q
+}
+
+// Latest quote. Zero if no quote has been received.
+func (a *Asset) Quote() Quote {
+  a.Rwm.RLock()
+  defer a.Rwm.RUnlock()
+  return a.quote
+}
+
+func (a *Asset) Spread() float64 {
+  return a.Quote().Spread()
+}
+
+func (a *Asset) SpreadPct() float64 {
+  return a.Quote().SpreadPct()
+}
+
+func maxSpreadPct(asset_class string) float64 {
+  if asset_class == "crypto" {
+    return config.C.MaxSpreadPctCrypto
+  }
+  return config.C.MaxSpreadPctStock
+}
+
+// Refuses market orders when the spread is too wide or unknown. Other order types
+// set their own price and are not checked.
+func (a *Asset) spreadCheck(params request.OrderParams, now time.Time) error {
+  limit := maxSpreadPct(a.Class)
+  if params.Type != "market" || limit == 0 {
+    return nil
+  }
+  q := a.Quote()
+  if !q.valid() || now.Sub(q.Time) > config.C.MaxQuoteAge {
+    return errors.New("No recent quote")
+  }
+  if pct := q.SpreadPct(); pct > limit {
+    return fmt.Errorf("Spread %.3f%% above %.3f%%", pct, limit)
+  }
+  return nil
+}
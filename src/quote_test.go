@@ -0,0 +1,60 @@
+package main
+
+import (
+  "math"
+  "time"
+  "testing"
+  "github.com/stretchr/testify/assert"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+)
+
+func TestQuoteMessage(t *testing.T) {
+  a := newAssetTesting()
+  m := NewMarket("crypto", "", map[string]*Asset{"Foo": a})
+  assert.True(t, math.IsNaN(a.SpreadPct()))
+
+  msg := `[{"T":"q","S":"Foo","bp":99.5,"bs":2,"ap":100.5,"as":3,"t":"2024-07-02T14:00:00.123456Z"}]`
+  assert.Nil(t, m.messageHandler(MarketMessage{[]byte(msg), time.Now()}))
+  q := a.Quote()
+  assert.Equal(t, 99.5, q.Bid)
+  assert.Equal(t, 3.0, q.AskSize)
+  assert.Equal(t, 1.0, a.Spread())
+  assert.Equal(t, 1.0, a.SpreadPct())
+
+  // Older quotes are dropped
+  msg = `[{"T":"q","S":"Foo","bp":90,"bs":2,"ap":110,"as":3,"t":"2024-07-02T13:59:59Z"}]`
+  m.messageHandler(MarketMessage{[]byte(msg), time.Now()})
+  assert.Equal(t, 99.5, a.Quote().Bid)
+
+  // Crossed
+  assert.True(t, math.IsNaN(Quote{Bid: 2, Ask: 1}.Spread()))
+}
+
+func TestSpreadCheck(t *testing.T) {
+  saved := *config.C
+  t.Cleanup(func() {
+    *config.C = saved
+  })
+  a := newAssetTesting()
+  a.Class = "crypto"
+  now := time.Date(2024, 7, 2, 14, 0, 0, 0, time.UTC)
+  market := request.Market("gtc")
+
+  assert.Nil(t, a.spreadCheck(market, now), "Disabled")
+
+  config.C.MaxSpreadPctCrypto = 0.5
+  assert.NotNil(t, a.spreadCheck(market, now), "No quote")
+
+  a.updateQuote(Quote{Bid: 99.9, Ask: 100.1, Time: now.Add(-time.Second)})
+  assert.Nil(t, a.spreadCheck(market, now))
+  assert.NotNil(t, a.spreadCheck(market, now.Add(2 * time.Minute)), "Stale quote")
+
+  a.updateQuote(Quote{Bid: 99, Ask: 101, Time: now})
+  assert.NotNil(t, a.spreadCheck(market, now))
+  assert.Nil(t, a.spreadCheck(request.Limit(100, "gtc"), now), "Limit orders set their own price")
+
+  // Stocks have their own threshold
+  a.Class = "stock"
+  assert.Nil(t, a.spreadCheck(market, now))
+}
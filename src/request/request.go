@@ -4,20 +4,25 @@ import (
   "io"
   "log"
   "strings"
+  "strconv"
   "time"
   "errors"
   "fmt"
   "net/http"
+  "encoding/json"
   "github.com/valyala/fastjson"
   "github.com/shopspring/decimal"
   "github.com/Kjellemann1/AlgoTrader-Go/constant"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/sizing"
+  "github.com/Kjellemann1/AlgoTrader-Go/metrics"
   "github.com/Kjellemann1/AlgoTrader-Go/util"
 )
 
 var p = fastjson.Parser{}
 
 var HttpClient = &http.Client{
-  Timeout: constant.HTTP_TIMEOUT_SEC,
+  Timeout: config.C.HTTPTimeout,
   Transport: &http.Transport{
     MaxIdleConns: 100,
     MaxIdleConnsPerHost: 50,
@@ -69,18 +74,27 @@ func parseBody(body []byte) ([]*fastjson.Value, error) {
   return arr, nil
 }
 
+var (
+  orderSendSeconds = metrics.NewHistogram("algotrader_order_send_seconds", "Time from sending an order to the response", metrics.LatencyBuckets)
+  orderResponses = metrics.NewCounter("algotrader_order_responses_total", "Order responses by HTTP status, or error if there was no response", "status")
+)
+
 func SendOrder(payload string) (string, int, error) {
-  url := constant.ENDPOINT + "/orders"
+  url := config.C.Endpoint + "/orders"
   request, err := http.NewRequest("POST", url, strings.NewReader(payload))
   if err != nil {
     util.Error(err, "Request", request)
     return "", 0, err
   }
   request.Header = constant.AUTH_HEADERS
+  sent := time.Now()
   response, err := HttpClient.Do(request)
+  orderSendSeconds.Observe(time.Since(sent).Seconds())
   if err != nil {
+    orderResponses.Inc("error")
     return "", 0, err
   }
+  orderResponses.Inc(strconv.Itoa(response.StatusCode))
   defer response.Body.Close()
   body_bytes, err := io.ReadAll(response.Body)
   if err != nil {
@@ -90,24 +104,321 @@ func SendOrder(payload string) (string, int, error) {
   return string(body_bytes), response.StatusCode, nil
 }
 
-func CalculateOpenQty(asset_class string, last_price float64) decimal.Decimal {
-  qty, _ := decimal.NewFromString("0")
-  if asset_class == "stock" {
-    qty = decimal.NewFromFloat(constant.NOTIONAL_USD / last_price).RoundDown(0)
-    if qty.Cmp(decimal.NewFromInt(1)) == -1 {
-      qty = decimal.NewFromInt(0)
+// Order type and time in force of an order. Prices are ignored for order types
+// that do not use them.
+type OrderParams struct {
+  Type           string   // "market", "limit", "stop" or "stop_limit"
+  TimeInForce    string   // "day", "gtc", "ioc" or "fok"
+  LimitPrice     float64
+  StopPrice      float64
+
+  // Take profit and stop loss legs of "bracket" and "oco" orders. The stop loss leg
+  // is a stop limit order if StopLossLimit is set.
+  OrderClass     string   // "simple" if empty, "bracket" or "oco"
+  TakeProfit     float64
+  StopLoss       float64
+  StopLossLimit  float64
+
+  // Sizes open orders. Fixed notional_usd if nil.
+  Sizer          sizing.Sizer
+}
+
+func Market(tif string) OrderParams {
+  return OrderParams{Type: "market", TimeInForce: tif}
+}
+
+func Limit(limit_price float64, tif string) OrderParams {
+  return OrderParams{Type: "limit", TimeInForce: tif, LimitPrice: limit_price}
+}
+
+func Stop(stop_price float64, tif string) OrderParams {
+  return OrderParams{Type: "stop", TimeInForce: tif, StopPrice: stop_price}
+}
+
+func StopLimit(stop_price float64, limit_price float64, tif string) OrderParams {
+  return OrderParams{Type: "stop_limit", TimeInForce: tif, StopPrice: stop_price, LimitPrice: limit_price}
+}
+
+// Entry order with take profit and stop loss legs that are held by the broker until
+// the entry is filled. When one leg is filled the other is canceled.
+func (o OrderParams) Bracket(take_profit float64, stop_loss float64) OrderParams {
+  o.OrderClass = "bracket"
+  o.TakeProfit = take_profit
+  o.StopLoss = stop_loss
+  return o
+}
+
+// Open order sized by s instead of the fixed notional
+func (o OrderParams) Sized(s sizing.Sizer) OrderParams {
+  o.Sizer = s
+  return o
+}
+
+// Take profit limit order and stop loss order for an open position, where the fill
+// of one cancels the other.
+func OCO(take_profit float64, stop_loss float64, tif string) OrderParams {
+  return OrderParams{
+    Type: "limit", TimeInForce: tif, OrderClass: "oco", LimitPrice: take_profit,
+    TakeProfit: take_profit, StopLoss: stop_loss,
+  }
+}
+
+// Name stored as order type on positions and in the database. Market IOC orders are
+// stored as "IOC" as they have always been.
+func (o OrderParams) String() string {
+  switch {
+  case o.OrderClass == "oco":
+    return "oco_" + o.TimeInForce
+  case o.OrderClass == "bracket":
+    return o.Type + "_" + o.TimeInForce + "_bracket"
+  case o.Type == "market" && o.TimeInForce == "ioc":
+    return "IOC"
+  }
+  return o.Type + "_" + o.TimeInForce
+}
+
+// Whether the order is canceled by the broker if it can not be filled immediately.
+func (o OrderParams) Immediate() bool {
+  return o.TimeInForce == "ioc" || o.TimeInForce == "fok"
+}
+
+func (o OrderParams) Validate(asset_class string) error {
+  switch o.Type {
+  case "market":
+  case "limit":
+    if o.LimitPrice <= 0 {
+      return errors.New("Limit order without limit price")
+    }
+  case "stop":
+    if o.StopPrice <= 0 {
+      return errors.New("Stop order without stop price")
+    }
+  case "stop_limit":
+    if o.StopPrice <= 0 || o.LimitPrice <= 0 {
+      return errors.New("Stop limit order without stop or limit price")
+    }
+  default:
+    return errors.New("Invalid order type: " + o.Type)
+  }
+
+  switch o.TimeInForce {
+  case "gtc", "ioc":
+  case "day", "fok":
+    if asset_class == "crypto" {
+      return errors.New("Time in force not supported for crypto: " + o.TimeInForce)
+    }
+  default:
+    return errors.New("Invalid time in force: " + o.TimeInForce)
+  }
+
+  if asset_class == "crypto" && o.Type == "stop" {
+    return errors.New("Stop orders are not supported for crypto. Use stop_limit")
+  }
+
+  switch o.OrderClass {
+  case "", "simple":
+    return nil
+  case "bracket":
+    if o.Type != "market" && o.Type != "limit" {
+      return errors.New("Bracket entry must be a market or limit order")
+    }
+  case "oco":
+    if o.Type != "limit" {
+      return errors.New("OCO orders must be of type limit")
+    }
+  default:
+    return errors.New("Invalid order class: " + o.OrderClass)
+  }
+  if asset_class == "crypto" {
+    return errors.New("Bracket and OCO orders are not supported for crypto")
+  }
+  if o.TimeInForce != "day" && o.TimeInForce != "gtc" {
+    return errors.New("Bracket and OCO orders must be day or gtc")
+  }
+  if o.TakeProfit <= 0 || o.StopLoss <= 0 {
+    return errors.New("Bracket and OCO orders need take profit and stop loss prices")
+  }
+
+  return nil
+}
+
+// Checks that the take profit is on the profitable side of the stop loss for an
+// order with the given side. For an OCO order the side is the side of the exit.
+func (o OrderParams) ValidateExits(side string) error {
+  if o.OrderClass != "bracket" && o.OrderClass != "oco" {
+    return nil
+  }
+  long := side == "buy"
+  if o.OrderClass == "oco" {
+    long = side == "sell"
+  }
+  if long && o.TakeProfit <= o.StopLoss || !long && o.TakeProfit >= o.StopLoss {
+    return errors.New("Take profit on wrong side of stop loss")
+  }
+  return nil
+}
+
+// Stock prices >= 1 USD are limited to whole cents, and stock prices below 1 USD
+// to 4 decimals. Crypto prices are sent with 9 decimals.
+func FormatPrice(asset_class string, price float64) string {
+  d := decimal.NewFromFloat(price)
+  switch {
+  case asset_class == "crypto":
+    return d.Round(9).String()
+  case price >= 1:
+    return d.Round(2).String()
+  default:
+    return d.Round(4).String()
+  }
+}
+
+func orderPayload(symbol string, asset_class string, client_order_id string, qty decimal.Decimal, side string, params OrderParams) (string, error) {
+  payload := map[string]any{
+    "symbol": symbol,
+    "qty": qty.String(),
+    "side": side,
+    "type": params.Type,
+    "time_in_force": params.TimeInForce,
+    "order_class": "simple",
+  }
+  if client_order_id != "" {
+    payload["client_order_id"] = client_order_id
+  }
+  if params.Type == "limit" && params.OrderClass != "oco" || params.Type == "stop_limit" {
+    payload["limit_price"] = FormatPrice(asset_class, params.LimitPrice)
+  }
+  if params.Type == "stop" || params.Type == "stop_limit" {
+    payload["stop_price"] = FormatPrice(asset_class, params.StopPrice)
+  }
+  if params.OrderClass == "bracket" || params.OrderClass == "oco" {
+    payload["order_class"] = params.OrderClass
+    payload["take_profit"] = map[string]string{"limit_price": FormatPrice(asset_class, params.TakeProfit)}
+    stop_loss := map[string]string{"stop_price": FormatPrice(asset_class, params.StopLoss)}
+    if params.StopLossLimit > 0 {
+      stop_loss["limit_price"] = FormatPrice(asset_class, params.StopLossLimit)
+    }
+    payload["stop_loss"] = stop_loss
+  }
+  b, err := json.Marshal(payload)
+  return string(b), err
+}
+
+// Sends an open order of any type for qty, which must be positive. Side is "buy" to
+// open a long position and "sell" to open a short position.
+func OpenOrder(side string, symbol string, asset_class string, position_id string, qty decimal.Decimal, params OrderParams) (string, int, error) {
+  if err := params.Validate(asset_class); err != nil {
+    return "", 0, err
+  }
+  if err := params.ValidateExits(side); err != nil {
+    return "", 0, err
+  }
+  if !qty.IsPositive() {
+    return "", 0, errors.New("Open qty is not positive")
+  }
+
+  payload, err := orderPayload(symbol, asset_class, position_id, qty, side, params)
+  if err != nil {
+    return "", 0, err
+  }
+
+  return SendOrder(payload)
+}
+
+// Client order id of close attempt n of the position. Client order ids can not be
+// reused, so every close of a position gets its own.
+func CloseClientOrderID(position_id string, n int) string {
+  return position_id + "_close" + strconv.Itoa(n)
+}
+
+// Sends a close order of any type for qty, which must be positive. The client order
+// id is made with CloseClientOrderID.
+func CloseOrder(side string, symbol string, asset_class string, client_order_id string, qty decimal.Decimal, params OrderParams) (string, int, error) {
+  if err := params.Validate(asset_class); err != nil {
+    return "", 0, err
+  }
+
+  payload, err := orderPayload(symbol, asset_class, client_order_id, qty, side, params)
+  if err != nil {
+    return "", 0, err
+  }
+
+  return SendOrder(payload)
+}
+
+// Sends an OCO exit for qty, which must be positive. The orders are sent without
+// client order id, and are tied to the position by the order ids of the legs.
+func ExitOrder(side string, symbol string, asset_class string, qty decimal.Decimal, params OrderParams) (string, int, error) {
+  if params.OrderClass != "oco" {
+    return "", 0, errors.New("Exit orders must be of order class oco")
+  }
+  if err := params.Validate(asset_class); err != nil {
+    return "", 0, err
+  }
+  if err := params.ValidateExits(side); err != nil {
+    return "", 0, err
+  }
+
+  payload, err := orderPayload(symbol, asset_class, "", qty, side, params)
+  if err != nil {
+    return "", 0, err
+  }
+
+  return SendOrder(payload)
+}
+
+// Cancels an open order by the order id assigned by the broker. A 204 status
+// means the cancel request was accepted.
+func CancelOrder(order_id string) (int, error) {
+  req, err := http.NewRequest("DELETE", config.C.Endpoint + "/orders/" + order_id, nil)
+  if err != nil {
+    return 0, err
+  }
+  req.Header = constant.AUTH_HEADERS
+  response, err := HttpClient.Do(req)
+  if err != nil {
+    return 0, err
+  }
+  defer response.Body.Close()
+  _, _ = io.Copy(io.Discard, response.Body)
+  return response.StatusCode, nil
+}
+
+// Returns the order id assigned by the broker from the response to an order request.
+func ParseOrderID(body string) string {
+  parsed, err := fastjson.Parse(body)
+  if err != nil {
+    return ""
+  }
+  return string(parsed.GetStringBytes("id"))
+}
+
+// Returns the order ids of the take profit and stop loss legs from the response to a
+// bracket or OCO order. The take profit order of an OCO order is the order itself.
+func ParseLegIDs(body string) (take_profit_id string, stop_loss_id string) {
+  parsed, err := fastjson.Parse(body)
+  if err != nil {
+    return "", ""
+  }
+  orders := parsed.GetArray("legs")
+  if string(parsed.GetStringBytes("order_class")) == "oco" {
+    orders = append(orders, parsed)
+  }
+  for _, o := range orders {
+    switch string(o.GetStringBytes("type")) {
+    case "limit":
+      take_profit_id = string(o.GetStringBytes("id"))
+    case "stop", "stop_limit":
+      stop_loss_id = string(o.GetStringBytes("id"))
     }
-  } else if asset_class == "crypto" {
-    qty = decimal.NewFromFloat(constant.NOTIONAL_USD / last_price).RoundDown(9)
   }
-  return qty
+  return
 }
 
 func GetPositions(backoff_sec float64, retries int) (arr []*fastjson.Value, err error) {
-  if retries >= constant.REQUEST_RETRIES {
+  if retries >= config.C.RequestRetries {
     return nil, errors.New("Max retries reached. Failed to get positions.")
   }
-  body, err := GetReq(constant.ENDPOINT + "/positions")
+  body, err := GetReq(config.C.Endpoint + "/positions")
   if err != nil {
     util.Error(err, "Trying again in (seconds)", &backoff_sec)
     util.Backoff(&backoff_sec)
@@ -122,48 +433,49 @@ func GetPositions(backoff_sec float64, retries int) (arr []*fastjson.Value, err
   return arr, nil
 }
 
-func OpenLongIOC(symbol string, asset_class string, position_id string, last_price float64) (string, int, error) {
-  qty := CalculateOpenQty(asset_class, last_price)
-  if qty.IsZero() {
-    return "", 0, errors.New("Calculated open qty is zero")
-  }
+// Trading calendar of the stock market between start and end, both inclusive
+func GetCalendar(start time.Time, end time.Time) ([]byte, error) {
+  url := fmt.Sprintf("%s/calendar?start=%s&end=%s",
+    config.C.Endpoint, start.Format(time.DateOnly), end.Format(time.DateOnly),
+  )
+  return GetReq(url)
+}
 
-  payload := `{` +
-    `"symbol": "` + symbol + `", ` +
-    `"client_order_id": "` + position_id + `", ` +
-    `"qty": "` + qty.String() + `", ` +
-    `"side": "buy", "type": "market", "time_in_force": "ioc", "order_class": "simple"` +
-  `}`
+func GetClock() ([]byte, error) {
+  return GetReq(config.C.Endpoint + "/clock")
+}
 
-  body, status, err := SendOrder(payload)
-  if err != nil || status != 200 {
-    return body, status, err
+// Metadata of all assets of the class, including inactive ones. The class is
+// "stock" or "crypto".
+func GetAssets(asset_class string) ([]*fastjson.Value, error) {
+  if asset_class == "stock" {
+    asset_class = "us_equity"
   }
-
-  return body, status, nil
+  body, err := GetReq(config.C.Endpoint + "/assets?asset_class=" + asset_class)
+  if err != nil {
+    return nil, err
+  }
+  return parseBody(body)
 }
 
-func CloseIOC(side string, symbol string, order_id string, qty decimal.Decimal) (string, int, error) {
-  payload := `{` +
-    `"symbol": "` + symbol + `", ` +
-    `"client_order_id": "` + order_id + `_close", ` +
-    `"qty": "` + qty.String() + `", ` +
-    `"side": "` + side + `", ` +
-    `"type": "market", "time_in_force": "ioc", "order_class": "simple"` +
-  `}`
-
-  body, status, err := SendOrder(payload)
-  if err != nil || status != 200 {
-    return body, status, err
+// Equity of the trading account
+func GetEquity() (float64, error) {
+  body, err := GetReq(config.C.Endpoint + "/account")
+  if err != nil {
+    return 0, err
   }
-
-  return body, status, nil
+  v, err := fastjson.ParseBytes(body)
+  if err != nil {
+    return 0, err
+  }
+  return strconv.ParseFloat(string(v.GetStringBytes("equity")), 64)
 }
 
-func CloseGTC(side string, symbol string, order_id string, qty decimal.Decimal) (string, int, error) {
+// Client order id must be unique, see CloseClientOrderID
+func CloseGTC(side string, symbol string, client_order_id string, qty decimal.Decimal) (string, int, error) {
   payload := `{` +
     `"symbol": "` + symbol + `", ` +
-    `"client_order_id": "` + order_id + `_close", ` +
+    `"client_order_id": "` + client_order_id + `", ` +
     `"qty": "` + qty.String() + `", ` +
     `"side": "` + side + `", ` +
     `"type": "market", "time_in_force": "gtc", "order_class": "simple"` +
@@ -181,14 +493,14 @@ func CloseGTC(side string, symbol string, order_id string, qty decimal.Decimal)
 }
 
 func CloseAllPositions(backoff_sec float64, retries int) {
-  if retries >= constant.REQUEST_RETRIES {
+  if retries >= config.C.RequestRetries {
     log.Printf("[ FAIL ]\tFailed to close all positions after %d retries\n", retries)
-    util.Error(errors.New("Failed to close all positions."), "Max retries reached", retries)
+    util.Emergency(errors.New("Failed to close all positions."), "Max retries reached", retries)
     return
   }
 
   // cancel_orders=true will cancel all open orders before liquidating
-  url := "https://paper-api.alpaca.markets/v2/positions?cancel_orders=true"
+  url := config.C.Endpoint + "/positions?cancel_orders=true"
   req, err := http.NewRequest("DELETE", url, nil)
   if err != nil {
     util.Error(err)
@@ -233,7 +545,7 @@ func urlGetClosedOrders(symbols map[string]map[string]int) (url string) {
   // ones since the time of the last executed trade from the database.
   url = fmt.Sprintf(
     "%s/orders?status=closed&limit=500&direction=desc&symbols=%s",  // Max limit is 500
-    constant.ENDPOINT, symbols_str,
+    config.C.Endpoint, symbols_str,
   )
   return
 }
@@ -282,8 +594,12 @@ func GetAssetQtys() (qtys map[string]decimal.Decimal, err error) {
     if err != nil {
       return nil, err
     }
+    // Short positions are reported with a negative qty
+    if string(v.GetStringBytes("side")) == "short" && qty.IsPositive() {
+      qty = qty.Neg()
+    }
     crypto = false
-    for _, s := range constant.CRYPTO_SYMBOLS {
+    for _, s := range config.C.CryptoSymbols {
       if strings.Replace(s, "/", "", 1) == string(v.GetStringBytes("symbol")) {
         qtys[s] = qty
         crypto = true
@@ -10,7 +10,7 @@ import (
   "github.com/shopspring/decimal"
   "github.com/stretchr/testify/assert"
   "github.com/Kjellemann1/AlgoTrader-Go/push"
-  "github.com/Kjellemann1/AlgoTrader-Go/constant"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
   "github.com/Kjellemann1/AlgoTrader-Go/util"
 )
 
@@ -33,11 +33,11 @@ func init() {
 }
 
 func TestGetPositions(t *testing.T) {
-  iter := constant.REQUEST_RETRIES
+  iter := config.C.RequestRetries
   HttpClient = &http.Client{
     Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
       iter++
-      if iter == 2 * constant.REQUEST_RETRIES - 1 {
+      if iter == 2 * config.C.RequestRetries - 1 {
         return &http.Response{ StatusCode: 200, Body: io.NopCloser(strings.NewReader(`[{"id":1}]`)) }, nil
       }
       return nil, errors.New("error")
@@ -61,11 +61,11 @@ func TestGetPositions(t *testing.T) {
 
 
 func TestGetClosedOrders(t *testing.T) {
-  iter := constant.REQUEST_RETRIES
+  iter := config.C.RequestRetries
   HttpClient = &http.Client{
     Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
       iter++
-      if iter == 2 * constant.REQUEST_RETRIES - 1 {
+      if iter == 2 * config.C.RequestRetries - 1 {
         return &http.Response{ StatusCode: 200, Body: io.NopCloser(strings.NewReader(`[{"id":1}]`)) }, nil
       }
       return nil, errors.New("error")
@@ -94,7 +94,7 @@ func TestGetClosedOrders(t *testing.T) {
     url := urlGetClosedOrders(testMap)
     baseUrl := fmt.Sprintf(
       "%s/orders?status=closed&limit=500&direction=desc&symbols=%s",  // Max limit is 500
-      constant.ENDPOINT, "foo%2Cfoo%2Fbar",
+      config.C.Endpoint, "foo%2Cfoo%2Fbar",
     )
     assert.Equal(t, baseUrl, url)
   })
@@ -179,3 +179,153 @@ var getAssetQtysResponse = &http.Response{StatusCode: 200, Body: io.NopCloser(st
     "qty_available": "61.473846805"
   }
 ]`))}
+
+func TestOpenOrder(t *testing.T) {
+  var payload string
+  HttpClient = &http.Client{
+    Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+      body, _ := io.ReadAll(req.Body)
+      payload = string(body)
+      return &http.Response{ StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"id": "abc"}`)) }, nil
+    }),
+  }
+
+  body, status, err := OpenOrder("sell", "FOO", "stock", "id", decimal.NewFromInt(5), Market("ioc"))
+  assert.Nil(t, err)
+  assert.Equal(t, 200, status)
+  assert.Equal(t, "abc", ParseOrderID(body))
+  assert.Contains(t, payload, `"side":"sell"`)
+  assert.Contains(t, payload, `"qty":"5"`)
+  assert.Contains(t, payload, `"type":"market"`)
+  assert.NotContains(t, payload, `limit_price`)
+
+  _, _, err = OpenOrder("buy", "FOO", "stock", "id", decimal.NewFromInt(4), StopLimit(12.345, 12.5, "day"))
+  assert.Nil(t, err)
+  assert.Contains(t, payload, `"type":"stop_limit"`)
+  assert.Contains(t, payload, `"time_in_force":"day"`)
+  assert.Contains(t, payload, `"stop_price":"12.35"`)
+  assert.Contains(t, payload, `"limit_price":"12.5"`)
+  assert.Contains(t, payload, `"qty":"4"`)
+
+  payload = ""
+  _, _, err = OpenOrder("buy", "BTC/USD", "crypto", "id", decimal.NewFromInt(1), Limit(10, "day"))
+  assert.NotNil(t, err)
+  assert.Equal(t, "", payload, "Invalid orders are not sent")
+  _, _, err = OpenOrder("buy", "FOO", "stock", "id", decimal.Zero, Market("ioc"))
+  assert.NotNil(t, err)
+  assert.Equal(t, "", payload, "Zero qty is not sent")
+}
+
+func TestOrderParams(t *testing.T) {
+  assert.Equal(t, "IOC", Market("ioc").String())
+  assert.Equal(t, "limit_gtc", Limit(1, "gtc").String())
+  assert.True(t, Limit(1, "fok").Immediate())
+  assert.False(t, Stop(1, "day").Immediate())
+
+  assert.Nil(t, Limit(1, "gtc").Validate("crypto"))
+  assert.Nil(t, StopLimit(1, 2, "ioc").Validate("crypto"))
+  assert.NotNil(t, Limit(0, "gtc").Validate("stock"))
+  assert.NotNil(t, Stop(1, "gtc").Validate("crypto"))
+  assert.NotNil(t, StopLimit(1, 0, "gtc").Validate("stock"))
+  assert.NotNil(t, Market("opg").Validate("stock"))
+  assert.NotNil(t, Market("fok").Validate("crypto"))
+
+  assert.Equal(t, "0.1235", FormatPrice("stock", 0.123456))
+  assert.Equal(t, "101.23", FormatPrice("stock", 101.2345))
+  assert.Equal(t, "0.000012345", FormatPrice("crypto", 0.0000123454))
+}
+
+func TestGetAssetQtysShort(t *testing.T) {
+  HttpClient = &http.Client{
+    Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+      body := `[{"symbol": "FOO", "qty": "10", "side": "short"}, {"symbol": "BAR", "qty": "-4", "side": "short"}]`
+      return &http.Response{ StatusCode: 200, Body: io.NopCloser(strings.NewReader(body)) }, nil
+    }),
+  }
+
+  resp, err := GetAssetQtys()
+  assert.Nil(t, err)
+  assert.True(t, decimal.NewFromInt(-10).Equal(resp["FOO"]))
+  assert.True(t, decimal.NewFromInt(-4).Equal(resp["BAR"]))
+}
+
+func TestBracketAndOCOPayload(t *testing.T) {
+  var payload string
+  HttpClient = &http.Client{
+    Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+      body, _ := io.ReadAll(req.Body)
+      payload = string(body)
+      return &http.Response{ StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{}`)) }, nil
+    }),
+  }
+
+  _, _, err := OpenOrder("buy", "FOO", "stock", "id", decimal.NewFromInt(5), Market("gtc").Bracket(11, 9.5))
+  assert.Nil(t, err)
+  assert.Contains(t, payload, `"order_class":"bracket"`)
+  assert.Contains(t, payload, `"take_profit":{"limit_price":"11"}`)
+  assert.Contains(t, payload, `"stop_loss":{"stop_price":"9.5"}`)
+
+  _, _, err = OpenOrder("sell", "FOO", "stock", "id", decimal.NewFromInt(5), Market("gtc").Bracket(11, 9.5))
+  assert.NotNil(t, err, "Take profit above stop loss for a short")
+  _, _, err = OpenOrder("buy", "FOO", "stock", "id", decimal.NewFromInt(5), Market("ioc").Bracket(11, 9.5))
+  assert.NotNil(t, err, "Brackets must be day or gtc")
+
+  params := OCO(11, 9.5, "gtc")
+  params.StopLossLimit = 9.4
+  _, _, err = ExitOrder("sell", "FOO", "stock", decimal.NewFromInt(5), params)
+  assert.Nil(t, err)
+  assert.Contains(t, payload, `"order_class":"oco"`)
+  assert.Contains(t, payload, `"stop_loss":{"limit_price":"9.4","stop_price":"9.5"}`)
+  assert.NotContains(t, payload, `client_order_id`)
+  assert.NotContains(t, payload, `"limit_price":"11",`)
+  assert.Equal(t, "oco_gtc", params.String())
+  assert.Equal(t, "market_gtc_bracket", Market("gtc").Bracket(11, 9.5).String())
+}
+
+func TestParseLegIDs(t *testing.T) {
+  bracket := `{"id": "entry", "order_class": "bracket", "type": "market", "legs": [` +
+    `{"id": "tp", "type": "limit"}, {"id": "sl", "type": "stop"}]}`
+  tp, sl := ParseLegIDs(bracket)
+  assert.Equal(t, "tp", tp)
+  assert.Equal(t, "sl", sl)
+
+  oco := `{"id": "tp", "order_class": "oco", "type": "limit", "legs": [{"id": "sl", "type": "stop_limit"}]}`
+  tp, sl = ParseLegIDs(oco)
+  assert.Equal(t, "tp", tp)
+  assert.Equal(t, "sl", sl)
+
+  tp, sl = ParseLegIDs(`{"id": "x", "order_class": "simple", "type": "limit"}`)
+  assert.Equal(t, "", tp + sl)
+}
+
+func TestGetEquity(t *testing.T) {
+  var url string
+  HttpClient = &http.Client{
+    Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+      url = req.URL.String()
+      return &http.Response{ StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"equity": "10250.75", "cash": "5000"}`)) }, nil
+    }),
+  }
+  equity, err := GetEquity()
+  assert.Nil(t, err)
+  assert.Equal(t, 10250.75, equity)
+  assert.Equal(t, config.C.Endpoint + "/account", url)
+}
+
+func TestGetAssets(t *testing.T) {
+  var url string
+  HttpClient = &http.Client{
+    Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+      url = req.URL.String()
+      return &http.Response{ StatusCode: 200, Body: io.NopCloser(strings.NewReader(`[
+        {"symbol": "AAPL", "class": "us_equity", "status": "active", "tradable": true},
+        {"symbol": "OLD", "class": "us_equity", "status": "inactive", "tradable": false}
+      ]`)) }, nil
+    }),
+  }
+  arr, err := GetAssets("stock")
+  assert.Nil(t, err)
+  assert.Equal(t, 2, len(arr))
+  assert.Equal(t, config.C.Endpoint + "/assets?asset_class=us_equity", url)
+  assert.Equal(t, "OLD", string(arr[1].GetStringBytes("symbol")))
+}
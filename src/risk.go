@@ -0,0 +1,241 @@
+// Portfolio level limits that are checked before every open, on top of the checks
+// of the asset itself. Every limit is disabled when set to 0 in the config.
+//
+// The daily loss limit counts realized pnl of closes since the start of the New York
+// day plus the unrealized pnl of open positions at the last close. Hitting it stops
+// new positions until the next day.
+
+package main
+
+import (
+  "fmt"
+  "errors"
+  "maps"
+  "sync"
+  "time"
+  "github.com/shopspring/decimal"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/calendar"
+  "github.com/Kjellemann1/AlgoTrader-Go/util"
+)
+
+type RiskManager struct {
+  assets    map[string]map[string]*Asset
+  day       string       // New York date of the realized pnl
+  realized  float64
+  tripped   bool         // Daily loss limit hit
+  orders    []time.Time  // Trigger times of the opens in the last minute
+  mutex     sync.Mutex
+  reserved  float64      // Notional of the opens checked but not yet pending
+  // Held from the exposure snapshot until the order is reserved. Not held together
+  // with mutex, which is taken under position locks by recordClose.
+  exposure_mutex  sync.Mutex
+}
+
+// Replaced in main and in backtests with one that sees all assets
+var Risk = NewRiskManager(nil)
+
+func NewRiskManager(assets map[string]map[string]*Asset) *RiskManager {
+  return &RiskManager{assets: assets}
+}
+
+// Exposure and pnl of the open positions, summed over all assets
+type riskSnapshot struct {
+  exposure    float64
+  unrealized  float64
+  positions   int
+  symbol      int  // Positions of the asset being opened
+  strategy    int  // Positions of the strategy being opened
+}
+
+// Copies the positions of the asset, so that their locks are not taken while
+// holding the asset lock. Order updates lock the position before the asset.
+func (a *Asset) positionsCopy() map[string]*Position {
+  a.Rwm.RLock()
+  defer a.Rwm.RUnlock()
+  return maps.Clone(a.Positions)
+}
+
+// Strategy a position name belongs to. Strategies holding several positions per
+// symbol name them with a number after the strategy name.
+func strategyOf(position_name string) string {
+  best := position_name
+  best_len := 0
+  for _, s := range strategyRegistry {
+    if len(s.Name) <= best_len || (position_name != s.Name && !isPositionOf(position_name, s.Name)) {
+      continue
+    }
+    best, best_len = s.Name, len(s.Name)
+  }
+  return best
+}
+
+func (r *RiskManager) snapshot(a *Asset, strat_name string) riskSnapshot {
+  var snap riskSnapshot
+  strategy := strategyOf(strat_name)
+  assets := []*Asset{a}
+  globRwm.RLock()
+  for _, m := range r.assets {
+    for _, other := range m {
+      if other != a {
+        assets = append(assets, other)
+      }
+    }
+  }
+  globRwm.RUnlock()
+
+  for _, asset := range assets {
+    asset.Rwm.RLock()
+    last := asset.C[len(asset.C)-1]
+    asset_qty := asset.Qty.Abs().InexactFloat64()
+    asset.Rwm.RUnlock()
+    snap.exposure += asset_qty * last
+
+    positions := asset.positionsCopy()
+    snap.positions += len(positions)
+    if asset == a {
+      snap.symbol = len(positions)
+    }
+    for name, pos := range positions {
+      if strategyOf(name) == strategy {
+        snap.strategy++
+      }
+      pos.Rwm.RLock()
+      if pos.OpenOrderPending {
+        snap.exposure += pos.OpenNotional
+      }
+      snap.unrealized += pos.unrealized(last)
+      pos.Rwm.RUnlock()
+    }
+  }
+  return snap
+}
+
+// Starts a new day of realized pnl when the New York date changes, lifting the
+// daily loss stop.
+func (r *RiskManager) rollDay(t time.Time) {
+  r.mutex.Lock()
+  defer r.mutex.Unlock()
+  r.rollDayLocked(t)
+}
+
+func (r *RiskManager) rollDayLocked(t time.Time) {
+  day := t.In(calendar.NewYork).Format(time.DateOnly)
+  if day == r.day {
+    return
+  }
+  if r.day != "" && r.tripped {
+    util.Info("New trading day. Daily loss stop lifted.")
+  }
+  r.day = day
+  r.realized = 0
+  if r.tripped {
+    r.tripped = false
+    NNP.NoNewPositionsFalse("DailyLossLimit")
+  }
+}
+
+// Stops new positions if the loss of the day exceeds the limit. Returns whether
+// the limit is hit.
+func (r *RiskManager) checkLossLocked(unrealized float64) bool {
+  if config.C.DailyLossLimitUSD <= 0 {
+    return false
+  }
+  if r.realized + unrealized > -config.C.DailyLossLimitUSD {
+    return r.tripped
+  }
+  if !r.tripped {
+    r.tripped = true
+    NNP.NoNewPositionsTrue("DailyLossLimit")
+    util.Warning(errors.New("Daily loss limit hit. No new positions until tomorrow."),
+      "Realized", r.realized, "Unrealized", unrealized, "Limit", config.C.DailyLossLimitUSD,
+    )
+  }
+  return true
+}
+
+// Records the pnl of closing qty of a position. Qty is positive when a long
+// position is closed and negative for a short.
+func (r *RiskManager) recordClose(qty decimal.Decimal, open_price float64, close_price float64, t time.Time) {
+  if open_price <= 0 || close_price <= 0 {
+    return
+  }
+  r.mutex.Lock()
+  defer r.mutex.Unlock()
+  r.rollDayLocked(t)
+  r.realized += qty.InexactFloat64() * (close_price - open_price)
+  r.checkLossLocked(0)
+}
+
+// Returns why opening a position for strat_name in a would break a position limit
+// or the daily loss limit, or nil if it is allowed.
+func (r *RiskManager) check(a *Asset, strat_name string, t time.Time) error {
+  c := config.C
+  snap := r.snapshot(a, strat_name)
+
+  if c.MaxOpenPositions > 0 && snap.positions >= c.MaxOpenPositions {
+    return fmt.Errorf("Max open positions (%d)", c.MaxOpenPositions)
+  }
+  if c.MaxPositionsPerSymbol > 0 && snap.symbol >= c.MaxPositionsPerSymbol {
+    return fmt.Errorf("Max positions per symbol (%d)", c.MaxPositionsPerSymbol)
+  }
+  if c.MaxPositionsPerStrategy > 0 && snap.strategy >= c.MaxPositionsPerStrategy {
+    return fmt.Errorf("Max positions per strategy (%d)", c.MaxPositionsPerStrategy)
+  }
+
+  r.mutex.Lock()
+  defer r.mutex.Unlock()
+  r.rollDayLocked(t)
+  if r.checkLossLocked(snap.unrealized) {
+    return fmt.Errorf("Daily loss limit (%.2f)", c.DailyLossLimitUSD)
+  }
+  return nil
+}
+
+// Checks the sized open order of notional dollars against the exposure and order
+// rate limits. Orders that are allowed count towards the rate limit, and their
+// notional is reserved until release is called once the position is pending, so that
+// concurrent opens can not pass the exposure limit together.
+func (r *RiskManager) checkOrder(a *Asset, notional float64, t time.Time) error {
+  c := config.C
+  r.exposure_mutex.Lock()
+  defer r.exposure_mutex.Unlock()
+  if c.MaxGrossExposureUSD > 0 {
+    exposure := r.snapshot(a, "").exposure + r.reserved
+    if exposure + notional > c.MaxGrossExposureUSD {
+      return fmt.Errorf("Max gross exposure (%.2f + %.2f of %.2f)", exposure, notional, c.MaxGrossExposureUSD)
+    }
+  }
+  if err := r.checkRate(t); err != nil {
+    return err
+  }
+  r.reserved += notional
+  return nil
+}
+
+// Releases notional reserved by checkOrder
+func (r *RiskManager) release(notional float64) {
+  r.exposure_mutex.Lock()
+  defer r.exposure_mutex.Unlock()
+  r.reserved -= notional
+}
+
+func (r *RiskManager) checkRate(t time.Time) error {
+  c := config.C
+  r.mutex.Lock()
+  defer r.mutex.Unlock()
+  if c.MaxOrdersPerMinute > 0 {
+    recent := r.orders[:0]
+    for _, o := range r.orders {
+      if t.Sub(o) < time.Minute {
+        recent = append(recent, o)
+      }
+    }
+    r.orders = recent
+    if len(r.orders) >= c.MaxOrdersPerMinute {
+      return fmt.Errorf("Max orders per minute (%d)", c.MaxOrdersPerMinute)
+    }
+    r.orders = append(r.orders, t)
+  }
+  return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+  "time"
+  "testing"
+  "github.com/shopspring/decimal"
+  "github.com/stretchr/testify/assert"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+)
+
+// Sets the risk limits for the test and restores the config afterwards
+func withRiskConfig(t *testing.T, set func(c *config.Config)) {
+  saved := *config.C
+  t.Cleanup(func() {
+    *config.C = saved
+  })
+  set(config.C)
+}
+
+func riskAsset(symbol string, last float64) *Asset {
+  a := newAssetTesting()
+  a.Symbol = symbol
+  a.Positions = make(map[string]*Position)
+  a.C[len(a.C)-1] = last
+  return a
+}
+
+func filledPosition(a *Asset, strat_name string, qty float64, price float64) {
+  pos := NewPosition(a.Symbol)
+  pos.StratName = strat_name
+  pos.Qty = decimal.NewFromFloat(qty)
+  pos.OpenFilledAvgPrice = price
+  pos.OpenOrderPending = false
+  a.Positions[strat_name] = pos
+  a.Qty = a.Qty.Add(pos.Qty)
+}
+
+func TestStrategyOf(t *testing.T) {
+  noop := func(a *Asset, s *Strategy) {}
+  withRegistry(t, &Strategy{Name: "rand", Tick: noop}, &Strategy{Name: "rand_walk", Tick: noop})
+  assert.Equal(t, "rand", strategyOf("rand"))
+  assert.Equal(t, "rand", strategyOf("rand3"))
+  assert.Equal(t, "rand_walk", strategyOf("rand_walk12"))
+  assert.Equal(t, "other", strategyOf("other"))
+}
+
+func TestRiskLimits(t *testing.T) {
+  now := time.Date(2024, 7, 2, 15, 0, 0, 0, time.UTC)
+  foo := riskAsset("Foo", 100)
+  bar := riskAsset("Bar", 10)
+  assets := map[string]map[string]*Asset{"crypto": {"Foo": foo, "Bar": bar}}
+  filledPosition(foo, "a1", 2, 100)
+  filledPosition(foo, "b1", 1, 100)
+  filledPosition(bar, "a2", 5, 10)
+
+  t.Run("disabled", func(t *testing.T) {
+    assert.NoError(t, NewRiskManager(assets).check(foo, "c", now))
+  })
+
+  t.Run("open positions", func(t *testing.T) {
+    withRiskConfig(t, func(c *config.Config) { c.MaxOpenPositions = 3 })
+    assert.ErrorContains(t, NewRiskManager(assets).check(bar, "c", now), "Max open positions")
+    config.C.MaxOpenPositions = 4
+    assert.NoError(t, NewRiskManager(assets).check(bar, "c", now))
+  })
+
+  t.Run("per symbol", func(t *testing.T) {
+    withRiskConfig(t, func(c *config.Config) { c.MaxPositionsPerSymbol = 2 })
+    assert.ErrorContains(t, NewRiskManager(assets).check(foo, "c", now), "Max positions per symbol")
+    assert.NoError(t, NewRiskManager(assets).check(bar, "c", now))
+  })
+
+  t.Run("per strategy", func(t *testing.T) {
+    noop := func(a *Asset, s *Strategy) {}
+    withRegistry(t, &Strategy{Name: "a", Tick: noop}, &Strategy{Name: "b", Tick: noop})
+    withRiskConfig(t, func(c *config.Config) { c.MaxPositionsPerStrategy = 2 })
+    assert.ErrorContains(t, NewRiskManager(assets).check(bar, "a3", now), "Max positions per strategy")
+    assert.NoError(t, NewRiskManager(assets).check(bar, "b2", now))
+  })
+
+  t.Run("gross exposure", func(t *testing.T) {
+    // 300 in Foo and 50 in Bar
+    withRiskConfig(t, func(c *config.Config) { c.MaxGrossExposureUSD = 399 })
+    assert.ErrorContains(t, NewRiskManager(assets).checkOrder(bar, 50, now), "Max gross exposure")
+    config.C.MaxGrossExposureUSD = 400
+    assert.NoError(t, NewRiskManager(assets).checkOrder(bar, 50, now))
+
+    // Pending opens count at the value they were sent with
+    pending := NewPosition("Bar")
+    pending.OpenOrderPending = true
+    pending.OpenNotional = 20
+    bar.Positions["pending"] = pending
+    t.Cleanup(func() {
+      delete(bar.Positions, "pending")
+    })
+    assert.ErrorContains(t, NewRiskManager(assets).checkOrder(bar, 50, now), "Max gross exposure")
+    assert.NoError(t, NewRiskManager(assets).checkOrder(bar, 30, now))
+  })
+
+  t.Run("reserved", func(t *testing.T) {
+    withRiskConfig(t, func(c *config.Config) { c.MaxGrossExposureUSD = 400 })
+    r := NewRiskManager(assets)
+    assert.NoError(t, r.checkOrder(bar, 30, now))
+    // The first open is not pending yet
+    assert.ErrorContains(t, r.checkOrder(bar, 30, now), "Max gross exposure")
+    r.release(30)
+    assert.NoError(t, r.checkOrder(bar, 30, now))
+  })
+
+  t.Run("orders per minute", func(t *testing.T) {
+    withRiskConfig(t, func(c *config.Config) { c.MaxOrdersPerMinute = 2 })
+    r := NewRiskManager(assets)
+    assert.NoError(t, r.checkOrder(bar, 50, now))
+    assert.NoError(t, r.checkOrder(bar, 50, now.Add(30 * time.Second)))
+    assert.ErrorContains(t, r.checkOrder(bar, 50, now.Add(59 * time.Second)), "Max orders per minute")
+    // The first order has left the window
+    assert.NoError(t, r.checkOrder(bar, 50, now.Add(61 * time.Second)))
+  })
+}
+
+func TestDailyLossLimit(t *testing.T) {
+  t.Cleanup(func() {
+    NNP.NoNewPositionsFalse("DailyLossLimit")
+  })
+  withRiskConfig(t, func(c *config.Config) { c.DailyLossLimitUSD = 100 })
+  // 14:00 and 23:00 in New York on 2 July, and the morning after
+  day := time.Date(2024, 7, 2, 18, 0, 0, 0, time.UTC)
+  late := time.Date(2024, 7, 3, 3, 0, 0, 0, time.UTC)
+  next := time.Date(2024, 7, 3, 14, 0, 0, 0, time.UTC)
+
+  t.Run("realized", func(t *testing.T) {
+    a := riskAsset("Foo", 100)
+    r := NewRiskManager(map[string]map[string]*Asset{"crypto": {"Foo": a}})
+
+    r.recordClose(decimal.NewFromInt(1), 100, 40, day)
+    assert.False(t, NNP.Flag)
+    // Short closed above the open price
+    r.recordClose(decimal.NewFromInt(-1), 100, 141, late)
+    assert.True(t, NNP.Flag)
+    assert.ErrorContains(t, r.check(a, "c", late), "Daily loss limit")
+
+    // Lifted on the next New York day
+    r.rollDay(next)
+    assert.False(t, NNP.Flag)
+    assert.NoError(t, r.check(a, "c", next))
+  })
+
+  t.Run("unrealized", func(t *testing.T) {
+    a := riskAsset("Foo", 60)
+    filledPosition(a, "a", 2, 100)
+    r := NewRiskManager(map[string]map[string]*Asset{"crypto": {"Foo": a}})
+
+    r.recordClose(decimal.NewFromInt(1), 100, 80, day)
+    assert.False(t, NNP.Flag)
+    // Realized -20 and unrealized -80
+    assert.ErrorContains(t, r.check(a, "c", day), "Daily loss limit")
+    assert.True(t, NNP.Flag)
+    r.rollDay(next)
+    assert.False(t, NNP.Flag)
+  })
+
+  t.Run("open checks", func(t *testing.T) {
+    saved := Risk
+    t.Cleanup(func() {
+      Risk = saved
+    })
+    a := riskAsset("Foo", 100)
+    a.Class = "crypto"
+    Risk = NewRiskManager(map[string]map[string]*Asset{"crypto": {"Foo": a}})
+    Risk.recordClose(decimal.NewFromInt(1), 200, 90, day)
+
+    a.Time, a.ReceivedTime = next, next
+    assert.False(t, a.openChecks("long", "c", day))
+    assert.True(t, a.openChecks("long", "c", next))
+  })
+}
@@ -0,0 +1,362 @@
+// Universe screener. The configured symbols are the candidates, and when
+// screen_top_n is set only the best of each asset class are traded. Candidates are
+// screened at startup before the assets are created, and every screen_interval
+// while running, in which case symbols are added and removed like admin commands.
+//
+// Candidates are filtered on price, average daily dollar volume, volatility of
+// daily returns and spread, and ranked by the mean of their percentiles in dollar
+// volume, volatility and spread. Symbols with positions at the broker are kept at
+// startup. While running, symbols that fall out of the universe stop opening
+// positions and are only removed once their strategies have closed them.
+
+package main
+
+import (
+  "os"
+  "cmp"
+  "fmt"
+  "log"
+  "math"
+  "sync"
+  "time"
+  "slices"
+  "context"
+  "encoding/json"
+  "github.com/valyala/fastjson"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+  "github.com/Kjellemann1/AlgoTrader-Go/util"
+)
+
+type Candidate struct {
+  Symbol         string   `json:"symbol"`
+  Class          string   `json:"class"`
+  Price          float64  `json:"price"`
+  DollarVolume   float64  `json:"dollar_volume"`   // Average daily
+  VolatilityPct  float64  `json:"volatility_pct"`  // Standard deviation of daily returns
+  SpreadPct      float64  `json:"spread_pct"`      // NaN if there is no quote
+  Score          float64  `json:"score"`           // From 0 to 1, higher is better
+  Selected       bool     `json:"selected"`
+  Reason         string   `json:"reason,omitempty"`  // Why it was filtered out or kept
+}
+
+// Candidates of each asset class. Set from the config at startup, as the symbol
+// lists of the config follow the subscribed assets.
+var screenCandidates map[string][]string
+
+// Computes the metrics of the candidates from daily bars and snapshots
+func screenMetrics(asset_class string, symbols []string) (map[string]*Candidate, error) {
+  candidates := make(map[string]*Candidate)
+  closes := make(map[string][]float64)
+  for _, symbol := range symbols {
+    candidates[symbol] = &Candidate{Symbol: symbol, Class: asset_class, SpreadPct: math.NaN()}
+  }
+
+  days := config.C.ScreenLookbackDays
+  // Stocks trade about 5 of 7 days
+  start := time.Now().UTC().AddDate(0, 0, -days * 3 / 2 - 4)
+  if asset_class == "crypto" {
+    start = time.Now().UTC().AddDate(0, 0, -days)
+  }
+  url := func(page_token string) string {
+    return urlBars(asset_class, symbols, "1Day", start, time.Time{}, page_token)
+  }
+  err := visitBars(url, func(symbol string, bar *fastjson.Value) {
+    closes[symbol] = append(closes[symbol], bar.GetFloat64("c"))
+    if c, ok := candidates[symbol]; ok {
+      c.DollarVolume += bar.GetFloat64("c") * bar.GetFloat64("v")
+    }
+  })
+  if err != nil {
+    return nil, err
+  }
+  for symbol, c := range candidates {
+    arr := closes[symbol]
+    if len(arr) == 0 {
+      continue
+    }
+    c.Price = arr[len(arr)-1]
+    c.DollarVolume /= float64(len(arr))
+    c.VolatilityPct = returnsStdDev(arr) * 100
+  }
+
+  parsed, err := makeRequest(urlSnapshots(asset_class, symbols))
+  if err != nil {
+    return nil, err
+  }
+  snapshots := parsed
+  if asset_class == "crypto" {
+    snapshots = parsed.Get("snapshots")
+  }
+  for symbol, c := range candidates {
+    q := snapshots.Get(symbol, "latestQuote")
+    if q == nil {
+      continue
+    }
+    c.SpreadPct = Quote{Bid: q.GetFloat64("bp"), Ask: q.GetFloat64("ap")}.SpreadPct()
+  }
+  return candidates, nil
+}
+
+// Standard deviation of the returns between consecutive closes
+func returnsStdDev(closes []float64) float64 {
+  if len(closes) < 3 {
+    return 0
+  }
+  returns := make([]float64, 0, len(closes) - 1)
+  for i := 1; i < len(closes); i++ {
+    if closes[i-1] > 0 {
+      returns = append(returns, closes[i] / closes[i-1] - 1)
+    }
+  }
+  if len(returns) < 2 {
+    return 0
+  }
+  mean := 0.0
+  for _, r := range returns {
+    mean += r
+  }
+  mean /= float64(len(returns))
+  variance := 0.0
+  for _, r := range returns {
+    variance += (r - mean) * (r - mean)
+  }
+  return math.Sqrt(variance / float64(len(returns) - 1))
+}
+
+// Reason the candidate does not pass the filters, or empty if it does
+func (c *Candidate) filter() string {
+  switch {
+  case c.Price == 0:
+    return "No bars"
+  case c.Price < config.C.ScreenMinPrice:
+    return "Price below minimum"
+  case config.C.ScreenMaxPrice > 0 && c.Price > config.C.ScreenMaxPrice:
+    return "Price above maximum"
+  case c.DollarVolume < config.C.ScreenMinDollarVolume:
+    return "Dollar volume below minimum"
+  case c.VolatilityPct < config.C.ScreenMinVolatilityPct:
+    return "Volatility below minimum"
+  case config.C.ScreenMaxSpreadPct > 0 && !(c.SpreadPct <= config.C.ScreenMaxSpreadPct):
+    return "Spread above maximum"
+  }
+  return ""
+}
+
+// Percentile of each candidate in the metric, from 0 for the worst to 1 for the best
+func percentiles(candidates []*Candidate, better func(x, y *Candidate) bool) map[*Candidate]float64 {
+  p := make(map[*Candidate]float64)
+  if len(candidates) == 1 {
+    p[candidates[0]] = 1
+    return p
+  }
+  for _, c := range candidates {
+    worse := 0
+    for _, other := range candidates {
+      if better(c, other) {
+        worse++
+      }
+    }
+    p[c] = float64(worse) / float64(len(candidates) - 1)
+  }
+  return p
+}
+
+// Scores the candidates that pass the filters and selects the top_n best. The
+// symbols in keep are selected regardless. Returns the selected symbols.
+func rankCandidates(candidates map[string]*Candidate, top_n int, keep []string) []string {
+  var passed []*Candidate
+  for _, c := range candidates {
+    if c.Reason = c.filter(); c.Reason == "" {
+      passed = append(passed, c)
+    }
+  }
+  liquidity := percentiles(passed, func(x, y *Candidate) bool {
+    return x.DollarVolume > y.DollarVolume
+  })
+  volatility := percentiles(passed, func(x, y *Candidate) bool {
+    return x.VolatilityPct > y.VolatilityPct
+  })
+  // Candidates without a quote rank last
+  spread := percentiles(passed, func(x, y *Candidate) bool {
+    return x.SpreadPct < y.SpreadPct || !math.IsNaN(x.SpreadPct) && math.IsNaN(y.SpreadPct)
+  })
+  for _, c := range passed {
+    c.Score = (liquidity[c] + volatility[c] + spread[c]) / 3
+  }
+  slices.SortFunc(passed, func(x, y *Candidate) int {
+    if x.Score != y.Score {
+      return cmp.Compare(y.Score, x.Score)
+    }
+    return cmp.Compare(x.Symbol, y.Symbol)
+  })
+
+  var selected []string
+  for i, c := range passed {
+    if i < top_n {
+      c.Selected = true
+      selected = append(selected, c.Symbol)
+    }
+  }
+  for _, symbol := range keep {
+    c, ok := candidates[symbol]
+    if !ok || c.Selected {
+      continue
+    }
+    c.Selected = true
+    c.Reason = "Open positions"
+    selected = append(selected, symbol)
+  }
+  slices.Sort(selected)
+  return selected
+}
+
+// Screens the candidates of the asset class. Symbols in keep are selected regardless.
+func screen(asset_class string, symbols []string, keep []string) ([]string, []*Candidate, error) {
+  candidates, err := screenMetrics(asset_class, symbols)
+  if err != nil {
+    return nil, nil, err
+  }
+  selected := rankCandidates(candidates, config.C.ScreenTopN, keep)
+  result := make([]*Candidate, 0, len(candidates))
+  for _, symbol := range symbols {
+    result = append(result, candidates[symbol])
+  }
+  return selected, result, nil
+}
+
+// Logs the result and writes it to screen_file
+func saveScreen(t time.Time, selected map[string][]string, candidates []*Candidate) {
+  for _, asset_class := range []string{"stock", "crypto"} {
+    if list, ok := selected[asset_class]; ok {
+      util.Info(fmt.Sprintf("Screened %s universe: %v", asset_class, list))
+    }
+  }
+  if config.C.ScreenFile == "" {
+    return
+  }
+  // NaN can not be encoded
+  for _, c := range candidates {
+    if math.IsNaN(c.SpreadPct) {
+      c.SpreadPct = -1
+    }
+  }
+  data, err := json.MarshalIndent(map[string]any{
+    "time": t,
+    "selected": selected,
+    "candidates": candidates,
+  }, "", "  ")
+  if err == nil {
+    err = os.WriteFile(config.C.ScreenFile, data, 0644)
+  }
+  if err != nil {
+    util.Warning(err, "File", config.C.ScreenFile)
+  }
+}
+
+// Screens every asset class and returns the selected symbols of each. keep holds
+// the symbols with open positions.
+func screenAll(keep func(asset_class string) []string) (map[string][]string, error) {
+  selected := make(map[string][]string)
+  var all []*Candidate
+  for _, asset_class := range []string{"stock", "crypto"} {
+    symbols := screenCandidates[asset_class]
+    if len(symbols) == 0 {
+      continue
+    }
+    list, candidates, err := screen(asset_class, symbols, keep(asset_class))
+    if err != nil {
+      return nil, err
+    }
+    selected[asset_class] = list
+    all = append(all, candidates...)
+  }
+  saveScreen(time.Now().UTC(), selected, all)
+  return selected, nil
+}
+
+// Narrows the symbols of the config to the screened universe before the assets are
+// created. Symbols with positions at the broker are kept. All symbols are traded if
+// the screen fails.
+func screenUniverse() {
+  screenCandidates = map[string][]string{
+    "stock": slices.Clone(config.C.StockSymbols),
+    "crypto": slices.Clone(config.C.CryptoSymbols),
+  }
+  if config.C.ScreenTopN == 0 {
+    return
+  }
+  qtys, err := request.GetAssetQtys()
+  if err != nil {
+    util.Warning(err, "Details", "Screener not run. All symbols are traded.")
+    return
+  }
+  selected, err := screenAll(func(asset_class string) []string {
+    var keep []string
+    for _, symbol := range screenCandidates[asset_class] {
+      if qty, ok := qtys[symbol]; ok && !qty.IsZero() {
+        keep = append(keep, symbol)
+      }
+    }
+    return keep
+  })
+  if err != nil {
+    util.Warning(err, "Details", "Screener not run. All symbols are traded.")
+    return
+  }
+  config.C.StockSymbols = selected["stock"]
+  config.C.CryptoSymbols = selected["crypto"]
+  if len(config.C.StockSymbols) + len(config.C.CryptoSymbols) == 0 {
+    log.Panicln("No symbols passed the screener")
+  }
+}
+
+// Moves the subscriptions of the markets to the selected symbols. Symbols that
+// are no longer selected are removed once flat.
+func applyScreen(wg *sync.WaitGroup, ctx context.Context, markets map[string]*Market, selected map[string][]string) {
+  for asset_class, m := range markets {
+    list, ok := selected[asset_class]
+    if !ok || len(list) == 0 {
+      continue
+    }
+    for _, symbol := range list {
+      if m.keepSymbol(symbol) {
+        continue
+      }
+      if err := m.addSymbol(symbol); err != nil {
+        util.Warning(err, "Symbol", symbol)
+      }
+    }
+    for _, symbol := range m.symbols() {
+      if a := m.asset(symbol); a == nil || a.retiring.Load() || slices.Contains(list, symbol) {
+        continue
+      }
+      if err := m.removeSymbol(wg, ctx, symbol, false); err != nil {
+        util.Warning(err, "Symbol", symbol)
+      }
+    }
+  }
+}
+
+// Screens every screen_interval and applies the result to the markets
+func runScreener(wg *sync.WaitGroup, ctx context.Context, markets map[string]*Market) {
+  defer wg.Done()
+  ticker := time.NewTicker(config.C.ScreenInterval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+    }
+    // Symbols with open positions are removed once flat by applyScreen
+    selected, err := screenAll(func(asset_class string) []string {
+      return nil
+    })
+    if err != nil {
+      util.Warning(err, "Details", "Screen failed. Universe unchanged.")
+      continue
+    }
+    applyScreen(wg, ctx, markets, selected)
+  }
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+  "io"
+  "os"
+  "math"
+  "strings"
+  "testing"
+  "net/http"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+)
+
+func withScreenConfig(t *testing.T) {
+  saved := *config.C
+  t.Cleanup(func() {
+    *config.C = saved
+  })
+  config.C.ScreenFile = ""
+}
+
+func TestRankCandidates(t *testing.T) {
+  withScreenConfig(t)
+  config.C.ScreenMinPrice = 5
+  config.C.ScreenMaxSpreadPct = 0.5
+  candidates := map[string]*Candidate{
+    "A": {Symbol: "A", Price: 10, DollarVolume: 3e6, VolatilityPct: 2, SpreadPct: 0.1},
+    "B": {Symbol: "B", Price: 20, DollarVolume: 2e6, VolatilityPct: 3, SpreadPct: 0.2},
+    "C": {Symbol: "C", Price: 30, DollarVolume: 1e6, VolatilityPct: 1, SpreadPct: 0.3},
+    "PENNY": {Symbol: "PENNY", Price: 1, DollarVolume: 9e6, VolatilityPct: 9, SpreadPct: 0.1},
+    "WIDE": {Symbol: "WIDE", Price: 10, DollarVolume: 9e6, VolatilityPct: 9, SpreadPct: 2},
+    "NOQUOTE": {Symbol: "NOQUOTE", Price: 10, DollarVolume: 9e6, VolatilityPct: 9, SpreadPct: math.NaN()},
+  }
+
+  selected := rankCandidates(candidates, 2, []string{"C"})
+  assert.Equal(t, []string{"A", "B", "C"}, selected)
+  assert.Equal(t, "Price below minimum", candidates["PENNY"].Reason)
+  assert.Equal(t, "Spread above maximum", candidates["WIDE"].Reason)
+  assert.Equal(t, "Spread above maximum", candidates["NOQUOTE"].Reason)
+  assert.Equal(t, "Open positions", candidates["C"].Reason)
+  assert.InDelta(t, 2.5 / 3, candidates["A"].Score, 1e-9)
+  assert.False(t, candidates["PENNY"].Selected)
+}
+
+func TestReturnsStdDev(t *testing.T) {
+  assert.Equal(t, 0.0, returnsStdDev([]float64{1, 2}))
+  assert.InDelta(t, 0.0, returnsStdDev([]float64{100, 110, 121}), 1e-9)
+  assert.InDelta(t, 0.11547, returnsStdDev([]float64{100, 110, 99, 108.9}), 1e-5)
+}
+
+func TestScreenUniverse(t *testing.T) {
+  withScreenConfig(t)
+  config.C.StockSymbols = []string{}
+  config.C.CryptoSymbols = []string{"BTC/USD", "ETH/USD", "SHIB/USD"}
+  config.C.ScreenTopN = 1
+  config.C.ScreenFile = filepath.Join(t.TempDir(), "screener.json")
+
+  request.HttpClient = &http.Client{
+    Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+      var body string
+      switch {
+      case strings.HasSuffix(req.URL.Path, "/positions"):
+        body = `[{"symbol": "SHIBUSD", "qty": "1000", "side": "long"}]`
+      case strings.HasSuffix(req.URL.Path, "/bars"):
+        body = `{"bars": {
+          "BTC/USD": [{"c": 100, "v": 50}, {"c": 110, "v": 50}, {"c": 99, "v": 50}],
+          "ETH/USD": [{"c": 10, "v": 50}, {"c": 10.1, "v": 50}, {"c": 10, "v": 50}],
+          "SHIB/USD": [{"c": 1, "v": 5}, {"c": 1, "v": 5}, {"c": 1, "v": 5}]
+        }, "next_page_token": null}`
+      case strings.HasSuffix(req.URL.Path, "/snapshots"):
+        body = `{"snapshots": {
+          "BTC/USD": {"latestQuote": {"bp": 99, "ap": 99.1}},
+          "ETH/USD": {"latestQuote": {"bp": 10, "ap": 10.01}}
+        }}`
+      }
+      return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+    }),
+  }
+
+  screenUniverse()
+  assert.Equal(t, []string{"BTC/USD", "SHIB/USD"}, config.C.CryptoSymbols, "Best and the one with a position")
+  assert.Equal(t, []string{"BTC/USD", "ETH/USD", "SHIB/USD"}, screenCandidates["crypto"])
+
+  data, err := os.ReadFile(config.C.ScreenFile)
+  assert.Nil(t, err)
+  assert.Contains(t, string(data), `"reason": "Open positions"`)
+}
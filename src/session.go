@@ -0,0 +1,150 @@
+// Stock market sessions. Stock strategies only run and open positions while the
+// market is open, and stock positions are flattened before the close if configured.
+
+package main
+
+import (
+  "os"
+  "fmt"
+  "maps"
+  "slices"
+  "time"
+  "sync"
+  "errors"
+  "context"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+  "github.com/Kjellemann1/AlgoTrader-Go/calendar"
+  "github.com/Kjellemann1/AlgoTrader-Go/util"
+)
+
+// Empty until loaded, in which case stocks are treated as always open
+var MarketCalendar = calendar.New(false)
+
+// Days before and after today fetched from the calendar endpoint
+const (
+  calendarDaysBack = 10
+  calendarDaysAhead = 30
+)
+
+func loadCalendar() error {
+  cal := calendar.New(config.C.ExtendedHours)
+  if config.C.CalendarFile != "" {
+    body, err := os.ReadFile(config.C.CalendarFile)
+    if err != nil {
+      return err
+    }
+    sessions, err := calendar.Parse(body)
+    if err != nil {
+      return err
+    }
+    cal.LoadSessions(sessions)
+  } else {
+    now := time.Now().In(calendar.NewYork)
+    start := now.Add(-stockHistLookback()).AddDate(0, 0, -calendarDaysBack)
+    end := now.AddDate(0, 0, calendarDaysAhead)
+    if err := fetchCalendar(cal, start, end); err != nil {
+      return err
+    }
+  }
+  MarketCalendar = cal
+  return nil
+}
+
+func fetchCalendar(cal *calendar.Calendar, start time.Time, end time.Time) error {
+  body, err := request.GetCalendar(start, end)
+  if err != nil {
+    return err
+  }
+  if body == nil {
+    return errors.New("Empty calendar response")
+  }
+  sessions, err := calendar.Parse(body)
+  if err != nil {
+    return err
+  }
+  cal.Load(sessions, start, end)
+  return nil
+}
+
+func logClock() {
+  body, err := request.GetClock()
+  if err != nil || body == nil {
+    util.Warning(fmt.Errorf("Failed to get market clock: %v", err))
+    return
+  }
+  clock, err := calendar.ParseClock(body)
+  if err != nil {
+    util.Warning(err)
+    return
+  }
+  if clock.IsOpen != MarketCalendar.IsOpen(clock.Timestamp) && !config.C.ExtendedHours {
+    util.Warning(errors.New("Market clock and calendar disagree on whether the market is open"))
+  }
+  if clock.IsOpen {
+    util.Info("Stock market is open. Closes " + clock.NextClose.In(calendar.NewYork).Format(time.DateTime) + " New York time")
+  } else {
+    util.Info("Stock market is closed. Opens " + clock.NextOpen.In(calendar.NewYork).Format(time.DateTime) + " New York time")
+  }
+}
+
+// Extends the calendar once a day so that it keeps covering the coming days.
+func refreshCalendar(wg *sync.WaitGroup, ctx context.Context) {
+  defer wg.Done()
+  ticker := time.NewTicker(24 * time.Hour)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+      now := time.Now().In(calendar.NewYork)
+      if err := fetchCalendar(MarketCalendar, now, now.AddDate(0, 0, calendarDaysAhead)); err != nil {
+        util.Warning(err, "Details", "Failed to refresh market calendar")
+      }
+    }
+  }
+}
+
+// Whether stock strategies may open positions at t. Opens are blocked outside the
+// session and in the last minutes before the close when flattening is enabled.
+func stockOpenAllowed(t time.Time) bool {
+  if !MarketCalendar.IsOpen(t) {
+    return false
+  }
+  if config.C.FlattenBeforeClose > 0 {
+    if left, ok := MarketCalendar.UntilClose(t); ok && left <= config.C.FlattenBeforeClose {
+      return false
+    }
+  }
+  return true
+}
+
+// Closes all stock positions that are not pending once the close is nearer than
+// the configured flatten time.
+func flattenStocks(assets map[string]*Asset, now time.Time) {
+  left, ok := MarketCalendar.UntilClose(now)
+  if !ok || left > config.C.FlattenBeforeClose {
+    return
+  }
+  globRwm.RLock()
+  list := slices.Collect(maps.Values(assets))
+  globRwm.RUnlock()
+  for _, a := range list {
+    a.closeAll("Flattening before close")
+  }
+}
+
+func flattenBeforeClose(wg *sync.WaitGroup, ctx context.Context, assets map[string]*Asset) {
+  defer wg.Done()
+  ticker := time.NewTicker(15 * time.Second)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+      flattenStocks(assets, clockNow("stock"))
+    }
+  }
+}
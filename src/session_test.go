@@ -0,0 +1,140 @@
+package main
+
+import (
+  "time"
+  "testing"
+  "github.com/stretchr/testify/assert"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+  "github.com/Kjellemann1/AlgoTrader-Go/calendar"
+)
+
+// Sessions on Tuesday 2 July 2024 and an early close on Wednesday 3 July, with
+// Thursday 4 July closed
+func withCalendar(t *testing.T) {
+  sessions, err := calendar.Parse([]byte(`[
+    {"date":"2024-07-02","open":"09:30","close":"16:00"},
+    {"date":"2024-07-03","open":"09:30","close":"13:00"},
+    {"date":"2024-07-05","open":"09:30","close":"16:00"}
+  ]`))
+  if err != nil {
+    t.Fatal(err)
+  }
+  saved := MarketCalendar
+  MarketCalendar = calendar.New(false)
+  MarketCalendar.LoadSessions(sessions)
+  t.Cleanup(func() {
+    MarketCalendar = saved
+  })
+}
+
+func ny(day int, hour int, min int) time.Time {
+  return time.Date(2024, 7, day, hour, min, 0, 0, calendar.NewYork).UTC()
+}
+
+func TestMissingMinutesSession(t *testing.T) {
+  withCalendar(t)
+  a := newAssetTesting()
+  a.Class = "stock"
+
+  // Within the session
+  a.Time = ny(2, 10, 0)
+  assert.Equal(t, 2, len(a.missingMinutes(ny(2, 10, 3))))
+
+  // Across the close and the next open only minutes in the sessions are missing
+  a.Time = ny(2, 15, 58)
+  missing := a.missingMinutes(ny(3, 9, 33))
+  assert.Equal(t, []time.Time{ny(2, 15, 59), ny(2, 16, 0), ny(3, 9, 31), ny(3, 9, 32)}, missing)
+
+  // Over the holiday
+  a.Time = ny(3, 13, 0)
+  assert.Equal(t, 0, len(a.missingMinutes(ny(5, 9, 31))))
+
+  // Outside the calendar the old same day rule applies
+  a.Time = ny(10, 10, 0)
+  assert.Equal(t, 2, len(a.missingMinutes(ny(10, 10, 3))))
+  assert.Equal(t, 0, len(a.missingMinutes(ny(11, 10, 3))))
+
+  // Crypto is not affected by the calendar
+  a.Class = "crypto"
+  a.Time = ny(3, 13, 0)
+  assert.Equal(t, 5, len(a.missingMinutes(ny(3, 13, 6))))
+}
+
+func TestStockOpenAllowed(t *testing.T) {
+  withCalendar(t)
+  saved := config.C.FlattenBeforeClose
+  t.Cleanup(func() {
+    config.C.FlattenBeforeClose = saved
+  })
+
+  config.C.FlattenBeforeClose = 0
+  assert.True(t, stockOpenAllowed(ny(3, 12, 59)))
+  assert.False(t, stockOpenAllowed(ny(3, 13, 0)))
+  assert.False(t, stockOpenAllowed(ny(4, 12, 0)))
+
+  config.C.FlattenBeforeClose = 10 * time.Minute
+  assert.True(t, stockOpenAllowed(ny(3, 12, 49)))
+  assert.False(t, stockOpenAllowed(ny(3, 12, 50)))
+}
+
+func TestStockOpenAllowedExtendedHours(t *testing.T) {
+  sessions, err := calendar.Parse([]byte(`[
+    {"date":"2024-07-02","open":"09:30","close":"16:00","session_open":"0400","session_close":"2000"}
+  ]`))
+  if err != nil {
+    t.Fatal(err)
+  }
+  saved := MarketCalendar
+  saved_flatten := config.C.FlattenBeforeClose
+  MarketCalendar = calendar.New(true)
+  MarketCalendar.LoadSessions(sessions)
+  config.C.FlattenBeforeClose = 10 * time.Minute
+  t.Cleanup(func() {
+    MarketCalendar = saved
+    config.C.FlattenBeforeClose = saved_flatten
+  })
+
+  // Blocked and flattened before the post market close, not the regular close
+  assert.True(t, stockOpenAllowed(ny(2, 15, 55)))
+  assert.True(t, stockOpenAllowed(ny(2, 17, 0)))
+  assert.False(t, stockOpenAllowed(ny(2, 19, 50)))
+
+  a := newAssetTesting()
+  a.Class = "stock"
+  a.Positions = map[string]*Position{"filled": {Symbol: "Foo"}}
+  closes := 0
+  a.close = func(params request.OrderParams, strat_name string) {
+    closes++
+  }
+  flattenStocks(map[string]*Asset{"Foo": a}, ny(2, 15, 55))
+  assert.Equal(t, 0, closes)
+  flattenStocks(map[string]*Asset{"Foo": a}, ny(2, 19, 51))
+  assert.Equal(t, 1, closes)
+}
+
+func TestFlattenStocks(t *testing.T) {
+  withCalendar(t)
+  saved := config.C.FlattenBeforeClose
+  t.Cleanup(func() {
+    config.C.FlattenBeforeClose = saved
+  })
+  config.C.FlattenBeforeClose = 10 * time.Minute
+
+  a := newAssetTesting()
+  a.Class = "stock"
+  a.Positions = map[string]*Position{
+    "filled": {Symbol: "Foo"},
+    "pending": {Symbol: "Foo", OpenOrderPending: true},
+  }
+  var closed []string
+  a.close = func(params request.OrderParams, strat_name string) {
+    closed = append(closed, strat_name)
+  }
+  assets := map[string]*Asset{"Foo": a}
+
+  flattenStocks(assets, ny(3, 12, 49))
+  assert.Empty(t, closed)
+  flattenStocks(assets, ny(3, 12, 51))
+  assert.Equal(t, []string{"filled"}, closed)
+}
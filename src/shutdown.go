@@ -1,3 +1,9 @@
+// Shutdown policies, run on signals as mapped in the config or from the admin API.
+// The prompt policy asks on stdin what to do, which is of no use under a process
+// manager, so the other policies run unattended. They stop waiting for orders at
+// shutdown_deadline and record their outcome in the shutdowns table. The process
+// exits if it is still running shutdownExitGrace after the deadline.
+
 package main
 
 import ( 
@@ -5,26 +11,61 @@ import (
   "log"
   "fmt"
   "time"
+  "errors"
   "sync"
+  "strings"
   "os/signal"
   "syscall"
   "context"
+  "sync/atomic"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
   "github.com/Kjellemann1/AlgoTrader-Go/request"
+  "github.com/Kjellemann1/AlgoTrader-Go/util"
 )
 
-func stallIfOrdersPending(assets map[string]map[string]*Asset) {
-  var retries int
-  ticker := time.NewTicker(5 * time.Second)
+// How often the shutdown checks pending orders and retries closing positions
+var shutdownPollInterval = 5 * time.Second
+
+const shutdownExitGrace = 30 * time.Second
+
+var shutdownSignals = map[string]os.Signal{
+  "SIGINT": os.Interrupt,
+  "SIGTERM": syscall.SIGTERM,
+  "SIGUSR1": syscall.SIGUSR1,
+  "SIGUSR2": syscall.SIGUSR2,
+}
+
+// Policies of the signals that are mapped in the config, by signal name
+func signalPolicies() map[string]string {
+  policies := make(map[string]string)
+  for name, policy := range map[string]string{
+    "SIGINT": config.C.ShutdownSIGINT,
+    "SIGTERM": config.C.ShutdownSIGTERM,
+    "SIGUSR1": config.C.ShutdownSIGUSR1,
+    "SIGUSR2": config.C.ShutdownSIGUSR2,
+  } {
+    if policy != "" {
+      policies[name] = policy
+    }
+  }
+  return policies
+}
+
+// Waits for the pending orders to be filled or canceled. Returns false if the
+// deadline is reached first.
+func stallIfOrdersPending(assets map[string]map[string]*Asset, deadline time.Time) bool {
+  ticker := time.NewTicker(shutdownPollInterval)
   defer ticker.Stop()
   for range ticker.C {
+    globRwm.RLock()
     pending := pendingOrders(assets)
+    globRwm.RUnlock()
     if len(pending) == 0 {
-      return
-    } else if retries > 6 {
-      log.Printf("[ WARNING ]\tTimeout reached waiting for pending orders: 30 seconds\t  -> Shutting down ...\n")
-      return
+      return true
+    } else if !time.Now().Before(deadline) {
+      util.Warning(errors.New("Deadline reached waiting for pending orders"), "Details", "Shutting down ...")
+      return false
     } else {
-      retries++
       log.Println("Waiting for pending orders:")
       for symbol, positions := range pending {
         count_open := 0
@@ -40,18 +81,193 @@ func stallIfOrdersPending(assets map[string]map[string]*Asset) {
       }
     }
   }
+  return false
+}
+
+// Graceful shutdown, started by a signal or the admin API. Shutdowns are run by
+// shutdownHandler, and only the first one requested is run.
+type Shutdown struct {
+  marketCancel   context.CancelFunc
+  accountCancel  context.CancelFunc
+  assets         map[string]map[string]*Asset
+  db_chan        chan *Query
+  started        atomic.Bool
+  requested      chan func()
+  exit           func(int)  // Called when the shutdown does not finish in time
+  db_done        bool       // The last queries are sent. Guarded by db_mutex.
+  db_mutex       sync.Mutex
+}
+
+func NewShutdown(marketCancel context.CancelFunc, accountCancel context.CancelFunc, assets map[string]map[string]*Asset, db_chan chan *Query) *Shutdown {
+  return &Shutdown{
+    marketCancel: marketCancel,
+    accountCancel: accountCancel,
+    assets: assets,
+    db_chan: db_chan,
+    requested: make(chan func(), 1),
+    exit: os.Exit,
+  }
+}
+
+// Sends query to the database without waiting. Returns false if the queue is full,
+// or if the shutdown has sent its last queries and the database is stopping.
+func (s *Shutdown) sendQuery(query *Query) bool {
+  s.db_mutex.Lock()
+  defer s.db_mutex.Unlock()
+  if s.db_done {
+    return false
+  }
+  select {
+  case s.db_chan <- query:
+    return true
+  default:
+    return false
+  }
+}
+
+// Queues the shutdown f. Returns false if a shutdown has already been requested.
+func (s *Shutdown) request(f func()) bool {
+  if !s.started.CompareAndSwap(false, true) {
+    return false
+  }
+  s.requested <- f
+  return true
+}
+
+type ShutdownOutcome struct {
+  Policy           string
+  Trigger          string  // Signal name, or "admin"
+  StartTime        time.Time
+  EndTime          time.Time
+  PositionsLeft    int     // Positions saved for the next run
+  OrdersPending    int     // Positions with orders still pending when the account stopped
+  DeadlineReached  bool
+}
+
+// Closes the positions of the asset classes through the strategies, retrying
+// closes that are canceled, until they are flat or the deadline is reached.
+// Returns false if the deadline is reached first.
+func (s *Shutdown) flatten(classes []string, deadline time.Time) bool {
+  ticker := time.NewTicker(shutdownPollInterval)
+  defer ticker.Stop()
+  for {
+    var assets []*Asset
+    globRwm.RLock()
+    for _, asset_class := range classes {
+      for _, a := range s.assets[asset_class] {
+        assets = append(assets, a)
+      }
+    }
+    globRwm.RUnlock()
+
+    left := 0
+    for _, a := range assets {
+      left += a.closeAll("Closing on shutdown")
+    }
+    if left == 0 {
+      return true
+    }
+    if !time.Now().Before(deadline) {
+      util.Warning(errors.New("Deadline reached flattening"), "Asset classes", classes, "Positions left", left)
+      return false
+    }
+    <-ticker.C
+  }
+}
+
+// Runs the policy. Markets are stopped first, so that strategies neither open nor
+// close positions while shutting down. The positions table is cleared if
+// clear_table is set, and saved otherwise.
+func (s *Shutdown) run(policy string, trigger string, clear_table bool) {
+  outcome := &ShutdownOutcome{Policy: policy, Trigger: trigger, StartTime: time.Now().UTC()}
+  deadline := outcome.StartTime.Add(config.C.ShutdownDeadline)
+  time.AfterFunc(config.C.ShutdownDeadline + shutdownExitGrace, func() {
+    util.Error(errors.New("Shutdown did not finish in time"), "Details", "Exiting ...")
+    util.Flush()
+    s.exit(1)
+  })
+  util.Info("Shutting down", "Policy", policy, "Trigger", trigger)
+
+  s.marketCancel()
+  flat := true
+  switch policy {
+  case "flatten":
+    flat = s.flatten([]string{"stock", "crypto"}, deadline)
+  case "flatten_stock", "flatten_crypto":
+    flat = s.flatten([]string{strings.TrimPrefix(policy, "flatten_")}, deadline)
+  case "close_all":
+    request.CloseAllPositions(2, 0)
+  }
+  stalled := stallIfOrdersPending(s.assets, deadline)
+  s.accountCancel()
+
+  globRwm.RLock()
+  for _, asset_class := range s.assets {
+    for _, a := range asset_class {
+      outcome.PositionsLeft += len(a.positionsCopy())
+    }
+  }
+  for _, positions := range pendingOrders(s.assets) {
+    outcome.OrdersPending += len(positions)
+  }
+  globRwm.RUnlock()
+  outcome.DeadlineReached = !flat || !stalled
+  outcome.EndTime = time.Now().UTC()
+
+  s.db_mutex.Lock()
+  defer s.db_mutex.Unlock()
+  s.db_done = true
+  if clear_table {
+    s.db_chan <- &Query{Action: "delete_all_positions"}
+  } else {
+    s.db_chan <- &Query{Action: "save_state"}
+  }
+  s.db_chan <- &Query{Action: "shutdown", Outcome: outcome}
+  s.db_chan <- nil
 }
 
-func shutdownHandler(wg *sync.WaitGroup, marketCancel context.CancelFunc, accountCancel context.CancelFunc, assets map[string]map[string]*Asset, db_chan chan *Query) {
+// The policy as a shutdown to request
+func (s *Shutdown) policy(policy string, trigger string) func() {
+  return func() {
+    s.run(policy, trigger, false)
+  }
+}
+
+func shutdownHandler(wg *sync.WaitGroup, s *Shutdown) {
   defer wg.Done()
+  policies := signalPolicies()
   sigChan := make(chan os.Signal, 1)
-  defer close(sigChan)
-  signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+  // Notify relays all signals when given none
+  if len(policies) > 0 {
+    for name := range policies {
+      signal.Notify(sigChan, shutdownSignals[name])
+    }
+    defer signal.Stop(sigChan)
+  }
 
   for {
-    sig := <-sigChan
-    NNP.NoNewPositionsTrue("Run")
+    var sig os.Signal
+    select {
+    case f := <-s.requested:
+      NNP.NoNewPositionsTrue("Run")
+      f()
+      return
+    case sig = <-sigChan:
+    }
+    var name string
+    for n, shutdown_sig := range shutdownSignals {
+      if shutdown_sig == sig {
+        name = n
+      }
+    }
     log.Printf("Received signal: %v\n", sig)
+    if policy := policies[name]; policy != "prompt" {
+      // Picked up by the select, unless another shutdown was requested first
+      s.request(s.policy(policy, name))
+      continue
+    }
+
+    NNP.NoNewPositionsTrue("Run")
     fmt.Printf("  -> 1) Abort\n  -> 2) Save state and shutdown\n  -> 3) Close all positions and shutdown\n")
     fmt.Printf("Enter choice: ")
     var input string
@@ -63,32 +279,19 @@ func shutdownHandler(wg *sync.WaitGroup, marketCancel context.CancelFunc, accoun
       log.Println("Shutdown aborted. Resuming...")
       continue
     case "2":
-      log.Println("Saving state and shutting down...")
-      marketCancel()
-      stallIfOrdersPending(assets)
-      accountCancel()
-      db_chan <- &Query{Action: "save_state"}
-      db_chan <- nil
-      return
+      s.request(s.policy("save", name))
     case "3":
       fmt.Printf("Are you sure you want to CLOSE ALL POSITIONS? (y/n): ")
       _, _ = fmt.Scanln(&input)
 
       switch input {
       case "Y", "y":
-        log.Println("Closing all positions and shutting down...")
-        request.CloseAllPositions(2, 0)
-        marketCancel()
-        accountCancel()
         fmt.Printf("Do you want to clear the positions table? (y/n): ")
         _, _ = fmt.Scanln(&input)
-        if input == "Y" || input == "y" {
-          db_chan <- &Query{Action: "delete_all_positions"}
-        } else {
-          db_chan <- &Query{Action: "save_state"}
-        }
-        db_chan <- nil
-        return
+        clear_table := input == "Y" || input == "y"
+        s.request(func() {
+          s.run("close_all", name, clear_table)
+        })
       default :
         NNP.NoNewPositionsFalse("Run")
         log.Println("Shutdown aborted. Resuming...")
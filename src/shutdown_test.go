@@ -0,0 +1,103 @@
+package main
+
+import (
+  "time"
+  "testing"
+  "github.com/stretchr/testify/assert"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+)
+
+func newShutdownTesting(t *testing.T, assets map[string]map[string]*Asset) (*Shutdown, *int) {
+  saved := *config.C
+  saved_interval := shutdownPollInterval
+  t.Cleanup(func() {
+    *config.C = saved
+    shutdownPollInterval = saved_interval
+  })
+  config.C.ShutdownDeadline = 50 * time.Millisecond
+  shutdownPollInterval = 5 * time.Millisecond
+
+  canceled := 0
+  cancel := func() {
+    canceled++
+  }
+  s := NewShutdown(cancel, cancel, assets, make(chan *Query, 3))
+  s.exit = func(int) {}
+  return s, &canceled
+}
+
+func TestSignalPolicies(t *testing.T) {
+  saved := *config.C
+  t.Cleanup(func() {
+    *config.C = saved
+  })
+  assert.Equal(t, map[string]string{"SIGINT": "prompt", "SIGTERM": "save"}, signalPolicies())
+  config.C.ShutdownSIGINT = ""
+  config.C.ShutdownSIGUSR1 = "flatten_crypto"
+  assert.Equal(t, map[string]string{"SIGTERM": "save", "SIGUSR1": "flatten_crypto"}, signalPolicies())
+}
+
+func TestShutdownFlatten(t *testing.T) {
+  crypto := newAssetTesting()
+  crypto.Positions = make(map[string]*Position)
+  pos := NewPosition("Foo")
+  pos.OpenOrderPending = false
+  crypto.Positions["foo"] = pos
+  crypto.close = func(params request.OrderParams, strat_name string) {
+    crypto.removePosition(strat_name)
+  }
+  stock := newAssetTesting()
+  stock.Positions = map[string]*Position{"bar": {}}
+  stock.close = func(params request.OrderParams, strat_name string) {
+    t.Error("Stock positions are kept")
+  }
+  s, canceled := newShutdownTesting(t, map[string]map[string]*Asset{
+    "crypto": {"Foo": crypto},
+    "stock": {"Bar": stock},
+  })
+
+  s.run("flatten_crypto", "SIGUSR1", false)
+  assert.Equal(t, 2, *canceled)
+  assert.Empty(t, crypto.Positions)
+  assert.Equal(t, "save_state", (<-s.db_chan).Action)
+  q := <-s.db_chan
+  assert.Equal(t, "shutdown", q.Action)
+  assert.Equal(t, "flatten_crypto", q.Outcome.Policy)
+  assert.Equal(t, "SIGUSR1", q.Outcome.Trigger)
+  assert.Equal(t, 1, q.Outcome.PositionsLeft)
+  assert.False(t, q.Outcome.DeadlineReached)
+  assert.Nil(t, <-s.db_chan)
+  assert.False(t, s.sendQuery(&Query{Action: "save_state"}), "The database is stopping")
+}
+
+func TestShutdownDeadline(t *testing.T) {
+  a := newAssetTesting()
+  pos := NewPosition("Foo")
+  pos.OpenOrderPending = false
+  a.Positions = map[string]*Position{"foo": pos}
+  closes := 0
+  // The close is never filled
+  a.close = func(params request.OrderParams, strat_name string) {
+    closes++
+    pos.CloseOrderPending = true
+  }
+  s, _ := newShutdownTesting(t, map[string]map[string]*Asset{"crypto": {"Foo": a}})
+
+  start := time.Now()
+  s.run("flatten", "SIGTERM", false)
+  assert.Less(t, time.Since(start), time.Second)
+  assert.Equal(t, 1, closes, "Pending closes are not resent")
+  <-s.db_chan
+  q := <-s.db_chan
+  assert.True(t, q.Outcome.DeadlineReached)
+  assert.Equal(t, 1, q.Outcome.PositionsLeft)
+  assert.Equal(t, 1, q.Outcome.OrdersPending)
+}
+
+func TestShutdownRequest(t *testing.T) {
+  s, _ := newShutdownTesting(t, nil)
+  assert.True(t, s.request(s.policy("save", "admin")))
+  assert.False(t, s.request(s.policy("flatten", "SIGTERM")), "Only the first shutdown is run")
+  assert.Len(t, s.requested, 1)
+}
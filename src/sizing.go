@@ -0,0 +1,84 @@
+package main
+
+import (
+  "sync"
+  "time"
+  "errors"
+  "context"
+  "github.com/shopspring/decimal"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+  "github.com/Kjellemann1/AlgoTrader-Go/sizing"
+  "github.com/Kjellemann1/AlgoTrader-Go/util"
+)
+
+// Account equity used by the sizers. It is fetched in the background every refresh
+// interval, so that opens never wait for the account endpoint.
+type equityCache struct {
+  fetch    func() (float64, error)
+  refresh  time.Duration
+  value    float64
+  updated  time.Time
+  mutex    sync.Mutex
+}
+
+var accountEquity = &equityCache{fetch: request.GetEquity, refresh: time.Minute}
+
+// Returns the last fetched equity
+func (e *equityCache) get() (float64, error) {
+  e.mutex.Lock()
+  defer e.mutex.Unlock()
+  if e.updated.IsZero() {
+    return 0, errors.New("Account equity not fetched")
+  }
+  return e.value, nil
+}
+
+// Fetches the equity. The last value is kept if it fails.
+func (e *equityCache) update() error {
+  equity, err := e.fetch()
+  if err != nil {
+    return err
+  }
+  e.mutex.Lock()
+  defer e.mutex.Unlock()
+  e.value, e.updated = equity, time.Now()
+  return nil
+}
+
+func (e *equityCache) start(wg *sync.WaitGroup, ctx context.Context) {
+  defer wg.Done()
+  ticker := time.NewTicker(e.refresh)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+      if err := e.update(); err != nil {
+        util.Warning(err, "Details", "Failed to refresh account equity")
+      }
+    }
+  }
+}
+
+// Qty of an open order at price, or at the limit price if set, using the sizer of
+// the params and the order size rules of the asset. Called with a.Mutex held.
+func (a *Asset) openQty(params request.OrderParams, price float64, equity func() (float64, error)) (decimal.Decimal, error) {
+  sizer := params.Sizer
+  if sizer == nil {
+    sizer = sizing.Notional{USD: config.C.NotionalUSD}
+  }
+  if params.LimitPrice > 0 {
+    price = params.LimitPrice
+  }
+  in := sizing.Input{
+    Price: price,
+    StopLoss: params.StopLoss,
+    Equity: equity,
+    ATR: func(period int) float64 {
+      return a.atr(period).Value(0)
+    },
+  }
+  return sizing.Qty(sizer, in, a.Rules)
+}
@@ -0,0 +1,168 @@
+// Package sizing calculates the qty of open orders. A strategy selects a sizer per
+// open call through the order params, and the default is a fixed notional.
+//
+// Sizers return an unrounded qty. Qty rounds it down to the order size increment of
+// the asset and rejects orders below the minimum order size. Close orders are
+// rounded with the same rules.
+
+package sizing
+
+import (
+  "fmt"
+  "math"
+  "errors"
+  "github.com/shopspring/decimal"
+)
+
+// What a sizer knows about the open. Equity and ATR are functions so that the
+// account is only fetched and the indicator only created when a sizer uses them.
+type Input struct {
+  Price     float64  // Price the qty is calculated from, the limit price if set
+  StopLoss  float64  // Stop loss of a bracket order, 0 if none
+  Equity    func() (float64, error)
+  ATR       func(period int) float64
+}
+
+type Sizer interface {
+  Qty(in Input) (float64, error)
+}
+
+// Fixed amount in dollars
+type Notional struct {
+  USD  float64
+}
+
+func (s Notional) Qty(in Input) (float64, error) {
+  if s.USD <= 0 {
+    return 0, errors.New("Notional must be positive")
+  }
+  return s.USD / in.Price, nil
+}
+
+// Percent of account equity
+type PercentOfEquity struct {
+  Pct  float64
+}
+
+func (s PercentOfEquity) Qty(in Input) (float64, error) {
+  equity, err := in.Equity()
+  if err != nil {
+    return 0, err
+  }
+  return equity * s.Pct / 100 / in.Price, nil
+}
+
+// Sizes the position so that a move of Multiple times the average true range loses
+// RiskPct percent of equity. Multiple defaults to 1.
+type VolatilityTarget struct {
+  RiskPct   float64
+  Period    int
+  Multiple  float64
+}
+
+func (s VolatilityTarget) Qty(in Input) (float64, error) {
+  atr := in.ATR(s.Period)
+  if math.IsNaN(atr) || atr <= 0 {
+    return 0, fmt.Errorf("ATR(%d) not available", s.Period)
+  }
+  multiple := s.Multiple
+  if multiple == 0 {
+    multiple = 1
+  }
+  equity, err := in.Equity()
+  if err != nil {
+    return 0, err
+  }
+  return equity * s.RiskPct / 100 / (atr * multiple), nil
+}
+
+// Sizes the position so that hitting the stop loses RiskPct percent of equity. The
+// stop distance is in price units, and is taken from the stop loss of a bracket
+// order if not set.
+type FixedFractional struct {
+  RiskPct       float64
+  StopDistance  float64
+}
+
+func (s FixedFractional) Qty(in Input) (float64, error) {
+  distance := s.StopDistance
+  if distance == 0 && in.StopLoss > 0 {
+    distance = math.Abs(in.Price - in.StopLoss)
+  }
+  if distance <= 0 {
+    return 0, errors.New("No stop distance")
+  }
+  equity, err := in.Equity()
+  if err != nil {
+    return 0, err
+  }
+  return equity * s.RiskPct / 100 / distance, nil
+}
+
+// Kelly criterion for a strategy that wins WinRate of its trades, with Payoff as the
+// ratio of the average win to the average loss. Fraction scales the Kelly fraction,
+// e.g. 0.5 for half Kelly, and defaults to 1. The position never exceeds equity.
+type Kelly struct {
+  WinRate   float64
+  Payoff    float64
+  Fraction  float64
+}
+
+func (s Kelly) Qty(in Input) (float64, error) {
+  if s.Payoff <= 0 {
+    return 0, errors.New("Payoff must be positive")
+  }
+  f := s.WinRate - (1 - s.WinRate) / s.Payoff
+  if f <= 0 {
+    return 0, errors.New("No edge")
+  }
+  if s.Fraction > 0 {
+    f *= s.Fraction
+  }
+  equity, err := in.Equity()
+  if err != nil {
+    return 0, err
+  }
+  return equity * math.Min(f, 1) / in.Price, nil
+}
+
+// Order size rules of an asset
+type Rules struct {
+  MinQty     decimal.Decimal  // Smallest qty that can be ordered
+  Increment  decimal.Decimal  // Qty must be a multiple of this
+}
+
+// Whole shares for stocks and nine decimals for crypto
+func DefaultRules(asset_class string) Rules {
+  if asset_class == "stock" {
+    return Rules{MinQty: decimal.NewFromInt(1), Increment: decimal.NewFromInt(1)}
+  }
+  return Rules{MinQty: decimal.Zero, Increment: decimal.New(1, -9)}
+}
+
+// Rounds qty down to the increment. Zero if the result is below the minimum order
+// size.
+func (r Rules) Round(qty decimal.Decimal) decimal.Decimal {
+  if r.Increment.IsPositive() {
+    qty = qty.Div(r.Increment).RoundDown(0).Mul(r.Increment)
+  }
+  if qty.LessThan(r.MinQty) || !qty.IsPositive() {
+    return decimal.Zero
+  }
+  return qty
+}
+
+// Qty of the sizer rounded to the rules of the asset
+func Qty(s Sizer, in Input, rules Rules) (decimal.Decimal, error) {
+  if in.Price <= 0 {
+    return decimal.Zero, errors.New("Price must be positive")
+  }
+  qty, err := s.Qty(in)
+  if err != nil {
+    return decimal.Zero, err
+  }
+  if math.IsNaN(qty) || math.IsInf(qty, 0) || qty <= 0 {
+    return decimal.Zero, nil
+  }
+  return rules.Round(decimal.NewFromFloat(qty)), nil
+}
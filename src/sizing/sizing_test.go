@@ -0,0 +1,97 @@
+package sizing
+
+import (
+  "math"
+  "errors"
+  "testing"
+  "github.com/shopspring/decimal"
+  "github.com/stretchr/testify/assert"
+)
+
+func input(price float64) Input {
+  return Input{
+    Price: price,
+    Equity: func() (float64, error) {
+      return 10000, nil
+    },
+    ATR: func(period int) float64 {
+      return 2
+    },
+  }
+}
+
+func TestSizers(t *testing.T) {
+  crypto := DefaultRules("crypto")
+
+  qty, err := Qty(Notional{USD: 50}, input(20), crypto)
+  assert.Nil(t, err)
+  assert.Equal(t, "2.5", qty.String())
+
+  qty, err = Qty(PercentOfEquity{Pct: 2}, input(40), crypto)
+  assert.Nil(t, err)
+  assert.Equal(t, "5", qty.String())
+
+  // 1% of equity over 3 ATRs of 2
+  qty, err = Qty(VolatilityTarget{RiskPct: 1, Period: 14, Multiple: 3}, input(40), crypto)
+  assert.Nil(t, err)
+  assert.Equal(t, "16.666666666", qty.String())
+
+  // Stop distance from the bracket stop loss
+  in := input(40)
+  in.StopLoss = 35
+  qty, err = Qty(FixedFractional{RiskPct: 0.5}, in, crypto)
+  assert.Nil(t, err)
+  assert.Equal(t, "10", qty.String())
+  qty, err = Qty(FixedFractional{RiskPct: 0.5, StopDistance: 2}, in, crypto)
+  assert.Nil(t, err)
+  assert.Equal(t, "25", qty.String())
+
+  // Half of f = 0.75 - 0.25 / 1 = 0.5
+  qty, err = Qty(Kelly{WinRate: 0.75, Payoff: 1, Fraction: 0.5}, input(100), crypto)
+  assert.Nil(t, err)
+  assert.Equal(t, "25", qty.String())
+}
+
+func TestSizerErrors(t *testing.T) {
+  crypto := DefaultRules("crypto")
+
+  _, err := Qty(FixedFractional{RiskPct: 1}, input(40), crypto)
+  assert.NotNil(t, err, "No stop")
+
+  in := input(40)
+  in.ATR = func(period int) float64 {
+    return math.NaN()
+  }
+  _, err = Qty(VolatilityTarget{RiskPct: 1, Period: 14}, in, crypto)
+  assert.NotNil(t, err, "ATR not warmed up")
+
+  _, err = Qty(Kelly{WinRate: 0.3, Payoff: 1}, input(40), crypto)
+  assert.NotNil(t, err, "Negative edge")
+
+  in.Equity = func() (float64, error) {
+    return 0, errors.New("Account unavailable")
+  }
+  _, err = Qty(PercentOfEquity{Pct: 1}, in, crypto)
+  assert.NotNil(t, err)
+
+  _, err = Qty(Notional{USD: 50}, input(0), crypto)
+  assert.NotNil(t, err)
+}
+
+func TestRules(t *testing.T) {
+  stock := DefaultRules("stock")
+  qty, err := Qty(Notional{USD: 50}, input(12.5), stock)
+  assert.Nil(t, err)
+  assert.Equal(t, "4", qty.String())
+
+  // Below one share
+  qty, err = Qty(Notional{USD: 50}, input(60), stock)
+  assert.Nil(t, err)
+  assert.True(t, qty.IsZero())
+
+  rules := Rules{MinQty: decimal.RequireFromString("0.0001"), Increment: decimal.RequireFromString("0.0001")}
+  qty, _ = Qty(Notional{USD: 50}, input(30000), rules)
+  assert.Equal(t, "0.0016", qty.String())
+  qty, _ = Qty(Notional{USD: 1}, input(30000), rules)
+  assert.True(t, qty.IsZero())
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+  "errors"
+  "testing"
+  "time"
+  "github.com/stretchr/testify/assert"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+  "github.com/Kjellemann1/AlgoTrader-Go/sizing"
+)
+
+func TestOpenQty(t *testing.T) {
+  a := newAssetTesting()
+  a.Rules = sizing.DefaultRules("stock")
+  equity := func() (float64, error) {
+    return 10000, nil
+  }
+
+  // Fixed notional by default, calculated from the limit price if set
+  qty, err := a.openQty(IOC, 10, equity)
+  assert.Nil(t, err)
+  assert.Equal(t, int64(config.C.NotionalUSD / 10), qty.IntPart())
+  qty, _ = a.openQty(request.Limit(12.5, "day"), 10, equity)
+  assert.Equal(t, int64(config.C.NotionalUSD / 12.5), qty.IntPart())
+
+  qty, err = a.openQty(IOC.Sized(sizing.PercentOfEquity{Pct: 5}), 10, equity)
+  assert.Nil(t, err)
+  assert.Equal(t, "50", qty.String())
+
+  // The ATR of an asset without history is not available
+  _, err = a.openQty(IOC.Sized(sizing.VolatilityTarget{RiskPct: 1, Period: 14}), 10, equity)
+  assert.NotNil(t, err)
+}
+
+func TestEquityCache(t *testing.T) {
+  calls := 0
+  fail := false
+  e := &equityCache{refresh: time.Hour, fetch: func() (float64, error) {
+    calls++
+    if fail {
+      return 0, errors.New("Account unavailable")
+    }
+    return 1000, nil
+  }}
+
+  fail = true
+  assert.NotNil(t, e.update())
+  _, err := e.get()
+  assert.NotNil(t, err, "No value to fall back on")
+
+  fail = false
+  assert.Nil(t, e.update())
+  equity, err := e.get()
+  assert.Nil(t, err)
+  assert.Equal(t, 1000.0, equity)
+  e.get()
+  assert.Equal(t, 2, calls, "Only fetched by update")
+
+  // The last value is used if the refresh fails
+  fail = true
+  assert.NotNil(t, e.update())
+  equity, err = e.get()
+  assert.Nil(t, err)
+  assert.Equal(t, 1000.0, equity)
+}
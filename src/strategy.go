@@ -0,0 +1,222 @@
+// Strategies register themselves in the strategy registry with a name, the asset
+// classes and symbols they trade, a parameter struct and a tick function. Assets
+// run the tick functions of the strategies that apply to them on every update.
+//
+// Strategies run on every minute bar and trade unless they set a trigger, in which
+// case they run when a bar of that timeframe closes. Timeframes above one minute
+// must also be declared in Timeframes, which the assets aggregate from minute bars.
+//
+// Parameters, asset classes, symbols and timeframes can be overridden in the
+// strategy config file, which is read at startup:
+//
+//   rand:
+//     enabled: true
+//     classes: [crypto]
+//     symbols: [BTC/USD, ETH/USD]
+//     timeframes: [5m, 1h]
+//     trigger: 5m
+//     params:
+//       probability: 5
+
+package main
+
+import (
+  "os"
+  "fmt"
+  "bytes"
+  "errors"
+  "regexp"
+  "slices"
+  "strconv"
+  "strings"
+  "time"
+  "gopkg.in/yaml.v3"
+  "github.com/Kjellemann1/AlgoTrader-Go/util"
+)
+
+type Strategy struct {
+  Name        string
+  Classes     []string         // Asset classes the strategy applies to. Empty means all.
+  Symbols     []string         // Symbols the strategy applies to. Empty means all symbols of the classes.
+  Timeframes  []time.Duration  // Higher timeframes the strategy reads through Asset.frame
+  Trigger     time.Duration    // Runs the strategy on bar closes of this timeframe. 0 runs it on every update, 1m on minute bars only.
+  Params      any              // Pointer to the parameter struct of the strategy, holding the defaults
+  Tick        func(a *Asset, s *Strategy)
+}
+
+func (s *Strategy) run(a *Asset) {
+  s.Tick(a, s)
+}
+
+// Checks that the timeframes can be aggregated, and that the trigger is a minute or
+// one of the timeframes.
+func (s *Strategy) validateTimeframes() error {
+  for _, size := range s.Timeframes {
+    if err := validTimeframe(size); err != nil {
+      return fmt.Errorf("Strategy %q: %w", s.Name, err)
+    }
+  }
+  if s.Trigger != 0 && s.Trigger != time.Minute && !slices.Contains(s.Timeframes, s.Trigger) {
+    return fmt.Errorf("Strategy %q triggers on %s, which is not one of its timeframes", s.Name, s.Trigger)
+  }
+  return nil
+}
+
+// Name of the n-th position of a strategy that holds more than one position per
+// symbol. Strategies with a single position use the strategy name.
+func (s *Strategy) PositionName(n int) string {
+  return s.Name + strconv.Itoa(n)
+}
+
+func (s *Strategy) appliesTo(asset_class string, symbol string) bool {
+  if len(s.Classes) > 0 && !slices.Contains(s.Classes, asset_class) {
+    return false
+  }
+  if len(s.Symbols) > 0 && !slices.Contains(s.Symbols, symbol) {
+    return false
+  }
+  return true
+}
+
+var strategyRegistry []*Strategy
+
+var validStratName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Adds a strategy to the registry. Panics on invalid, duplicate or colliding names, so that
+// mistakes are caught at startup.
+func registerStrategy(s *Strategy) {
+  if !validStratName.MatchString(s.Name) {
+    panic(fmt.Sprintf("Invalid strategy name %q", s.Name))
+  }
+  // The name must survive the round trip through the client order id
+  position_id := (&Asset{Symbol: "X"}).createPositionID(s.PositionName(0))
+  if name := grepStratName(&position_id); name == nil || *name != s.PositionName(0) {
+    panic(fmt.Sprintf("Strategy name %q can not be parsed from position id", s.Name))
+  }
+  if lookupStrategy(s.Name) != nil {
+    panic(fmt.Sprintf("Strategy %q registered twice", s.Name))
+  }
+  // Position names are the strategy name followed by a number, so "rand" and
+  // "rand1" could not be told apart
+  for _, other := range strategyRegistry {
+    if isPositionOf(s.Name, other.Name) || isPositionOf(other.Name, s.Name) {
+      panic(fmt.Sprintf("Strategy name %q collides with the position names of %q", s.Name, other.Name))
+    }
+  }
+  if s.Tick == nil {
+    panic(fmt.Sprintf("Strategy %q has no tick function", s.Name))
+  }
+  if err := s.validateTimeframes(); err != nil {
+    panic(err.Error())
+  }
+  strategyRegistry = append(strategyRegistry, s)
+}
+
+// Whether position_name could be the name of a position of the strategy strat_name.
+func isPositionOf(position_name string, strat_name string) bool {
+  rest, ok := strings.CutPrefix(position_name, strat_name)
+  return ok && rest != "" && strings.Trim(rest, "0123456789") == ""
+}
+
+func lookupStrategy(name string) *Strategy {
+  for _, s := range strategyRegistry {
+    if s.Name == name {
+      return s
+    }
+  }
+  return nil
+}
+
+// Registered strategies that apply to the asset
+func registeredFor(asset_class string, symbol string) []*Strategy {
+  var strategies []*Strategy
+  for _, s := range strategyRegistry {
+    if s.appliesTo(asset_class, symbol) {
+      strategies = append(strategies, s)
+    }
+  }
+  return strategies
+}
+
+type strategyConfig struct {
+  Enabled     *bool            `yaml:"enabled"`
+  Classes     []string         `yaml:"classes"`
+  Symbols     []string         `yaml:"symbols"`
+  Timeframes  []time.Duration  `yaml:"timeframes"`
+  Trigger     *time.Duration   `yaml:"trigger"`
+  Params      yaml.Node        `yaml:"params"`
+}
+
+// Reads the strategy config file and applies it to the registry. The registry is
+// left unchanged if the file does not exist.
+func loadStrategyConfig(path string) error {
+  data, err := os.ReadFile(path)
+  if errors.Is(err, os.ErrNotExist) {
+    util.Info("No strategy config at " + path + ", using defaults")
+    return nil
+  }
+  if err != nil {
+    return err
+  }
+  return applyStrategyConfig(data)
+}
+
+func applyStrategyConfig(data []byte) error {
+  var configs map[string]strategyConfig
+  dec := yaml.NewDecoder(bytes.NewReader(data))
+  dec.KnownFields(true)
+  if err := dec.Decode(&configs); err != nil {
+    return fmt.Errorf("Invalid strategy config: %w", err)
+  }
+
+  disabled := make(map[string]bool)
+  for name, cfg := range configs {
+    s := lookupStrategy(name)
+    if s == nil {
+      return fmt.Errorf("Unknown strategy %q in strategy config", name)
+    }
+    for _, asset_class := range cfg.Classes {
+      if asset_class != "stock" && asset_class != "crypto" {
+        return fmt.Errorf("Invalid asset class %q for strategy %q", asset_class, name)
+      }
+    }
+    if cfg.Params.Kind != 0 {
+      if s.Params == nil {
+        return fmt.Errorf("Strategy %q takes no params", name)
+      }
+      // Re-encoded to decode with KnownFields, which yaml.Node.Decode does not support
+      raw, err := yaml.Marshal(&cfg.Params)
+      if err != nil {
+        return err
+      }
+      dec := yaml.NewDecoder(bytes.NewReader(raw))
+      dec.KnownFields(true)
+      if err := dec.Decode(s.Params); err != nil {
+        return fmt.Errorf("Invalid params for strategy %q: %w", name, err)
+      }
+    }
+    if cfg.Classes != nil {
+      s.Classes = cfg.Classes
+    }
+    if cfg.Symbols != nil {
+      s.Symbols = cfg.Symbols
+    }
+    if cfg.Timeframes != nil {
+      s.Timeframes = cfg.Timeframes
+    }
+    if cfg.Trigger != nil {
+      s.Trigger = *cfg.Trigger
+    }
+    if err := s.validateTimeframes(); err != nil {
+      return err
+    }
+    if cfg.Enabled != nil && !*cfg.Enabled {
+      disabled[name] = true
+    }
+  }
+
+  strategyRegistry = slices.DeleteFunc(strategyRegistry, func(s *Strategy) bool {
+    return disabled[s.Name]
+  })
+  return nil
+}
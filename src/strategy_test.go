@@ -0,0 +1,96 @@
+package main
+
+import (
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+// Replaces the registry for the duration of the test
+func withRegistry(t *testing.T, strategies ...*Strategy) {
+  saved := strategyRegistry
+  strategyRegistry = nil
+  t.Cleanup(func() {
+    strategyRegistry = saved
+  })
+  for _, s := range strategies {
+    registerStrategy(s)
+  }
+}
+
+type testParams struct {
+  Threshold  float64  `yaml:"threshold"`
+  Period     int      `yaml:"period"`
+}
+
+func TestRegisterStrategy(t *testing.T) {
+  tick := func(a *Asset, s *Strategy) {}
+  withRegistry(t, &Strategy{Name: "foo", Tick: tick})
+
+  assert.Panics(t, func() { registerStrategy(&Strategy{Name: "foo", Tick: tick}) })
+  assert.Panics(t, func() { registerStrategy(&Strategy{Name: "b]ar", Tick: tick}) })
+  assert.Panics(t, func() { registerStrategy(&Strategy{Name: "", Tick: tick}) })
+  assert.Panics(t, func() { registerStrategy(&Strategy{Name: "bar"}) })
+  assert.Panics(t, func() { registerStrategy(&Strategy{Name: "foo1", Tick: tick}) })
+  assert.NotPanics(t, func() { registerStrategy(&Strategy{Name: "foo_bar", Tick: tick}) })
+
+  position_id := (&Asset{Symbol: "BTC/USD"}).createPositionID(lookupStrategy("foo").PositionName(2))
+  assert.Equal(t, "foo2", *grepStratName(&position_id))
+}
+
+func TestStrategiesFor(t *testing.T) {
+  var ticked []string
+  tick := func(a *Asset, s *Strategy) {
+    ticked = append(ticked, s.Name)
+  }
+  withRegistry(t,
+    &Strategy{Name: "all", Tick: tick},
+    &Strategy{Name: "crypto", Classes: []string{"crypto"}, Tick: tick},
+    &Strategy{Name: "btc", Classes: []string{"crypto"}, Symbols: []string{"BTC/USD"}, Tick: tick},
+  )
+
+  a := allocAsset("crypto", "BTC/USD")
+  a.checkForSignal(a.triggeredStrategies())
+  assert.Equal(t, []string{"all", "crypto", "btc"}, ticked)
+
+  ticked = nil
+  a = allocAsset("crypto", "ETH/USD")
+  a.checkForSignal(a.triggeredStrategies())
+  assert.Equal(t, []string{"all", "crypto"}, ticked)
+
+  ticked = nil
+  a = allocAsset("stock", "AAPL")
+  a.checkForSignal(a.triggeredStrategies())
+  assert.Equal(t, []string{"all"}, ticked)
+}
+
+func TestApplyStrategyConfig(t *testing.T) {
+  tick := func(a *Asset, s *Strategy) {}
+  foo := &Strategy{Name: "foo", Params: &testParams{Threshold: 1.5, Period: 10}, Tick: tick}
+  withRegistry(t, foo, &Strategy{Name: "bar", Tick: tick})
+
+  err := applyStrategyConfig([]byte(`
+foo:
+  symbols: [BTC/USD]
+  params:
+    period: 20
+bar:
+  enabled: false
+`))
+  assert.Nil(t, err)
+  assert.Equal(t, &testParams{Threshold: 1.5, Period: 20}, foo.Params)
+  assert.Equal(t, []string{"BTC/USD"}, foo.Symbols)
+  assert.Nil(t, lookupStrategy("bar"))
+  assert.Equal(t, 1, len(strategyRegistry))
+
+  assert.NotNil(t, applyStrategyConfig([]byte("baz:\n  enabled: true\n")))
+  assert.NotNil(t, applyStrategyConfig([]byte("foo:\n  params:\n    perid: 5\n")))
+  assert.NotNil(t, applyStrategyConfig([]byte("foo:\n  classes: [forex]\n")))
+  assert.NotNil(t, applyStrategyConfig([]byte("foo:\n  enbled: true\n")))
+}
+
+func TestLoadStrategyConfigFile(t *testing.T) {
+  withRegistry(t, &Strategy{Name: "rand", Params: &randParams{Probability: 5}, Tick: (*Asset).rand})
+  assert.Nil(t, loadStrategyConfig("does_not_exist.yaml"))
+  assert.Nil(t, loadStrategyConfig("strategies.yaml"))
+  assert.Equal(t, 5, lookupStrategy("rand").Params.(*randParams).Probability)
+}
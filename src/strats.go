@@ -2,32 +2,41 @@ package main
 
 import (
   "math/rand"
-  // "github.com/markcheno/go-talib"
-  // "github.com/Kjellemann1/Gostuff/indicators"
 )
 
-func (a *Asset) rand() {
-  a.Mutex.Lock()
+func init() {
+  registerStrategy(&Strategy{
+    Name: "rand",
+    Params: &randParams{Probability: 5},
+    Tick: (*Asset).rand,
+  })
+}
 
-  probability := 5
+type randParams struct {
+  Probability int `yaml:"probability"`  // Percent chance of a signal on each side per tick
+}
+
+func (a *Asset) rand(s *Strategy) {
+  p := s.Params.(*randParams)
+  a.Mutex.Lock()
 
   num1 := rand.Intn(100)
   num2 := rand.Intn(100)
 
-  if num1 < probability {
-    a.open("long", "IOC", "rand1")
-    a.close("IOC", "rand2")
-  } else if num1 >= (100 - probability) {
-    a.close("IOC", "rand1")
-    a.open("long", "IOC", "rand2")
+  if num1 < p.Probability {
+    a.open("long", IOC, s.PositionName(1))
+    a.close(IOC, s.PositionName(2))
+  } else if num1 >= (100 - p.Probability) {
+    a.close(IOC, s.PositionName(1))
+    a.open("long", IOC, s.PositionName(2))
   }
 
-  if num2 < probability {
-    a.open("long", "IOC", "rand3")
-    a.close("IOC", "rand4")
-  } else if num1 >= (100 - probability) {
-    a.close("IOC", "rand3")
-    a.open("long", "IOC", "rand4")
+  if num2 < p.Probability {
+    a.open("long", IOC, s.PositionName(3))
+    a.close(IOC, s.PositionName(4))
+  } else if num1 >= (100 - p.Probability) {
+    a.close(IOC, s.PositionName(3))
+    a.open("long", IOC, s.PositionName(4))
   }
 
   a.Mutex.Unlock()
@@ -0,0 +1,224 @@
+// Adding and removing symbols while running. The assets maps are shared by the
+// markets, the account, the database and the risk manager, so they are only changed
+// with globRwm locked, and lookups while running take the read lock.
+//
+// A new asset is backfilled before it is added, so that its strategies never see
+// empty windows. A removed asset stops opening positions, and is unsubscribed and
+// dropped once its positions are closed, either by closing them right away or by
+// waiting for the strategies to close them. Its strategy goroutines are then
+// stopped, and signals from market messages still in flight are dropped.
+
+package main
+
+import (
+  "fmt"
+  "maps"
+  "sync"
+  "time"
+  "errors"
+  "slices"
+  "strings"
+  "context"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/util"
+)
+
+// How often a symbol being removed retries closing its positions
+var retireInterval = 5 * time.Second
+
+func (m *Market) asset(symbol string) *Asset {
+  globRwm.RLock()
+  defer globRwm.RUnlock()
+  return m.assets[symbol]
+}
+
+func (m *Market) symbols() []string {
+  globRwm.RLock()
+  defer globRwm.RUnlock()
+  return slices.Sorted(maps.Keys(m.assets))
+}
+
+// Asset class of a symbol by its format, e.g. AAPL or BTC/USD
+func symbolClass(symbol string) (string, error) {
+  switch strings.Count(symbol, "/") {
+  case 0:
+    if symbol != "" {
+      return "stock", nil
+    }
+  case 1:
+    return "crypto", nil
+  }
+  return "", fmt.Errorf("Invalid symbol %q", symbol)
+}
+
+// Symbol list of the config for the asset class. Kept in sync with the assets, as
+// broker positions are matched to the crypto symbols by it.
+func configSymbols(asset_class string) *[]string {
+  if asset_class == "crypto" {
+    return &config.C.CryptoSymbols
+  }
+  return &config.C.StockSymbols
+}
+
+// Creates, backfills and subscribes to a new asset
+func (m *Market) addSymbol(symbol string) error {
+  m.symbols_mutex.Lock()
+  defer m.symbols_mutex.Unlock()
+  if asset_class, err := symbolClass(symbol); err != nil || asset_class != m.asset_class {
+    return fmt.Errorf("Invalid %s symbol %q", m.asset_class, symbol)
+  }
+  if m.asset(symbol) != nil {
+    return fmt.Errorf("%s already subscribed", symbol)
+  }
+  if AssetInfos != nil && len(tradableSymbols([]string{symbol})) == 0 {
+    return fmt.Errorf("%s is not tradable", symbol)
+  }
+
+  a := newAsset(m.asset_class, symbol)
+  if err := backfill(map[string]*Asset{symbol: a}, m.asset_class); err != nil {
+    return fmt.Errorf("Backfill of %s failed: %w", symbol, err)
+  }
+
+  globRwm.Lock()
+  m.assets[symbol] = a
+  list := configSymbols(m.asset_class)
+  *list = append(slices.Clone(*list), symbol)
+  globRwm.Unlock()
+
+  // Not connected means the symbol is subscribed to with the others on reconnect
+  if err := m.write(m.subscriptionMessage("subscribe", []string{symbol})); err != nil {
+    util.Warning(err, "Symbol", symbol, "Details", "Subscribing on reconnect")
+  }
+  util.Ok(fmt.Sprintf("Added %s", symbol))
+  return nil
+}
+
+// Starts removing the symbol. The asset is dropped in the background once its
+// positions are closed, which are closed right away if flatten is set.
+func (m *Market) removeSymbol(wg *sync.WaitGroup, ctx context.Context, symbol string, flatten bool) error {
+  m.symbols_mutex.Lock()
+  defer m.symbols_mutex.Unlock()
+  a := m.asset(symbol)
+  if a == nil {
+    return fmt.Errorf("%s not subscribed", symbol)
+  }
+  if len(m.symbols()) == 1 {
+    return fmt.Errorf("Can not remove %s, the last %s symbol", symbol, m.asset_class)
+  }
+  if !a.retiring.CompareAndSwap(false, true) {
+    return fmt.Errorf("%s is already being removed", symbol)
+  }
+  wg.Add(1)
+  go m.retire(wg, ctx, a, flatten)
+  return nil
+}
+
+// Stops the removal of a symbol that has not been dropped yet. Returns false if the
+// symbol is not subscribed.
+func (m *Market) keepSymbol(symbol string) bool {
+  m.symbols_mutex.Lock()
+  defer m.symbols_mutex.Unlock()
+  a := m.asset(symbol)
+  if a == nil {
+    return false
+  }
+  a.retiring.Store(false)
+  return true
+}
+
+// Waits until the asset has no positions and drops it. If flatten is set the
+// positions are closed, and those that can not be closed yet, e.g. stocks outside
+// the session, are retried.
+func (m *Market) retire(wg *sync.WaitGroup, ctx context.Context, a *Asset, flatten bool) {
+  defer wg.Done()
+  ticker := time.NewTicker(retireInterval)
+  defer ticker.Stop()
+  for {
+    left := len(a.positionsCopy())
+    if flatten {
+      left = a.closeAll("Closing before removal")
+    }
+    if left == 0 && m.dropSymbol(a) {
+      return
+    }
+    if !a.retiring.Load() {
+      return
+    }
+    select {
+    case <-ctx.Done():
+      util.Warning(errors.New("Shutting down before positions were closed"), "Symbol", a.Symbol)
+      return
+    case <-ticker.C:
+    }
+  }
+}
+
+// Drops the asset unless its removal was stopped with keepSymbol
+func (m *Market) dropSymbol(a *Asset) bool {
+  m.symbols_mutex.Lock()
+  defer m.symbols_mutex.Unlock()
+  if m.asset(a.Symbol) != a {
+    // Dropped by an earlier removal that was stopped and started again
+    return true
+  }
+  if !a.retiring.Load() {
+    return false
+  }
+  globRwm.Lock()
+  delete(m.assets, a.Symbol)
+  list := configSymbols(m.asset_class)
+  *list = slices.DeleteFunc(slices.Clone(*list), func(s string) bool {
+    return s == a.Symbol
+  })
+  globRwm.Unlock()
+  a.stopStrategies()
+
+  // Not connected means the symbol is left out of the subscription on reconnect
+  if err := m.write(m.subscriptionMessage("unsubscribe", []string{a.Symbol})); err != nil {
+    util.Warning(err, "Symbol", a.Symbol, "Details", "Unsubscribed on reconnect")
+  }
+  util.Ok(fmt.Sprintf("Removed %s", a.Symbol))
+  return true
+}
+
+// Sends closes for the positions without pending orders. Returns the number of
+// positions left, including those with orders pending.
+func (a *Asset) closeAll(reason string) int {
+  positions := a.positionsCopy()
+  var open []string
+  for strat_name, pos := range positions {
+    pos.Rwm.RLock()
+    if !pos.OpenOrderPending && !pos.CloseOrderPending {
+      open = append(open, strat_name)
+    }
+    pos.Rwm.RUnlock()
+  }
+
+  a.Mutex.Lock()
+  for _, strat_name := range open {
+    a.logEvent("info", strat_name, reason)
+    a.close(IOC, strat_name)
+  }
+  a.Mutex.Unlock()
+  return len(positions)
+}
+
+// Sends a close for the position of the strategy, unless it has orders pending
+func (a *Asset) closePosition(strat_name string, reason string) error {
+  pos, ok := a.positionsCopy()[strat_name]
+  if !ok {
+    return fmt.Errorf("No %s position in %s", strat_name, a.Symbol)
+  }
+  pos.Rwm.RLock()
+  pending := pos.OpenOrderPending || pos.CloseOrderPending
+  pos.Rwm.RUnlock()
+  if pending {
+    return errors.New("Position has orders pending")
+  }
+
+  a.Mutex.Lock()
+  defer a.Mutex.Unlock()
+  a.logEvent("info", strat_name, reason)
+  a.close(IOC, strat_name)
+  return nil
+}
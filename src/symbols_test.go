@@ -0,0 +1,106 @@
+package main
+
+import (
+  "io"
+  "sync"
+  "time"
+  "context"
+  "strings"
+  "testing"
+  "net/http"
+  "github.com/stretchr/testify/assert"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+)
+
+func withCryptoSymbols(t *testing.T, symbols ...string) {
+  saved := config.C.CryptoSymbols
+  config.C.CryptoSymbols = symbols
+  t.Cleanup(func() {
+    config.C.CryptoSymbols = saved
+  })
+}
+
+func TestAddSymbol(t *testing.T) {
+  withCryptoSymbols(t, "BTC/USD")
+  withAssetInfos(t, nil)
+  var url string
+  request.HttpClient = &http.Client{
+    Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+      url = req.URL.String()
+      body := `{"bars":{"ETH/USD":[{"t":"2024-07-02T14:00:00Z","o":1,"h":2,"l":1,"c":2,"v":5,"vw":1.5,"n":3}]},"next_page_token":null}`
+      return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+    }),
+  }
+  m := NewMarket("crypto", "", map[string]*Asset{"BTC/USD": newAssetTesting()})
+
+  assert.Nil(t, m.addSymbol("ETH/USD"))
+  assert.Contains(t, url, "symbols=ETH%2FUSD")
+  a := m.asset("ETH/USD")
+  assert.NotNil(t, a)
+  assert.Equal(t, 2.0, a.C[a.i(0)], "Backfilled")
+  assert.Equal(t, []string{"BTC/USD", "ETH/USD"}, config.C.CryptoSymbols)
+  assert.Equal(t, []string{"BTC/USD", "ETH/USD"}, m.symbols())
+
+  assert.NotNil(t, m.addSymbol("ETH/USD"), "Already subscribed")
+  assert.NotNil(t, m.addSymbol("AAPL"), "Wrong asset class")
+}
+
+func TestRemoveSymbol(t *testing.T) {
+  withCryptoSymbols(t, "BTC/USD", "ETH/USD")
+  saved := retireInterval
+  retireInterval = 10 * time.Millisecond
+  t.Cleanup(func() {
+    retireInterval = saved
+  })
+
+  a := newAssetTesting()
+  a.Symbol = "ETH/USD"
+  runs := 0
+  a.strategies = []strategyFunc{func(a *Asset) { runs++ }}
+  a.startStrategies()
+  pos := NewPosition(a.Symbol)
+  pos.OpenOrderPending = false
+  a.Positions = map[string]*Position{"foo": pos}
+  var mutex sync.Mutex
+  closed := 0
+  a.close = func(params request.OrderParams, strat_name string) {
+    mutex.Lock()
+    defer mutex.Unlock()
+    closed++
+  }
+  m := NewMarket("crypto", "", map[string]*Asset{"BTC/USD": newAssetTesting(), "ETH/USD": a})
+
+  var wg sync.WaitGroup
+  ctx, cancel := context.WithCancel(context.Background())
+  defer cancel()
+  assert.Nil(t, m.removeSymbol(&wg, ctx, "ETH/USD", true))
+  assert.NotNil(t, m.removeSymbol(&wg, ctx, "ETH/USD", true), "Already being removed")
+  assert.False(t, a.openChecks("long", "bar", time.Now()))
+
+  // Kept until the position is closed
+  assert.Eventually(t, func() bool {
+    mutex.Lock()
+    defer mutex.Unlock()
+    return closed > 0
+  }, time.Second, 5 * time.Millisecond)
+  assert.NotNil(t, m.asset("ETH/USD"))
+  a.removePosition("foo")
+  wg.Wait()
+  assert.Nil(t, m.asset("ETH/USD"))
+  assert.Equal(t, []string{"BTC/USD"}, config.C.CryptoSymbols)
+  // The strategy goroutine is stopped, and signals are dropped
+  a.checkForSignal([]int{0})
+  assert.Equal(t, 0, runs)
+
+  assert.NotNil(t, m.removeSymbol(&wg, ctx, "BTC/USD", true), "Last symbol")
+}
+
+func TestAdminExec(t *testing.T) {
+  a := newAssetTesting()
+  ad := NewAdmin(map[string]*Market{"crypto": NewMarket("crypto", "", map[string]*Asset{"BTC/USD": a})}, nil, nil, context.Background())
+  assert.Equal(t, "crypto: BTC/USD", ad.exec("symbols"))
+  assert.Contains(t, ad.exec("add AAPL"), "No stock symbols subscribed")
+  assert.Contains(t, ad.exec("remove ETH/USD"), "not subscribed")
+  assert.Contains(t, ad.exec("subscribe AAPL"), "Unknown command")
+}
@@ -0,0 +1,140 @@
+// Higher timeframe bars aggregated from the minute bars. Strategies declare the
+// timeframes they read, and the asset keeps a frame for each of them with windows of
+// the same length as the minute windows.
+//
+// Bars are aligned to the clock in UTC, and daily stock bars to the New York trading
+// day. A stock bar ends at the close if the session closes before the end of the bar.
+// Stock frames only see minutes within the session, like the minute windows.
+
+package main
+
+import (
+  "fmt"
+  "time"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/calendar"
+)
+
+const oneDay = 24 * time.Hour
+
+// The last bar of the windows is the bar in progress until the minute that ends it
+// has been added, just like the minute windows hold the current minute.
+type Frame struct {
+  Size   time.Duration
+  Start  time.Time  // Start of the last bar
+  O      []float64
+  H      []float64
+  L      []float64
+  C      []float64
+  V      []float64
+}
+
+func newFrame(size time.Duration) *Frame {
+  return &Frame{
+    Size: size,
+    O: make([]float64, config.C.WindowSize),
+    H: make([]float64, config.C.WindowSize),
+    L: make([]float64, config.C.WindowSize),
+    C: make([]float64, config.C.WindowSize),
+    V: make([]float64, config.C.WindowSize),
+  }
+}
+
+// Timeframes that can be aggregated are whole minutes that divide a day
+func validTimeframe(size time.Duration) error {
+  if size <= time.Minute || size > oneDay || size % time.Minute != 0 || oneDay % size != 0 {
+    return fmt.Errorf("Invalid timeframe %s. Must be whole minutes dividing a day, e.g. 5m, 15m, 1h or 24h.", size)
+  }
+  return nil
+}
+
+// Timeframe parameter of the market data API
+func apiTimeframe(size time.Duration) string {
+  switch {
+  case size >= oneDay:
+    return "1Day"
+  case size >= time.Hour && size % time.Hour == 0:
+    return fmt.Sprintf("%dHour", size / time.Hour)
+  }
+  return fmt.Sprintf("%dMin", size / time.Minute)
+}
+
+// Index of the bar pos bars back
+func (f *Frame) i(pos int) int {
+  return len(f.C) - 1 - pos
+}
+
+// Start of the bar that the minute starting at t belongs to
+func (f *Frame) barStart(asset_class string, t time.Time) time.Time {
+  if f.Size == oneDay && asset_class == "stock" {
+    ny := t.In(calendar.NewYork)
+    return time.Date(ny.Year(), ny.Month(), ny.Day(), 0, 0, 0, 0, calendar.NewYork).UTC()
+  }
+  return t.Truncate(f.Size)
+}
+
+func (f *Frame) barEnd(asset_class string, start time.Time) time.Time {
+  end := start.Add(f.Size)
+  if f.Size == oneDay && asset_class == "stock" {
+    end = start.In(calendar.NewYork).AddDate(0, 0, 1).UTC()
+  }
+  if asset_class == "stock" {
+    if close, ok := MarketCalendar.CloseOf(start); ok && close.After(start) && close.Before(end) {
+      end = close
+    }
+  }
+  return end
+}
+
+// Adds the minute bar ending at t. Returns whether the minute ends the bar.
+func (f *Frame) update(asset_class string, o float64, h float64, l float64, c float64, v float64, t time.Time) bool {
+  start := f.barStart(asset_class, t.Add(-time.Minute))
+  last := len(f.C) - 1
+  if start.Equal(f.Start) {
+    f.H[last] = max(f.H[last], h)
+    f.L[last] = min(f.L[last], l)
+    f.C[last] = c
+    f.V[last] += v
+  } else {
+    f.load(o, h, l, c, v, start)
+  }
+  return !t.Before(f.barEnd(asset_class, start))
+}
+
+// Adds a whole bar starting at start, as returned by the market data API
+func (f *Frame) load(o float64, h float64, l float64, c float64, v float64, start time.Time) {
+  rollFloat(&f.O, o)
+  rollFloat(&f.H, h)
+  rollFloat(&f.L, l)
+  rollFloat(&f.C, c)
+  rollFloat(&f.V, v)
+  f.Start = start
+}
+
+// Frame of the given size, or nil if no strategy of the asset declares it
+func (a *Asset) frame(size time.Duration) *Frame {
+  return a.frames[size]
+}
+
+// Adds the minute bar ending at t to the frames and marks the timeframes of the bars
+// it ends as closed. Called with a.Rwm held.
+func (a *Asset) updateFrames(o float64, h float64, l float64, c float64, v float64, t time.Time) {
+  a.closed[time.Minute] = true
+  for size, f := range a.frames {
+    if f.update(a.Class, o, h, l, c, v, t) {
+      a.closed[size] = true
+    }
+  }
+}
+
+// Indexes of the strategies that run on the last update. Strategies without a
+// trigger run on every bar and trade. Called with a.Rwm held.
+func (a *Asset) triggeredStrategies() []int {
+  triggered := make([]int, 0, len(a.strategies))
+  for idx := range a.strategies {
+    if idx >= len(a.triggers) || a.triggers[idx] == 0 || a.closed[a.triggers[idx]] {
+      triggered = append(triggered, idx)
+    }
+  }
+  return triggered
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+  "io"
+  "time"
+  "strings"
+  "testing"
+  "net/http"
+  "github.com/stretchr/testify/assert"
+  "github.com/Kjellemann1/AlgoTrader-Go/config"
+  "github.com/Kjellemann1/AlgoTrader-Go/request"
+)
+
+func TestValidTimeframe(t *testing.T) {
+  for _, size := range []time.Duration{5 * time.Minute, 15 * time.Minute, time.Hour, 4 * time.Hour, oneDay} {
+    assert.Nil(t, validTimeframe(size), size)
+  }
+  for _, size := range []time.Duration{time.Minute, 90 * time.Second, 7 * time.Minute, 48 * time.Hour} {
+    assert.NotNil(t, validTimeframe(size), size)
+  }
+  assert.Equal(t, "5Min", apiTimeframe(5 * time.Minute))
+  assert.Equal(t, "90Min", apiTimeframe(90 * time.Minute))
+  assert.Equal(t, "4Hour", apiTimeframe(4 * time.Hour))
+  assert.Equal(t, "1Day", apiTimeframe(oneDay))
+}
+
+func TestFrameAggregation(t *testing.T) {
+  f := newFrame(5 * time.Minute)
+  start := time.Date(2024, 7, 2, 10, 0, 0, 0, time.UTC)
+  last := f.i(0)
+
+  // Minute bars are stamped with their end time, so 10:05 ends the 10:00 bar
+  for k := 1; k <= 5; k++ {
+    closed := f.update("crypto", float64(10 + k), float64(20 + k), float64(k), float64(10 + k), 1, start.Add(time.Duration(k) * time.Minute))
+    assert.Equal(t, k == 5, closed, k)
+  }
+  assert.Equal(t, start, f.Start)
+  assert.Equal(t, []float64{11, 25, 1, 15, 5}, []float64{f.O[last], f.H[last], f.L[last], f.C[last], f.V[last]})
+
+  // The next minute starts a new bar
+  f.update("crypto", 30, 31, 29, 30, 2, start.Add(6 * time.Minute))
+  assert.Equal(t, start.Add(5 * time.Minute), f.Start)
+  assert.Equal(t, 15.0, f.C[f.i(1)])
+  assert.Equal(t, 30.0, f.O[last])
+}
+
+func TestFrameSessionClose(t *testing.T) {
+  withCalendar(t)
+
+  // 4 hour bars start at 12:00 New York time in the summer. The early close ends
+  // the bar at 13:00.
+  f := newFrame(4 * time.Hour)
+  assert.False(t, f.update("stock", 1, 1, 1, 1, 1, ny(3, 12, 59)))
+  assert.True(t, f.update("stock", 1, 1, 1, 1, 1, ny(3, 13, 0)))
+  assert.Equal(t, ny(3, 12, 0), f.Start)
+
+  // Daily stock bars follow the New York date and end at the close
+  d := newFrame(oneDay)
+  assert.False(t, d.update("stock", 1, 1, 1, 1, 1, ny(2, 9, 31)))
+  assert.Equal(t, ny(2, 0, 0), d.Start)
+  assert.True(t, d.update("stock", 1, 1, 1, 1, 1, ny(2, 16, 0)))
+  assert.False(t, d.update("stock", 1, 1, 1, 1, 1, ny(3, 9, 31)))
+  assert.Equal(t, ny(3, 0, 0), d.Start)
+}
+
+func TestTrigger(t *testing.T) {
+  a := newAssetTesting()
+  a.Class = "crypto"
+  a.frames[5 * time.Minute] = newFrame(5 * time.Minute)
+  runs := make([]int, 3)
+  for i := range runs {
+    a.strategies = append(a.strategies, func(a *Asset) {
+      runs[i]++
+    })
+  }
+  a.triggers = []time.Duration{0, time.Minute, 5 * time.Minute}
+
+  start := time.Date(2024, 7, 2, 10, 0, 0, 0, time.UTC)
+  for k := 1; k <= 10; k++ {
+    a.checkForSignal(a.updateWindowOnBar(1, 1, 1, 1, 1, 1, 1, start.Add(time.Duration(k) * time.Minute), time.Now()))
+  }
+  a.checkForSignal(a.updateWindowOnTrade(1, 1, start.Add(10 * time.Minute + time.Second), time.Now()))
+  assert.Equal(t, []int{11, 10, 2}, runs)
+}
+
+func TestStrategyTimeframes(t *testing.T) {
+  tick := func(a *Asset, s *Strategy) {}
+  assert.Panics(t, func() {
+    registerStrategy(&Strategy{Name: "foo", Tick: tick, Timeframes: []time.Duration{7 * time.Minute}})
+  })
+  foo := &Strategy{Name: "foo", Tick: tick}
+  withRegistry(t, foo)
+
+  err := applyStrategyConfig([]byte("foo:\n  timeframes: [5m, 1h]\n  trigger: 1h\n"))
+  assert.Nil(t, err)
+  assert.Equal(t, []time.Duration{5 * time.Minute, time.Hour}, foo.Timeframes)
+  assert.Equal(t, time.Hour, foo.Trigger)
+  assert.NotNil(t, applyStrategyConfig([]byte("foo:\n  trigger: 15m\n")))
+
+  a := allocAsset("crypto", "BTC/USD")
+  assert.NotNil(t, a.frame(5 * time.Minute))
+  assert.NotNil(t, a.frame(time.Hour))
+  assert.Nil(t, a.frame(15 * time.Minute))
+}
+
+func TestBackfillFrames(t *testing.T) {
+  request.HttpClient = &http.Client{
+    Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+      body := `{"bars":{"Foo":[`
+      if strings.Contains(req.URL.RawQuery, "timeframe=5Min") {
+        body += `{"t":"2024-07-02T13:55:00Z","o":40,"h":40,"l":40,"c":40,"v":1},` +
+          `{"t":"2024-07-02T14:00:00Z","o":50,"h":50,"l":50,"c":50,"v":1}`
+      } else {
+        for m := 0; m < 8; m++ {
+          if m > 0 {
+            body += ","
+          }
+          body += `{"t":"2024-07-02T14:0` + string(rune('0' + m)) + `:00Z","o":1,"h":1,"l":1,"c":1,"v":1,"vw":1,"n":1}`
+        }
+      }
+      body += `]},"next_page_token":null}`
+      return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+    }),
+  }
+  a := newAssetTesting()
+  a.Class = "crypto"
+  a.frames[5 * time.Minute] = newFrame(5 * time.Minute)
+
+  assert.Nil(t, backfill(map[string]*Asset{"Foo": a}, "crypto"))
+  f := a.frame(5 * time.Minute)
+  last := config.C.WindowSize - 1
+  assert.Equal(t, []float64{40, 50}, f.C[last-1:], "Only the bars of the timeframe, in time order")
+  assert.Equal(t, 0.0, f.C[last-2])
+}
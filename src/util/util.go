@@ -6,11 +6,10 @@ import (
   "encoding/json"
   "bytes"
   "log"
+  "log/slog"
   "github.com/valyala/fastjson"
-  "runtime"
-  "errors"
-  "path/filepath"
   "github.com/Kjellemann1/AlgoTrader-Go/push"
+  "github.com/Kjellemann1/AlgoTrader-Go/logging"
 )
 
 func BackoffWithMax(backoff_sec *float64, backoff_max_sec float64) {
@@ -41,160 +40,107 @@ func AddWhitespace(s string, n int) string {
   return s
 }
 
+// The log functions below log through the logging package as their caller, with
+// the key value details as attributes. Notifications are pushed as text.
+
+// Details as "\n  -> key: value" lines
+func detailsText(details ...any) string {
+  text := ""
+  for i := 0; i < len(details); i += 2 {
+    key, ok := details[i].(string)
+    if !ok {
+      text += "\n  -> Invalid key (not a string): " + fmt.Sprint(details[i])
+      continue
+    }
+    var value any
+    if i + 1 < len(details) {
+      value = details[i+1]
+    }
+    text += "\n  -> " + key + ": " + fmt.Sprint(value)
+  }
+  return text
+}
+
 var Close = CloseFunc
-func CloseFunc(message string) {
-  log.Println("[ CLOSE ]\t" + message)
+func CloseFunc(message string, details ...any) {
+  logging.Log(slog.LevelInfo, 1, message, append([]any{"Event", "close"}, details...)...)
 }
 
 var Open = OpenFunc
-func OpenFunc(message string) {
-  log.Println("[ OPEN ]\t" + message)
+func OpenFunc(message string, details ...any) {
+  logging.Log(slog.LevelInfo, 1, message, append([]any{"Event", "open"}, details...)...)
 }
 
 var Ok = OkFunc
 func OkFunc(message string) {
-  log.Println("[ OK ]\t" + message)
+  logging.Log(slog.LevelInfo, 1, message, "Event", "ok")
 }
 
 func Info(message string, details ...any) {
-  logMsg := "[ INFO ]\t" + message
-  for i := 0; i < len(details); i += 2 {
-    key, ok := details[i].(string)
-    if !ok {
-      logMsg += "\n  -> Invalid key (not a string): " + fmt.Sprint(details[i])
-      continue
-    }
-    logMsg += "\n  -> " + key + ": " + fmt.Sprint(details[i+1])
-  }
-  log.Println(logMsg)
-  push.Info(logMsg)
+  logging.Log(slog.LevelInfo, 1, message, details...)
+  push.Info(logging.Component(1), message + detailsText(details...))
 }
 
 var Error = ErrorFunc
 func ErrorFunc(err error, details ...any) {
   if err == nil {
-    log.Println(errors.New("Called with nil error"))
+    logging.Log(slog.LevelError, 1, "Called with nil error")
     return
   }
-  _, file, line, ok := runtime.Caller(1)
-  if !ok {
-    file = "unknown"
-    line = -1
-  } else {
-    file = filepath.Base(file)
-  }
-  logMsg := "[ ERROR ]\t" + err.Error() + "\n  -> " + file + ":" + fmt.Sprint(line)
-  for i := 0; i < len(details); i += 2 {
-    key, ok := details[i].(string)
-    if !ok {
-      logMsg += "\n  -> Invalid key (not a string): " + fmt.Sprint(details[i])
-      continue
-    }
-    logMsg += "\n  -> " + key + ": " + fmt.Sprint(details[i+1])
+  logging.Log(slog.LevelError, 1, err.Error(), details...)
+  push.Error(logging.Component(1), err.Error() + detailsText(details...))
+}
+
+// Error pushed with emergency priority, for errors like closing all positions
+func Emergency(err error, details ...any) {
+  if err == nil {
+    logging.Log(slog.LevelError, 1, "Called with nil error")
+    return
   }
-  log.Println(logMsg)
-  push.Error(logMsg)
+  logging.Log(slog.LevelError, 1, err.Error(), details...)
+  push.Emergency(logging.Component(1), err.Error() + detailsText(details...))
+}
+
+// Waits for the errors and emergencies to be pushed. Called before exiting on an
+// error, since they are sent from a queue.
+func Flush() {
+  push.Flush()
 }
 
 func ErrorPanic(err error, details ...any) {
   if err == nil {
-    log.Println(errors.New("Called with nil error"))
+    logging.Log(slog.LevelError, 1, "Called with nil error")
     return
   }
-  _, file, line, ok := runtime.Caller(1)
-  if !ok {
-    file = "unknown"
-    line = -1
-  } else {
-    file = filepath.Base(file)
-  }
-  logMsg := "[ ERROR ]\t" + err.Error() + "\n  -> " + file + ":" + fmt.Sprint(line)
-  for i := 0; i < len(details); i += 2 {
-    key, ok := details[i].(string)
-    if !ok {
-      logMsg += "\n  -> Invalid key (not a string): " + fmt.Sprint(details[i])
-      continue
-    }
-    logMsg += "\n  -> " + key + ": " + fmt.Sprint(details[i+1])
-  }
-  log.Panicln(logMsg)
-  push.Error(logMsg)
+  logging.Log(slog.LevelError, 1, err.Error(), details...)
+  panic("[ ERROR ]\t" + err.Error() + detailsText(details...))
 }
 
 var Warning = WarningFunc
 func WarningFunc(err error, details ...any) {
   if err == nil {
-    log.Println(errors.New("Called with nil error"))
+    logging.Log(slog.LevelError, 1, "Called with nil error")
     return
   }
-  _, file, line, ok := runtime.Caller(1)
-  if !ok {
-    file = "unknown"
-    line = -1
-  } else {
-    file = filepath.Base(file)
-  }
-  logMsg := "[ WARNING ]\t" + err.Error() + "\n  -> " + file + ":" + fmt.Sprint(line)
-  for i := 0; i < len(details); i += 2 {
-    key, ok := details[i].(string)
-    if !ok {
-      logMsg += "\n  -> Invalid key (not a string): " + fmt.Sprint(details[i])
-      continue
-    }
-    logMsg += "\n  -> " + key + ": " + fmt.Sprint(details[i+1])
-  }
-  log.Println(logMsg)
-  push.Warning(logMsg)
+  logging.Log(slog.LevelWarn, 1, err.Error(), details...)
+  push.Warning(logging.Component(1), err.Error() + detailsText(details...))
 }
 
 // Caller 2
 func Error2(err error, details ...any) {
   if err == nil {
-    log.Println(errors.New("Called with nil error"))
+    logging.Log(slog.LevelError, 1, "Called with nil error")
     return
   }
-
-  _, file, line, ok := runtime.Caller(2)
-  if !ok {
-    file = "unknown"
-    line = -1
-  } else {
-    file = filepath.Base(file)
-  }
-  logMsg := "[ ERROR ]\t" + err.Error() + "\n  -> " + file + ":" + fmt.Sprint(line)
-  for i := 0; i < len(details); i += 2 {
-    key, ok := details[i].(string)
-    if !ok {
-      logMsg += "\n  -> Invalid key (not a string): " + fmt.Sprint(details[i])
-      continue
-    }
-    logMsg += "\n  -> " + key + ": " + fmt.Sprint(details[i+1])
-  }
-  log.Println(logMsg)
-  push.Error(logMsg)
+  logging.Log(slog.LevelError, 2, err.Error(), details...)
+  push.Error(logging.Component(2), err.Error() + detailsText(details...))
 }
 
 func Warning2(err error, details ...any) {
   if err == nil {
-    log.Println(errors.New("Called with nil error"))
+    logging.Log(slog.LevelError, 1, "Called with nil error")
     return
   }
-  _, file, line, ok := runtime.Caller(2)
-  if !ok {
-    file = "unknown"
-    line = -1
-  } else {
-    file = filepath.Base(file)
-  }
-  logMsg := "[ WARNING ]\t" + err.Error() + "\n  -> " + file + ":" + fmt.Sprint(line)
-  for i := 0; i < len(details); i += 2 {
-    key, ok := details[i].(string)
-    if !ok {
-      logMsg += "\n  -> Invalid key (not a string): " + fmt.Sprint(details[i])
-      continue
-    }
-    logMsg += "\n  -> " + key + ": " + fmt.Sprint(details[i+1])
-  }
-  log.Println(logMsg)
-  push.Warning(logMsg)
+  logging.Log(slog.LevelWarn, 2, err.Error(), details...)
+  push.Warning(logging.Component(2), err.Error() + detailsText(details...))
 }